@@ -12,8 +12,13 @@ const char* password = "64CBCCC7";
 const char* serverHost = "192.168.8.194"; // Replace with your actual server hostname
 const char* serverPath = "/api/arduino/gate/enter/upload";
 const int serverPort = 8080; // Default HTTP port, use 443 for HTTPS
-const char* apiKey = "533a269d-e866-43be-93af-e65a43905762";
-const char* locationId = "678bd59982059b655da1098e";
+// Device credentials issued by POST /api/devices, the location comes from the device
+const char* deviceId = "gate-enter-01";
+const char* apiKey = "REPLACE_WITH_DEVICE_SECRET";
+const char* heartbeatPath = "/api/arduino/heartbeat";
+const char* firmwareVersion = "1.1.0";
+const unsigned long HEARTBEAT_INTERVAL = 60000; // Report health every minute so the server can detect silent gates
+unsigned long lastHeartbeat = 0;
 
 // Pin definitions for CAMERA_MODEL_AI_THINKER
 #define PWDN_GPIO_NUM     32
@@ -231,6 +236,11 @@ void loop() {
   
   // Check ultrasonic sensor for vehicle detection
   checkUltrasonicSensor();
+
+  if (millis() - lastHeartbeat > HEARTBEAT_INTERVAL) {
+    lastHeartbeat = millis();
+    sendHeartbeat();
+  }
   
   // Check if it's time to close the gate
   // if (gateIsOpen && (millis() - gateOpenTime > GATE_OPEN_TIME)) {
@@ -240,6 +250,31 @@ void loop() {
   delay(100);
 }
 
+void sendHeartbeat() {
+  if (WiFi.status() != WL_CONNECTED) {
+    return;
+  }
+
+  HTTPClient http;
+  String url = "http://" + String(serverHost) + ":" + String(serverPort) + String(heartbeatPath);
+
+  DynamicJsonDocument doc(256);
+  doc["firmware_version"] = firmwareVersion;
+  String payload;
+  serializeJson(doc, payload);
+
+  http.begin(url);
+  http.addHeader("Content-Type", "application/json");
+  http.addHeader("X-Device-ID", deviceId);
+  http.addHeader("X-API-Key", apiKey);
+
+  int httpCode = http.POST(payload);
+  if (httpCode != HTTP_CODE_OK) {
+    Serial.printf("Heartbeat failed: %d\n", httpCode);
+  }
+  http.end();
+}
+
 void checkUltrasonicSensor() {
   // Clear trigger pin
   digitalWrite(TRIGGER_PIN, LOW);
@@ -319,10 +354,7 @@ void takePhotoAndSend() {
   head += "Content-Disposition: form-data; name=\"image\"; filename=\"image.jpg\"\r\n";
   head += "Content-Type: image/jpeg\r\n\r\n";
   
-  String tail = "\r\n--" + boundary + "\r\n";
-  tail += "Content-Disposition: form-data; name=\"location_id\"\r\n\r\n";
-  tail += locationId;
-  tail += "\r\n--" + boundary + "--\r\n";
+  String tail = "\r\n--" + boundary + "--\r\n";
   
   // Calculate content length
   size_t contentLength = head.length() + fb->len + tail.length();
@@ -351,6 +383,8 @@ void takePhotoAndSend() {
   client.println(" HTTP/1.1");
   client.print("Host: ");
   client.println(serverHost);
+  client.print("X-Device-ID: ");
+  client.println(deviceId);
   client.print("X-API-Key: ");
   client.println(apiKey);
   client.print("Content-Type: multipart/form-data; boundary=");
@@ -426,7 +460,7 @@ void takePhotoAndSend() {
     return;
   }
   
-  // Send tail with location_id
+  // Send closing boundary
   client.print(tail);
   
   // Free the camera frame buffer
@@ -7,8 +7,10 @@
 
 hd44780_I2Cexp lcd; // Create hd44780 object for I2C backpack, auto-detects address
 
-// TCRT5000 sensor settings
-#define SENSOR_THRESHOLD 500 // Adjust based on your calibration
+// TCRT5000 sensor settings, updated from the server config
+float sensorThreshold = 500; // Adjust based on your calibration
+bool alertEnabled = true;
+int ledBrightness = 255;
 
 // WiFi credentials
 const char* ssid = "Dialog 4G 405";
@@ -16,19 +18,33 @@ const char* password = "64CBCCC7";
 
 // Backend server settings
 const char* serverHost = "192.168.8.194";
-const char* serverPath = "/api/arduino/spot/status";
+const char* serverPath = "/api/arduino/spot/snapshot";
+const char* configPath = "/api/arduino/config";
+const char* configAckPath = "/api/arduino/config/ack";
+const char* displayPath = "/api/arduino/display";
 const int serverPort = 8080;
-const char* locationId = "678bd59982059b655da1098e";
-const char* apiKey = "533a269d-e866-43be-93af-e65a43905762";
+// Device credentials issued by POST /api/devices, the location comes from the device
+const char* deviceId = "slot-sensor-01";
+const char* apiKey = "REPLACE_WITH_DEVICE_SECRET";
 
 // Parking spot configuration
 const String spotNumbers[3] = {"A1", "A2", "A3"};
 const int sensorPins[3] = {14, 12, 13}; // Using GPIO numbers for ESP8266 (D5=14, D6=12, D7=13)
 
-// Status check interval (milliseconds)
-const unsigned long checkInterval = 5000;
+// Status check interval (milliseconds), updated from the server config
+unsigned long checkInterval = 5000;
 unsigned long lastCheckTime = 0;
 
+// Remote configuration polling
+const unsigned long CONFIG_POLL_INTERVAL = 60000;
+unsigned long lastConfigPoll = 0;
+String configETag = "";
+
+// Last display feed, only refetched when the server reports a change
+String displayETag = "";
+int displayFree = -1;
+String displayMessage = "";
+
 void setup() {
   Serial.begin(115200);
 
@@ -66,20 +82,36 @@ void setup() {
   Serial.println("\nWiFi connected");
   Serial.print("IP address: ");
   Serial.println(WiFi.localIP());
+
+  fetchConfig();
 }
 
 void loop() {
+  if (millis() - lastConfigPoll >= CONFIG_POLL_INTERVAL) {
+    fetchConfig();
+  }
+
   if (millis() - lastCheckTime >= checkInterval) {
     lastCheckTime = millis();
     int availableCount = 0;
-    // Check each sensor and send status
+    bool occupied[3];
+    // Read every sensor, then send them as one snapshot
     for (int i = 0; i < 3; i++) {
-      bool isOccupied = digitalRead(sensorPins[i]) == LOW; // TCRT5000 outputs LOW when object is detected
-      if (!isOccupied) {
+      occupied[i] = digitalRead(sensorPins[i]) == LOW; // TCRT5000 outputs LOW when object is detected
+      if (!occupied[i]) {
         availableCount++;
       }
-      sendSpotStatus(spotNumbers[i], isOccupied);
     }
+    // Prefer the server's count, which leaves out reserved slots and covers
+    // every controller, and fall back to the local reading when offline
+    bool online = sendSnapshot(occupied) >= 0 && fetchDisplay();
+    if (online) {
+      availableCount = displayFree;
+    }
+    lcd.setCursor(0, 0);
+    lcd.print("Park Me ");
+    lcd.print(online ? displayMessage : String(""));
+    lcd.print("        "); // Clear any leftover message
     lcd.setCursor(0, 1);
     lcd.print("Available: ");
     lcd.print(availableCount);
@@ -87,10 +119,54 @@ void loop() {
   }
 }
 
-void sendSpotStatus(String spotNumber, bool isOccupied) {
+// fetchDisplay updates the free count and message from the server's display
+// feed and returns false if the request failed
+bool fetchDisplay() {
+  WiFiClient client;
+  HTTPClient http;
+  String url = "http://" + String(serverHost) + ":" + String(serverPort) + String(displayPath);
+
+  http.begin(client, url);
+  http.addHeader("X-Device-ID", deviceId);
+  http.addHeader("X-API-Key", apiKey);
+  if (displayETag.length() > 0) {
+    http.addHeader("If-None-Match", displayETag);
+  }
+  const char* headerKeys[] = {"ETag"};
+  http.collectHeaders(headerKeys, 1);
+
+  int httpCode = http.GET();
+  if (httpCode == HTTP_CODE_NOT_MODIFIED) {
+    http.end();
+    return displayFree >= 0;
+  }
+  if (httpCode != HTTP_CODE_OK) {
+    Serial.printf("[DISPLAY] GET failed: %d\n", httpCode);
+    http.end();
+    return false;
+  }
+
+  DynamicJsonDocument doc(256);
+  DeserializationError error = deserializeJson(doc, http.getString());
+  String etag = http.header("ETag");
+  http.end();
+  if (error) {
+    Serial.println("[DISPLAY] Invalid display response");
+    return false;
+  }
+
+  displayFree = doc["free"] | 0;
+  displayMessage = doc["msg"] | "";
+  displayETag = etag;
+  return true;
+}
+
+// sendSnapshot posts the state of every spot and returns the free count
+// reported by the server, or -1 if the request failed
+int sendSnapshot(bool occupied[]) {
   if (WiFi.status() != WL_CONNECTED) {
     Serial.println("WiFi not connected, skipping status update");
-    return;
+    return -1;
   }
   
   WiFiClient client;
@@ -99,27 +175,114 @@ void sendSpotStatus(String spotNumber, bool isOccupied) {
   
   // Create JSON payload
   DynamicJsonDocument doc(1024);
-  doc["location_id"] = locationId;
-  doc["spot_number"] = spotNumber;
-  doc["is_occupied"] = isOccupied;
+  JsonArray slots = doc.createNestedArray("slots");
+  for (int i = 0; i < 3; i++) {
+    JsonObject slot = slots.createNestedObject();
+    slot["spot_number"] = spotNumbers[i];
+    slot["is_occupied"] = occupied[i];
+  }
   
   String payload;
   serializeJson(doc, payload);
   
   http.begin(client, url);
   http.addHeader("Content-Type", "application/json");
+  http.addHeader("X-Device-ID", deviceId);
   http.addHeader("X-API-Key", apiKey);
   
+  int freeSlots = -1;
   int httpCode = http.POST(payload);
   if (httpCode > 0) {
     Serial.printf("[HTTP] POST... code: %d\n", httpCode);
     if (httpCode == HTTP_CODE_OK) {
-      String response = http.getString();
-      Serial.println(response);
+      DynamicJsonDocument response(2048);
+      if (!deserializeJson(response, http.getString())) {
+        freeSlots = response["data"]["free_slots"] | -1;
+      }
     }
   } else {
     Serial.printf("[HTTP] POST... failed, error: %s\n", http.errorToString(httpCode).c_str());
   }
   
   http.end();
-}
\ No newline at end of file
+  return freeSlots;
+}
+
+// fetchConfig downloads the device config when it changed on the server,
+// applies it and acknowledges the applied version
+void fetchConfig() {
+  lastConfigPoll = millis();
+  if (WiFi.status() != WL_CONNECTED) {
+    return;
+  }
+
+  WiFiClient client;
+  HTTPClient http;
+  String url = "http://" + String(serverHost) + ":" + String(serverPort) + String(configPath);
+
+  http.begin(client, url);
+  http.addHeader("X-Device-ID", deviceId);
+  http.addHeader("X-API-Key", apiKey);
+  if (configETag.length() > 0) {
+    http.addHeader("If-None-Match", configETag);
+  }
+  const char* headerKeys[] = {"ETag"};
+  http.collectHeaders(headerKeys, 1);
+
+  int httpCode = http.GET();
+  if (httpCode != HTTP_CODE_OK) {
+    if (httpCode != HTTP_CODE_NOT_MODIFIED) {
+      Serial.printf("[CONFIG] GET failed: %d\n", httpCode);
+    }
+    http.end();
+    return;
+  }
+
+  DynamicJsonDocument doc(512);
+  DeserializationError error = deserializeJson(doc, http.getString());
+  String etag = http.header("ETag");
+  http.end();
+  if (error) {
+    Serial.println("[CONFIG] Invalid config response");
+    return;
+  }
+
+  JsonObject config = doc["data"]["config"];
+  int version = doc["data"]["version"];
+  checkInterval = config["sampleRate"] | 5000;
+  sensorThreshold = config["threshold"] | 500.0;
+  alertEnabled = config["alertEnabled"] | true;
+  ledBrightness = config["ledBrightness"] | 255;
+  if (ledBrightness > 0) {
+    lcd.backlight();
+  } else {
+    lcd.noBacklight();
+  }
+  configETag = etag;
+  Serial.printf("[CONFIG] Applied version %d\n", version);
+
+  if (version > 0) {
+    acknowledgeConfig(version);
+  }
+}
+
+void acknowledgeConfig(int version) {
+  WiFiClient client;
+  HTTPClient http;
+  String url = "http://" + String(serverHost) + ":" + String(serverPort) + String(configAckPath);
+
+  DynamicJsonDocument doc(64);
+  doc["version"] = version;
+  String payload;
+  serializeJson(doc, payload);
+
+  http.begin(client, url);
+  http.addHeader("Content-Type", "application/json");
+  http.addHeader("X-Device-ID", deviceId);
+  http.addHeader("X-API-Key", apiKey);
+  int httpCode = http.POST(payload);
+  if (httpCode != HTTP_CODE_OK) {
+    Serial.printf("[CONFIG] Ack failed: %d\n", httpCode);
+  }
+  http.end();
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/dfanso/parkme-backend/config"
 	"github.com/dfanso/parkme-backend/internal/controllers"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/gateway"
+	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/repositories"
 	"github.com/dfanso/parkme-backend/internal/routes"
 	"github.com/dfanso/parkme-backend/internal/services"
 	"github.com/dfanso/parkme-backend/pkg/auth"
 	"github.com/dfanso/parkme-backend/pkg/database"
+	"github.com/dfanso/parkme-backend/pkg/mailer"
+	"github.com/dfanso/parkme-backend/pkg/notifier"
 	"github.com/dfanso/parkme-backend/pkg/s3"
 
 	customMiddleware "github.com/dfanso/parkme-backend/pkg/middleware"
@@ -32,11 +38,15 @@ func main() {
 	e.HideBanner = false // Show the Echo banner
 	e.HidePort = false   // Show the port number
 
-	// Initialize JWT manager
-	jwtManager, err := auth.NewJWTManager()
+	// Client IPs only come from forwarding headers set by trusted proxies
+	ipExtractor, err := customMiddleware.IPExtractor(cfg.Server.TrustedProxies)
 	if err != nil {
-		log.Fatalf("Failed to initialize JWT manager: %v", err)
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
 	}
+	e.IPExtractor = ipExtractor
+
+	// Initialize JWT manager, its keys are loaded by the signing key service
+	jwtManager := auth.NewJWTManager(cfg.JWT.Issuer, cfg.Auth.AccessTokenTTL)
 
 	// Initialize S3 client
 	s3Client, err := s3.NewS3Client(cfg.S3BucketName)
@@ -49,41 +59,193 @@ func main() {
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
+	// Initialize event bus
+	eventBus := events.NewBus()
+
 	// Initialize repositories
 	userRepo := repositories.NewUserRepository(db)
 	vehicleRepo := repositories.NewVehicleRepository(db)
 	bookingRepo := repositories.NewBookingRepository(db)
 	walletRepo := repositories.NewWalletRepository(db)
 	parkingLocationRepo := repositories.NewParkingLocationRepository(db)
+	webhookRepo := repositories.NewWebhookRepository(db)
+	notificationRepo := repositories.NewNotificationRepository(db)
+	sensorReadingRepo := repositories.NewSensorReadingRepository(db)
+	deviceRepo := repositories.NewDeviceRepository(db)
+	deviceConfigRepo := repositories.NewDeviceConfigRepository(db)
+	telemetryRepo := repositories.NewTelemetryRepository(db)
+	mismatchRepo := repositories.NewOccupancyMismatchRepository(db)
+	guestRepo := repositories.NewGuestSessionRepository(db)
+	authSessionRepo := repositories.NewAuthSessionRepository(db)
+	signingKeyRepo := repositories.NewSigningKeyRepository(db)
+	accountTokenRepo := repositories.NewAccountTokenRepository(db)
+	loginThrottleRepo := repositories.NewLoginThrottleRepository(db)
+	auditLogRepo := repositories.NewAuditLogRepository(db)
+	userMFARepo := repositories.NewUserMFARepository(db)
+	externalIdentityRepo := repositories.NewExternalIdentityRepository(db)
+	organizationRepo := repositories.NewOrganizationRepository(db)
+	vehicleClaimRepo := repositories.NewVehicleClaimRepository(db)
+	migrationRepo := repositories.NewMigrationRepository(db)
+
+	// Ensure indexes
+	if err := parkingLocationRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create parking location indexes: %v", err)
+	}
+	if err := webhookRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create webhook indexes: %v", err)
+	}
+	if err := notificationRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create notification indexes: %v", err)
+	}
+	if err := sensorReadingRepo.EnsureIndexes(context.Background(), cfg.Sensor.ReadingRetention); err != nil {
+		log.Fatalf("Failed to create sensor reading indexes: %v", err)
+	}
+	if err := deviceRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create device indexes: %v", err)
+	}
+	if err := deviceConfigRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create device config indexes: %v", err)
+	}
+	if err := mismatchRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create occupancy mismatch indexes: %v", err)
+	}
+	if err := guestRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create guest session indexes: %v", err)
+	}
+	if err := authSessionRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create auth session indexes: %v", err)
+	}
+	if err := signingKeyRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create signing key indexes: %v", err)
+	}
+	if err := accountTokenRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create account token indexes: %v", err)
+	}
+	if err := loginThrottleRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create login throttle indexes: %v", err)
+	}
+	if err := auditLogRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create audit log indexes: %v", err)
+	}
+	if err := userMFARepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create two-factor indexes: %v", err)
+	}
+	if err := externalIdentityRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create external identity indexes: %v", err)
+	}
+	if err := walletRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create wallet indexes: %v", err)
+	}
+	if err := organizationRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create organization indexes: %v", err)
+	}
+	if err := vehicleClaimRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create vehicle claim indexes: %v", err)
+	}
+	telemetryRetention := repositories.TelemetryRetention{
+		Raw:    cfg.Telemetry.Retention,
+		Rollup: cfg.Telemetry.RollupRetention,
+		Alert:  cfg.Telemetry.AlertRetention,
+	}
+	if err := telemetryRepo.EnsureCollections(context.Background(), telemetryRetention); err != nil {
+		log.Fatalf("Failed to create telemetry collections: %v", err)
+	}
+
+	// Apply data migrations
+	if err := services.NewMigrationService(migrationRepo, userRepo).Run(context.Background()); err != nil {
+		log.Fatalf("Failed to apply migrations: %v", err)
+	}
 
 	// Initialize services
+	signingKeyService := services.NewSigningKeyService(cfg, signingKeyRepo, jwtManager)
+	if err := signingKeyService.Init(context.Background()); err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
 	userService := services.NewUserService(userRepo)
-	vehicleService := services.NewVehicleService(vehicleRepo)
-	walletService := services.NewWalletService(walletRepo)
-	parkingLocationService := services.NewParkingLocationService(parkingLocationRepo)
-	bookingService := services.NewBookingService(bookingRepo, vehicleService, walletService, parkingLocationService, userService)
+	authSessionService := services.NewAuthSessionService(cfg, authSessionRepo, userService, jwtManager)
+	auditService := services.NewAuditService(auditLogRepo)
+	loginThrottleService := services.NewLoginThrottleService(cfg, loginThrottleRepo, auditService)
+	mfaService := services.NewMFAService(cfg, userMFARepo, userService, authSessionService, loginThrottleService, auditService)
+	oidcService, err := services.NewOIDCService(cfg, externalIdentityRepo, userService, authSessionService, mfaService, auditService)
+	if err != nil {
+		log.Fatalf("Failed to initialize OpenID Connect providers: %v", err)
+	}
+	accountMailer, err := mailer.New(cfg.Mail.Driver, cfg.Mail.From, cfg.Mail.Dir)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+	accountService := services.NewAccountService(cfg, accountTokenRepo, userService, authSessionService, loginThrottleService, accountMailer)
+	vehicleService := services.NewVehicleService(vehicleRepo, userService)
+	walletService := services.NewWalletService(walletRepo, eventBus)
+	organizationService := services.NewOrganizationService(organizationRepo, vehicleService, walletService, userService)
+	vehicleClaimService := services.NewVehicleClaimService(vehicleClaimRepo, vehicleService, auditService)
+	parkingLocationService := services.NewParkingLocationService(parkingLocationRepo, sensorReadingRepo, eventBus)
+	bookingService := services.NewBookingService(bookingRepo, vehicleService, organizationService, parkingLocationService, userService, eventBus)
 	arduinoService, err := services.NewArduinoService(cfg, vehicleService)
 	if err != nil {
 		log.Fatalf("Failed to initialize Arduino service: %v", err)
 	}
 	userStatsService := services.NewUserStatsService(bookingRepo)
+	webhookService := services.NewWebhookService(cfg, webhookRepo, eventBus)
+	telemetryService := services.NewTelemetryService(cfg, telemetryRepo, eventBus)
+	deviceService := services.NewDeviceService(cfg, deviceRepo, deviceConfigRepo, parkingLocationService, eventBus)
+	guestService := services.NewGuestService(cfg, guestRepo, bookingService, vehicleService, parkingLocationService, walletService, eventBus)
+	gateService := services.NewGateService(cfg, arduinoService, bookingService, userService, parkingLocationService, organizationService, guestService, eventBus)
+	displayService := services.NewDisplayService(cfg, bookingRepo, parkingLocationService)
+	reconciliationService := services.NewReconciliationService(cfg, mismatchRepo, bookingRepo, parkingLocationService, bookingService, guestService, eventBus)
+
+	// Notification providers log to the console until real gateways are configured
+	notificationProviders := map[models.NotificationChannel]notifier.Provider{
+		models.NotificationChannelEmail: notifier.NewConsoleProvider("email"),
+		models.NotificationChannelPush:  notifier.NewConsoleProvider("push"),
+		models.NotificationChannelSMS:   notifier.NewConsoleProvider("sms"),
+	}
+	notificationService := services.NewNotificationService(cfg, notificationRepo, bookingRepo, userService, organizationService, parkingLocationService, vehicleService, eventBus, notificationProviders)
+
+	// Start background workers
+	signingKeyService.Start(context.Background())
+	webhookService.Start(context.Background())
+	notificationService.Start(context.Background())
+	deviceService.Start(context.Background())
+	telemetryService.Start(context.Background())
+	reconciliationService.Start(context.Background())
+
+	// The MQTT gateway is optional, HTTP Arduino routes are always served
+	if cfg.MQTT.Enabled {
+		gateway.NewMQTTGateway(cfg, deviceService, gateService, parkingLocationService).Start(context.Background())
+	}
 
 	// Initialize controllers
-	authController := controllers.NewAuthController(userService, jwtManager, s3Client)
-	userController := controllers.NewUserController(userService)
-	vehicleController := controllers.NewVehicleController(vehicleService)
+	authController := controllers.NewAuthController(userService, authSessionService, accountService, loginThrottleService, mfaService, jwtManager, s3Client)
+	userController := controllers.NewUserController(userService, loginThrottleService, mfaService, authSessionService, accountService)
+	vehicleController := controllers.NewVehicleController(vehicleService, vehicleClaimService, s3Client)
 	bookingController := controllers.NewBookingController(bookingService)
-	arduinoController := controllers.NewArduinoController(arduinoService, bookingService, userService, parkingLocationService, walletService)
+	arduinoController := controllers.NewArduinoController(gateService, parkingLocationService)
 	walletController := controllers.NewWalletController(walletService)
 	parkingLocationController := controllers.NewParkingLocationController(parkingLocationService)
 	userStatsController := controllers.NewUserStatsController(userStatsService)
+	streamController := controllers.NewStreamController(eventBus, parkingLocationService, userService, authSessionService)
+	webhookController := controllers.NewWebhookController(webhookService)
+	notificationController := controllers.NewNotificationController(notificationService)
+	deviceController := controllers.NewDeviceController(deviceService)
+	telemetryController := controllers.NewTelemetryController(telemetryService)
+	reconciliationController := controllers.NewReconciliationController(reconciliationService)
+	displayController := controllers.NewDisplayController(displayService)
+	guestController := controllers.NewGuestController(guestService)
+	auditController := controllers.NewAuditController(auditService)
+	oidcController := controllers.NewOIDCController(oidcService)
+	organizationController := controllers.NewOrganizationController(organizationService)
+	vehicleClaimController := controllers.NewVehicleClaimController(vehicleClaimService, s3Client)
 
 	// Register routes
-	routes.RegisterRoutes(e, userController, authController, vehicleController, arduinoController, bookingController, walletController, parkingLocationController, userStatsController)
+	routes.RegisterRoutes(e, userController, authController, vehicleController, arduinoController, bookingController, walletController, parkingLocationController, userStatsController, streamController, webhookController, notificationController, deviceController, telemetryController, reconciliationController, displayController, guestController, auditController, oidcController, organizationController, vehicleClaimController)
+	if err := routes.Policy.Verify(e.Routes()); err != nil {
+		log.Fatalf("Route authorization is incomplete: %v", err)
+	}
 
 	// Protected routes group
 	protected := e.Group("/api")
-	protected.Use(customMiddleware.AuthMiddleware(jwtManager, userService))
+	protected.Use(customMiddleware.AuthMiddleware(jwtManager, userService, authSessionService, mfaService))
 
 	// health check route
 	e.GET("/health", func(c echo.Context) error {
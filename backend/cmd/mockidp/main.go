@@ -0,0 +1,259 @@
+// Command mockidp is a minimal OpenID provider for trying the OpenID Connect
+// sign in locally. It accepts any email address, with a form or straight away
+// when the authorization request carries a login_hint, and issues ES256
+// signed ID tokens. Point the backend at it with
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9000
+//	OIDC_MOCK_CLIENT_ID=parkme
+//	OIDC_MOCK_CLIENT_SECRET=parkme-secret
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dfanso/parkme-backend/pkg/auth"
+	"github.com/dfanso/parkme-backend/pkg/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const codeTTL = time.Minute
+
+type grant struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	name          string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+type server struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *ecdsa.PrivateKey
+	keyID        string
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Mock IdP sign in</title>
+<h1>Mock IdP</h1>
+<form method="post" action="/authorize">
+  {{range $name, $values := .Query}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">{{end}}{{end}}
+  <p><label>Email <input name="email" type="email" required></label></p>
+  <p><label>Name <input name="name"></label></p>
+  <p><label><input name="email_verified" type="checkbox" value="true" checked> Email verified</label></p>
+  <p><button type="submit">Sign in</button></p>
+</form>`))
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, as the backend reaches it")
+	clientID := flag.String("client-id", "parkme", "client ID the backend uses")
+	clientSecret := flag.String("client-secret", "parkme-secret", "client secret the backend uses")
+	flag.Parse()
+
+	key, err := auth.GenerateKey()
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+	s := &server{
+		issuer:       strings.TrimRight(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		keyID:        auth.KeyID(&key.PublicKey),
+		codes:        map[string]grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+
+	log.Printf("Mock IdP %s listening on %s", s.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func (s *server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"ES256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (s *server) jwks(w http.ResponseWriter, r *http.Request) {
+	jwk := auth.PublicJWK(&s.key.PublicKey)
+	jwk.KeyID = s.keyID
+	jwk.Use = "sig"
+	jwk.Algorithm = "ES256"
+	writeJSON(w, http.StatusOK, auth.JWKSet{Keys: []auth.JWK{jwk}})
+}
+
+// authorize shows the sign in form, or signs in the login_hint straight away,
+// and redirects back to the client with a code
+func (s *server) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	q := r.Form
+	if q.Get("client_id") != s.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	back := func(params url.Values) {
+		params.Set("state", q.Get("state"))
+		redirect.RawQuery = params.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	}
+	if q.Get("response_type") != "code" {
+		back(url.Values{"error": {"unsupported_response_type"}})
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		back(url.Values{"error": {"invalid_request"}, "error_description": {"PKCE with S256 is required"}})
+		return
+	}
+
+	email := q.Get("email")
+	verified := q.Get("email_verified") == "true"
+	if email == "" && q.Get("login_hint") != "" {
+		email, verified = q.Get("login_hint"), true
+	}
+	if email == "" || r.Method != http.MethodPost && q.Get("login_hint") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, map[string]url.Values{"Query": r.URL.Query()})
+		return
+	}
+
+	code, err := oidc.RandomString(24)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	s.codes[code] = grant{
+		clientID:      s.clientID,
+		redirectURI:   q.Get("redirect_uri"),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		email:         email,
+		name:          q.Get("name"),
+		emailVerified: verified,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	s.mu.Unlock()
+	back(url.Values{"code": {code}})
+}
+
+// token redeems a code for an ID token after checking the client and PKCE
+func (s *server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "expected a form POST")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(s.clientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	s.mu.Lock()
+	g, found := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+	switch {
+	case !found || time.Now().After(g.expiresAt):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	case g.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match")
+		return
+	case oidc.CodeChallengeS256(r.PostForm.Get("code_verifier")) != g.codeChallenge:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	sum := sha256.Sum256([]byte(strings.ToLower(g.email)))
+	claims := jwt.MapClaims{
+		"iss":            s.issuer,
+		"sub":            "mock-" + hex.EncodeToString(sum[:8]),
+		"aud":            g.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.email,
+		"email_verified": g.emailVerified,
+	}
+	if g.name != "" {
+		claims["name"] = g.name
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	idToken.Header["kid"] = s.keyID
+	signed, err := idToken.SignedString(s.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	accessToken, _ := oidc.RandomString(24)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
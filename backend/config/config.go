@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
@@ -10,14 +13,14 @@ import (
 
 type Config struct {
 	Server struct {
-		Port string
+		Port           string
+		TrustedProxies []string // CIDRs or IPs of reverse proxies allowed to set X-Forwarded-For
 	}
 	MongoDB struct {
 		URI  string
 		NAME string
 	}
-	ARDUNIO_API_KEY string
-	AWS             struct {
+	AWS struct {
 		Region          string
 		AccessKeyID     string
 		SecretAccessKey string
@@ -26,6 +29,106 @@ type Config struct {
 		APIKey string
 	}
 	S3BucketName string `mapstructure:"S3_BUCKET_NAME"`
+	Auth         struct {
+		AccessTokenTTL  time.Duration
+		RefreshTokenTTL time.Duration
+	}
+	Login struct {
+		FailureWindow      time.Duration
+		FreeAttempts       int
+		BaseDelay          time.Duration
+		MaxDelay           time.Duration
+		LockoutThreshold   int
+		LockoutDuration    time.Duration
+		IPLockoutThreshold int
+	}
+	MFA struct {
+		Issuer        string
+		RequiredRoles []string
+		ChallengeTTL  time.Duration
+		MaxAttempts   int
+		RecoveryCodes int
+	}
+	OIDC struct {
+		CallbackBaseURL string
+		RedirectURL     string
+		StateTTL        time.Duration
+		Providers       []OIDCProvider
+	}
+	Account struct {
+		AppURL           string
+		VerificationTTL  time.Duration
+		PasswordResetTTL time.Duration
+	}
+	Mail struct {
+		Driver string
+		From   string
+		Dir    string
+	}
+	JWT struct {
+		Issuer           string
+		InitialKeyFile   string
+		KeyEncryptionKey string
+		RotateEvery      time.Duration
+		PublishAhead     time.Duration
+		KeyCheckInterval time.Duration
+	}
+	Webhook struct {
+		MaxAttempts int
+		Timeout     time.Duration
+	}
+	Notification struct {
+		ReminderLead time.Duration
+		ScanInterval time.Duration
+	}
+	Sensor struct {
+		ReadingRetention time.Duration
+	}
+	Device struct {
+		OfflineAfter        time.Duration
+		HealthCheckInterval time.Duration
+	}
+	Telemetry struct {
+		Retention       time.Duration
+		RollupRetention time.Duration
+		AlertRetention  time.Duration
+		RollupInterval  time.Duration
+	}
+	Reconciliation struct {
+		Interval time.Duration
+		Grace    time.Duration
+		Reassign bool
+	}
+	Gate struct {
+		DedupeWindow time.Duration
+	}
+	Guest struct {
+		Enabled bool
+		PayURL  string
+	}
+	Display struct {
+		ReservationHold time.Duration
+	}
+	MQTT struct {
+		Enabled     bool
+		BrokerURL   string
+		ClientID    string
+		Username    string
+		Password    string
+		TopicPrefix string
+	}
+}
+
+// OIDCProvider is a company or social identity provider users can sign in with
+type OIDCProvider struct {
+	Name           string // Used in the login URL
+	DisplayName    string
+	Issuer         string
+	ClientID       string
+	ClientSecret   string
+	Scopes         []string
+	AllowedDomains []string // Email domains that may sign in, empty for any
+	AutoCreate     bool     // Create users on their first sign in
 }
 
 func Load() *Config {
@@ -36,14 +139,12 @@ func Load() *Config {
 
 	// Server configuration
 	cfg.Server.Port = getEnv("SERVER_PORT", "8080")
+	cfg.Server.TrustedProxies = getEnvList("TRUSTED_PROXIES", nil)
 
 	// MongoDB configuration
 	cfg.MongoDB.URI = getEnv("MONGODB_URI", "mongodb://localhost:27017")
 	cfg.MongoDB.NAME = getEnv("MONGODB_NAME", "Test")
 
-	// Arduino API key
-	cfg.ARDUNIO_API_KEY = getEnv("ARDUNIO_API_KEY", "1234")
-
 	// AWS configuration
 	cfg.AWS.Region = getEnv("AWS_REGION", "us-east-1")
 	cfg.AWS.AccessKeyID = getEnv("AWS_ACCESS_KEY_ID", "")
@@ -52,6 +153,120 @@ func Load() *Config {
 	// S3 bucket configuration
 	cfg.S3BucketName = getEnv("S3_BUCKET_NAME", "parkme-uploads")
 
+	// Access tokens are short lived, the session's refresh token renews them
+	// and expires after RefreshTokenTTL without use
+	cfg.Auth.AccessTokenTTL = getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	cfg.Auth.RefreshTokenTTL = getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+	// Failed logins are counted per email and per IP address within the window.
+	// After the free attempts each failure doubles the wait for the account,
+	// and reaching a lockout threshold locks the account or IP address
+	cfg.Login.FailureWindow = getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	cfg.Login.FreeAttempts = getEnvInt("LOGIN_FREE_ATTEMPTS", 3)
+	cfg.Login.BaseDelay = getEnvDuration("LOGIN_BASE_DELAY", time.Second)
+	cfg.Login.MaxDelay = getEnvDuration("LOGIN_MAX_DELAY", 5*time.Minute)
+	cfg.Login.LockoutThreshold = getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10)
+	cfg.Login.LockoutDuration = getEnvDuration("LOGIN_LOCKOUT_DURATION", 30*time.Minute)
+	cfg.Login.IPLockoutThreshold = getEnvInt("LOGIN_IP_LOCKOUT_THRESHOLD", 100)
+
+	// Two-factor authentication with authenticator apps. Users of the required
+	// roles can only manage their account until they enrol. A login challenge
+	// accepts MaxAttempts codes before the password has to be entered again
+	cfg.MFA.Issuer = getEnv("MFA_ISSUER", "ParkMe")
+	cfg.MFA.RequiredRoles = getEnvList("MFA_REQUIRED_ROLES", []string{"admin"})
+	cfg.MFA.ChallengeTTL = getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute)
+	cfg.MFA.MaxAttempts = getEnvInt("MFA_MAX_ATTEMPTS", 5)
+	cfg.MFA.RecoveryCodes = getEnvInt("MFA_RECOVERY_CODES", 10)
+
+	// OpenID Connect sign in. OIDC_PROVIDERS lists provider names, each set up
+	// with OIDC_<NAME>_* variables. The provider redirects back to the callback
+	// under OIDC_CALLBACK_BASE_URL, which then sends the browser on to
+	// OIDC_REDIRECT_URL with the tokens in the URL fragment
+	cfg.OIDC.CallbackBaseURL = getEnv("OIDC_CALLBACK_BASE_URL", "http://localhost:"+cfg.Server.Port)
+	cfg.OIDC.RedirectURL = getEnv("OIDC_REDIRECT_URL", getEnv("APP_URL", "http://localhost:3000")+"/auth/callback")
+	cfg.OIDC.StateTTL = getEnvDuration("OIDC_STATE_TTL", 10*time.Minute)
+	for _, name := range getEnvList("OIDC_PROVIDERS", nil) {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg.OIDC.Providers = append(cfg.OIDC.Providers, OIDCProvider{
+			Name:           name,
+			DisplayName:    getEnv(prefix+"DISPLAY_NAME", name),
+			Issuer:         getEnv(prefix+"ISSUER", ""),
+			ClientID:       getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret:   getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:         getEnvList(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+			AllowedDomains: getEnvList(prefix+"ALLOWED_DOMAINS", nil),
+			AutoCreate:     getEnvBool(prefix+"AUTO_CREATE", true),
+		})
+	}
+
+	// Email verification and password reset links point at the app and can
+	// be used once before they expire
+	cfg.Account.AppURL = getEnv("APP_URL", "http://localhost:3000")
+	cfg.Account.VerificationTTL = getEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	cfg.Account.PasswordResetTTL = getEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+
+	// Account emails are logged by the console driver or written as .eml
+	// files into MAIL_DIR by the file driver
+	cfg.Mail.Driver = getEnv("MAIL_DRIVER", "console")
+	cfg.Mail.From = getEnv("MAIL_FROM", "ParkMe <no-reply@parkme.local>")
+	cfg.Mail.Dir = getEnv("MAIL_DIR", "tmp/mail")
+
+	// Tokens are signed with a key set that rotates every RotateEvery. New keys
+	// appear in the JWKS PublishAhead before they sign, old keys verify until
+	// the last token they signed expires. An existing PEM key seeds an empty set.
+	// Stored private keys are encrypted with JWT_KEY_ENCRYPTION_KEY, 32 random
+	// bytes in base64 (openssl rand -base64 32)
+	cfg.JWT.Issuer = getEnv("JWT_ISSUER", "parkme")
+	cfg.JWT.InitialKeyFile = getEnv("JWT_INITIAL_KEY_FILE", "keys/private.pem")
+	cfg.JWT.KeyEncryptionKey = getEnv("JWT_KEY_ENCRYPTION_KEY", "")
+	cfg.JWT.RotateEvery = getEnvDuration("JWT_ROTATE_EVERY", 30*24*time.Hour)
+	cfg.JWT.PublishAhead = getEnvDuration("JWT_PUBLISH_AHEAD", time.Hour)
+	cfg.JWT.KeyCheckInterval = getEnvDuration("JWT_KEY_CHECK_INTERVAL", time.Minute)
+
+	// Webhook delivery configuration
+	cfg.Webhook.MaxAttempts = getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8)
+	cfg.Webhook.Timeout = getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+
+	// Notification configuration
+	cfg.Notification.ReminderLead = getEnvDuration("NOTIFICATION_REMINDER_LEAD", 15*time.Minute)
+	cfg.Notification.ScanInterval = getEnvDuration("NOTIFICATION_SCAN_INTERVAL", time.Minute)
+
+	// Raw slot sensor readings are kept for calibration, then expire
+	cfg.Sensor.ReadingRetention = getEnvDuration("SENSOR_READING_RETENTION", 7*24*time.Hour)
+
+	// Devices that have not called in for OfflineAfter are reported offline
+	cfg.Device.OfflineAfter = getEnvDuration("DEVICE_OFFLINE_AFTER", 3*time.Minute)
+	cfg.Device.HealthCheckInterval = getEnvDuration("DEVICE_HEALTH_CHECK_INTERVAL", 30*time.Second)
+
+	// Raw telemetry is downsampled into hourly rollups that are kept longer
+	cfg.Telemetry.Retention = getEnvDuration("TELEMETRY_RETENTION", 7*24*time.Hour)
+	cfg.Telemetry.RollupRetention = getEnvDuration("TELEMETRY_ROLLUP_RETENTION", 365*24*time.Hour)
+	cfg.Telemetry.AlertRetention = getEnvDuration("DEVICE_ALERT_RETENTION", 90*24*time.Hour)
+	cfg.Telemetry.RollupInterval = getEnvDuration("TELEMETRY_ROLLUP_INTERVAL", 15*time.Minute)
+
+	// Occupancy reconciliation alerts on mismatches that persist beyond the grace period
+	cfg.Reconciliation.Interval = getEnvDuration("RECONCILE_INTERVAL", time.Minute)
+	cfg.Reconciliation.Grace = getEnvDuration("RECONCILE_GRACE", 5*time.Minute)
+	cfg.Reconciliation.Reassign = getEnvBool("RECONCILE_REASSIGN", false)
+
+	// Repeated gate triggers for the same plate within the window get the previous decision
+	cfg.Gate.DedupeWindow = getEnvDuration("GATE_DEDUPE_WINDOW", 30*time.Second)
+
+	// Unregistered plates enter as guests and pay at exit with the code shown on the gate
+	cfg.Guest.Enabled = getEnvBool("GUEST_ENABLED", true)
+	cfg.Guest.PayURL = getEnv("GUEST_PAY_URL", "http://localhost:3000/pay/")
+
+	// Displays stop counting a reserved slot as free this long before the reservation starts
+	cfg.Display.ReservationHold = getEnvDuration("DISPLAY_RESERVATION_HOLD", 15*time.Minute)
+
+	// Optional MQTT gateway for the parking hardware, alongside the HTTP routes
+	cfg.MQTT.Enabled = getEnvBool("MQTT_ENABLED", false)
+	cfg.MQTT.BrokerURL = getEnv("MQTT_BROKER_URL", "tcp://localhost:1883")
+	cfg.MQTT.ClientID = getEnv("MQTT_CLIENT_ID", "parkme-backend")
+	cfg.MQTT.Username = getEnv("MQTT_USERNAME", "")
+	cfg.MQTT.Password = getEnv("MQTT_PASSWORD", "")
+	cfg.MQTT.TopicPrefix = getEnv("MQTT_TOPIC_PREFIX", "parkme")
+
 	return cfg
 }
 
@@ -63,6 +278,49 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList reads a comma separated list. Set the variable to "none" for an
+// empty list
+func getEnvList(key string, defaultValue []string) []string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	if value == "none" {
+		return nil
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 func LoadConfig() (*Config, error) {
 	viper.SetConfigFile(".env")
 	viper.AutomaticEnv()
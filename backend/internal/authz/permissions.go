@@ -0,0 +1,91 @@
+package authz
+
+import "github.com/dfanso/parkme-backend/internal/models"
+
+// Permission names an action a role may perform
+type Permission string
+
+const (
+	// PermAccount covers a caller's own profile, sessions and notification
+	// settings. Unverified accounts only get this
+	PermAccount Permission = "account:manage"
+	// PermSelfService covers a caller's own vehicles, bookings, wallet and
+	// guest payments. Handlers check ownership of the resource
+	PermSelfService Permission = "self:service"
+
+	PermUsersManage        Permission = "users:manage"
+	PermLocationsView      Permission = "locations:view"
+	PermLocationsManage    Permission = "locations:manage"
+	PermLocationsConfigure Permission = "locations:configure"
+	PermSlotsOverride      Permission = "slots:override"
+	PermOccupancyMonitor   Permission = "occupancy:monitor"
+	PermGuestsSettle       Permission = "guests:settle"
+	PermVehiclesView       Permission = "vehicles:view"
+	PermVehiclesManage     Permission = "vehicles:manage"
+	PermBookingsView       Permission = "bookings:view"
+	PermBookingsManage     Permission = "bookings:manage"
+	PermDevicesManage      Permission = "devices:manage"
+	PermWebhooksManage     Permission = "webhooks:manage"
+	PermAuditView          Permission = "audit:view"
+	// PermOrganizationsManage overrides fleet manager membership of any
+	// organization. Members are checked in the handler
+	PermOrganizationsManage Permission = "organizations:manage"
+	// PermVehiclesVerify reviews registration documents and plate disputes
+	PermVehiclesVerify Permission = "vehicles:verify"
+)
+
+var attendantPermissions = []Permission{
+	PermAccount,
+	PermSelfService,
+	PermLocationsView,
+	PermSlotsOverride,
+	PermOccupancyMonitor,
+	PermGuestsSettle,
+	PermVehiclesView,
+	PermBookingsView,
+}
+
+// rolePermissions lists what each role may do. Admins may do everything,
+// site operators and attendants only at the locations they are assigned to
+var rolePermissions = map[models.Role][]Permission{
+	models.RoleUser: {
+		PermAccount,
+		PermSelfService,
+		PermLocationsView,
+	},
+	models.RoleAttendant: attendantPermissions,
+	models.RoleSiteOperator: append([]Permission{
+		PermLocationsConfigure,
+		PermBookingsManage,
+	}, attendantPermissions...),
+}
+
+// unverifiedPermissions is all a user may do before verifying their email
+var unverifiedPermissions = []Permission{
+	PermAccount,
+	PermLocationsView,
+}
+
+// RoleCan reports whether the role grants the permission
+func RoleCan(role models.Role, perm Permission) bool {
+	if role == models.RoleAdmin {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// UnverifiedCan reports whether a user pending email verification may use
+// the permission. Their role's other permissions apply once verified
+func UnverifiedCan(perm Permission) bool {
+	for _, p := range unverifiedPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
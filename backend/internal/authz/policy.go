@@ -0,0 +1,102 @@
+package authz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Access says who may reach a route before permissions are considered
+type Access int
+
+const (
+	// AccessUser routes need a signed in user holding the rule's permission
+	AccessUser Access = iota
+	// AccessPublic routes need no credentials
+	AccessPublic
+	// AccessDevice routes are called by Arduino modules with device credentials
+	AccessDevice
+)
+
+// Rule is the access requirement of a single route
+type Rule struct {
+	Access     Access
+	Permission Permission
+	// Location names the path parameter holding a location ID. Scoped staff
+	// must be assigned to that location
+	Location string
+	// MFA routes need a session that signed in with a second factor
+	MFA bool
+}
+
+// Public is the rule for routes open to anyone
+func Public() Rule {
+	return Rule{Access: AccessPublic}
+}
+
+// Device is the rule for routes called by authenticated devices
+func Device() Rule {
+	return Rule{Access: AccessDevice}
+}
+
+// Allow is the rule for routes that need the permission
+func Allow(perm Permission) Rule {
+	return Rule{Access: AccessUser, Permission: perm}
+}
+
+// AllowAt is the rule for routes that need the permission at the location
+// named by the path parameter
+func AllowAt(perm Permission, param string) Rule {
+	return Rule{Access: AccessUser, Permission: perm, Location: param}
+}
+
+// WithMFA returns the rule for a sensitive route that also needs a session
+// signed in with a second factor
+func (r Rule) WithMFA() Rule {
+	r.MFA = true
+	return r
+}
+
+// Policy maps "METHOD /path" route keys to their rules
+type Policy map[string]Rule
+
+// Key builds the policy key of a route
+func Key(method, path string) string {
+	return method + " " + path
+}
+
+// Lookup returns the rule of a route. Routes without a rule are denied
+func (p Policy) Lookup(method, path string) (Rule, bool) {
+	rule, ok := p[Key(method, path)]
+	return rule, ok
+}
+
+// Verify checks that every API route has a rule and every rule belongs to a
+// registered route, so routes cannot be added without deciding who may call them
+func (p Policy) Verify(routes []*echo.Route) error {
+	var problems []string
+	registered := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		// Groups with middleware register catch-all not found routes
+		if route.Method == echo.RouteNotFound || !strings.HasPrefix(route.Path, "/api") {
+			continue
+		}
+		key := Key(route.Method, route.Path)
+		registered[key] = true
+		if _, ok := p[key]; !ok {
+			problems = append(problems, "no rule for "+key)
+		}
+	}
+	for key := range p {
+		if !registered[key] {
+			problems = append(problems, "rule for unregistered route "+key)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("authorization policy: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
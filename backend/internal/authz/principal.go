@@ -0,0 +1,89 @@
+package authz
+
+import (
+	"errors"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrForbidden = errors.New("you are not authorized to access this resource")
+
+// Principal is the authenticated user a request acts for
+type Principal struct {
+	UserID      primitive.ObjectID
+	Role        models.Role
+	LocationIDs []primitive.ObjectID
+	Status      string
+	// MFA is set when the session signed in with a second factor, MFARequired
+	// when the user's role must
+	MFA         bool
+	MFARequired bool
+}
+
+// FromContext returns the principal stored by AuthMiddleware
+func FromContext(c echo.Context) Principal {
+	p := Principal{}
+	p.UserID, _ = c.Get("userID").(primitive.ObjectID)
+	role, _ := c.Get("userRole").(string)
+	p.Role = models.Role(role)
+	p.LocationIDs, _ = c.Get("userLocations").([]primitive.ObjectID)
+	p.Status, _ = c.Get("userStatus").(string)
+	p.MFA, _ = c.Get("mfa").(bool)
+	p.MFARequired, _ = c.Get("mfaRequired").(bool)
+	return p
+}
+
+// NeedsMFA reports whether the user must pass a second factor before using
+// anything but their account, which is where they enrol
+func (p Principal) NeedsMFA() bool {
+	return p.MFARequired && !p.MFA
+}
+
+func (p Principal) Can(perm Permission) bool {
+	if p.NeedsMFA() && perm != PermAccount {
+		return false
+	}
+	if p.Status == models.UserStatusPendingVerification && !UnverifiedCan(perm) {
+		return false
+	}
+	return RoleCan(p.Role, perm)
+}
+
+// IsGlobal reports whether the principal is not limited to assigned locations
+func (p Principal) IsGlobal() bool {
+	return p.Role == models.RoleAdmin
+}
+
+// CanAccessLocation reports whether staff permissions apply at the location
+func (p Principal) CanAccessLocation(locationID primitive.ObjectID) bool {
+	if p.IsGlobal() {
+		return true
+	}
+	for _, id := range p.LocationIDs {
+		if id == locationID {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAt reports whether the principal holds the permission at the location
+func (p Principal) CanAt(perm Permission, locationID primitive.ObjectID) bool {
+	return p.Can(perm) && p.CanAccessLocation(locationID)
+}
+
+// CanActFor reports whether the principal owns a resource or holds the
+// permission that overrides ownership
+func (p Principal) CanActFor(ownerID primitive.ObjectID, override Permission) bool {
+	return p.UserID == ownerID || p.Can(override)
+}
+
+// CheckOwner returns ErrForbidden unless the principal may act for the owner
+func (p Principal) CheckOwner(ownerID primitive.ObjectID, override Permission) error {
+	if !p.CanActFor(ownerID, override) {
+		return ErrForbidden
+	}
+	return nil
+}
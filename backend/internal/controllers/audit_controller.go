@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditController struct {
+	service *services.AuditService
+}
+
+func NewAuditController(service *services.AuditService) *AuditController {
+	return &AuditController{
+		service: service,
+	}
+}
+
+// GetEntries lists audit entries, newest first, filtered by ?action=,
+// ?user_id=, ?from= and ?to= (RFC3339) and capped by ?limit=
+func (c *AuditController) GetEntries(ctx echo.Context) error {
+	query := services.AuditQuery{
+		Action: models.AuditAction(ctx.QueryParam("action")),
+	}
+
+	if value := ctx.QueryParam("user_id"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid user_id", err)
+		}
+		query.UserID = &id
+	}
+
+	for name, target := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+		value := ctx.QueryParam(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid "+name+", expected RFC3339", err)
+		}
+		*target = &t
+	}
+
+	if value := ctx.QueryParam("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid limit", err)
+		}
+		query.Limit = limit
+	}
+
+	entries, err := c.service.GetEntries(ctx.Request().Context(), query)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get audit entries", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Audit entries retrieved successfully", entries)
+}
@@ -1,7 +1,11 @@
 package controllers
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/dfanso/parkme-backend/internal/dto"
 	"github.com/dfanso/parkme-backend/internal/models"
@@ -16,16 +20,24 @@ import (
 )
 
 type AuthController struct {
-	userService *services.UserService
-	jwtManager  *auth.JWTManager
-	s3Client    *s3.S3Client
+	userService    *services.UserService
+	sessionService *services.AuthSessionService
+	accountService *services.AccountService
+	loginThrottle  *services.LoginThrottleService
+	mfaService     *services.MFAService
+	jwtManager     *auth.JWTManager
+	s3Client       *s3.S3Client
 }
 
-func NewAuthController(userService *services.UserService, jwtManager *auth.JWTManager, s3Client *s3.S3Client) *AuthController {
+func NewAuthController(userService *services.UserService, sessionService *services.AuthSessionService, accountService *services.AccountService, loginThrottle *services.LoginThrottleService, mfaService *services.MFAService, jwtManager *auth.JWTManager, s3Client *s3.S3Client) *AuthController {
 	return &AuthController{
-		userService: userService,
-		jwtManager:  jwtManager,
-		s3Client:    s3Client,
+		userService:    userService,
+		sessionService: sessionService,
+		accountService: accountService,
+		loginThrottle:  loginThrottle,
+		mfaService:     mfaService,
+		jwtManager:     jwtManager,
+		s3Client:       s3Client,
 	}
 }
 
@@ -34,6 +46,38 @@ func (c *AuthController) GetJWTManager() *auth.JWTManager {
 	return c.jwtManager
 }
 
+// GetJWKS publishes the public keys that verify access tokens, so other
+// services can check ParkMe tokens without calling this API
+func (c *AuthController) GetJWKS(ctx echo.Context) error {
+	ctx.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return ctx.JSON(http.StatusOK, c.jwtManager.JWKS())
+}
+
+// GetSessionService returns the session service instance
+func (c *AuthController) GetSessionService() *services.AuthSessionService {
+	return c.sessionService
+}
+
+// GetMFAService returns the two-factor authentication service instance
+func (c *AuthController) GetMFAService() *services.MFAService {
+	return c.mfaService
+}
+
+func (c *AuthController) sessionError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrAccountInactive):
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "Account is not active", err)
+	case errors.Is(err, services.ErrInvalidRefreshToken),
+		errors.Is(err, services.ErrSessionExpired),
+		errors.Is(err, services.ErrRefreshTokenReused):
+		return utils.ErrorResponse(ctx, http.StatusUnauthorized, err.Error(), err)
+	case errors.Is(err, services.ErrSessionNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Session not found", err)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err)
+	}
+}
+
 func (c *AuthController) Login(ctx echo.Context) error {
 	var credentials struct {
 		Email    string `json:"email"`
@@ -44,31 +88,67 @@ func (c *AuthController) Login(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 	}
 
-	// Find user by email using FindOne
-	user, err := c.userService.FindOne(ctx.Request().Context(), bson.M{"email": credentials.Email})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid credentials")
+	// Refuse early while the account or address is throttled
+	reqCtx := ctx.Request().Context()
+	if wait, err := c.loginThrottle.Check(reqCtx, credentials.Email, ctx.RealIP()); err != nil {
+		if errors.Is(err, services.ErrLoginThrottled) || errors.Is(err, services.ErrAccountLocked) {
+			ctx.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check login attempts")
 	}
 
-	if user == nil {
+	// Find user by email using FindOne
+	user, err := c.userService.FindOne(reqCtx, bson.M{"email": credentials.Email})
+	if err != nil || user == nil {
+		c.recordLoginFailure(ctx, credentials.Email, nil)
 		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid credentials")
 	}
 
 	// Compare password
 	if err := user.ComparePassword(credentials.Password); err != nil {
+		c.recordLoginFailure(ctx, credentials.Email, &user.ID)
 		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid credentials")
 	}
 
-	// Generate JWT token with correct arguments
-	token, err := c.jwtManager.GenerateToken(user.ID, string(user.Role))
+	// Accounts with two-factor authentication finish signing in with a code.
+	// Failed attempts are only forgotten once the code checks out
+	mfaEnabled, err := c.mfaService.Enabled(reqCtx, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check two-factor authentication")
+	}
+	if mfaEnabled {
+		if err := services.CheckUserCanSignIn(user); err != nil {
+			return echo.NewHTTPError(http.StatusForbidden, "Account is not active")
+		}
+		challenge, err := c.mfaService.StartChallenge(reqCtx, user)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start two-factor authentication")
+		}
+		return ctx.JSON(http.StatusOK, challenge)
+	}
+	if err := c.loginThrottle.RecordSuccess(reqCtx, credentials.Email); err != nil {
+		log.Printf("login: failed to clear failed attempts for user %s: %v", user.ID.Hex(), err)
+	}
+
+	// Start a session with an access token and a refresh token
+	tokens, err := c.sessionService.SignIn(reqCtx, user, ctx.Request().UserAgent(), ctx.RealIP(), false)
 	if err != nil {
+		if errors.Is(err, services.ErrAccountInactive) {
+			return echo.NewHTTPError(http.StatusForbidden, "Account is not active")
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate token")
 	}
 
-	return ctx.JSON(http.StatusOK, map[string]interface{}{
-		"token": token,
-		"user":  user,
-	})
+	return ctx.JSON(http.StatusOK, tokens)
+}
+
+// recordLoginFailure counts a failed login. The caller still gets the
+// invalid credentials response if counting fails
+func (c *AuthController) recordLoginFailure(ctx echo.Context, email string, userID *primitive.ObjectID) {
+	if err := c.loginThrottle.RecordFailure(ctx.Request().Context(), email, ctx.RealIP(), userID); err != nil {
+		log.Printf("login: failed to record failed attempt: %v", err)
+	}
 }
 
 func (c *AuthController) Register(ctx echo.Context) error {
@@ -90,7 +170,7 @@ func (c *AuthController) Register(ctx echo.Context) error {
 		Email:    req.Email,
 		Password: req.Password,
 		Role:     models.RoleUser,
-		Status:   models.UserStatusActive,
+		Status:   models.UserStatusPendingVerification,
 	}
 	if err := user.BeforeCreate(); err != nil {
 		// Handle validation errors specifically
@@ -104,19 +184,278 @@ func (c *AuthController) Register(ctx echo.Context) error {
 		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to create user", err)
 	}
 
-	// Generate JWT token
-	token, err := c.jwtManager.GenerateToken(user.ID, string(user.Role))
+	// A failed email does not fail the sign up, a new link can be requested
+	if err := c.accountService.SendVerification(ctx.Request().Context(), user); err != nil {
+		log.Printf("register: failed to send verification email to user %s: %v", user.ID.Hex(), err)
+	}
+
+	// Sign the new user in, with limited access until the email is verified
+	tokens, err := c.sessionService.SignIn(ctx.Request().Context(), user, ctx.Request().UserAgent(), ctx.RealIP(), false)
+	if err != nil {
+		return c.sessionError(ctx, err, "Failed to generate token")
+	}
+
+	return utils.SuccessResponse(ctx, http.StatusOK, "Login successful", tokens)
+}
+
+func (c *AuthController) accountError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidAccountToken):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, services.ErrEmailAlreadyVerified):
+		return utils.ErrorResponse(ctx, http.StatusConflict, err.Error(), err)
+	default:
+		if e, ok := err.(validation.Error); ok {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Validation failed", e)
+		}
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err)
+	}
+}
+
+// VerifyEmail redeems the link sent after sign up
+func (c *AuthController) VerifyEmail(ctx echo.Context) error {
+	var req dto.VerifyEmailRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	user, err := c.accountService.VerifyEmail(ctx.Request().Context(), req.Token)
+	if err != nil {
+		return c.accountError(ctx, err, "Failed to verify email")
+	}
+	user.Password = ""
+	return utils.SuccessResponse(ctx, http.StatusOK, "Email verified successfully", user)
+}
+
+// ResendVerification emails the signed in user a new verification link
+func (c *AuthController) ResendVerification(ctx echo.Context) error {
+	userID := ctx.Get("userID").(primitive.ObjectID)
+	user, err := c.userService.GetByID(ctx.Request().Context(), userID)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
+	}
+
+	if err := c.accountService.SendVerification(ctx.Request().Context(), user); err != nil {
+		return c.accountError(ctx, err, "Failed to send verification email")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Verification email sent", nil)
+}
+
+// ForgotPassword emails a reset link. The response is the same whether or
+// not the address has an account
+func (c *AuthController) ForgotPassword(ctx echo.Context) error {
+	var req dto.ForgotPasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+	if req.Email == "" {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Email is required", nil)
+	}
+
+	if err := c.accountService.RequestPasswordReset(ctx.Request().Context(), req.Email); err != nil {
+		return c.accountError(ctx, err, "Failed to send password reset email")
+	}
+	return utils.SuccessResponse(ctx, http.StatusAccepted, "If the address has an account, a reset link has been sent", nil)
+}
+
+// ResetPassword sets a new password with a reset link and signs out every session
+func (c *AuthController) ResetPassword(ctx echo.Context) error {
+	var req dto.ResetPasswordRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	if err := c.accountService.ResetPassword(ctx.Request().Context(), req.Token, req.Password); err != nil {
+		return c.accountError(ctx, err, "Failed to reset password")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Password reset successfully, please log in again", nil)
+}
+
+func (c *AuthController) mfaError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode),
+		errors.Is(err, services.ErrMFANotEnrolling):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, services.ErrInvalidMFAToken),
+		errors.Is(err, services.ErrMFAAttemptsExceeded):
+		return utils.ErrorResponse(ctx, http.StatusUnauthorized, err.Error(), err)
+	case errors.Is(err, services.ErrMFAAlreadyEnabled),
+		errors.Is(err, services.ErrMFANotEnabled):
+		return utils.ErrorResponse(ctx, http.StatusConflict, err.Error(), err)
+	default:
+		return c.sessionError(ctx, err, message)
+	}
+}
+
+// currentUser loads the signed in user
+func (c *AuthController) currentUser(ctx echo.Context) (*models.User, error) {
+	return c.userService.GetByID(ctx.Request().Context(), ctx.Get("userID").(primitive.ObjectID))
+}
+
+// VerifyMFA completes a login that asked for a second factor, with a TOTP
+// code or a recovery code
+func (c *AuthController) VerifyMFA(ctx echo.Context) error {
+	var req dto.MFAVerifyRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	tokens, err := c.mfaService.CompleteChallenge(ctx.Request().Context(), req.MFAToken, req.Code, ctx.Request().UserAgent(), ctx.RealIP())
+	if err != nil {
+		return c.mfaError(ctx, err, "Failed to verify two-factor code")
+	}
+	return ctx.JSON(http.StatusOK, tokens)
+}
+
+func (c *AuthController) GetMFAStatus(ctx echo.Context) error {
+	user, err := c.currentUser(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
+	}
+
+	status, err := c.mfaService.GetStatus(ctx.Request().Context(), user)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get two-factor status", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Two-factor status retrieved successfully", status)
+}
+
+// BeginMFAEnrolment returns a new secret and its provisioning URI for the
+// user's authenticator app
+func (c *AuthController) BeginMFAEnrolment(ctx echo.Context) error {
+	user, err := c.currentUser(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
+	}
+
+	enrolment, err := c.mfaService.BeginEnrolment(ctx.Request().Context(), user)
+	if err != nil {
+		return c.mfaError(ctx, err, "Failed to start two-factor enrolment")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Scan the code with your authenticator app and confirm with a code", enrolment)
+}
+
+// ConfirmMFAEnrolment enables two-factor authentication and returns the
+// recovery codes. Refreshing the session then gives tokens with the mfa claim
+func (c *AuthController) ConfirmMFAEnrolment(ctx echo.Context) error {
+	var req dto.MFACodeRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+	user, err := c.currentUser(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
+	}
+
+	sessionID := ctx.Get("sessionID").(primitive.ObjectID)
+	codes, err := c.mfaService.ConfirmEnrolment(ctx.Request().Context(), user, sessionID, req.Code)
+	if err != nil {
+		return c.mfaError(ctx, err, "Failed to enable two-factor authentication")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Two-factor authentication enabled, store the recovery codes safely",
+		dto.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableMFA turns two-factor authentication off with a current code
+func (c *AuthController) DisableMFA(ctx echo.Context) error {
+	var req dto.MFACodeRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+	user, err := c.currentUser(ctx)
 	if err != nil {
-		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to generate token", err)
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
 	}
-	user.Password = "" // Clear password
 
-	return utils.SuccessResponse(ctx, http.StatusOK, "Login successful", dto.LoginResponse{
-		Token: token,
-		User:  user,
+	if err := c.mfaService.Disable(ctx.Request().Context(), user, req.Code); err != nil {
+		return c.mfaError(ctx, err, "Failed to disable two-factor authentication")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Two-factor authentication disabled", nil)
+}
+
+// RenewRecoveryCodes replaces the recovery codes, checked with a TOTP code
+func (c *AuthController) RenewRecoveryCodes(ctx echo.Context) error {
+	var req dto.MFACodeRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+	user, err := c.currentUser(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
+	}
+
+	codes, err := c.mfaService.RenewRecoveryCodes(ctx.Request().Context(), user, req.Code)
+	if err != nil {
+		return c.mfaError(ctx, err, "Failed to renew recovery codes")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Recovery codes renewed", dto.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Refresh exchanges a refresh token for new tokens. The old refresh token
+// stops working
+func (c *AuthController) Refresh(ctx echo.Context) error {
+	var req dto.RefreshRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+	if req.RefreshToken == "" {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Refresh token is required", nil)
+	}
+
+	tokens, err := c.sessionService.Refresh(ctx.Request().Context(), req.RefreshToken, ctx.Request().UserAgent(), ctx.RealIP())
+	if err != nil {
+		return c.sessionError(ctx, err, "Failed to refresh token")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Token refreshed successfully", tokens)
+}
+
+// Logout signs out the session the request was made with
+func (c *AuthController) Logout(ctx echo.Context) error {
+	userID := ctx.Get("userID").(primitive.ObjectID)
+	sessionID := ctx.Get("sessionID").(primitive.ObjectID)
+	if err := c.sessionService.SignOut(ctx.Request().Context(), userID, sessionID); err != nil {
+		return c.sessionError(ctx, err, "Failed to log out")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Logged out successfully", nil)
+}
+
+// LogoutAll signs the user out of every session, including this one
+func (c *AuthController) LogoutAll(ctx echo.Context) error {
+	userID := ctx.Get("userID").(primitive.ObjectID)
+	revoked, err := c.sessionService.RevokeAll(ctx.Request().Context(), userID, models.SessionRevokedLogoutAll)
+	if err != nil {
+		return c.sessionError(ctx, err, "Failed to log out")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Logged out of all sessions", map[string]int64{
+		"revoked": revoked,
 	})
 }
 
+// GetSessions lists the devices the user is signed in on
+func (c *AuthController) GetSessions(ctx echo.Context) error {
+	userID := ctx.Get("userID").(primitive.ObjectID)
+	sessionID := ctx.Get("sessionID").(primitive.ObjectID)
+	sessions, err := c.sessionService.GetSessions(ctx.Request().Context(), userID, sessionID)
+	if err != nil {
+		return c.sessionError(ctx, err, "Failed to get sessions")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Sessions retrieved successfully", sessions)
+}
+
+// RevokeSession signs out one of the user's other devices
+func (c *AuthController) RevokeSession(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	userID := ctx.Get("userID").(primitive.ObjectID)
+	if err := c.sessionService.SignOut(ctx.Request().Context(), userID, id); err != nil {
+		return c.sessionError(ctx, err, "Failed to revoke session")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Session revoked successfully", nil)
+}
+
 // GetProfile retrieves the user profile using the JWT token
 func (c *AuthController) GetProfile(ctx echo.Context) error {
 	// Get userID from context (set by AuthMiddleware)
@@ -3,6 +3,7 @@ package controllers
 import (
 	"net/http"
 
+	"github.com/dfanso/parkme-backend/internal/authz"
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/services"
 	"github.com/dfanso/parkme-backend/pkg/utils"
@@ -18,6 +19,12 @@ func NewBookingController(service *services.BookingService) *BookingController {
 	return &BookingController{service: service}
 }
 
+// canAccessBooking reports whether the caller made the booking or holds the
+// permission at the booking's location
+func canAccessBooking(principal authz.Principal, booking *models.Booking, perm authz.Permission) bool {
+	return booking.UserID == principal.UserID || principal.CanAt(perm, booking.LocationID)
+}
+
 func (c *BookingController) CreateBooking(ctx echo.Context) error {
 	var booking models.Booking
 	if err := ctx.Bind(&booking); err != nil {
@@ -25,6 +32,8 @@ func (c *BookingController) CreateBooking(ctx echo.Context) error {
 	}
 	userID := ctx.Get("userID").(primitive.ObjectID)
 	booking.UserID = userID
+	// Drivers cannot choose the status, only the entry gate activates bookings
+	booking.Status = ""
 
 	if err := c.service.CreateBooking(ctx.Request().Context(), &booking); err != nil {
 		switch err {
@@ -34,6 +43,10 @@ func (c *BookingController) CreateBooking(ctx echo.Context) error {
 			return echo.NewHTTPError(http.StatusConflict, "Spot already booked")
 		case services.ErrBookingInPast:
 			return echo.NewHTTPError(http.StatusBadRequest, "Cannot book in the past")
+		case services.ErrSpotIncompatible:
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		case services.ErrVehicleNotDrivable:
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
 		default:
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
@@ -55,6 +68,9 @@ func (c *BookingController) GetBooking(ctx echo.Context) error {
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	if !canAccessBooking(authz.FromContext(ctx), booking, authz.PermBookingsView) {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not authorized to access this booking")
+	}
 
 	return utils.SuccessResponse(ctx, http.StatusOK, "Booking retrieved successfully", booking)
 }
@@ -70,6 +86,16 @@ func (c *BookingController) GetVehicleBookings(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	// Only list the bookings the caller is allowed to see
+	principal := authz.FromContext(ctx)
+	visible := make([]models.Booking, 0, len(bookings))
+	for i := range bookings {
+		if canAccessBooking(principal, &bookings[i], authz.PermBookingsView) {
+			visible = append(visible, bookings[i])
+		}
+	}
+	bookings = visible
+
 	return utils.SuccessResponse(ctx, http.StatusOK, "Vehicle bookings retrieved successfully", bookings)
 }
 
@@ -79,6 +105,17 @@ func (c *BookingController) CancelBooking(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid booking ID")
 	}
 
+	booking, err := c.service.GetBooking(ctx.Request().Context(), id)
+	if err != nil {
+		if err == services.ErrBookingNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "Booking not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if !canAccessBooking(authz.FromContext(ctx), booking, authz.PermBookingsManage) {
+		return echo.NewHTTPError(http.StatusForbidden, "You are not authorized to cancel this booking")
+	}
+
 	if err := c.service.CancelBooking(ctx.Request().Context(), id); err != nil {
 		if err == services.ErrBookingNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "Booking not found")
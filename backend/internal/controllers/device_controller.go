@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeviceController struct {
+	service *services.DeviceService
+}
+
+func NewDeviceController(service *services.DeviceService) *DeviceController {
+	return &DeviceController{
+		service: service,
+	}
+}
+
+func (c *DeviceController) GetDeviceService() *services.DeviceService {
+	return c.service
+}
+
+type ProvisionDeviceRequest struct {
+	DeviceID   string            `json:"device_id"` // Optional, generated when empty
+	Name       string            `json:"name"`
+	Type       models.DeviceType `json:"type"`
+	LocationID string            `json:"location_id"`
+}
+
+func (c *DeviceController) handleError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrDeviceNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Device not found", err)
+	case errors.Is(err, services.ErrLocationNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Location not found", err)
+	case errors.Is(err, services.ErrInvalidDevice):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid device", err)
+	case errors.Is(err, services.ErrDeviceIDTaken):
+		return utils.ErrorResponse(ctx, http.StatusConflict, "Device ID is already in use", err)
+	case errors.Is(err, services.ErrDeviceRevoked):
+		return utils.ErrorResponse(ctx, http.StatusConflict, "Device has been revoked", err)
+	case errors.Is(err, services.ErrInvalidDeviceConfig), errors.Is(err, services.ErrInvalidConfigAck):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid device config", err)
+	case errors.Is(err, services.ErrConfigConflict):
+		return utils.ErrorResponse(ctx, http.StatusPreconditionFailed, "Device config was changed, reload and try again", err)
+	case errors.Is(err, services.ErrInvalidSensorData):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid heartbeat", err)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err)
+	}
+}
+
+// Provision registers a device. The secret is only returned in this response
+func (c *DeviceController) Provision(ctx echo.Context) error {
+	var req ProvisionDeviceRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	locationID, err := primitive.ObjectIDFromHex(req.LocationID)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid location ID format", err)
+	}
+
+	device := &models.Device{
+		DeviceID:   req.DeviceID,
+		Name:       req.Name,
+		Type:       req.Type,
+		LocationID: locationID,
+		CreatedBy:  ctx.Get("userID").(primitive.ObjectID),
+	}
+	secret, err := c.service.Provision(ctx.Request().Context(), device)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to provision device")
+	}
+
+	return utils.SuccessResponse(ctx, http.StatusCreated, "Device provisioned successfully", map[string]interface{}{
+		"device": device,
+		"secret": secret,
+	})
+}
+
+// GetHealth lists the health of every device, optionally filtered by ?location_id=
+func (c *DeviceController) GetHealth(ctx echo.Context) error {
+	locationID, err := optionalLocationID(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid location ID format", err)
+	}
+
+	statuses, err := c.service.GetHealth(ctx.Request().Context(), locationID)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get device health")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Device health retrieved successfully", statuses)
+}
+
+// Heartbeat lets an authenticated device report that it is alive along with
+// its battery level, firmware version and last error
+func (c *DeviceController) Heartbeat(ctx echo.Context) error {
+	var heartbeat services.Heartbeat
+	if err := ctx.Bind(&heartbeat); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	device := ctx.Get("device").(*models.Device)
+	if err := c.service.RecordHeartbeat(ctx.Request().Context(), device, heartbeat); err != nil {
+		return c.handleError(ctx, err, "Failed to record heartbeat")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Heartbeat recorded successfully", map[string]interface{}{
+		"device_id":    device.DeviceID,
+		"last_seen_at": device.LastSeenAt,
+	})
+}
+
+func optionalLocationID(ctx echo.Context) (*primitive.ObjectID, error) {
+	value := ctx.QueryParam("location_id")
+	if value == "" {
+		return nil, nil
+	}
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// GetAll lists devices, optionally filtered by ?location_id=
+func (c *DeviceController) GetAll(ctx echo.Context) error {
+	locationID, err := optionalLocationID(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid location ID format", err)
+	}
+
+	devices, err := c.service.GetDevices(ctx.Request().Context(), locationID)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get devices")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Devices retrieved successfully", devices)
+}
+
+func (c *DeviceController) GetByID(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	device, err := c.service.GetDevice(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get device")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Device retrieved successfully", device)
+}
+
+// Rotate issues a new secret for a device. The new secret is only returned in this response
+func (c *DeviceController) Rotate(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	device, secret, err := c.service.RotateSecret(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to rotate device secret")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Device secret rotated successfully", map[string]interface{}{
+		"device": device,
+		"secret": secret,
+	})
+}
+
+func (c *DeviceController) Revoke(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	device, err := c.service.Revoke(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to revoke device")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Device revoked successfully", device)
+}
+
+// GetConfig returns a device's config to admins along with the version the device applied
+func (c *DeviceController) GetConfig(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	config, err := c.service.GetConfigByID(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get device config")
+	}
+	ctx.Response().Header().Set("ETag", config.ETag())
+	return utils.SuccessResponse(ctx, http.StatusOK, "Device config retrieved successfully", config)
+}
+
+// UpdateConfig stores new settings as the next config version. An If-Match
+// header with the current ETag guards against overwriting concurrent changes
+func (c *DeviceController) UpdateConfig(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	var settings models.ModuleConfig
+	if err := ctx.Bind(&settings); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	var expectedVersion *int
+	if ifMatch := ctx.Request().Header.Get("If-Match"); ifMatch != "" {
+		version, err := parseConfigETag(ifMatch)
+		if err != nil {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid If-Match header", err)
+		}
+		expectedVersion = &version
+	}
+
+	config, err := c.service.UpdateConfig(ctx.Request().Context(), id, settings, expectedVersion, ctx.Get("userID").(primitive.ObjectID))
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to update device config")
+	}
+	ctx.Response().Header().Set("ETag", config.ETag())
+	return utils.SuccessResponse(ctx, http.StatusOK, "Device config updated successfully", config)
+}
+
+// FetchConfig serves the authenticated device its config. Devices send the
+// ETag of the config they hold in If-None-Match and get 304 when it is current
+func (c *DeviceController) FetchConfig(ctx echo.Context) error {
+	device := ctx.Get("device").(*models.Device)
+	config, err := c.service.GetConfig(ctx.Request().Context(), device)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get device config")
+	}
+
+	etag := config.ETag()
+	ctx.Response().Header().Set("ETag", etag)
+	if ctx.Request().Header.Get("If-None-Match") == etag {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Device config retrieved successfully", map[string]interface{}{
+		"version": config.Version,
+		"config":  config.Config,
+	})
+}
+
+type ConfigAckRequest struct {
+	Version int `json:"version"`
+}
+
+// AcknowledgeConfig records the config version the device applied
+func (c *DeviceController) AcknowledgeConfig(ctx echo.Context) error {
+	var req ConfigAckRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	device := ctx.Get("device").(*models.Device)
+	config, err := c.service.AcknowledgeConfig(ctx.Request().Context(), device, req.Version)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to acknowledge device config")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Device config acknowledged successfully", map[string]interface{}{
+		"version":         config.Version,
+		"applied_version": config.AppliedVersion,
+	})
+}
+
+// parseConfigETag extracts the version from an ETag such as "v3"
+func parseConfigETag(etag string) (int, error) {
+	etag = strings.TrimPrefix(etag, "W/")
+	etag = strings.Trim(etag, `"`)
+	return strconv.Atoi(strings.TrimPrefix(etag, "v"))
+}
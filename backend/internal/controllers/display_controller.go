@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DisplayController struct {
+	service *services.DisplayService
+}
+
+func NewDisplayController(service *services.DisplayService) *DisplayController {
+	return &DisplayController{
+		service: service,
+	}
+}
+
+// GetDeviceFeed returns the display feed for the calling device's location,
+// optionally for one ?zone=
+func (c *DisplayController) GetDeviceFeed(ctx echo.Context) error {
+	return c.feed(ctx, deviceLocation(ctx))
+}
+
+// GetLocationFeed returns the display feed for a location, optionally for one ?zone=
+func (c *DisplayController) GetLocationFeed(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+	return c.feed(ctx, id)
+}
+
+// feed writes the bare feed without the usual response envelope to keep it
+// small, and answers 304 while the feed is unchanged
+func (c *DisplayController) feed(ctx echo.Context, locationID primitive.ObjectID) error {
+	feed, err := c.service.GetFeed(ctx.Request().Context(), locationID, ctx.QueryParam("zone"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrLocationNotFound):
+			return utils.ErrorResponse(ctx, http.StatusNotFound, "Location not found", err)
+		case errors.Is(err, services.ErrZoneNotFound):
+			return utils.ErrorResponse(ctx, http.StatusNotFound, "Zone not found", err)
+		default:
+			return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get display feed", err)
+		}
+	}
+
+	body, err := json.Marshal(feed)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to encode display feed", err)
+	}
+	hash := fnv.New64a()
+	hash.Write(body)
+	etag := fmt.Sprintf(`"%x"`, hash.Sum64())
+
+	ctx.Response().Header().Set("ETag", etag)
+	if ctx.Request().Header.Get("If-None-Match") == etag {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+	return ctx.JSONBlob(http.StatusOK, body)
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dfanso/parkme-backend/internal/authz"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type GuestController struct {
+	service *services.GuestService
+}
+
+func NewGuestController(service *services.GuestService) *GuestController {
+	return &GuestController{
+		service: service,
+	}
+}
+
+func (c *GuestController) handleError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrGuestSessionNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Guest session not found", err)
+	case errors.Is(err, services.ErrGuestSessionPaid),
+		errors.Is(err, services.ErrGuestSessionClosed),
+		errors.Is(err, services.ErrGuestSessionClaimed),
+		errors.Is(err, services.ErrGuestSessionBusy):
+		return utils.ErrorResponse(ctx, http.StatusConflict, err.Error(), err)
+	case errors.Is(err, services.ErrGuestVehicleNotRegistered):
+		return utils.ErrorResponse(ctx, http.StatusUnprocessableEntity, err.Error(), err)
+	case errors.Is(err, services.ErrInsufficientBalance):
+		return utils.ErrorResponse(ctx, http.StatusPaymentRequired, "Insufficient wallet balance", err)
+	case errors.Is(err, services.ErrWalletNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Wallet not found", err)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err)
+	}
+}
+
+// GetQuote shows what a guest owes. It is public so the QR code on the exit
+// gate can be opened without an account
+func (c *GuestController) GetQuote(ctx echo.Context) error {
+	quote, err := c.service.GetQuote(ctx.Request().Context(), ctx.Param("code"))
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get guest session")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Guest session retrieved successfully", quote)
+}
+
+// Pay settles a guest session from the caller's wallet
+func (c *GuestController) Pay(ctx echo.Context) error {
+	userID := ctx.Get("userID").(primitive.ObjectID)
+	session, err := c.service.Pay(ctx.Request().Context(), ctx.Param("code"), userID)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to pay guest session")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Guest session paid successfully", session)
+}
+
+// Claim moves a guest session into the caller's account
+func (c *GuestController) Claim(ctx echo.Context) error {
+	userID := ctx.Get("userID").(primitive.ObjectID)
+	session, err := c.service.Claim(ctx.Request().Context(), ctx.Param("code"), userID)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to claim guest session")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Guest session claimed successfully", session)
+}
+
+// SettleCash records a guest session paid in cash at the booth
+func (c *GuestController) SettleCash(ctx echo.Context) error {
+	principal := authz.FromContext(ctx)
+	session, err := c.service.GetSession(ctx.Request().Context(), ctx.Param("code"))
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to settle guest session")
+	}
+	if !principal.CanAccessLocation(session.LocationID) {
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "You are not authorized to settle sessions at this location", authz.ErrForbidden)
+	}
+
+	session, err = c.service.SettleCash(ctx.Request().Context(), session.PayCode, principal.UserID)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to settle guest session")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Guest session settled successfully", session)
+}
+
+// GetAll lists guest sessions, filtered by ?location_id= and ?status=. Staff
+// scoped to locations must name one of theirs
+func (c *GuestController) GetAll(ctx echo.Context) error {
+	locationID, err := optionalLocationID(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid location ID", err)
+	}
+	principal := authz.FromContext(ctx)
+	if !principal.IsGlobal() {
+		if locationID == nil {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "location_id is required", nil)
+		}
+		if !principal.CanAccessLocation(*locationID) {
+			return utils.ErrorResponse(ctx, http.StatusForbidden, "You are not authorized to view sessions at this location", authz.ErrForbidden)
+		}
+	}
+
+	sessions, err := c.service.GetSessions(ctx.Request().Context(), locationID, models.GuestSessionStatus(ctx.QueryParam("status")))
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get guest sessions")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Guest sessions retrieved successfully", sessions)
+}
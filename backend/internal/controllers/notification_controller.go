@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type NotificationController struct {
+	service *services.NotificationService
+}
+
+func NewNotificationController(service *services.NotificationService) *NotificationController {
+	return &NotificationController{
+		service: service,
+	}
+}
+
+func (c *NotificationController) GetNotifications(ctx echo.Context) error {
+	userID := ctx.Get("userID").(primitive.ObjectID)
+
+	notifications, err := c.service.GetNotifications(ctx.Request().Context(), userID)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get notifications", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Notifications retrieved successfully", notifications)
+}
+
+func (c *NotificationController) GetPreferences(ctx echo.Context) error {
+	userID := ctx.Get("userID").(primitive.ObjectID)
+
+	prefs, err := c.service.GetPreferences(ctx.Request().Context(), userID)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get notification preferences", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Notification preferences retrieved successfully", prefs)
+}
+
+func (c *NotificationController) UpdatePreferences(ctx echo.Context) error {
+	var prefs models.NotificationPreferences
+	if err := ctx.Bind(&prefs); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+	prefs.ID = primitive.NilObjectID
+	prefs.UserID = ctx.Get("userID").(primitive.ObjectID)
+
+	if err := c.service.UpdatePreferences(ctx.Request().Context(), &prefs); err != nil {
+		if errors.Is(err, services.ErrInvalidChannel) || errors.Is(err, services.ErrUnknownTemplate) {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid notification preferences", err)
+		}
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to update notification preferences", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Notification preferences updated successfully", prefs)
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+)
+
+type OIDCController struct {
+	service *services.OIDCService
+}
+
+func NewOIDCController(service *services.OIDCService) *OIDCController {
+	return &OIDCController{
+		service: service,
+	}
+}
+
+// GetProviders lists the identity providers users can sign in with
+func (c *OIDCController) GetProviders(ctx echo.Context) error {
+	return utils.SuccessResponse(ctx, http.StatusOK, "Sign in providers retrieved successfully", c.service.Providers())
+}
+
+// Login sends the browser to the provider's sign in page. ?login_hint=
+// suggests the account to the provider
+func (c *OIDCController) Login(ctx echo.Context) error {
+	authURL, err := c.service.BeginLogin(ctx.Request().Context(), ctx.Param("provider"), ctx.QueryParam("login_hint"))
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownOIDCProvider) {
+			return utils.ErrorResponse(ctx, http.StatusNotFound, err.Error(), err)
+		}
+		return utils.ErrorResponse(ctx, http.StatusBadGateway, "Failed to start sign in with the provider", err)
+	}
+	return ctx.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes the sign in when the provider redirects back. The browser
+// is sent on to the app with the tokens, a two-factor challenge or an error
+// in the URL fragment, which is not sent to servers. Clients asking for JSON
+// get the result in the response instead
+func (c *OIDCController) Callback(ctx echo.Context) error {
+	if code := ctx.QueryParam("error"); code != "" {
+		return c.finish(ctx, http.StatusUnauthorized, url.Values{
+			"error":             {code},
+			"error_description": {ctx.QueryParam("error_description")},
+		}, nil)
+	}
+
+	result, err := c.service.CompleteLogin(ctx.Request().Context(), ctx.Param("provider"),
+		ctx.QueryParam("state"), ctx.QueryParam("code"), ctx.Request().UserAgent(), ctx.RealIP())
+	if err != nil {
+		status := http.StatusUnauthorized
+		switch {
+		case errors.Is(err, services.ErrUnknownOIDCProvider):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrAccountInactive),
+			errors.Is(err, services.ErrOIDCDomainNotAllowed),
+			errors.Is(err, services.ErrOIDCNoAccount):
+			status = http.StatusForbidden
+		case errors.Is(err, services.ErrExternalIdentityLinked):
+			status = http.StatusConflict
+		case errors.Is(err, services.ErrInvalidOIDCState),
+			errors.Is(err, services.ErrOIDCLoginFailed),
+			errors.Is(err, services.ErrOIDCEmailNotVerified):
+		default:
+			return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to sign in with the provider", err)
+		}
+		return c.finish(ctx, status, url.Values{
+			"error":             {"login_failed"},
+			"error_description": {err.Error()},
+		}, nil)
+	}
+
+	if result.Challenge != nil {
+		return c.finish(ctx, http.StatusOK, url.Values{
+			"mfa_required": {"true"},
+			"mfa_token":    {result.Challenge.MFAToken},
+			"expires_at":   {strconv.FormatInt(result.Challenge.ExpiresAt.Unix(), 10)},
+		}, result.Challenge)
+	}
+	return c.finish(ctx, http.StatusOK, url.Values{
+		"token":         {result.Tokens.Token},
+		"refresh_token": {result.Tokens.RefreshToken},
+		"expires_at":    {strconv.FormatInt(result.Tokens.ExpiresAt.Unix(), 10)},
+	}, result.Tokens)
+}
+
+func (c *OIDCController) finish(ctx echo.Context, status int, fragment url.Values, body interface{}) error {
+	if !strings.Contains(ctx.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON) {
+		return ctx.Redirect(http.StatusFound, c.service.RedirectURL()+"#"+fragment.Encode())
+	}
+	if status != http.StatusOK {
+		return utils.ErrorResponse(ctx, status, fragment.Get("error_description"), nil)
+	}
+	return ctx.JSON(status, body)
+}
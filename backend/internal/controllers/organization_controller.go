@@ -0,0 +1,393 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/authz"
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errInvalidOrganizationID = errors.New("invalid organization ID format")
+
+type OrganizationController struct {
+	service *services.OrganizationService
+}
+
+func NewOrganizationController(service *services.OrganizationService) *OrganizationController {
+	return &OrganizationController{
+		service: service,
+	}
+}
+
+func (c *OrganizationController) handleError(ctx echo.Context, err error, message string) error {
+	var validationErrors validation.Errors
+	switch {
+	case errors.As(err, &validationErrors):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Validation failed", validationErrors)
+	case errors.Is(err, errInvalidOrganizationID):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	case errors.Is(err, authz.ErrForbidden):
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "You are not authorized to manage this organization", err)
+	case errors.Is(err, services.ErrNotVehicleOwner):
+		return utils.ErrorResponse(ctx, http.StatusForbidden, err.Error(), err)
+	case errors.Is(err, services.ErrOrganizationNotFound),
+		errors.Is(err, services.ErrVehicleNotFound),
+		errors.Is(err, services.ErrMemberEmailNotFound),
+		errors.Is(err, services.ErrOrganizationInviteNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, err.Error(), err)
+	case errors.Is(err, services.ErrNotOrganizationMember),
+		errors.Is(err, services.ErrVehicleNotInOrganization),
+		errors.Is(err, services.ErrInvalidStatementPeriod),
+		errors.Is(err, services.ErrInvalidAmount):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, services.ErrOrganizationMemberExists),
+		errors.Is(err, services.ErrLastFleetManager),
+		errors.Is(err, services.ErrVehicleInOrganization):
+		return utils.ErrorResponse(ctx, http.StatusConflict, err.Error(), err)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err)
+	}
+}
+
+// access returns the organization named by the path and the caller's
+// membership of it. Holders of PermOrganizationsManage act as a fleet manager
+// of every organization. When manage is set the caller must be a fleet manager
+func (c *OrganizationController) access(ctx echo.Context, manage bool) (primitive.ObjectID, *models.OrgMember, error) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return id, nil, errInvalidOrganizationID
+	}
+
+	principal := authz.FromContext(ctx)
+	if principal.Can(authz.PermOrganizationsManage) {
+		if _, err := c.service.Get(ctx.Request().Context(), id); err != nil {
+			return id, nil, err
+		}
+		return id, &models.OrgMember{OrganizationID: id, UserID: principal.UserID, Role: models.OrgRoleFleetManager}, nil
+	}
+
+	member, err := c.service.GetMembership(ctx.Request().Context(), id, principal.UserID)
+	if errors.Is(err, services.ErrNotOrganizationMember) {
+		return id, nil, authz.ErrForbidden
+	}
+	if err != nil {
+		return id, nil, err
+	}
+	if manage && !member.IsManager() {
+		return id, nil, authz.ErrForbidden
+	}
+	return id, member, nil
+}
+
+// Create starts an organization with the caller as its fleet manager
+func (c *OrganizationController) Create(ctx echo.Context) error {
+	var req dto.OrganizationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	organization := &models.Organization{Name: req.Name, BillingPolicy: req.BillingPolicy}
+	if err := c.service.Create(ctx.Request().Context(), organization, authz.FromContext(ctx).UserID); err != nil {
+		return c.handleError(ctx, err, "Failed to create organization")
+	}
+	return utils.SuccessResponse(ctx, http.StatusCreated, "Organization created successfully", dto.OrganizationMembership{
+		Organization: *organization,
+		Role:         models.OrgRoleFleetManager,
+	})
+}
+
+// GetAll lists the caller's organizations, or every organization for admins
+func (c *OrganizationController) GetAll(ctx echo.Context) error {
+	principal := authz.FromContext(ctx)
+
+	var organizations []dto.OrganizationMembership
+	var err error
+	if principal.Can(authz.PermOrganizationsManage) {
+		organizations, err = c.service.GetAll(ctx.Request().Context())
+	} else {
+		organizations, err = c.service.GetForUser(ctx.Request().Context(), principal.UserID)
+	}
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get organizations")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Organizations retrieved successfully", organizations)
+}
+
+func (c *OrganizationController) GetByID(ctx echo.Context) error {
+	id, member, err := c.access(ctx, false)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get organization")
+	}
+
+	organization, err := c.service.Get(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get organization")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Organization retrieved successfully", dto.OrganizationMembership{
+		Organization: *organization,
+		Role:         member.Role,
+	})
+}
+
+// Update renames the organization or changes its billing policy
+func (c *OrganizationController) Update(ctx echo.Context) error {
+	id, _, err := c.access(ctx, true)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to update organization")
+	}
+
+	var req dto.OrganizationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	organization, err := c.service.Update(ctx.Request().Context(), id, req)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to update organization")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Organization updated successfully", organization)
+}
+
+// GetMembers lists the members and their spending this month. Drivers only
+// see themselves
+func (c *OrganizationController) GetMembers(ctx echo.Context) error {
+	id, member, err := c.access(ctx, false)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get members")
+	}
+
+	if !member.IsManager() {
+		self, err := c.service.GetMember(ctx.Request().Context(), id, member.UserID)
+		if err != nil {
+			return c.handleError(ctx, err, "Failed to get members")
+		}
+		return utils.SuccessResponse(ctx, http.StatusOK, "Members retrieved successfully", []dto.OrgMemberResponse{*self})
+	}
+
+	members, err := c.service.GetMembers(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get members")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Members retrieved successfully", members)
+}
+
+// AddMember invites a registered user as a driver or fleet manager
+func (c *OrganizationController) AddMember(ctx echo.Context) error {
+	id, _, err := c.access(ctx, true)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to add member")
+	}
+
+	var req dto.AddOrgMemberRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	member, err := c.service.AddMember(ctx.Request().Context(), id, req)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to add member")
+	}
+	return utils.SuccessResponse(ctx, http.StatusCreated, "Member invited successfully", member)
+}
+
+// AcceptInvitation makes the caller a member of the organization that invited them
+func (c *OrganizationController) AcceptInvitation(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	if err := c.service.AcceptMembership(ctx.Request().Context(), id, authz.FromContext(ctx).UserID); err != nil {
+		return c.handleError(ctx, err, "Failed to accept invitation")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Invitation accepted successfully", nil)
+}
+
+// UpdateMember changes a member's role or monthly spending limit
+func (c *OrganizationController) UpdateMember(ctx echo.Context) error {
+	id, _, err := c.access(ctx, true)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to update member")
+	}
+	userID, err := primitive.ObjectIDFromHex(ctx.Param("userId"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid user ID format", err)
+	}
+
+	var req dto.UpdateOrgMemberRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	member, err := c.service.UpdateMember(ctx.Request().Context(), id, userID, req)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to update member")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Member updated successfully", member)
+}
+
+// RemoveMember takes a member out of the organization. Members may leave
+// on their own
+func (c *OrganizationController) RemoveMember(ctx echo.Context) error {
+	userID, err := primitive.ObjectIDFromHex(ctx.Param("userId"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid user ID format", err)
+	}
+	leaving := userID == authz.FromContext(ctx).UserID
+	id, _, err := c.access(ctx, !leaving)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to remove member")
+	}
+
+	if err := c.service.RemoveMember(ctx.Request().Context(), id, userID); err != nil {
+		return c.handleError(ctx, err, "Failed to remove member")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Member removed successfully", nil)
+}
+
+// GetVehicles lists the fleet. Drivers only see the vehicles assigned to them
+func (c *OrganizationController) GetVehicles(ctx echo.Context) error {
+	id, member, err := c.access(ctx, false)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get vehicles")
+	}
+
+	vehicles, err := c.service.GetVehicles(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get vehicles")
+	}
+	if !member.IsManager() {
+		assigned := []models.Vehicle{}
+		for _, vehicle := range vehicles {
+			if vehicle.Owner == member.UserID || vehicle.IsAssignedTo(member.UserID) {
+				assigned = append(assigned, vehicle)
+			}
+		}
+		vehicles = assigned
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Vehicles retrieved successfully", vehicles)
+}
+
+// AssignVehicle adds a member's vehicle to the fleet or assigns a fleet
+// vehicle to another driver. Another member's vehicle is only invited until
+// its owner accepts
+func (c *OrganizationController) AssignVehicle(ctx echo.Context) error {
+	id, _, err := c.access(ctx, true)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to assign vehicle")
+	}
+
+	var req dto.AssignOrgVehicleRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	vehicle, err := c.service.AssignVehicle(ctx.Request().Context(), id, authz.FromContext(ctx).UserID, req)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to assign vehicle")
+	}
+	if vehicle.OrganizationID == nil {
+		return utils.SuccessResponse(ctx, http.StatusAccepted, "Vehicle invited, waiting for its owner to accept", vehicle)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Vehicle assigned successfully", vehicle)
+}
+
+// AcceptVehicle lets the caller accept the organization's invitation for
+// their vehicle
+func (c *OrganizationController) AcceptVehicle(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+	vehicleID, err := primitive.ObjectIDFromHex(ctx.Param("vehicleId"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid vehicle ID format", err)
+	}
+
+	vehicle, err := c.service.AcceptVehicle(ctx.Request().Context(), id, vehicleID, authz.FromContext(ctx).UserID)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to accept invitation")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Vehicle added to the organization successfully", vehicle)
+}
+
+// ReleaseVehicle takes a vehicle out of the fleet or withdraws its
+// invitation, leaving it with its owner. Owners may take their own vehicle out
+func (c *OrganizationController) ReleaseVehicle(ctx echo.Context) error {
+	vehicleID, err := primitive.ObjectIDFromHex(ctx.Param("vehicleId"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid vehicle ID format", err)
+	}
+	id, _, err := c.access(ctx, true)
+	switch {
+	case err == nil:
+		err = c.service.ReleaseVehicle(ctx.Request().Context(), id, vehicleID)
+	case errors.Is(err, authz.ErrForbidden):
+		err = c.service.WithdrawVehicle(ctx.Request().Context(), id, vehicleID, authz.FromContext(ctx).UserID)
+	}
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to release vehicle")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Vehicle released successfully", nil)
+}
+
+func (c *OrganizationController) GetWallet(ctx echo.Context) error {
+	id, _, err := c.access(ctx, false)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get balance")
+	}
+
+	wallet, err := c.service.GetWallet(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get balance")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Balance retrieved successfully", map[string]float64{
+		"balance": wallet.Balance,
+	})
+}
+
+func (c *OrganizationController) TopUp(ctx echo.Context) error {
+	id, member, err := c.access(ctx, true)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to top up wallet")
+	}
+
+	var req TopUpRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	transaction, err := c.service.TopUp(ctx.Request().Context(), id, member.UserID, req.Amount)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to top up wallet")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Wallet topped up successfully", transaction)
+}
+
+// GetStatement returns the statement of a calendar month given as YYYY-MM,
+// "current" is the month in progress
+func (c *OrganizationController) GetStatement(ctx echo.Context) error {
+	id, _, err := c.access(ctx, true)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get statement")
+	}
+
+	period := ctx.Param("period")
+	if period == "current" {
+		period = time.Now().Format("2006-01")
+	}
+
+	statement, err := c.service.GetStatement(ctx.Request().Context(), id, period)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get statement")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Statement retrieved successfully", statement)
+}
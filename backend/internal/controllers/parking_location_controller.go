@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/services"
@@ -20,17 +23,15 @@ func NewParkingLocationController(service *services.ParkingLocationService) *Par
 }
 
 func (c *ParkingLocationController) CreateLocation(ctx echo.Context) error {
-	userRole := models.Role(ctx.Get("userRole").(string))
-	if userRole != models.RoleAdmin {
-		return ctx.JSON(http.StatusForbidden, map[string]string{"error": "You are not authorized to create a parking location"})
-	}
-
 	var location models.ParkingLocation
 	if err := ctx.Bind(&location); err != nil {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
 
 	if err := c.service.CreateLocation(ctx.Request().Context(), &location); err != nil {
+		if errors.Is(err, services.ErrInvalidLocation) {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
@@ -63,6 +64,39 @@ func (c *ParkingLocationController) GetAllLocations(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, locations)
 }
 
+const (
+	defaultNearbyRadius = 5000.0  // meters
+	maxNearbyRadius     = 50000.0 // meters
+)
+
+// GetNearbyLocations returns locations around ?lat=&lng= within ?radius= meters
+func (c *ParkingLocationController) GetNearbyLocations(ctx echo.Context) error {
+	lat, err := strconv.ParseFloat(ctx.QueryParam("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or missing lat"})
+	}
+
+	lng, err := strconv.ParseFloat(ctx.QueryParam("lng"), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or missing lng"})
+	}
+
+	radius := defaultNearbyRadius
+	if value := ctx.QueryParam("radius"); value != "" {
+		radius, err = strconv.ParseFloat(value, 64)
+		if err != nil || radius <= 0 || radius > maxNearbyRadius {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid radius"})
+		}
+	}
+
+	locations, err := c.service.FindNearby(ctx.Request().Context(), lat, lng, radius)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, locations)
+}
+
 func (c *ParkingLocationController) UpdateLocation(ctx echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
 	if err != nil {
@@ -76,6 +110,9 @@ func (c *ParkingLocationController) UpdateLocation(ctx echo.Context) error {
 
 	location.ID = id
 	if err := c.service.UpdateLocation(ctx.Request().Context(), &location); err != nil {
+		if errors.Is(err, services.ErrInvalidLocation) {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
 		if err == services.ErrLocationNotFound {
 			return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Location not found"})
 		}
@@ -114,6 +151,45 @@ func (c *ParkingLocationController) UpdateSlotStatus(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, map[string]string{"message": "Slot status updated successfully"})
 }
 
+// GetSensorReadings returns raw slot sensor readings for calibration.
+// Supports ?slot=, ?since= (RFC3339) and ?limit=
+func (c *ParkingLocationController) GetSensorReadings(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+
+	var since *time.Time
+	if value := ctx.QueryParam("since"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid since, expected RFC3339"})
+		}
+		since = &t
+	}
+
+	var limit int64
+	if value := ctx.QueryParam("limit"); value != "" {
+		limit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		}
+	}
+
+	readings, err := c.service.GetSensorReadings(ctx.Request().Context(), id, ctx.QueryParam("slot"), since, limit)
+	if err != nil {
+		switch err {
+		case services.ErrLocationNotFound:
+			return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Location not found"})
+		case services.ErrSlotNotFound:
+			return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Slot not found"})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, readings)
+}
+
 func (c *ParkingLocationController) DeleteLocation(ctx echo.Context) error {
 	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
 	if err != nil {
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReconciliationController struct {
+	service *services.ReconciliationService
+}
+
+func NewReconciliationController(service *services.ReconciliationService) *ReconciliationController {
+	return &ReconciliationController{
+		service: service,
+	}
+}
+
+// GetMismatches lists the occupancy mismatches found at a location,
+// optionally filtered with ?status=open|resolved
+func (c *ReconciliationController) GetMismatches(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	status := models.MismatchStatus(ctx.QueryParam("status"))
+	if status != "" && status != models.MismatchStatusOpen && status != models.MismatchStatusResolved {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid status", nil)
+	}
+
+	mismatches, err := c.service.GetMismatches(ctx.Request().Context(), id, status)
+	if err != nil {
+		if errors.Is(err, services.ErrLocationNotFound) {
+			return utils.ErrorResponse(ctx, http.StatusNotFound, "Location not found", err)
+		}
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get mismatches", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Mismatches retrieved successfully", mismatches)
+}
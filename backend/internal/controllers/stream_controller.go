@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/authz"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	streamBufferSize        = 64
+	streamKeepAliveInterval = 15 * time.Second
+)
+
+type StreamController struct {
+	bus             *events.Bus
+	locationService *services.ParkingLocationService
+	userService     *services.UserService
+	sessionService  *services.AuthSessionService
+}
+
+func NewStreamController(bus *events.Bus, locationService *services.ParkingLocationService, userService *services.UserService, sessionService *services.AuthSessionService) *StreamController {
+	return &StreamController{
+		bus:             bus,
+		locationService: locationService,
+		userService:     userService,
+		sessionService:  sessionService,
+	}
+}
+
+// isStaff reports whether the principal sees every event of the location
+// rather than the public view drivers get
+func isStaff(principal authz.Principal, locationID primitive.ObjectID) bool {
+	return principal.CanAt(authz.PermOccupancyMonitor, locationID)
+}
+
+// refresh reloads the principal's user and session so a stream ends once the
+// user is banned or signed out, and follows role changes
+func (c *StreamController) refresh(ctx echo.Context, principal authz.Principal) (authz.Principal, error) {
+	reqCtx := ctx.Request().Context()
+	user, err := c.userService.GetByID(reqCtx, principal.UserID)
+	if err != nil {
+		return principal, err
+	}
+	if err := services.CheckUserCanSignIn(user); err != nil {
+		return principal, err
+	}
+	sessionID, _ := ctx.Get("sessionID").(primitive.ObjectID)
+	if err := c.sessionService.Validate(reqCtx, sessionID); err != nil {
+		return principal, err
+	}
+	principal.Role = user.Role
+	principal.LocationIDs = user.LocationIDs
+	principal.Status = user.Status
+	return principal, nil
+}
+
+// StreamLocation pushes slot changes, gate events and free-count updates for a
+// single location to the client using Server-Sent Events. Staff of the
+// location get every event, drivers only occupancy and anonymous gate events
+func (c *StreamController) StreamLocation(ctx echo.Context) error {
+	locationID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid location ID format", err)
+	}
+
+	location, err := c.locationService.GetLocation(ctx.Request().Context(), locationID)
+	if err != nil {
+		if err == services.ErrLocationNotFound {
+			return utils.ErrorResponse(ctx, http.StatusNotFound, "Location not found", err)
+		}
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get location", err)
+	}
+
+	// Subscribe before sending the snapshot so no change is missed in between
+	sub := c.bus.Subscribe(events.ForLocation(locationID), streamBufferSize)
+	defer sub.Close()
+
+	res := ctx.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	snapshot := events.New(events.TypeLocationSnapshot, locationID, map[string]interface{}{
+		"slots":       location.Slots,
+		"free_slots":  location.FreeSlots(),
+		"total_slots": len(location.Slots),
+	})
+	if err := writeServerSentEvent(res, snapshot); err != nil {
+		return nil
+	}
+
+	principal := authz.FromContext(ctx)
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case event, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			if !isStaff(principal, locationID) {
+				if event, ok = events.PublicView(event); !ok {
+					continue
+				}
+			}
+			if err := writeServerSentEvent(res, event); err != nil {
+				return nil
+			}
+		case <-keepAlive.C:
+			if principal, err = c.refresh(ctx, principal); err != nil {
+				return nil
+			}
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func writeServerSentEvent(res *echo.Response, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+)
+
+type TelemetryController struct {
+	service *services.TelemetryService
+}
+
+func NewTelemetryController(service *services.TelemetryService) *TelemetryController {
+	return &TelemetryController{
+		service: service,
+	}
+}
+
+type TelemetryBatchRequest struct {
+	Readings []services.SensorData `json:"readings"`
+}
+
+type AlertBatchRequest struct {
+	Alerts []services.AlertData `json:"alerts"`
+}
+
+// IngestTelemetry stores a batch of sensor readings from the authenticated device
+func (c *TelemetryController) IngestTelemetry(ctx echo.Context) error {
+	var req TelemetryBatchRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	device := ctx.Get("device").(*models.Device)
+	stored, err := c.service.IngestTelemetry(ctx.Request().Context(), device, req.Readings)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSensorData) {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid telemetry", err)
+		}
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to store telemetry", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusAccepted, "Telemetry stored successfully", map[string]int{"stored": stored})
+}
+
+// IngestAlerts stores a batch of alerts from the authenticated device
+func (c *TelemetryController) IngestAlerts(ctx echo.Context) error {
+	var req AlertBatchRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	device := ctx.Get("device").(*models.Device)
+	stored, err := c.service.IngestAlerts(ctx.Request().Context(), device, req.Alerts)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSensorData) {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid alerts", err)
+		}
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to store alerts", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusAccepted, "Alerts stored successfully", map[string]int{"stored": stored})
+}
+
+// GetTelemetry queries telemetry by ?module_id=, ?location_id=, ?type=,
+// ?from=, ?to= (RFC3339) and ?limit=. ?resolution=hourly returns the rollups
+func (c *TelemetryController) GetTelemetry(ctx echo.Context) error {
+	query, err := parseTelemetryQuery(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid query", err)
+	}
+
+	switch ctx.QueryParam("resolution") {
+	case "", "raw":
+		points, err := c.service.GetTelemetry(ctx.Request().Context(), query)
+		if err != nil {
+			return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get telemetry", err)
+		}
+		return utils.SuccessResponse(ctx, http.StatusOK, "Telemetry retrieved successfully", points)
+	case "hourly":
+		rollups, err := c.service.GetRollups(ctx.Request().Context(), query)
+		if err != nil {
+			return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get telemetry", err)
+		}
+		return utils.SuccessResponse(ctx, http.StatusOK, "Telemetry retrieved successfully", rollups)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid resolution, expected raw or hourly", nil)
+	}
+}
+
+// GetAlerts queries device alerts by the same filters as GetTelemetry plus ?severity=
+func (c *TelemetryController) GetAlerts(ctx echo.Context) error {
+	query, err := parseTelemetryQuery(ctx)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid query", err)
+	}
+
+	if severity := models.AlertSeverity(ctx.QueryParam("severity")); severity != "" {
+		if !severity.IsValid() {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid severity, expected info, warning or critical", nil)
+		}
+		query.Severity = severity
+	}
+
+	alerts, err := c.service.GetAlerts(ctx.Request().Context(), query)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get alerts", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Alerts retrieved successfully", alerts)
+}
+
+func parseTelemetryQuery(ctx echo.Context) (services.TelemetryQuery, error) {
+	query := services.TelemetryQuery{
+		ModuleID: ctx.QueryParam("module_id"),
+		Type:     ctx.QueryParam("type"),
+	}
+
+	locationID, err := optionalLocationID(ctx)
+	if err != nil {
+		return query, fmt.Errorf("invalid location_id")
+	}
+	query.LocationID = locationID
+
+	for name, target := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+		value := ctx.QueryParam(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("invalid %s, expected RFC3339", name)
+		}
+		*target = &t
+	}
+
+	if value := ctx.QueryParam("limit"); value != "" {
+		query.Limit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || query.Limit <= 0 {
+			return query, fmt.Errorf("invalid limit")
+		}
+	}
+	return query, nil
+}
@@ -2,10 +2,16 @@ package controllers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"slices"
+	"strings"
 
+	"github.com/dfanso/parkme-backend/internal/authz"
+	"github.com/dfanso/parkme-backend/internal/dto"
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/services"
 	"github.com/dfanso/parkme-backend/pkg/utils"
@@ -15,12 +21,20 @@ import (
 )
 
 type UserController struct {
-	service *services.UserService
+	service        *services.UserService
+	loginThrottle  *services.LoginThrottleService
+	mfaService     *services.MFAService
+	sessionService *services.AuthSessionService
+	accountService *services.AccountService
 }
 
-func NewUserController(service *services.UserService) *UserController {
+func NewUserController(service *services.UserService, loginThrottle *services.LoginThrottleService, mfaService *services.MFAService, sessionService *services.AuthSessionService, accountService *services.AccountService) *UserController {
 	return &UserController{
-		service: service,
+		service:        service,
+		loginThrottle:  loginThrottle,
+		mfaService:     mfaService,
+		sessionService: sessionService,
+		accountService: accountService,
 	}
 }
 
@@ -44,6 +58,9 @@ func (c *UserController) GetByID(ctx echo.Context) error {
 	if err != nil {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
 	}
+	if err := authz.FromContext(ctx).CheckOwner(id, authz.PermUsersManage); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "You are not authorized to access this user", err)
+	}
 
 	user, err := c.service.GetByID(ctx.Request().Context(), id)
 	if err != nil {
@@ -87,12 +104,64 @@ func (c *UserController) Update(ctx echo.Context) error {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
 	}
 
-	var user models.User
-	if err := ctx.Bind(&user); err != nil {
+	principal := authz.FromContext(ctx)
+	if err := principal.CheckOwner(id, authz.PermUsersManage); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "You are not authorized to update this user", err)
+	}
+
+	existing, err := c.service.GetByID(ctx.Request().Context(), id)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
+	}
+
+	var req dto.UpdateUserRequest
+	if err := ctx.Bind(&req); err != nil {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
 	}
+	user := req.User
 
 	user.ID = id
+	user.CreatedAt = existing.CreatedAt
+	// Only the emailed link verifies an address
+	user.EmailVerifiedAt = existing.EmailVerifiedAt
+	// Only user managers may change what a user is allowed to do
+	if !principal.Can(authz.PermUsersManage) {
+		user.Role = existing.Role
+		user.Status = existing.Status
+		user.LocationIDs = existing.LocationIDs
+	}
+	accessChanged := user.Role != existing.Role || user.Status != existing.Status || !slices.Equal(user.LocationIDs, existing.LocationIDs)
+
+	// A new address has to be verified again before the account is active
+	emailChanged := !strings.EqualFold(strings.TrimSpace(user.Email), existing.Email)
+	if emailChanged {
+		if err := c.service.CheckEmailAvailable(ctx.Request().Context(), user.Email, id); err != nil {
+			if errors.Is(err, services.ErrUserExists) {
+				return utils.ErrorResponse(ctx, http.StatusConflict, "Email address is already in use", err)
+			}
+			return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to update user", err)
+		}
+		user.EmailVerifiedAt = nil
+		if user.Status == models.UserStatusActive {
+			user.Status = models.UserStatusPendingVerification
+		}
+	}
+
+	// Users prove they hold the account before choosing a new password, user
+	// managers may set one for someone else
+	passwordChanged := user.Password != ""
+	ownAccount := principal.UserID == id
+	if passwordChanged && ownAccount {
+		if err := existing.ComparePassword(req.CurrentPassword); err != nil {
+			return utils.ErrorResponse(ctx, http.StatusForbidden, "Current password is incorrect", nil)
+		}
+	}
+
+	// Changing what someone else may do or how they sign in needs a session
+	// that signed in with a second factor
+	if !ownAccount && (accessChanged || passwordChanged) && !principal.MFA {
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "Sign in with two-factor authentication to perform this action", nil)
+	}
 
 	// Call BeforeUpdate which includes validation
 	if err := user.BeforeUpdate(); err != nil {
@@ -102,10 +171,35 @@ func (c *UserController) Update(ctx echo.Context) error {
 		}
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid user data", err)
 	}
+	// Keep the current password when no new one is given
+	if user.Password == "" {
+		user.Password = existing.Password
+	}
 
 	if err := c.service.Update(ctx.Request().Context(), &user); err != nil {
 		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to update user", err)
 	}
+	user.Password = "" // Do not return password in response
+
+	// Whoever knew the old password is signed out, the caller stays signed in
+	if passwordChanged {
+		var err error
+		if sessionID, ok := ctx.Get("sessionID").(primitive.ObjectID); ok && ownAccount {
+			_, err = c.sessionService.RevokeOthers(ctx.Request().Context(), id, sessionID, models.SessionRevokedPasswordChange)
+		} else {
+			_, err = c.sessionService.RevokeAll(ctx.Request().Context(), id, models.SessionRevokedPasswordChange)
+		}
+		if err != nil {
+			return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to sign out other sessions", err)
+		}
+	}
+
+	// A failed email does not fail the update, a new link can be requested
+	if emailChanged {
+		if err := c.accountService.SendVerification(ctx.Request().Context(), &user); err != nil {
+			log.Printf("users: failed to send verification email to user %s: %v", id.Hex(), err)
+		}
+	}
 
 	return utils.SuccessResponse(ctx, http.StatusOK, "User updated successfully", user)
 }
@@ -122,3 +216,48 @@ func (c *UserController) Delete(ctx echo.Context) error {
 
 	return utils.SuccessResponse(ctx, http.StatusOK, "User deleted successfully", nil)
 }
+
+// Unlock lifts a login lockout or backoff on the user's account
+func (c *UserController) Unlock(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	user, err := c.service.GetByID(ctx.Request().Context(), id)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
+	}
+
+	actorID := authz.FromContext(ctx).UserID
+	unlocked, err := c.loginThrottle.Unlock(ctx.Request().Context(), user, &actorID, "unlocked by admin")
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to unlock user", err)
+	}
+	if !unlocked {
+		return utils.SuccessResponse(ctx, http.StatusOK, "User was not locked out", map[string]bool{"unlocked": false})
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "User unlocked successfully", map[string]bool{"unlocked": true})
+}
+
+// ResetMFA removes a user's two-factor enrolment when they lost their device
+// and recovery codes, and signs them out
+func (c *UserController) ResetMFA(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	user, err := c.service.GetByID(ctx.Request().Context(), id)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "User not found", err)
+	}
+
+	if err := c.mfaService.Reset(ctx.Request().Context(), user, authz.FromContext(ctx).UserID); err != nil {
+		if errors.Is(err, services.ErrMFANotEnabled) {
+			return utils.ErrorResponse(ctx, http.StatusConflict, err.Error(), err)
+		}
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to reset two-factor authentication", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Two-factor authentication reset successfully", nil)
+}
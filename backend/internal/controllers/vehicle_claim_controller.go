@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/authz"
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/s3"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// vehicleDocumentURLTTL is how long a reviewer's link to a registration
+// document stays valid
+const vehicleDocumentURLTTL = 15 * time.Minute
+
+// VehicleClaimController lets admins review verification requests and
+// ownership disputes
+type VehicleClaimController struct {
+	service  *services.VehicleClaimService
+	s3Client *s3.S3Client
+}
+
+func NewVehicleClaimController(service *services.VehicleClaimService, s3Client *s3.S3Client) *VehicleClaimController {
+	return &VehicleClaimController{
+		service:  service,
+		s3Client: s3Client,
+	}
+}
+
+func (c *VehicleClaimController) handleError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrVehicleClaimNotFound),
+		errors.Is(err, services.ErrVehicleNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, err.Error(), err)
+	case errors.Is(err, services.ErrVehicleClaimClosed),
+		errors.Is(err, services.ErrVehicleClaimOutdated):
+		return utils.ErrorResponse(ctx, http.StatusConflict, err.Error(), err)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err)
+	}
+}
+
+// GetAll lists claims, pending ones unless ?status= names another status or "all"
+func (c *VehicleClaimController) GetAll(ctx echo.Context) error {
+	status := models.VehicleClaimStatus(ctx.QueryParam("status"))
+	switch status {
+	case "":
+		status = models.VehicleClaimPending
+	case "all":
+		status = ""
+	case models.VehicleClaimPending, models.VehicleClaimApproved, models.VehicleClaimRejected:
+	default:
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid status", nil)
+	}
+
+	claims, err := c.service.GetClaims(ctx.Request().Context(), status)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get claims", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Claims retrieved successfully", claims)
+}
+
+// GetByID returns a claim with a short lived link to its registration document
+func (c *VehicleClaimController) GetByID(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	claim, err := c.service.GetClaim(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get claim")
+	}
+	documentURL, err := c.s3Client.GeneratePresignedGetURL(claim.DocumentKey, vehicleDocumentURLTTL)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get registration document", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Claim retrieved successfully", dto.VehicleClaimResponse{
+		VehicleClaim: *claim,
+		DocumentURL:  documentURL,
+	})
+}
+
+// Approve verifies the vehicle, or transfers it for a dispute
+func (c *VehicleClaimController) Approve(ctx echo.Context) error {
+	return c.review(ctx, c.service.Approve, "Claim approved successfully", "Failed to approve claim")
+}
+
+// Reject turns the claim down
+func (c *VehicleClaimController) Reject(ctx echo.Context) error {
+	return c.review(ctx, c.service.Reject, "Claim rejected successfully", "Failed to reject claim")
+}
+
+type claimDecision func(ctx context.Context, id, reviewerID primitive.ObjectID, note string) (*models.VehicleClaim, error)
+
+func (c *VehicleClaimController) review(ctx echo.Context, decide claimDecision, success, failure string) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	var req dto.ReviewVehicleClaimRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	claim, err := decide(ctx.Request().Context(), id, authz.FromContext(ctx).UserID, req.Note)
+	if err != nil {
+		return c.handleError(ctx, err, failure)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, success, claim)
+}
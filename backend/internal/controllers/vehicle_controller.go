@@ -2,26 +2,67 @@ package controllers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
+	"github.com/dfanso/parkme-backend/internal/authz"
+	"github.com/dfanso/parkme-backend/internal/dto"
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/s3"
 	"github.com/dfanso/parkme-backend/pkg/utils"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// maxVehicleDocumentSize caps the size of an uploaded registration document
+const maxVehicleDocumentSize = 10 << 20
+
 type VehicleController struct {
-	service *services.VehicleService
+	service      *services.VehicleService
+	claimService *services.VehicleClaimService
+	s3Client     *s3.S3Client
 }
 
-func NewVehicleController(service *services.VehicleService) *VehicleController {
+func NewVehicleController(service *services.VehicleService, claimService *services.VehicleClaimService, s3Client *s3.S3Client) *VehicleController {
 	return &VehicleController{
-		service: service,
+		service:      service,
+		claimService: claimService,
+		s3Client:     s3Client,
+	}
+}
+
+// findOwned returns the vehicle when the caller owns it or holds the
+// permission that overrides ownership. Drivers may view the fleet vehicles
+// assigned to them, changes go through the organization
+func (c *VehicleController) findOwned(ctx echo.Context, id primitive.ObjectID, override authz.Permission) (*models.Vehicle, error) {
+	vehicle, err := c.service.GetByID(ctx.Request().Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	principal := authz.FromContext(ctx)
+	// Authorized drivers may see the vehicle they book with
+	if override == authz.PermVehiclesView && vehicle.CanBeDrivenBy(principal.UserID) {
+		return vehicle, nil
 	}
+	if err := principal.CheckOwner(vehicle.Owner, override); err != nil {
+		return nil, err
+	}
+	if vehicle.OrganizationID != nil && override == authz.PermVehiclesManage && !principal.Can(override) {
+		return nil, authz.ErrForbidden
+	}
+	return vehicle, nil
+}
+
+func (c *VehicleController) findError(ctx echo.Context, err error) error {
+	if errors.Is(err, authz.ErrForbidden) {
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "You are not authorized to access this vehicle", err)
+	}
+	return utils.ErrorResponse(ctx, http.StatusNotFound, "Vehicle not found", err)
 }
 
 func (c *VehicleController) GetAll(ctx echo.Context) error {
@@ -38,9 +79,9 @@ func (c *VehicleController) GetByID(ctx echo.Context) error {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
 	}
 
-	vehicle, err := c.service.GetByID(ctx.Request().Context(), id)
+	vehicle, err := c.findOwned(ctx, id, authz.PermVehiclesView)
 	if err != nil {
-		return utils.ErrorResponse(ctx, http.StatusNotFound, "Vehicle not found", err)
+		return c.findError(ctx, err)
 	}
 
 	return utils.SuccessResponse(ctx, http.StatusOK, "Vehicle retrieved successfully", vehicle)
@@ -51,6 +92,9 @@ func (c *VehicleController) GetUserVehicles(ctx echo.Context) error {
 	if err != nil {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid user ID format", err)
 	}
+	if err := authz.FromContext(ctx).CheckOwner(userID, authz.PermVehiclesView); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "You are not authorized to access these vehicles", err)
+	}
 
 	vehicles, err := c.service.GetByOwner(ctx.Request().Context(), userID)
 	if err != nil {
@@ -66,14 +110,18 @@ func (c *VehicleController) Create(ctx echo.Context) error {
 	fmt.Printf("Raw Request Body: %s\n", string(body))
 	ctx.Request().Body = io.NopCloser(bytes.NewBuffer(body))
 
-	userID := ctx.Get("userID").(primitive.ObjectID)
-
-	vehicle.Owner = userID
-
 	if err := ctx.Bind(&vehicle); err != nil {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
 	}
 
+	// Vehicles belong to the caller unless staff register one for a driver
+	principal := authz.FromContext(ctx)
+	if vehicle.Owner.IsZero() || !principal.Can(authz.PermVehiclesManage) {
+		vehicle.Owner = principal.UserID
+	}
+	// Vehicles join an organization through its fleet manager
+	vehicle.OrganizationID = nil
+
 	// Validate the vehicle
 	if err := vehicle.Validate(); err != nil {
 		if e, ok := err.(validation.Errors); ok {
@@ -83,6 +131,9 @@ func (c *VehicleController) Create(ctx echo.Context) error {
 	}
 
 	if err := c.service.Create(ctx.Request().Context(), &vehicle); err != nil {
+		if errors.Is(err, services.ErrVehicleExists) {
+			return utils.ErrorResponse(ctx, http.StatusConflict, "Vehicle with this plate number already exists, claim it with its registration document if it is yours", err)
+		}
 		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to create vehicle", err)
 	}
 
@@ -95,13 +146,22 @@ func (c *VehicleController) Update(ctx echo.Context) error {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
 	}
 
-	userID := ctx.Get("userID").(primitive.ObjectID)
+	existing, err := c.findOwned(ctx, id, authz.PermVehiclesManage)
+	if err != nil {
+		return c.findError(ctx, err)
+	}
 
 	var vehicle models.Vehicle
 	if err := ctx.Bind(&vehicle); err != nil {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
 	}
-	vehicle.Owner = userID
+	vehicle.Owner = existing.Owner
+	vehicle.OrganizationID = existing.OrganizationID
+	vehicle.AssignedDriver = existing.AssignedDriver
+	vehicle.OrganizationInvite = existing.OrganizationInvite
+	vehicle.Verification = existing.Verification
+	vehicle.VerifiedAt = existing.VerifiedAt
+	vehicle.Drivers = existing.Drivers
 	vehicle.ID = id
 
 	// Validate the vehicle
@@ -113,6 +173,9 @@ func (c *VehicleController) Update(ctx echo.Context) error {
 	}
 
 	if err := c.service.Update(ctx.Request().Context(), &vehicle); err != nil {
+		if errors.Is(err, services.ErrVehicleExists) {
+			return utils.ErrorResponse(ctx, http.StatusConflict, err.Error(), err)
+		}
 		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to update vehicle", err)
 	}
 
@@ -124,6 +187,9 @@ func (c *VehicleController) Delete(ctx echo.Context) error {
 	if err != nil {
 		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
 	}
+	if _, err := c.findOwned(ctx, id, authz.PermVehiclesManage); err != nil {
+		return c.findError(ctx, err)
+	}
 
 	if err := c.service.Delete(ctx.Request().Context(), id); err != nil {
 		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to delete vehicle", err)
@@ -131,3 +197,186 @@ func (c *VehicleController) Delete(ctx echo.Context) error {
 
 	return utils.SuccessResponse(ctx, http.StatusOK, "Vehicle deleted successfully", nil)
 }
+
+func (c *VehicleController) handleError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, authz.ErrForbidden):
+		return utils.ErrorResponse(ctx, http.StatusForbidden, "You are not authorized to access this vehicle", err)
+	case errors.Is(err, services.ErrVehicleNotFound),
+		errors.Is(err, services.ErrDriverEmailNotFound),
+		errors.Is(err, services.ErrDriverNotFound),
+		errors.Is(err, services.ErrDriverInviteNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, err.Error(), err)
+	case errors.Is(err, services.ErrVehicleAlreadyVerified),
+		errors.Is(err, services.ErrVehicleAlreadyOwned),
+		errors.Is(err, services.ErrVehicleClaimExists),
+		errors.Is(err, services.ErrDriverExists),
+		errors.Is(err, services.ErrDriverIsOwner):
+		return utils.ErrorResponse(ctx, http.StatusConflict, err.Error(), err)
+	case errors.Is(err, services.ErrVehicleNotVerified):
+		return utils.ErrorResponse(ctx, http.StatusUnprocessableEntity, err.Error(), err)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err)
+	}
+}
+
+var (
+	errDocumentRequired = errors.New("a registration document is required")
+	errDocumentTooLarge = errors.New("registration document must be at most 10 MB")
+	errDocumentType     = errors.New("registration document must be an image or a PDF")
+)
+
+// uploadDocument stores the registration document sent in the "document"
+// form field and returns its key
+func (c *VehicleController) uploadDocument(ctx echo.Context) (string, error) {
+	file, err := ctx.FormFile("document")
+	if err != nil {
+		return "", errDocumentRequired
+	}
+	if file.Size > maxVehicleDocumentSize {
+		return "", errDocumentTooLarge
+	}
+	contentType := file.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") && contentType != "application/pdf" {
+		return "", errDocumentType
+	}
+	return utils.UploadDocumentToS3(file, c.s3Client, "vehicle-documents")
+}
+
+func (c *VehicleController) documentError(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errDocumentTooLarge):
+		return utils.ErrorResponse(ctx, http.StatusRequestEntityTooLarge, err.Error(), err)
+	case errors.Is(err, errDocumentRequired), errors.Is(err, errDocumentType):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, err.Error(), err)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to upload registration document", err)
+	}
+}
+
+// GetShared lists the vehicles other owners shared with the caller,
+// including invitations still to be accepted
+func (c *VehicleController) GetShared(ctx echo.Context) error {
+	vehicles, err := c.service.GetDrivenBy(ctx.Request().Context(), authz.FromContext(ctx).UserID)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get shared vehicles", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Shared vehicles retrieved successfully", vehicles)
+}
+
+// RequestVerification submits the owner's registration document for review
+func (c *VehicleController) RequestVerification(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+	vehicle, err := c.service.GetByID(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to request verification")
+	}
+	// Only the owner holds the registration, staff cannot verify on their behalf
+	if vehicle.Owner != authz.FromContext(ctx).UserID {
+		return c.handleError(ctx, authz.ErrForbidden, "Failed to request verification")
+	}
+	if err := c.claimService.CheckVerifiable(vehicle); err != nil {
+		return c.handleError(ctx, err, "Failed to request verification")
+	}
+
+	key, err := c.uploadDocument(ctx)
+	if err != nil {
+		return c.documentError(ctx, err)
+	}
+	claim, err := c.claimService.RequestVerification(ctx.Request().Context(), vehicle, key, ctx.FormValue("note"))
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to request verification")
+	}
+	return utils.SuccessResponse(ctx, http.StatusCreated, "Verification requested successfully", claim)
+}
+
+// OpenClaim disputes the ownership of a plate registered by someone else
+func (c *VehicleController) OpenClaim(ctx echo.Context) error {
+	principal := authz.FromContext(ctx)
+	vehicle, err := c.claimService.FindDisputable(ctx.Request().Context(), ctx.FormValue("plate_number"), principal.UserID)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to open claim")
+	}
+
+	key, err := c.uploadDocument(ctx)
+	if err != nil {
+		return c.documentError(ctx, err)
+	}
+	claim, err := c.claimService.OpenDispute(ctx.Request().Context(), vehicle, principal.UserID, key, ctx.FormValue("note"))
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to open claim")
+	}
+	return utils.SuccessResponse(ctx, http.StatusCreated, "Claim opened successfully", claim)
+}
+
+// GetClaims lists the verification requests and disputes the caller made
+func (c *VehicleController) GetClaims(ctx echo.Context) error {
+	claims, err := c.claimService.GetUserClaims(ctx.Request().Context(), authz.FromContext(ctx).UserID)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to get claims", err)
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Claims retrieved successfully", claims)
+}
+
+// InviteDriver lets another user book with the caller's verified vehicle
+// once they accept
+func (c *VehicleController) InviteDriver(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+	vehicle, err := c.findOwned(ctx, id, authz.PermVehiclesManage)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to invite driver")
+	}
+
+	var req dto.InviteDriverRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	driver, err := c.service.InviteDriver(ctx.Request().Context(), vehicle, req.Email)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to invite driver")
+	}
+	return utils.SuccessResponse(ctx, http.StatusCreated, "Driver invited successfully", driver)
+}
+
+// AcceptDriverInvite makes the caller an authorized driver of the vehicle
+func (c *VehicleController) AcceptDriverInvite(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	if err := c.service.AcceptDriverInvite(ctx.Request().Context(), id, authz.FromContext(ctx).UserID); err != nil {
+		return c.handleError(ctx, err, "Failed to accept invitation")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Invitation accepted successfully", nil)
+}
+
+// RemoveDriver withdraws a driver's access. Drivers may remove themselves,
+// which also declines an invitation
+func (c *VehicleController) RemoveDriver(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+	userID, err := primitive.ObjectIDFromHex(ctx.Param("userId"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid user ID format", err)
+	}
+	if userID != authz.FromContext(ctx).UserID {
+		if _, err := c.findOwned(ctx, id, authz.PermVehiclesManage); err != nil {
+			return c.handleError(ctx, err, "Failed to remove driver")
+		}
+	}
+
+	if err := c.service.RemoveDriver(ctx.Request().Context(), id, userID); err != nil {
+		return c.handleError(ctx, err, "Failed to remove driver")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Driver removed successfully", nil)
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WebhookController struct {
+	service *services.WebhookService
+}
+
+func NewWebhookController(service *services.WebhookService) *WebhookController {
+	return &WebhookController{
+		service: service,
+	}
+}
+
+type WebhookRequest struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	EventTypes  []string `json:"event_types"`
+	Active      *bool    `json:"active"`
+}
+
+func (c *WebhookController) handleError(ctx echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Webhook not found", err)
+	case errors.Is(err, services.ErrWebhookDeliveryNotFound):
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Delivery not found", err)
+	case errors.Is(err, services.ErrInvalidWebhookURL), errors.Is(err, services.ErrUnknownEventType):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid webhook", err)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err)
+	}
+}
+
+// GetEventTypes lists the domain events that can be subscribed to
+func (c *WebhookController) GetEventTypes(ctx echo.Context) error {
+	return utils.SuccessResponse(ctx, http.StatusOK, "Event types retrieved successfully", events.DomainTypes)
+}
+
+// Create registers a webhook. The signing secret is only returned in this response
+func (c *WebhookController) Create(ctx echo.Context) error {
+	var req WebhookRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	sub := &models.WebhookSubscription{
+		URL:         req.URL,
+		Description: req.Description,
+		EventTypes:  req.EventTypes,
+		CreatedBy:   ctx.Get("userID").(primitive.ObjectID),
+	}
+	if err := c.service.CreateSubscription(ctx.Request().Context(), sub); err != nil {
+		return c.handleError(ctx, err, "Failed to create webhook")
+	}
+
+	return utils.SuccessResponse(ctx, http.StatusCreated, "Webhook created successfully", map[string]interface{}{
+		"webhook": sub,
+		"secret":  sub.Secret,
+	})
+}
+
+func (c *WebhookController) GetAll(ctx echo.Context) error {
+	subs, err := c.service.GetSubscriptions(ctx.Request().Context())
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get webhooks")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Webhooks retrieved successfully", subs)
+}
+
+func (c *WebhookController) GetByID(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	sub, err := c.service.GetSubscription(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get webhook")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Webhook retrieved successfully", sub)
+}
+
+func (c *WebhookController) Update(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	var req WebhookRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err)
+	}
+
+	update := &models.WebhookSubscription{
+		ID:          id,
+		URL:         req.URL,
+		Description: req.Description,
+		EventTypes:  req.EventTypes,
+		Active:      req.Active == nil || *req.Active,
+	}
+	sub, err := c.service.UpdateSubscription(ctx.Request().Context(), update)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to update webhook")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Webhook updated successfully", sub)
+}
+
+func (c *WebhookController) Delete(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	if err := c.service.DeleteSubscription(ctx.Request().Context(), id); err != nil {
+		return c.handleError(ctx, err, "Failed to delete webhook")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Webhook deleted successfully", nil)
+}
+
+// GetDeliveries returns the delivery log for a webhook
+func (c *WebhookController) GetDeliveries(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	deliveries, err := c.service.GetDeliveries(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to get deliveries")
+	}
+	return utils.SuccessResponse(ctx, http.StatusOK, "Deliveries retrieved successfully", deliveries)
+}
+
+// ReplayDelivery sends a previous delivery's payload again
+func (c *WebhookController) ReplayDelivery(ctx echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("deliveryId"))
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid ID format", err)
+	}
+
+	delivery, err := c.service.ReplayDelivery(ctx.Request().Context(), id)
+	if err != nil {
+		return c.handleError(ctx, err, "Failed to replay delivery")
+	}
+	return utils.SuccessResponse(ctx, http.StatusAccepted, "Delivery queued for replay", delivery)
+}
@@ -1,6 +1,10 @@
 package dto
 
-import "github.com/dfanso/parkme-backend/internal/models"
+import (
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+)
 
 type LoginRequest struct {
 	Email    string `json:"email"`
@@ -13,9 +17,74 @@ type RegisterRequest struct {
 	Password string `json:"password"`
 }
 
+// UpdateUserRequest changes a user. Users changing their own password must
+// give the current one
+type UpdateUserRequest struct {
+	models.User
+	CurrentPassword string `json:"current_password"`
+}
+
 type LoginResponse struct {
-	Token string       `json:"token"`
-	User  *models.User `json:"user"`
+	Token        string       `json:"token"`
+	ExpiresAt    time.Time    `json:"expires_at"`
+	RefreshToken string       `json:"refresh_token"`
+	User         *models.User `json:"user"`
+}
+
+// MFAChallengeResponse is returned by login instead of tokens when the
+// account has two-factor authentication enabled
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"` // TOTP code or recovery code
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type MFAEnrolmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI to show as a QR code
+}
+
+type MFAStatusResponse struct {
+	Enabled                bool       `json:"enabled"`
+	Required               bool       `json:"required"` // The user's role must use two-factor authentication
+	ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginURL    string `json:"login_url"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
 }
 
 type UpdateProfileRequest struct {
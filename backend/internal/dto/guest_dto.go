@@ -0,0 +1,19 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GuestQuote is what anyone holding a pay code can see about the session
+type GuestQuote struct {
+	PayCode     string                    `json:"pay_code"`
+	PlateNumber string                    `json:"plate_number"`
+	LocationID  primitive.ObjectID        `json:"location_id"`
+	Status      models.GuestSessionStatus `json:"status"`
+	EntryAt     time.Time                 `json:"entry_at"`
+	Amount      float64                   `json:"amount"`
+	PaidAt      *time.Time                `json:"paid_at,omitempty"`
+}
@@ -0,0 +1,58 @@
+package dto
+
+import (
+	"github.com/dfanso/parkme-backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type NearbyLocationResponse struct {
+	ID             primitive.ObjectID `json:"id"`
+	Name           string             `json:"name"`
+	Address        string             `json:"address"`
+	Location       *models.GeoPoint   `json:"location"`
+	DistanceMeters float64            `json:"distance_meters"`
+	IsOpen         bool               `json:"is_open"`
+	ClosedReason   string             `json:"closed_reason,omitempty"`
+	FreeSlots      int                `json:"free_slots"`
+	TotalSlots     int                `json:"total_slots"`
+	HourlyRate     float64            `json:"hourly_rate"`
+}
+
+// SlotReadingResponse reports the outcome of a raw slot sensor reading.
+// IsOccupied is the debounced state; Pending means the raw reading differs
+// from it but is not yet stable enough to change the slot
+type SlotReadingResponse struct {
+	LocationID  primitive.ObjectID `json:"location_id"`
+	SlotNumber  string             `json:"spot_number"`
+	RawOccupied bool               `json:"raw_occupied"`
+	IsOccupied  bool               `json:"is_occupied"`
+	Changed     bool               `json:"changed"`
+	Pending     bool               `json:"pending"`
+}
+
+// SlotReading is the raw state of one slot in a lot controller snapshot
+type SlotReading struct {
+	SpotNumber string `json:"spot_number"`
+	IsOccupied bool   `json:"is_occupied"`
+}
+
+// SlotSnapshotResponse reports the outcome of a lot controller snapshot.
+// FreeSlots is the stored count after the snapshot was applied
+type SlotSnapshotResponse struct {
+	LocationID primitive.ObjectID    `json:"location_id"`
+	Slots      []SlotReadingResponse `json:"slots"`
+	Changed    int                   `json:"changed"`
+	FreeSlots  int                   `json:"free_slots"`
+	TotalSlots int                   `json:"total_slots"`
+}
+
+// DisplayFeed is the availability shown on a location's entrance displays.
+// Keys are short so the response fits an ESP8266 comfortably
+type DisplayFeed struct {
+	Zone    string         `json:"zone,omitempty"`
+	Free    int            `json:"free"`  // Free slots not held for a reservation
+	Total   int            `json:"total"` // Slots in the location or zone
+	Held    int            `json:"held"`  // Free slots held for current or upcoming reservations
+	Types   map[string]int `json:"types"` // Free slots per slot type
+	Message string         `json:"msg,omitempty"`
+}
@@ -0,0 +1,70 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OrganizationRequest struct {
+	Name          string               `json:"name"`
+	BillingPolicy models.BillingPolicy `json:"billing_policy"`
+}
+
+// OrganizationMembership is an organization together with the caller's role in it
+type OrganizationMembership struct {
+	models.Organization
+	Role   models.OrgRole         `json:"role,omitempty"`   // Empty for admins who are not members
+	Status models.OrgMemberStatus `json:"status,omitempty"` // Invited until the caller accepts
+}
+
+type AddOrgMemberRequest struct {
+	Email         string         `json:"email"`
+	Role          models.OrgRole `json:"role"`
+	SpendingLimit *float64       `json:"spending_limit"` // Per calendar month, omit for no limit
+}
+
+type UpdateOrgMemberRequest struct {
+	Role          models.OrgRole `json:"role"`
+	SpendingLimit *float64       `json:"spending_limit"`
+}
+
+// OrgMemberResponse is a member with what they spent from the organization
+// wallet in the current month
+type OrgMemberResponse struct {
+	models.OrgMember
+	Name           string  `json:"name"`
+	Email          string  `json:"email"`
+	SpentThisMonth float64 `json:"spent_this_month"`
+}
+
+type AssignOrgVehicleRequest struct {
+	VehicleID primitive.ObjectID  `json:"vehicle_id"`
+	DriverID  *primitive.ObjectID `json:"driver_id"` // Defaults to the assigned driver, or the owner
+}
+
+// StatementLine totals the charges of one driver or vehicle in a statement
+type StatementLine struct {
+	ID      primitive.ObjectID `json:"id"`
+	Label   string             `json:"label"` // Driver name or plate number
+	Charges float64            `json:"charges"`
+	Count   int                `json:"count"`
+}
+
+// OrganizationStatement summarizes an organization wallet over a calendar month
+type OrganizationStatement struct {
+	OrganizationID primitive.ObjectID   `json:"organization_id"`
+	Name           string               `json:"name"`
+	Period         string               `json:"period"` // YYYY-MM
+	From           time.Time            `json:"from"`
+	To             time.Time            `json:"to"`
+	OpeningBalance float64              `json:"opening_balance"`
+	ClosingBalance float64              `json:"closing_balance"`
+	TopUps         float64              `json:"top_ups"`
+	Charges        float64              `json:"charges"`
+	Refunds        float64              `json:"refunds"`
+	Drivers        []StatementLine      `json:"drivers"`
+	Vehicles       []StatementLine      `json:"vehicles"`
+	Transactions   []models.Transaction `json:"transactions"`
+}
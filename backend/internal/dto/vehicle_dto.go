@@ -0,0 +1,18 @@
+package dto
+
+import "github.com/dfanso/parkme-backend/internal/models"
+
+type InviteDriverRequest struct {
+	Email string `json:"email"`
+}
+
+type ReviewVehicleClaimRequest struct {
+	Note string `json:"note"`
+}
+
+// VehicleClaimResponse is a claim as shown to reviewers, with a short lived
+// link to the registration document
+type VehicleClaimResponse struct {
+	models.VehicleClaim
+	DocumentURL string `json:"document_url,omitempty"`
+}
@@ -0,0 +1,199 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Type identifies the kind of event published on the bus
+type Type string
+
+const (
+	TypeLocationSnapshot  Type = "location.snapshot"
+	TypeSlotStatusChanged Type = "slot.status_changed"
+	TypeFreeCountChanged  Type = "location.free_count_changed"
+	TypeGateEntered       Type = "gate.entered"
+	TypeGateExited        Type = "gate.exited"
+	TypeBookingCreated    Type = "booking.created"
+	TypeBookingCancelled  Type = "booking.cancelled"
+	TypeBookingCompleted  Type = "booking.completed"
+	TypeWalletToppedUp    Type = "wallet.topped_up"
+	TypeWalletCharged     Type = "wallet.charged"
+	TypeDeviceOffline     Type = "device.offline"
+	TypeDeviceOnline      Type = "device.online"
+	TypeDeviceAlert       Type = "device.alert"
+	TypeMismatchDetected  Type = "occupancy.mismatch_detected"
+	TypeMismatchResolved  Type = "occupancy.mismatch_resolved"
+	TypeGuestEntered      Type = "guest.entered"
+	TypeGuestPaid         Type = "guest.paid"
+	TypeGuestExited       Type = "guest.exited"
+)
+
+// DomainTypes lists the events that external systems can subscribe to
+var DomainTypes = []Type{
+	TypeSlotStatusChanged,
+	TypeFreeCountChanged,
+	TypeGateEntered,
+	TypeGateExited,
+	TypeBookingCreated,
+	TypeBookingCancelled,
+	TypeBookingCompleted,
+	TypeWalletToppedUp,
+	TypeWalletCharged,
+	TypeDeviceOffline,
+	TypeDeviceOnline,
+	TypeDeviceAlert,
+	TypeMismatchDetected,
+	TypeMismatchResolved,
+	TypeGuestEntered,
+	TypeGuestPaid,
+	TypeGuestExited,
+}
+
+// IsDomainType reports whether t is one of DomainTypes
+func IsDomainType(t Type) bool {
+	for _, known := range DomainTypes {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is a single message published on the bus
+type Event struct {
+	ID         string              `json:"id"`
+	Type       Type                `json:"type"`
+	LocationID *primitive.ObjectID `json:"location_id,omitempty"`
+	OccurredAt time.Time           `json:"occurred_at"`
+	Data       interface{}         `json:"data,omitempty"`
+}
+
+// New creates an event with a fresh ID and the current timestamp. Pass
+// primitive.NilObjectID for events that are not tied to a location
+func New(eventType Type, locationID primitive.ObjectID, data interface{}) Event {
+	event := Event{
+		ID:         uuid.New().String(),
+		Type:       eventType,
+		OccurredAt: time.Now(),
+		Data:       data,
+	}
+	if !locationID.IsZero() {
+		event.LocationID = &locationID
+	}
+	return event
+}
+
+// Filter decides whether a subscriber receives an event
+type Filter func(Event) bool
+
+// ForLocation returns a filter matching events for a single location
+func ForLocation(locationID primitive.ObjectID) Filter {
+	return func(e Event) bool {
+		return e.LocationID != nil && *e.LocationID == locationID
+	}
+}
+
+// Subscription receives events matching its filter until closed
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+	bus    *Bus
+	once   sync.Once
+}
+
+// Close removes the subscription from the bus
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.unsubscribe(s)
+	})
+}
+
+// Handler is called synchronously for every matching event
+type Handler func(Event)
+
+type handlerEntry struct {
+	filter  Filter
+	handler Handler
+}
+
+// Bus is an in-process publish/subscribe event bus. Publishing never blocks on
+// subscribers: events are dropped for subscribers whose buffer is full.
+// Handlers registered with Handle run inside Publish and never miss an event
+type Bus struct {
+	mu       sync.RWMutex
+	subs     map[*Subscription]struct{}
+	handlers []handlerEntry
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber. A nil filter receives every event
+func (b *Bus) Subscribe(filter Filter, buffer int) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{
+		C:      ch,
+		ch:     ch,
+		filter: filter,
+		bus:    b,
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Handle registers a handler that runs in the publisher's goroutine for every
+// matching event. Use it for consumers that must not lose events, and keep the
+// handler short since it delays the publisher. A nil filter matches every event
+func (b *Bus) Handle(filter Filter, handler Handler) {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handlerEntry{filter: filter, handler: handler})
+	b.mu.Unlock()
+}
+
+// Publish runs every matching handler, then delivers the event to every
+// matching subscriber
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		if h.filter != nil && !h.filter(e) {
+			continue
+		}
+		h.handler(e)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		if sub.filter != nil && !sub.filter(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			// Slow subscriber, drop the event rather than block the publisher
+		}
+	}
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+	close(sub.ch)
+}
@@ -0,0 +1,126 @@
+package events
+
+import (
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SlotStatusChanged is published when a slot flips between free and occupied
+type SlotStatusChanged struct {
+	SlotNumber string `json:"slot_number"`
+	IsOccupied bool   `json:"is_occupied"`
+}
+
+// FreeCountChanged is published with the new number of free slots at a location
+type FreeCountChanged struct {
+	FreeSlots  int `json:"free_slots"`
+	TotalSlots int `json:"total_slots"`
+}
+
+// GateEvent is published when a vehicle passes the entry or exit gate
+type GateEvent struct {
+	PlateNumber string             `json:"plate_number"`
+	VehicleID   primitive.ObjectID `json:"vehicle_id"`
+	UserID      primitive.ObjectID `json:"user_id"`
+	BookingID   primitive.ObjectID `json:"booking_id"`
+	SpotNumber  string             `json:"spot_number,omitempty"`
+	Amount      float64            `json:"amount,omitempty"`
+}
+
+// PublicGateEvent is the gate event drivers see on a location stream, without
+// who passed the gate
+type PublicGateEvent struct {
+	SpotNumber string `json:"spot_number,omitempty"`
+}
+
+// PublicView returns the event as drivers may see it. Occupancy changes pass
+// as they are, gate events lose the vehicle and user, every other event is
+// for staff only
+func PublicView(e Event) (Event, bool) {
+	switch e.Type {
+	case TypeSlotStatusChanged, TypeFreeCountChanged:
+		return e, true
+	case TypeGateEntered, TypeGateExited:
+		gate, ok := e.Data.(GateEvent)
+		if !ok {
+			return Event{}, false
+		}
+		e.Data = PublicGateEvent{SpotNumber: gate.SpotNumber}
+		return e, true
+	default:
+		return Event{}, false
+	}
+}
+
+// GuestEvent is published when an unregistered vehicle enters, pays or leaves
+type GuestEvent struct {
+	SessionID   primitive.ObjectID `json:"session_id"`
+	PlateNumber string             `json:"plate_number"`
+	SpotNumber  string             `json:"spot_number,omitempty"`
+	Amount      float64            `json:"amount,omitempty"`
+}
+
+// BookingEvent is published when a booking is created, cancelled or completed
+type BookingEvent struct {
+	BookingID   primitive.ObjectID   `json:"booking_id"`
+	UserID      primitive.ObjectID   `json:"user_id"`
+	VehicleID   primitive.ObjectID   `json:"vehicle_id"`
+	Status      models.BookingStatus `json:"status"`
+	BookingType models.BookingType   `json:"booking_type"`
+	SpotNumber  string               `json:"spot_number,omitempty"`
+	StartTime   time.Time            `json:"start_time"`
+	EndTime     *time.Time           `json:"end_time,omitempty"`
+	TotalAmount *float64             `json:"total_amount,omitempty"`
+}
+
+// NewBookingEvent builds the payload for a booking event
+func NewBookingEvent(booking *models.Booking) BookingEvent {
+	payload := BookingEvent{
+		BookingID:   booking.ID,
+		UserID:      booking.UserID,
+		VehicleID:   booking.VehicleID,
+		Status:      booking.Status,
+		BookingType: booking.BookingType,
+		StartTime:   booking.StartTime,
+		EndTime:     booking.EndTime,
+		TotalAmount: booking.TotalAmount,
+	}
+	if booking.SpotNumber != nil {
+		payload.SpotNumber = *booking.SpotNumber
+	}
+	return payload
+}
+
+// WalletEvent is published when a wallet is topped up or charged
+type WalletEvent struct {
+	WalletID       primitive.ObjectID     `json:"wallet_id"`
+	UserID         primitive.ObjectID     `json:"user_id"`
+	OrganizationID *primitive.ObjectID    `json:"organization_id,omitempty"` // Set for organization wallets
+	TransactionID  primitive.ObjectID     `json:"transaction_id"`
+	Type           models.TransactionType `json:"transaction_type"`
+	Amount         float64                `json:"amount"`
+	Balance        float64                `json:"balance"`
+	Description    string                 `json:"description"`
+}
+
+// DeviceEvent is published when a device goes silent or comes back online
+type DeviceEvent struct {
+	ID         primitive.ObjectID `json:"id"`
+	DeviceID   string             `json:"device_id"`
+	DeviceType models.DeviceType  `json:"device_type"`
+	Name       string             `json:"name,omitempty"`
+	LastSeenAt *time.Time         `json:"last_seen_at,omitempty"`
+}
+
+// NewDeviceEvent builds the payload for a device event
+func NewDeviceEvent(device *models.Device) DeviceEvent {
+	return DeviceEvent{
+		ID:         device.ID,
+		DeviceID:   device.DeviceID,
+		DeviceType: device.Type,
+		Name:       device.Name,
+		LastSeenAt: device.LastSeenAt,
+	}
+}
@@ -0,0 +1,268 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Channels a device publishes on, under
+// <prefix>/locations/<location id>/devices/<device id>/<channel>.
+// Replies go to <prefix>/devices/<device id>/<channel>/reply
+const (
+	ChannelSlotStatus   = "slot/status"
+	ChannelSlotSnapshot = "slot/snapshot"
+	ChannelHeartbeat    = "heartbeat"
+	ChannelGateEnter    = "gate/enter"
+	ChannelGateExit     = "gate/exit"
+)
+
+const (
+	mqttQoS            = 1
+	mqttMessageTimeout = 30 * time.Second
+	defaultImageExt    = ".jpg"
+)
+
+var (
+	errUnknownChannel = errors.New("unknown channel")
+	errWrongLocation  = errors.New("device is not bound to this location")
+	errWrongDevice    = errors.New("this device is not allowed to publish on this channel")
+)
+
+// channelDevices lists the device types allowed on each channel, mirroring
+// the RequireDeviceType rules of the HTTP Arduino routes. Heartbeats are
+// accepted from every device
+var channelDevices = map[string][]models.DeviceType{
+	ChannelSlotStatus:   {models.DeviceTypeSlotSensor},
+	ChannelSlotSnapshot: {models.DeviceTypeSlotSensor},
+	ChannelGateEnter:    {models.DeviceTypeGateEnter},
+	ChannelGateExit:     {models.DeviceTypeGateExit},
+}
+
+// Message is what a device publishes. The API key is the one issued when the
+// device was provisioned, the same as the X-API-Key header over HTTP
+type Message struct {
+	APIKey    string          `json:"api_key"`
+	RequestID string          `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Reply is published back to the device once its message was handled
+type Reply struct {
+	RequestID string      `json:"request_id,omitempty"`
+	Success   bool        `json:"success"`
+	Message   string      `json:"message,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// GateImage carries a gate camera frame, base64 encoded
+type GateImage struct {
+	Image  string `json:"image"`
+	Format string `json:"format"` // File extension such as "jpg", defaults to jpg
+}
+
+type SlotSnapshot struct {
+	Slots []dto.SlotReading `json:"slots"`
+}
+
+// MQTTGateway accepts slot readings, heartbeats and gate events from a
+// local MQTT broker and hands them to the same services as the HTTP routes
+type MQTTGateway struct {
+	client   mqtt.Client
+	prefix   string
+	devices  *services.DeviceService
+	gates    *services.GateService
+	location *services.ParkingLocationService
+}
+
+func NewMQTTGateway(
+	cfg *localconfig.Config,
+	deviceService *services.DeviceService,
+	gateService *services.GateService,
+	locationService *services.ParkingLocationService,
+) *MQTTGateway {
+	g := &MQTTGateway{
+		prefix:   strings.TrimSuffix(cfg.MQTT.TopicPrefix, "/"),
+		devices:  deviceService,
+		gates:    gateService,
+		location: locationService,
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.MQTT.BrokerURL).
+		SetClientID(cfg.MQTT.ClientID).
+		SetUsername(cfg.MQTT.Username).
+		SetPassword(cfg.MQTT.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		// Gate lookups are slow, let messages be handled concurrently
+		SetOrderMatters(false).
+		SetOnConnectHandler(g.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("mqtt: connection lost: %v", err)
+		})
+	g.client = mqtt.NewClient(opts)
+	return g
+}
+
+// Start connects to the broker and disconnects when ctx is cancelled. The
+// client keeps retrying while the broker is unreachable
+func (g *MQTTGateway) Start(ctx context.Context) {
+	g.client.Connect()
+	go func() {
+		<-ctx.Done()
+		g.client.Disconnect(250)
+	}()
+}
+
+// subscribe runs on every (re)connect so subscriptions survive broker restarts
+func (g *MQTTGateway) subscribe(client mqtt.Client) {
+	topic := g.prefix + "/locations/+/devices/+/#"
+	token := client.Subscribe(topic, mqttQoS, g.handleMessage)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		log.Printf("mqtt: failed to subscribe to %s: %v", topic, err)
+		return
+	}
+	log.Printf("mqtt: subscribed to %s", topic)
+}
+
+// parseTopic splits a device topic into its location, device and channel
+func (g *MQTTGateway) parseTopic(topic string) (locationID, deviceID, channel string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(topic, g.prefix+"/"), "/", 5)
+	if len(parts) != 5 || parts[0] != "locations" || parts[2] != "devices" {
+		return "", "", "", false
+	}
+	return parts[1], parts[3], parts[4], true
+}
+
+func (g *MQTTGateway) handleMessage(_ mqtt.Client, msg mqtt.Message) {
+	locationID, deviceID, channel, ok := g.parseTopic(msg.Topic())
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mqttMessageTimeout)
+	defer cancel()
+
+	var message Message
+	if err := json.Unmarshal(msg.Payload(), &message); err != nil {
+		g.reply(deviceID, channel, Reply{Message: "invalid message: " + err.Error()})
+		return
+	}
+
+	device, err := g.authorize(ctx, deviceID, locationID, channel, message.APIKey)
+	if err != nil {
+		g.reply(deviceID, channel, Reply{RequestID: message.RequestID, Message: err.Error()})
+		return
+	}
+
+	data, err := g.dispatch(ctx, device, channel, message.Data)
+	reply := Reply{RequestID: message.RequestID, Success: err == nil, Data: data}
+	if err != nil {
+		reply.Message = err.Error()
+	}
+	g.reply(deviceID, channel, reply)
+}
+
+// authorize authenticates the device and checks it may publish on the topic
+func (g *MQTTGateway) authorize(ctx context.Context, deviceID, locationID, channel, apiKey string) (*models.Device, error) {
+	device, err := g.devices.Authenticate(ctx, deviceID, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if device.LocationID.Hex() != locationID {
+		return nil, errWrongLocation
+	}
+	if channel == ChannelHeartbeat {
+		return device, nil
+	}
+	allowed, known := channelDevices[channel]
+	if !known {
+		return nil, fmt.Errorf("%w: %s", errUnknownChannel, channel)
+	}
+	for _, t := range allowed {
+		if device.Type == t {
+			return device, nil
+		}
+	}
+	return nil, errWrongDevice
+}
+
+func (g *MQTTGateway) dispatch(ctx context.Context, device *models.Device, channel string, data json.RawMessage) (interface{}, error) {
+	switch channel {
+	case ChannelSlotStatus:
+		var reading dto.SlotReading
+		if err := json.Unmarshal(data, &reading); err != nil {
+			return nil, fmt.Errorf("%w: %v", services.ErrInvalidSensorData, err)
+		}
+		return g.location.RecordSensorReading(ctx, device.LocationID, reading.SpotNumber, reading.IsOccupied)
+	case ChannelSlotSnapshot:
+		var snapshot SlotSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("%w: %v", services.ErrInvalidSensorData, err)
+		}
+		return g.location.RecordSensorSnapshot(ctx, device.LocationID, snapshot.Slots)
+	case ChannelHeartbeat:
+		var heartbeat services.Heartbeat
+		if err := json.Unmarshal(data, &heartbeat); err != nil {
+			return nil, fmt.Errorf("%w: %v", services.ErrInvalidSensorData, err)
+		}
+		return nil, g.devices.RecordHeartbeat(ctx, device, heartbeat)
+	case ChannelGateEnter:
+		return g.gate(ctx, device, services.GateDirectionEnter, data)
+	case ChannelGateExit:
+		return g.gate(ctx, device, services.GateDirectionExit, data)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownChannel, channel)
+	}
+}
+
+// gate always returns a decision so the barrier gets an answer, denied when
+// the request failed
+func (g *MQTTGateway) gate(ctx context.Context, device *models.Device, direction services.GateDirection, data json.RawMessage) (*services.GateDecision, error) {
+	var req GateImage
+	if err := json.Unmarshal(data, &req); err != nil {
+		return services.DeniedGateDecision(direction, services.ErrGateImageRequired), err
+	}
+	image, err := base64.StdEncoding.DecodeString(req.Image)
+	if err != nil {
+		return services.DeniedGateDecision(direction, services.ErrGateImageRequired), err
+	}
+	ext := defaultImageExt
+	if req.Format != "" {
+		ext = "." + strings.TrimPrefix(req.Format, ".")
+	}
+
+	var decision *services.GateDecision
+	if direction == services.GateDirectionEnter {
+		decision, err = g.gates.Enter(ctx, device, image, ext)
+	} else {
+		decision, err = g.gates.Exit(ctx, device, image, ext)
+	}
+	if err != nil && decision == nil {
+		decision = services.DeniedGateDecision(direction, err)
+	}
+	return decision, err
+}
+
+func (g *MQTTGateway) reply(deviceID, channel string, reply Reply) {
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("mqtt: failed to encode reply for %s: %v", deviceID, err)
+		return
+	}
+	topic := fmt.Sprintf("%s/devices/%s/%s/reply", g.prefix, deviceID, channel)
+	g.client.Publish(topic, mqttQoS, false, payload)
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AccountTokenPurpose string
+
+const (
+	AccountTokenVerifyEmail   AccountTokenPurpose = "verify_email"
+	AccountTokenPasswordReset AccountTokenPurpose = "password_reset"
+)
+
+// AccountToken backs a single use link sent by email. Only the hash of the
+// token's secret is stored
+type AccountToken struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Purpose    AccountTokenPurpose `bson:"purpose" json:"purpose"`
+	Email      string              `bson:"email" json:"email"` // The address the link was sent to
+	SecretHash string              `bson:"secret_hash" json:"-"`
+	CreatedAt  time.Time           `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time           `bson:"expires_at" json:"expires_at"`
+	UsedAt     *time.Time          `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
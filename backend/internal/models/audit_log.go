@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditAction string
+
+const (
+	AuditLoginAccountLocked   AuditAction = "login.account_locked"
+	AuditLoginAccountUnlocked AuditAction = "login.account_unlocked"
+	AuditLoginIPLocked        AuditAction = "login.ip_locked"
+
+	AuditMFAEnabled              AuditAction = "mfa.enabled"
+	AuditMFADisabled             AuditAction = "mfa.disabled"
+	AuditMFAReset                AuditAction = "mfa.reset" // Removed by an admin
+	AuditMFARecoveryCodeUsed     AuditAction = "mfa.recovery_code_used"
+	AuditMFARecoveryCodesRenewed AuditAction = "mfa.recovery_codes_renewed"
+
+	AuditOIDCIdentityLinked AuditAction = "oidc.identity_linked"
+	AuditOIDCUserCreated    AuditAction = "oidc.user_created"
+	// AuditOIDCAccountReclaimed resets an unverified account linked to a
+	// provider identity with the same email
+	AuditOIDCAccountReclaimed AuditAction = "oidc.account_reclaimed"
+
+	AuditVehicleVerified    AuditAction = "vehicle.verified"
+	AuditVehicleTransferred AuditAction = "vehicle.transferred" // Ownership moved to a claimant by an admin
+)
+
+// AuditEntry records a security relevant event. ActorID is the user who
+// caused it, unset when the system did
+type AuditEntry struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Action    AuditAction         `bson:"action" json:"action"`
+	ActorID   *primitive.ObjectID `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"` // The account affected
+	Subject   string              `bson:"subject,omitempty" json:"subject,omitempty"` // Email or IP address the event is about
+	IP        string              `bson:"ip,omitempty" json:"ip,omitempty"`
+	Detail    string              `bson:"detail,omitempty" json:"detail,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SessionRevokeReason string
+
+const (
+	SessionRevokedLogout         SessionRevokeReason = "logout"
+	SessionRevokedLogoutAll      SessionRevokeReason = "logout_all"
+	SessionRevokedTokenReuse     SessionRevokeReason = "token_reuse" // A rotated refresh token was presented again
+	SessionRevokedUserInactive   SessionRevokeReason = "user_inactive"
+	SessionRevokedPasswordReset  SessionRevokeReason = "password_reset"
+	SessionRevokedPasswordChange SessionRevokeReason = "password_change"
+	SessionRevokedMFAReset       SessionRevokeReason = "mfa_reset"
+	// SessionRevokedAccountReclaimed ends the sessions of whoever registered
+	// an unverified email once its owner signs in with a provider
+	SessionRevokedAccountReclaimed SessionRevokeReason = "account_reclaimed"
+)
+
+// AuthSession is a signed in app or browser. Its refresh token rotates on
+// every refresh and only hashes of the current and previous token are kept
+type AuthSession struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	RefreshHash   string              `bson:"refresh_hash" json:"-"`
+	PreviousHash  string              `bson:"previous_hash,omitempty" json:"-"`
+	UserAgent     string              `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	IP            string              `bson:"ip,omitempty" json:"ip,omitempty"`
+	MFA           bool                `bson:"mfa" json:"mfa"` // Signed in with a second factor
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+	LastUsedAt    time.Time           `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt     time.Time           `bson:"expires_at" json:"expires_at"`
+	RevokedAt     *time.Time          `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	RevokedReason SessionRevokeReason `bson:"revoked_reason,omitempty" json:"revoked_reason,omitempty"`
+	Current       bool                `bson:"-" json:"current"` // Set when listed by the session it belongs to
+}
+
+// IsActive reports whether the session can still be used
+func (s *AuthSession) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
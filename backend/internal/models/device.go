@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeviceType string
+
+const (
+	DeviceTypeGateEnter  DeviceType = "gate-enter"
+	DeviceTypeGateExit   DeviceType = "gate-exit"
+	DeviceTypeSlotSensor DeviceType = "slot-sensor"
+)
+
+// IsValid reports whether t is a known device type
+func (t DeviceType) IsValid() bool {
+	switch t {
+	case DeviceTypeGateEnter, DeviceTypeGateExit, DeviceTypeSlotSensor:
+		return true
+	}
+	return false
+}
+
+type DeviceStatus string
+
+const (
+	DeviceStatusActive  DeviceStatus = "active"
+	DeviceStatusRevoked DeviceStatus = "revoked"
+)
+
+// Device is an Arduino module allowed to call the device API. Each device is
+// bound to one location and authenticates with its own secret
+type Device struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DeviceID        string             `bson:"device_id" json:"device_id"` // Sent by the module in X-Device-ID
+	Name            string             `bson:"name,omitempty" json:"name,omitempty"`
+	Type            DeviceType         `bson:"type" json:"type"`
+	LocationID      primitive.ObjectID `bson:"location_id" json:"location_id"`
+	SecretHash      string             `bson:"secret_hash" json:"-"` // SHA-256 of the secret, the secret itself is never stored
+	Status          DeviceStatus       `bson:"status" json:"status"`
+	LastSeenAt      *time.Time         `bson:"last_seen_at,omitempty" json:"last_seen_at,omitempty"`
+	BatteryLevel    *int               `bson:"battery_level,omitempty" json:"battery_level,omitempty"` // Percent from the last heartbeat, absent for mains powered boards
+	FirmwareVersion string             `bson:"firmware_version,omitempty" json:"firmware_version,omitempty"`
+	LastError       string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	OfflineSince    *time.Time         `bson:"offline_since,omitempty" json:"offline_since,omitempty"` // Set by the health monitor when the device goes silent
+	RotatedAt       *time.Time         `bson:"rotated_at,omitempty" json:"rotated_at,omitempty"`
+	RevokedAt       *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	CreatedBy       primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+}
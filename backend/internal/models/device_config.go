@@ -0,0 +1,56 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ModuleConfig holds the settings a device applies at runtime
+type ModuleConfig struct {
+	SampleRate    int     `bson:"sample_rate" json:"sampleRate"`       // Milliseconds between sensor reads
+	Threshold     float64 `bson:"threshold" json:"threshold"`          // Sensor detection threshold
+	AlertEnabled  bool    `bson:"alert_enabled" json:"alertEnabled"`   // Whether the device reports alerts
+	LedBrightness int     `bson:"led_brightness" json:"ledBrightness"` // 0-255, 0 turns the display backlight off
+}
+
+// DefaultModuleConfig matches the values previously hard-coded in the firmware
+var DefaultModuleConfig = ModuleConfig{
+	SampleRate:    5000,
+	Threshold:     500,
+	AlertEnabled:  true,
+	LedBrightness: 255,
+}
+
+// Validate checks that the settings are within what the firmware supports
+func (c *ModuleConfig) Validate() error {
+	if c.SampleRate < 500 || c.SampleRate > 3600000 {
+		return fmt.Errorf("sampleRate must be between 500 and 3600000 milliseconds")
+	}
+	if c.Threshold < 0 {
+		return fmt.Errorf("threshold cannot be negative")
+	}
+	if c.LedBrightness < 0 || c.LedBrightness > 255 {
+		return fmt.Errorf("ledBrightness must be between 0 and 255")
+	}
+	return nil
+}
+
+// DeviceConfig is the versioned configuration document for one device. The
+// version increases on every change; devices acknowledge the version they applied
+type DeviceConfig struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DeviceID       primitive.ObjectID `bson:"device_id" json:"device_id"`
+	Version        int                `bson:"version" json:"version"`
+	Config         ModuleConfig       `bson:"config" json:"config"`
+	AppliedVersion int                `bson:"applied_version" json:"applied_version"`
+	AppliedAt      *time.Time         `bson:"applied_at,omitempty" json:"applied_at,omitempty"`
+	UpdatedBy      primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ETag identifies the config version in HTTP caching headers
+func (c *DeviceConfig) ETag() string {
+	return fmt.Sprintf(`"v%d"`, c.Version)
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExternalIdentity links a user to their account at an OpenID provider,
+// identified by the provider's subject
+type ExternalIdentity struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Provider    string             `bson:"provider" json:"provider"`
+	Subject     string             `bson:"subject" json:"subject"`
+	Email       string             `bson:"email" json:"email"` // As last reported by the provider
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	LastLoginAt time.Time          `bson:"last_login_at" json:"last_login_at"`
+}
+
+// OIDCLoginState is an OpenID sign in in progress, from the redirect to the
+// provider until its callback. The state parameter carries its ID and a
+// secret, the PKCE verifier and nonce never leave the server
+type OIDCLoginState struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	Provider     string             `bson:"provider"`
+	SecretHash   string             `bson:"secret_hash"`
+	Nonce        string             `bson:"nonce"`
+	CodeVerifier string             `bson:"code_verifier"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type GuestSessionStatus string
+
+const (
+	GuestStatusInside          GuestSessionStatus = "inside"           // Entered, not yet at the exit gate
+	GuestStatusAwaitingPayment GuestSessionStatus = "awaiting_payment" // Refused at the exit gate until paid
+	GuestStatusPaid            GuestSessionStatus = "paid"             // Paid, the exit gate will open
+	GuestStatusExited          GuestSessionStatus = "exited"
+	GuestStatusClaimed         GuestSessionStatus = "claimed" // Moved into a user's booking while inside
+)
+
+// GuestStatusesOnSite are the statuses of a guest whose vehicle is still inside
+var GuestStatusesOnSite = []GuestSessionStatus{
+	GuestStatusInside,
+	GuestStatusAwaitingPayment,
+	GuestStatusPaid,
+}
+
+type GuestPaymentMethod string
+
+const (
+	GuestPaymentWallet GuestPaymentMethod = "wallet" // Paid from the wallet of whoever entered the pay code
+	GuestPaymentCash   GuestPaymentMethod = "cash"   // Settled by staff at the booth
+)
+
+// GuestSession tracks a vehicle whose plate is not registered from entry
+// until it pays and leaves. The pay code is shown on the exit gate
+type GuestSession struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	LocationID    primitive.ObjectID  `bson:"location_id" json:"location_id"`
+	PlateNumber   string              `bson:"plate_number" json:"plate_number"`
+	SpotNumber    string              `bson:"spot_number,omitempty" json:"spot_number,omitempty"`
+	PayCode       string              `bson:"pay_code" json:"pay_code"`
+	Status        GuestSessionStatus  `bson:"status" json:"status"`
+	EntryAt       time.Time           `bson:"entry_at" json:"entry_at"`
+	ExitAt        *time.Time          `bson:"exit_at,omitempty" json:"exit_at,omitempty"`
+	Amount        *float64            `bson:"amount,omitempty" json:"amount,omitempty"` // Set when the exit gate or a payment prices the stay
+	PaidAt        *time.Time          `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+	PaymentMethod GuestPaymentMethod  `bson:"payment_method,omitempty" json:"payment_method,omitempty"`
+	PaidBy        *primitive.ObjectID `bson:"paid_by,omitempty" json:"paid_by,omitempty"`
+	ClaimedBy     *primitive.ObjectID `bson:"claimed_by,omitempty" json:"claimed_by,omitempty"`
+	ClaimedAt     *time.Time          `bson:"claimed_at,omitempty" json:"claimed_at,omitempty"`
+	BookingID     *primitive.ObjectID `bson:"booking_id,omitempty" json:"booking_id,omitempty"` // Booking created when the session was claimed
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// IsOnSite reports whether the guest's vehicle is still inside
+func (g *GuestSession) IsOnSite() bool {
+	for _, status := range GuestStatusesOnSite {
+		if g.Status == status {
+			return true
+		}
+	}
+	return false
+}
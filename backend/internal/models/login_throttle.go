@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type LoginThrottleScope string
+
+const (
+	LoginThrottleAccount LoginThrottleScope = "account" // Keyed by the normalised email tried
+	LoginThrottleIP      LoginThrottleScope = "ip"
+)
+
+// LoginThrottle counts recent failed logins for an account or an IP address.
+// It is shared by all server instances and expires once it no longer blocks
+// and the failures are older than the counting window
+type LoginThrottle struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Scope          LoginThrottleScope `bson:"scope" json:"scope"`
+	Key            string             `bson:"key" json:"key"`
+	Failures       int                `bson:"failures" json:"failures"`
+	FirstFailureAt time.Time          `bson:"first_failure_at" json:"first_failure_at"`
+	LastFailureAt  time.Time          `bson:"last_failure_at" json:"last_failure_at"`
+	BlockedUntil   *time.Time         `bson:"blocked_until,omitempty" json:"blocked_until,omitempty"`
+	LockedAt       *time.Time         `bson:"locked_at,omitempty" json:"locked_at,omitempty"` // Set when the failures reached the lockout threshold
+	ExpiresAt      time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// BlockedFor returns how long logins stay blocked, zero if they are not
+func (t *LoginThrottle) BlockedFor(now time.Time) time.Duration {
+	if t.BlockedUntil == nil || !now.Before(*t.BlockedUntil) {
+		return 0
+	}
+	return t.BlockedUntil.Sub(now)
+}
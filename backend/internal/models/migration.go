@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// Migration records a one-off data migration that has been applied
+type Migration struct {
+	ID        string    `bson:"_id" json:"id"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+}
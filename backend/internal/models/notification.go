@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type NotificationChannel string
+
+const (
+	NotificationChannelEmail NotificationChannel = "email"
+	NotificationChannelPush  NotificationChannel = "push"
+	NotificationChannelSMS   NotificationChannel = "sms"
+)
+
+type NotificationStatus string
+
+const (
+	NotificationStatusQueued  NotificationStatus = "queued"
+	NotificationStatusSent    NotificationStatus = "sent"
+	NotificationStatusFailed  NotificationStatus = "failed"
+	NotificationStatusSkipped NotificationStatus = "skipped"
+)
+
+// NotificationPreferences holds the channels a user wants to be notified on
+type NotificationPreferences struct {
+	ID          primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID    `bson:"user_id" json:"user_id"`
+	Channels    []NotificationChannel `bson:"channels" json:"channels"`
+	PhoneNumber string                `bson:"phone_number,omitempty" json:"phone_number,omitempty"`
+	PushToken   string                `bson:"push_token,omitempty" json:"push_token,omitempty"`
+	Muted       []string              `bson:"muted,omitempty" json:"muted,omitempty"` // Template names the user opted out of
+	UpdatedAt   time.Time             `bson:"updated_at" json:"updated_at"`
+}
+
+// DefaultNotificationPreferences returns the preferences used until a user saves their own
+func DefaultNotificationPreferences(userID primitive.ObjectID) *NotificationPreferences {
+	return &NotificationPreferences{
+		UserID:   userID,
+		Channels: []NotificationChannel{NotificationChannelEmail},
+	}
+}
+
+// IsMuted reports whether the user opted out of the given template
+func (p *NotificationPreferences) IsMuted(template string) bool {
+	for _, muted := range p.Muted {
+		if muted == template {
+			return true
+		}
+	}
+	return false
+}
+
+// Notification is an outbox entry for a single message on a single channel
+type Notification struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Channel   NotificationChannel `bson:"channel" json:"channel"`
+	Template  string              `bson:"template" json:"template"`
+	To        string              `bson:"to" json:"to"`
+	Subject   string              `bson:"subject" json:"subject"`
+	Body      string              `bson:"body" json:"body"`
+	Status    NotificationStatus  `bson:"status" json:"status"`
+	Error     string              `bson:"error,omitempty" json:"error,omitempty"`
+	DedupeKey string              `bson:"dedupe_key,omitempty" json:"-"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	SentAt    *time.Time          `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MismatchType string
+
+const (
+	// MismatchUnbookedOccupancy is an occupied slot without an active booking
+	MismatchUnbookedOccupancy MismatchType = "unbooked_occupancy"
+	// MismatchEmptyBookedSlot is an active booking whose slot reads empty
+	MismatchEmptyBookedSlot MismatchType = "empty_booked_slot"
+	// MismatchReservedSlotTaken is a slot reserved for a current pre-booking
+	// that is occupied before the reserved vehicle entered
+	MismatchReservedSlotTaken MismatchType = "reserved_slot_taken"
+)
+
+type MismatchStatus string
+
+const (
+	MismatchStatusOpen     MismatchStatus = "open"
+	MismatchStatusResolved MismatchStatus = "resolved"
+)
+
+const (
+	MismatchResolutionCleared    = "cleared"    // Sensor and bookings agree again
+	MismatchResolutionReassigned = "reassigned" // The booking was moved to the slot the vehicle is in
+)
+
+// OccupancyMismatch records a disagreement between slot sensors and bookings
+// found by the reconciliation job
+type OccupancyMismatch struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	LocationID      primitive.ObjectID  `bson:"location_id" json:"location_id"`
+	SlotNumber      string              `bson:"slot_number" json:"slot_number"`
+	Type            MismatchType        `bson:"type" json:"type"`
+	BookingID       *primitive.ObjectID `bson:"booking_id,omitempty" json:"booking_id,omitempty"` // The active booking or the reservation involved
+	Status          MismatchStatus      `bson:"status" json:"status"`
+	FirstDetectedAt time.Time           `bson:"first_detected_at" json:"first_detected_at"`
+	LastDetectedAt  time.Time           `bson:"last_detected_at" json:"last_detected_at"`
+	AlertedAt       *time.Time          `bson:"alerted_at,omitempty" json:"alerted_at,omitempty"`
+	ResolvedAt      *time.Time          `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+	Resolution      string              `bson:"resolution,omitempty" json:"resolution,omitempty"`
+	ReassignedTo    string              `bson:"reassigned_to,omitempty" json:"reassigned_to,omitempty"`
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BillingPolicy decides which wallet pays for a stay of an organization's vehicle
+type BillingPolicy string
+
+const (
+	BillingOrganization      BillingPolicy = "organization"       // Always the organization wallet, the gate refuses when it cannot pay
+	BillingOrganizationFirst BillingPolicy = "organization_first" // The organization wallet, the driver's own wallet when it cannot pay
+	BillingPersonal          BillingPolicy = "personal"           // Always the driver's own wallet
+)
+
+// Organization owns a fleet of vehicles and a shared wallet that pays for
+// them. Members are stored separately
+type Organization struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name          string             `bson:"name" json:"name"`
+	BillingPolicy BillingPolicy      `bson:"billing_policy" json:"billing_policy"`
+	CreatedBy     primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+func (o Organization) Validate() error {
+	return validation.ValidateStruct(&o,
+		validation.Field(&o.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&o.BillingPolicy, validation.Required, validation.In(
+			BillingOrganization, BillingOrganizationFirst, BillingPersonal,
+		)),
+	)
+}
+
+type OrgRole string
+
+const (
+	OrgRoleFleetManager OrgRole = "fleet_manager" // Manages members, vehicles and the wallet
+	OrgRoleDriver       OrgRole = "driver"        // Parks the organization's vehicles
+)
+
+type OrgMemberStatus string
+
+const (
+	OrgMemberInvited OrgMemberStatus = "invited" // Added by a fleet manager, not yet accepted
+	OrgMemberActive  OrgMemberStatus = "active"
+)
+
+// OrgMember is a user's membership of an organization. A driver's charges to
+// the organization wallet are capped per calendar month by SpendingLimit,
+// nil means no limit
+type OrgMember struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID `bson:"organization_id" json:"organization_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role           OrgRole            `bson:"role" json:"role"`
+	SpendingLimit  *float64           `bson:"spending_limit,omitempty" json:"spending_limit,omitempty"`
+	// Status is empty on memberships made before invitations existed, which
+	// counts as active
+	Status     OrgMemberStatus `bson:"status,omitempty" json:"status,omitempty"`
+	AcceptedAt *time.Time      `bson:"accepted_at,omitempty" json:"accepted_at,omitempty"`
+	CreatedAt  time.Time       `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time       `bson:"updated_at" json:"updated_at"`
+}
+
+func (m OrgMember) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.UserID, validation.Required),
+		validation.Field(&m.Role, validation.Required, validation.In(OrgRoleFleetManager, OrgRoleDriver)),
+		validation.Field(&m.SpendingLimit, validation.Min(0.0)),
+	)
+}
+
+// OrgMemberSpend counts what a member charged to the organization wallet in a
+// calendar month. Charges reserve their amount here with a conditional
+// increment, so concurrent charges cannot overshoot the spending limit
+type OrgMemberSpend struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	MemberID  primitive.ObjectID `bson:"member_id" json:"member_id"`
+	Period    string             `bson:"period" json:"period"` // Calendar month as YYYY-MM
+	Spent     float64            `bson:"spent" json:"spent"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// IsManager reports whether the member may manage the organization
+func (m *OrgMember) IsManager() bool {
+	return m.Role == OrgRoleFleetManager
+}
+
+// IsActive reports whether the user accepted the membership
+func (m *OrgMember) IsActive() bool {
+	return m.Status != OrgMemberInvited
+}
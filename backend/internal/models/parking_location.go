@@ -1,25 +1,373 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// DefaultHourlyRate is the price in wallet points charged per started hour
+// when a location does not define its own rate
+const DefaultHourlyRate = 100.0
+
+// Common slot types
+const (
+	SlotTypeStandard = "standard"
+	SlotTypeHandicap = "handicap"
+	SlotTypeElectric = "electric"
+	// SlotTypeMotorcycle bays take motorcycles only, and motorcycles park
+	// nowhere else
+	SlotTypeMotorcycle = "motorcycle"
+)
+
 // ParkingSlot represents a single parking slot in a location
 type ParkingSlot struct {
-	Number     string `bson:"number" json:"number"`           // Slot number/identifier
-	IsOccupied bool   `bson:"is_occupied" json:"is_occupied"` // Current occupancy status
-	Type       string `bson:"type" json:"type"`               // e.g., "standard", "handicap", "electric"
+	Number     string          `bson:"number" json:"number"`                             // Slot number/identifier
+	IsOccupied bool            `bson:"is_occupied" json:"is_occupied"`                   // Current occupancy status
+	Type       string          `bson:"type" json:"type"`                                 // e.g., "standard", "handicap", "electric", "motorcycle"
+	Zone       string          `bson:"zone,omitempty" json:"zone,omitempty"`             // Area of the location the slot is in, e.g. a floor
+	MaxSize    VehicleSize     `bson:"max_size,omitempty" json:"max_size,omitempty"`     // Largest vehicle size class that fits, empty means any
+	Connectors []ConnectorType `bson:"connectors,omitempty" json:"connectors,omitempty"` // Chargers of an electric slot, empty means any
+}
+
+// SlotType returns the slot's type, treating an unset type as standard
+func (s ParkingSlot) SlotType() string {
+	if s.Type == "" {
+		return SlotTypeStandard
+	}
+	return s.Type
+}
+
+// Fits reports whether the vehicle may park in the slot. Electric slots are
+// kept for vehicles that can charge there
+func (s ParkingSlot) Fits(vehicle *Vehicle) bool {
+	slotType := s.SlotType()
+	if (slotType == SlotTypeMotorcycle) != (vehicle.EffectiveCategory() == VehicleCategoryMotorcycle) {
+		return false
+	}
+	if s.MaxSize != "" && vehicle.EffectiveSize().Rank() > s.MaxSize.Rank() {
+		return false
+	}
+	if slotType == SlotTypeElectric {
+		return vehicle.FuelType.Chargeable() && s.hasConnector(vehicle.Connector)
+	}
+	return true
+}
+
+func (s ParkingSlot) hasConnector(connector ConnectorType) bool {
+	if len(s.Connectors) == 0 {
+		return true
+	}
+	for _, c := range s.Connectors {
+		if c == connector {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the slot's size class and chargers
+func (s ParkingSlot) validate() error {
+	if s.MaxSize != "" && s.MaxSize.Rank() == 0 {
+		return fmt.Errorf("slot %s: invalid max size %q", s.Number, s.MaxSize)
+	}
+	if len(s.Connectors) > 0 && s.SlotType() != SlotTypeElectric {
+		return fmt.Errorf("slot %s: only electric slots have connectors", s.Number)
+	}
+	for _, connector := range s.Connectors {
+		if !connector.IsValid() {
+			return fmt.Errorf("slot %s: invalid connector %q", s.Number, connector)
+		}
+	}
+	return nil
+}
+
+// GeoPoint is a GeoJSON point. Coordinates are stored as [longitude, latitude]
+type GeoPoint struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// NewGeoPoint creates a GeoJSON point from a latitude/longitude pair
+func NewGeoPoint(lat, lng float64) *GeoPoint {
+	return &GeoPoint{
+		Type:        "Point",
+		Coordinates: []float64{lng, lat},
+	}
+}
+
+// OpeningHours defines when a location is open on a given weekday.
+// Times are "HH:MM" in the location's timezone; a close time at or before
+// the open time means the location closes after midnight
+type OpeningHours struct {
+	Weekday time.Weekday `bson:"weekday" json:"weekday"` // 0 = Sunday
+	Open    string       `bson:"open" json:"open"`
+	Close   string       `bson:"close" json:"close"`
+}
+
+// HolidayClosure marks a full day on which the location is closed
+type HolidayClosure struct {
+	Date   string `bson:"date" json:"date"` // YYYY-MM-DD in the location's timezone
+	Reason string `bson:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// SensorPolicy controls how raw slot sensor readings are debounced before
+// they change a slot's occupancy. Separate sample counts for occupying and
+// vacating a slot provide hysteresis
+type SensorPolicy struct {
+	OccupySamples    int  `bson:"occupy_samples" json:"occupy_samples"`                             // Consecutive occupied readings needed to mark a slot occupied
+	VacateSamples    int  `bson:"vacate_samples" json:"vacate_samples"`                             // Consecutive free readings needed to mark a slot free
+	MinStableSeconds *int `bson:"min_stable_seconds,omitempty" json:"min_stable_seconds,omitempty"` // Minimum time the readings must agree for, 0 disables the time window
+}
+
+var defaultMinStableSeconds = 10
+
+// DefaultSensorPolicy suits the parking-lot sketch posting every 5 seconds
+var DefaultSensorPolicy = SensorPolicy{
+	OccupySamples:    3,
+	VacateSamples:    3,
+	MinStableSeconds: &defaultMinStableSeconds,
+}
+
+// MaxSensorSamples bounds the debounce window a location can configure
+const MaxSensorSamples = 20
+
+// SensorReading is a raw occupancy reading reported by a slot sensor
+type SensorReading struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	LocationID primitive.ObjectID `bson:"location_id" json:"location_id"`
+	SlotNumber string             `bson:"slot_number" json:"slot_number"`
+	IsOccupied bool               `bson:"is_occupied" json:"is_occupied"`
+	ReceivedAt time.Time          `bson:"received_at" json:"received_at"`
 }
 
 // ParkingLocation represents a parking facility
 type ParkingLocation struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name       string             `bson:"name" json:"name"`               // Name of the parking location
-	Address    string             `bson:"address" json:"address"`         // Physical address
-	Slots      []ParkingSlot      `bson:"slots" json:"slots"`             // List of parking slots
-	TotalSlots int                `bson:"total_slots" json:"total_slots"` // Total number of slots
-	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name            string             `bson:"name" json:"name"`                                             // Name of the parking location
+	Address         string             `bson:"address" json:"address"`                                       // Physical address
+	Location        *GeoPoint          `bson:"location,omitempty" json:"location,omitempty"`                 // GeoJSON coordinates
+	Timezone        string             `bson:"timezone,omitempty" json:"timezone,omitempty"`                 // IANA timezone, defaults to server local time
+	OpeningHours    []OpeningHours     `bson:"opening_hours,omitempty" json:"opening_hours,omitempty"`       // Empty means open 24/7
+	HolidayClosures []HolidayClosure   `bson:"holiday_closures,omitempty" json:"holiday_closures,omitempty"` // Full-day closures
+	HourlyRate      float64            `bson:"hourly_rate,omitempty" json:"hourly_rate,omitempty"`           // Points per started hour
+	SensorPolicy    *SensorPolicy      `bson:"sensor_policy,omitempty" json:"sensor_policy,omitempty"`       // Debounce rules, defaults to DefaultSensorPolicy
+	Slots           []ParkingSlot      `bson:"slots" json:"slots"`                                           // List of parking slots
+	TotalSlots      int                `bson:"total_slots" json:"total_slots"`                               // Total number of slots
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// FreeSlots returns the number of slots that are not currently occupied
+func (l *ParkingLocation) FreeSlots() int {
+	free := 0
+	for _, slot := range l.Slots {
+		if !slot.IsOccupied {
+			free++
+		}
+	}
+	return free
+}
+
+// CurrentRate returns the hourly rate for the location, falling back to the default
+func (l *ParkingLocation) CurrentRate() float64 {
+	if l.HourlyRate > 0 {
+		return l.HourlyRate
+	}
+	return DefaultHourlyRate
+}
+
+// EffectiveSensorPolicy returns the location's sensor policy with defaults
+// filled in for unset values
+func (l *ParkingLocation) EffectiveSensorPolicy() SensorPolicy {
+	policy := DefaultSensorPolicy
+	if l.SensorPolicy == nil {
+		return policy
+	}
+	if l.SensorPolicy.OccupySamples > 0 {
+		policy.OccupySamples = l.SensorPolicy.OccupySamples
+	}
+	if l.SensorPolicy.VacateSamples > 0 {
+		policy.VacateSamples = l.SensorPolicy.VacateSamples
+	}
+	if l.SensorPolicy.MinStableSeconds != nil {
+		policy.MinStableSeconds = l.SensorPolicy.MinStableSeconds
+	}
+	return policy
+}
+
+// MinStable returns how long readings must agree before they change a slot.
+// Zero means the sample count alone decides
+func (p SensorPolicy) MinStable() time.Duration {
+	if p.MinStableSeconds == nil {
+		return 0
+	}
+	return time.Duration(*p.MinStableSeconds) * time.Second
+}
+
+// FindSlotFor returns the index of the free slot that suits the vehicle best,
+// or -1. Chargeable vehicles are steered to electric slots, then the tightest
+// fitting slot is taken so larger ones stay free for larger vehicles
+func (l *ParkingLocation) FindSlotFor(vehicle *Vehicle) int {
+	best := -1
+	for i, slot := range l.Slots {
+		if slot.IsOccupied || !slot.Fits(vehicle) {
+			continue
+		}
+		if best == -1 || betterSlot(slot, l.Slots[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// betterSlot reports whether a is a better choice than b for a vehicle that
+// fits both
+func betterSlot(a, b ParkingSlot) bool {
+	aElectric, bElectric := a.SlotType() == SlotTypeElectric, b.SlotType() == SlotTypeElectric
+	if aElectric != bElectric {
+		return aElectric
+	}
+	return slotCapacity(a) < slotCapacity(b)
+}
+
+// slotCapacity ranks a slot by the largest size class it takes
+func slotCapacity(slot ParkingSlot) int {
+	if slot.MaxSize == "" {
+		return VehicleSizeOversize.Rank() + 1
+	}
+	return slot.MaxSize.Rank()
+}
+
+// FindSlot returns the index of the slot with the given number, or -1
+func (l *ParkingLocation) FindSlot(number string) int {
+	for i, slot := range l.Slots {
+		if slot.Number == number {
+			return i
+		}
+	}
+	return -1
+}
+
+// timeLocation resolves the location's timezone, falling back to server local time
+func (l *ParkingLocation) timeLocation() *time.Location {
+	if l.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(l.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// IsOpenAt reports whether the location is open at t. When closed, the
+// returned reason explains why so it can be shown to the driver
+func (l *ParkingLocation) IsOpenAt(t time.Time) (bool, string) {
+	t = t.In(l.timeLocation())
+
+	today := t.Format("2006-01-02")
+	for _, closure := range l.HolidayClosures {
+		if closure.Date == today {
+			if closure.Reason != "" {
+				return false, fmt.Sprintf("closed for holiday: %s", closure.Reason)
+			}
+			return false, "closed for holiday"
+		}
+	}
+
+	if len(l.OpeningHours) == 0 {
+		return true, ""
+	}
+
+	minutes := t.Hour()*60 + t.Minute()
+	yesterday := (t.Weekday() + 6) % 7
+
+	for _, hours := range l.OpeningHours {
+		open, err := parseClock(hours.Open)
+		if err != nil {
+			continue
+		}
+		closeAt, err := parseClock(hours.Close)
+		if err != nil {
+			continue
+		}
+
+		if closeAt > open {
+			if hours.Weekday == t.Weekday() && minutes >= open && minutes < closeAt {
+				return true, ""
+			}
+			continue
+		}
+
+		// Overnight opening, e.g. 18:00 - 02:00
+		if hours.Weekday == t.Weekday() && minutes >= open {
+			return true, ""
+		}
+		if hours.Weekday == yesterday && minutes < closeAt {
+			return true, ""
+		}
+	}
+
+	return false, "outside opening hours"
+}
+
+// ValidateSchedule checks that opening hours, holiday closures, coordinates,
+// the sensor policy and slot attributes are well formed
+func (l *ParkingLocation) ValidateSchedule() error {
+	if l.Timezone != "" {
+		if _, err := time.LoadLocation(l.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", l.Timezone)
+		}
+	}
+	for _, hours := range l.OpeningHours {
+		if hours.Weekday < time.Sunday || hours.Weekday > time.Saturday {
+			return fmt.Errorf("invalid weekday %d", hours.Weekday)
+		}
+		if _, err := parseClock(hours.Open); err != nil {
+			return err
+		}
+		if _, err := parseClock(hours.Close); err != nil {
+			return err
+		}
+	}
+	for _, closure := range l.HolidayClosures {
+		if _, err := time.Parse("2006-01-02", closure.Date); err != nil {
+			return fmt.Errorf("invalid holiday date %q", closure.Date)
+		}
+	}
+	if p := l.SensorPolicy; p != nil {
+		if p.OccupySamples < 0 || p.VacateSamples < 0 || (p.MinStableSeconds != nil && *p.MinStableSeconds < 0) {
+			return fmt.Errorf("sensor policy values cannot be negative")
+		}
+		if p.OccupySamples > MaxSensorSamples || p.VacateSamples > MaxSensorSamples {
+			return fmt.Errorf("sensor policy sample counts cannot exceed %d", MaxSensorSamples)
+		}
+	}
+	for _, slot := range l.Slots {
+		if err := slot.validate(); err != nil {
+			return err
+		}
+	}
+	if l.Location != nil {
+		if len(l.Location.Coordinates) != 2 {
+			return fmt.Errorf("location coordinates must be [longitude, latitude]")
+		}
+		lng, lat := l.Location.Coordinates[0], l.Location.Coordinates[1]
+		if lng < -180 || lng > 180 || lat < -90 || lat > 90 {
+			return fmt.Errorf("location coordinates out of range")
+		}
+		l.Location.Type = "Point"
+	}
+	return nil
+}
+
+// parseClock converts "HH:MM" into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
 }
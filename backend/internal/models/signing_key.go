@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// SigningKey is a stored key of the token signing key set. Sequence grows by
+// one per rotation so concurrent rotations cannot both succeed. The private
+// key is stored encrypted with the configured key encryption key
+type SigningKey struct {
+	ID                  string     `bson:"_id" json:"kid"`
+	Sequence            int64      `bson:"sequence" json:"sequence"`
+	EncryptedPrivateKey string     `bson:"encrypted_private_key,omitempty" json:"-"`
+	PrivateKeyPEM       string     `bson:"private_key_pem,omitempty" json:"-"` // Plaintext keys stored before encryption, sealed at startup
+	CreatedAt           time.Time  `bson:"created_at" json:"created_at"`
+	ActivatesAt         time.Time  `bson:"activates_at" json:"activates_at"`
+	ExpiresAt           *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // Set once a newer key takes over signing
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TelemetryMeta identifies the source of a telemetry point. It is the
+// metaField of the time-series collection, so queries on it are cheap
+type TelemetryMeta struct {
+	DeviceID   primitive.ObjectID `bson:"device_id" json:"device_id"`
+	ModuleID   string             `bson:"module_id" json:"module_id"`
+	LocationID primitive.ObjectID `bson:"location_id" json:"location_id"`
+	SensorType string             `bson:"sensor_type" json:"sensor_type"`
+}
+
+// TelemetryPoint is a single batch of readings reported by a device
+type TelemetryPoint struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Meta       TelemetryMeta          `bson:"meta" json:"meta"`
+	Timestamp  time.Time              `bson:"timestamp" json:"timestamp"`
+	Readings   map[string]interface{} `bson:"readings" json:"readings"`
+	ReceivedAt time.Time              `bson:"received_at" json:"received_at"`
+}
+
+// MetricStats summarizes the numeric values of one reading over a period
+type MetricStats struct {
+	Count int     `bson:"count" json:"count"`
+	Min   float64 `bson:"min" json:"min"`
+	Max   float64 `bson:"max" json:"max"`
+	Avg   float64 `bson:"avg" json:"avg"`
+}
+
+// TelemetryRollup is the hourly downsample of a device's telemetry, kept
+// longer than the raw points
+type TelemetryRollup struct {
+	ID      primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Meta    TelemetryMeta          `bson:"meta" json:"meta"`
+	Hour    time.Time              `bson:"hour" json:"hour"`
+	Samples int                    `bson:"samples" json:"samples"`
+	Metrics map[string]MetricStats `bson:"metrics" json:"metrics"`
+}
+
+type AlertSeverity string
+
+const (
+	AlertSeverityInfo     AlertSeverity = "info"
+	AlertSeverityWarning  AlertSeverity = "warning"
+	AlertSeverityCritical AlertSeverity = "critical"
+)
+
+// IsValid reports whether s is a known severity
+func (s AlertSeverity) IsValid() bool {
+	switch s {
+	case AlertSeverityInfo, AlertSeverityWarning, AlertSeverityCritical:
+		return true
+	}
+	return false
+}
+
+// DeviceAlert is a problem reported by a device, e.g. a gate obstruction or a camera failure
+type DeviceAlert struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	DeviceID   primitive.ObjectID     `bson:"device_id" json:"device_id"`
+	ModuleID   string                 `bson:"module_id" json:"module_id"`
+	LocationID primitive.ObjectID     `bson:"location_id" json:"location_id"`
+	Type       string                 `bson:"type" json:"type"`
+	Severity   AlertSeverity          `bson:"severity" json:"severity"`
+	Timestamp  time.Time              `bson:"timestamp" json:"timestamp"`
+	Details    map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	ReceivedAt time.Time              `bson:"received_at" json:"received_at"`
+}
@@ -14,6 +14,9 @@ const (
 	UserStatusActive   = "active"
 	UserStatusInactive = "inactive"
 	UserStatusBanned   = "banned"
+	// UserStatusPendingVerification accounts can sign in but only manage their
+	// account until the email address is verified
+	UserStatusPendingVerification = "pending_verification"
 
 	MinPasswordLength = 8
 	MaxPasswordLength = 72
@@ -23,19 +26,26 @@ type Role string
 
 const (
 	RoleAdmin Role = "admin"
-	RoleUser  Role = "user"
+	// RoleSiteOperator runs the locations listed in the user's LocationIDs
+	RoleSiteOperator Role = "site_operator"
+	// RoleAttendant works the booth at the locations in LocationIDs
+	RoleAttendant Role = "attendant"
+	// RoleUser is a driver
+	RoleUser Role = "user"
 )
 
 type User struct {
-	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Name            string             `json:"name" bson:"name"`
-	Email           string             `json:"email" bson:"email"`
-	Password        string             `json:"password,omitempty" bson:"password"`
-	Role            Role               `json:"role" bson:"role"`
-	Status          string             `json:"status" bson:"status"`
-	ProfileImageURL string             `json:"profile_image_url,omitempty" bson:"profile_image_url,omitempty"`
-	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt       time.Time          `json:"updated_at" bson:"updated_at"`
+	ID              primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Name            string               `json:"name" bson:"name"`
+	Email           string               `json:"email" bson:"email"`
+	Password        string               `json:"password,omitempty" bson:"password"`
+	Role            Role                 `json:"role" bson:"role"`
+	Status          string               `json:"status" bson:"status"`
+	LocationIDs     []primitive.ObjectID `json:"location_ids,omitempty" bson:"location_ids,omitempty"`
+	EmailVerifiedAt *time.Time           `json:"email_verified_at,omitempty" bson:"email_verified_at,omitempty"`
+	ProfileImageURL string               `json:"profile_image_url,omitempty" bson:"profile_image_url,omitempty"`
+	CreatedAt       time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time            `json:"updated_at" bson:"updated_at"`
 }
 
 func (u User) Validate() error {
@@ -62,13 +72,13 @@ func (u User) Validate() error {
 		// Role validation
 		validation.Field(&u.Role,
 			validation.Required.Error("role is required"),
-			validation.In(RoleAdmin, RoleUser).Error("invalid role"),
+			validation.In(RoleAdmin, RoleSiteOperator, RoleAttendant, RoleUser).Error("invalid role"),
 		),
 
 		// Status validation
 		validation.Field(&u.Status,
 			validation.Required.Error("status is required"),
-			validation.In(UserStatusActive, UserStatusInactive, UserStatusBanned).
+			validation.In(UserStatusActive, UserStatusInactive, UserStatusBanned, UserStatusPendingVerification).
 				Error("invalid status"),
 		),
 	)
@@ -91,16 +101,25 @@ func (u *User) ValidateUpdate() error {
 		),
 		validation.Field(&u.Role,
 			validation.Required.Error("role is required"),
-			validation.In(RoleAdmin, RoleUser).Error("invalid role"),
+			validation.In(RoleAdmin, RoleSiteOperator, RoleAttendant, RoleUser).Error("invalid role"),
 		),
 		validation.Field(&u.Status,
 			validation.Required.Error("status is required"),
-			validation.In(UserStatusActive, UserStatusInactive, UserStatusBanned).
+			validation.In(UserStatusActive, UserStatusInactive, UserStatusBanned, UserStatusPendingVerification).
 				Error("invalid status"),
 		),
 	)
 }
 
+// ValidatePassword checks a new password against the length limits
+func ValidatePassword(password string) error {
+	return validation.Validate(password,
+		validation.Required.Error("password is required"),
+		validation.Length(MinPasswordLength, MaxPasswordLength).
+			Error("password must be between 8 and 72 characters"),
+	)
+}
+
 func (u *User) HashPassword() error {
 	if len(u.Password) == 0 {
 		return validation.NewError("validation_error", "password cannot be empty")
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserMFA is a user's TOTP enrolment. It is pending until a code from the
+// authenticator app confirms it. Recovery codes are stored as hashes and
+// each can be used once
+type UserMFA struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID             primitive.ObjectID `bson:"user_id" json:"-"`
+	Secret             string             `bson:"secret" json:"-"`
+	LastUsedStep       int64              `bson:"last_used_step" json:"-"` // Time step of the last accepted code, codes are single use
+	RecoveryCodeHashes []string           `bson:"recovery_code_hashes,omitempty" json:"-"`
+	ConfirmedAt        *time.Time         `bson:"confirmed_at,omitempty" json:"-"`
+	CreatedAt          time.Time          `bson:"created_at" json:"-"`
+	UpdatedAt          time.Time          `bson:"updated_at" json:"-"`
+}
+
+// Enabled reports whether sign in asks for a second factor
+func (m *UserMFA) Enabled() bool {
+	return m != nil && m.ConfirmedAt != nil
+}
+
+// MFAChallenge is the second login step for a user whose password checked
+// out. The client presents its token with a TOTP or recovery code
+type MFAChallenge struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	SecretHash string             `bson:"secret_hash" json:"-"`
+	Attempts   int                `bson:"attempts" json:"attempts"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time          `bson:"expires_at" json:"expires_at"`
+}
@@ -1,16 +1,196 @@
 package models
 
 import (
+	"time"
+
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type VehicleCategory string
+
+const (
+	VehicleCategoryMotorcycle VehicleCategory = "motorcycle"
+	VehicleCategoryCar        VehicleCategory = "car"
+	VehicleCategoryVan        VehicleCategory = "van"
+	VehicleCategoryTruck      VehicleCategory = "truck"
+)
+
+// DefaultSize is the size class of a typical vehicle of the category
+func (c VehicleCategory) DefaultSize() VehicleSize {
+	switch c {
+	case VehicleCategoryMotorcycle:
+		return VehicleSizeSmall
+	case VehicleCategoryVan:
+		return VehicleSizeLarge
+	case VehicleCategoryTruck:
+		return VehicleSizeOversize
+	default:
+		return VehicleSizeMedium
+	}
+}
+
+// VehicleSize is a dimensions class, ordered from small to oversize. Slots
+// declare the largest class they take
+type VehicleSize string
+
+const (
+	VehicleSizeSmall    VehicleSize = "small"    // Motorcycles and scooters
+	VehicleSizeMedium   VehicleSize = "medium"   // Most cars
+	VehicleSizeLarge    VehicleSize = "large"    // SUVs and vans
+	VehicleSizeOversize VehicleSize = "oversize" // Trucks and high vans
+)
+
+var vehicleSizeRanks = map[VehicleSize]int{
+	VehicleSizeSmall:    1,
+	VehicleSizeMedium:   2,
+	VehicleSizeLarge:    3,
+	VehicleSizeOversize: 4,
+}
+
+// Rank orders size classes, 0 means the size is unknown
+func (s VehicleSize) Rank() int {
+	return vehicleSizeRanks[s]
+}
+
+type FuelType string
+
+const (
+	FuelPetrol       FuelType = "petrol"
+	FuelDiesel       FuelType = "diesel"
+	FuelHybrid       FuelType = "hybrid"
+	FuelPlugInHybrid FuelType = "plug_in_hybrid"
+	FuelElectric     FuelType = "electric"
+)
+
+// Chargeable reports whether vehicles with the fuel type plug in to charge
+func (f FuelType) Chargeable() bool {
+	return f == FuelElectric || f == FuelPlugInHybrid
+}
+
+// ConnectorType is the charging inlet of an electric or plug-in vehicle
+type ConnectorType string
+
+const (
+	ConnectorType1   ConnectorType = "type1"
+	ConnectorType2   ConnectorType = "type2"
+	ConnectorCCS1    ConnectorType = "ccs1"
+	ConnectorCCS2    ConnectorType = "ccs2"
+	ConnectorCHAdeMO ConnectorType = "chademo"
+	ConnectorNACS    ConnectorType = "nacs"
+	ConnectorGBT     ConnectorType = "gbt"
+)
+
+var connectorTypes = []interface{}{
+	ConnectorType1, ConnectorType2, ConnectorCCS1, ConnectorCCS2, ConnectorCHAdeMO, ConnectorNACS, ConnectorGBT,
+}
+
+// IsValid reports whether the connector is a known type
+func (c ConnectorType) IsValid() bool {
+	for _, known := range connectorTypes {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxColourLength bounds the free text colour of a vehicle
+const MaxColourLength = 30
+
+type VehicleVerification string
+
+const (
+	VehicleUnverified          VehicleVerification = "unverified"
+	VehiclePendingVerification VehicleVerification = "pending" // A registration document awaits review
+	VehicleVerified            VehicleVerification = "verified"
+)
+
+type VehicleDriverStatus string
+
+const (
+	VehicleDriverInvited VehicleDriverStatus = "invited"
+	VehicleDriverActive  VehicleDriverStatus = "active"
+)
+
+// VehicleDriver is a user the owner allowed to book with their vehicle. The
+// invitation must be accepted first
+type VehicleDriver struct {
+	UserID     primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Email      string              `json:"email" bson:"email"`
+	Status     VehicleDriverStatus `json:"status" bson:"status"`
+	InvitedAt  time.Time           `json:"invited_at" bson:"invited_at"`
+	AcceptedAt *time.Time          `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+}
+
 type Vehicle struct {
 	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty" `
 	PlateNumber string             `json:"plate_number" bson:"plate_number" validate:"required"`
 	Brand       string             `json:"brand" bson:"brand" validate:"required"`
 	Model       string             `json:"model" bson:"model" validate:"required"`
 	Owner       primitive.ObjectID `json:"owner" bson:"owner" validate:"required"`
+	// The profile decides which slots the vehicle fits. Vehicles registered
+	// before it existed are treated as medium sized cars
+	Category  VehicleCategory `json:"category" bson:"category"`
+	Size      VehicleSize     `json:"size" bson:"size"`
+	FuelType  FuelType        `json:"fuel_type" bson:"fuel_type"`
+	Connector ConnectorType   `json:"connector" bson:"connector"` // Only set for chargeable fuel types
+	Colour    string          `json:"colour" bson:"colour"`
+	// OrganizationID is set on fleet vehicles. Joining a fleet never changes
+	// the Owner, AssignedDriver names the member the vehicle is assigned to
+	OrganizationID *primitive.ObjectID `json:"organization_id,omitempty" bson:"organization_id,omitempty"`
+	AssignedDriver *primitive.ObjectID `json:"assigned_driver,omitempty" bson:"assigned_driver,omitempty"`
+	// OrganizationInvite is the organization waiting for the owner to accept
+	// the vehicle into its fleet
+	OrganizationInvite *primitive.ObjectID `json:"organization_invite,omitempty" bson:"organization_invite,omitempty"`
+	// Verification is empty on vehicles registered before verification
+	// existed, which counts as unverified
+	Verification VehicleVerification `json:"verification_status" bson:"verification_status,omitempty"`
+	VerifiedAt   *time.Time          `json:"verified_at,omitempty" bson:"verified_at"`
+	Drivers      []VehicleDriver     `json:"drivers,omitempty" bson:"drivers,omitempty"`
+}
+
+// EffectiveCategory returns the vehicle's category, treating an unset
+// category as a car
+func (v *Vehicle) EffectiveCategory() VehicleCategory {
+	if v.Category == "" {
+		return VehicleCategoryCar
+	}
+	return v.Category
+}
+
+// EffectiveSize returns the vehicle's size class, falling back to the usual
+// size of its category
+func (v *Vehicle) EffectiveSize() VehicleSize {
+	if v.Size == "" {
+		return v.EffectiveCategory().DefaultSize()
+	}
+	return v.Size
+}
+
+// IsVerified reports whether an admin accepted the owner's registration document
+func (v *Vehicle) IsVerified() bool {
+	return v.Verification == VehicleVerified
+}
+
+// CanBeDrivenBy reports whether the user may book with the vehicle, as its
+// owner, the fleet driver it is assigned to or an authorized driver who
+// accepted the invitation
+func (v *Vehicle) CanBeDrivenBy(userID primitive.ObjectID) bool {
+	if v.Owner == userID || v.IsAssignedTo(userID) {
+		return true
+	}
+	for _, driver := range v.Drivers {
+		if driver.UserID == userID && driver.Status == VehicleDriverActive {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAssignedTo reports whether the vehicle is in a fleet and assigned to the user
+func (v *Vehicle) IsAssignedTo(userID primitive.ObjectID) bool {
+	return v.OrganizationID != nil && v.AssignedDriver != nil && *v.AssignedDriver == userID
 }
 
 func (v Vehicle) Validate() error {
@@ -19,5 +199,28 @@ func (v Vehicle) Validate() error {
 		validation.Field(&v.Brand, validation.Required),
 		validation.Field(&v.Model, validation.Required),
 		validation.Field(&v.Owner, validation.Required),
+		validation.Field(&v.Category,
+			validation.In(VehicleCategoryMotorcycle, VehicleCategoryCar, VehicleCategoryVan, VehicleCategoryTruck).
+				Error("must be one of motorcycle, car, van or truck"),
+		),
+		validation.Field(&v.Size,
+			validation.In(VehicleSizeSmall, VehicleSizeMedium, VehicleSizeLarge, VehicleSizeOversize).
+				Error("must be one of small, medium, large or oversize"),
+			validation.When(v.Category == VehicleCategoryMotorcycle, validation.In(VehicleSizeSmall).
+				Error("a motorcycle must be small")),
+			validation.When(v.Category != VehicleCategoryMotorcycle, validation.NotIn(VehicleSizeSmall).
+				Error("only motorcycles are small")),
+		),
+		validation.Field(&v.FuelType,
+			validation.In(FuelPetrol, FuelDiesel, FuelHybrid, FuelPlugInHybrid, FuelElectric).
+				Error("must be one of petrol, diesel, hybrid, plug_in_hybrid or electric"),
+		),
+		validation.Field(&v.Connector,
+			validation.When(v.FuelType.Chargeable(),
+				validation.Required.Error("is required for electric and plug-in hybrid vehicles"),
+				validation.In(connectorTypes...).Error("must be one of type1, type2, ccs1, ccs2, chademo, nacs or gbt"),
+			).Else(validation.Empty.Error("is only set for electric and plug-in hybrid vehicles")),
+		),
+		validation.Field(&v.Colour, validation.Length(0, MaxColourLength)),
 	)
 }
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type VehicleClaimType string
+
+const (
+	VehicleClaimOwnership VehicleClaimType = "ownership" // The owner proves a vehicle they registered
+	VehicleClaimTransfer  VehicleClaimType = "transfer"  // Someone else disputes who owns a registered plate
+)
+
+type VehicleClaimStatus string
+
+const (
+	VehicleClaimPending  VehicleClaimStatus = "pending"
+	VehicleClaimApproved VehicleClaimStatus = "approved"
+	VehicleClaimRejected VehicleClaimStatus = "rejected"
+)
+
+// VehicleClaim asks an admin to confirm who owns a plate from an uploaded
+// registration document. An approved transfer claim moves the vehicle to the
+// claimant
+type VehicleClaim struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	VehicleID   primitive.ObjectID  `bson:"vehicle_id" json:"vehicle_id"`
+	PlateNumber string              `bson:"plate_number" json:"plate_number"` // As registered when the claim was made
+	ClaimantID  primitive.ObjectID  `bson:"claimant_id" json:"claimant_id"`
+	Type        VehicleClaimType    `bson:"type" json:"type"`
+	Status      VehicleClaimStatus  `bson:"status" json:"status"`
+	DocumentKey string              `bson:"document_key" json:"-"` // Private object, reviewers get a presigned URL
+	Note        string              `bson:"note,omitempty" json:"note,omitempty"`
+	ReviewedBy  *primitive.ObjectID `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time          `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	ReviewNote  string              `bson:"review_note,omitempty" json:"review_note,omitempty"`
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time           `bson:"updated_at" json:"updated_at"`
+}
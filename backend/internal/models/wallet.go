@@ -20,13 +20,20 @@ type Transaction struct {
 	Type        TransactionType    `bson:"type" json:"type"`
 	Amount      float64            `bson:"amount" json:"amount"`
 	Description string             `bson:"description" json:"description"`
-	CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
+	// UserID is the member who topped up or spent from an organization
+	// wallet, VehicleID the vehicle a charge was for
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"userId,omitempty"`
+	VehicleID *primitive.ObjectID `bson:"vehicle_id,omitempty" json:"vehicleId,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"createdAt"`
 }
 
+// Wallet holds the balance of a user, or of an organization when
+// OrganizationID is set
 type Wallet struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID    primitive.ObjectID `bson:"user_id" json:"userId"`
-	Balance   float64            `bson:"balance" json:"balance"`
-	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updatedAt"`
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID  `bson:"user_id,omitempty" json:"userId,omitempty"`
+	OrganizationID *primitive.ObjectID `bson:"organization_id,omitempty" json:"organizationId,omitempty"`
+	Balance        float64             `bson:"balance" json:"balance"`
+	CreatedAt      time.Time           `bson:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time           `bson:"updated_at" json:"updatedAt"`
 }
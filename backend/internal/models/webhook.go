@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookSubscription is an external endpoint that receives domain events
+type WebhookSubscription struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	URL         string             `bson:"url" json:"url"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	EventTypes  []string           `bson:"event_types" json:"event_types"` // Empty means all domain events
+	Secret      string             `bson:"secret" json:"-"`                // HMAC signing secret, only returned on creation
+	Active      bool               `bson:"active" json:"active"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Matches reports whether the subscription wants events of the given type
+func (w *WebhookSubscription) Matches(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery records a single event sent to a subscription and its attempts
+type WebhookDelivery struct {
+	ID             primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	SubscriptionID primitive.ObjectID    `bson:"subscription_id" json:"subscription_id"`
+	EventID        string                `bson:"event_id" json:"event_id"`
+	EventType      string                `bson:"event_type" json:"event_type"`
+	Payload        string                `bson:"payload" json:"payload"` // Exact JSON body that is signed and sent
+	Status         WebhookDeliveryStatus `bson:"status" json:"status"`
+	Attempts       int                   `bson:"attempts" json:"attempts"`
+	ResponseCode   int                   `bson:"response_code,omitempty" json:"response_code,omitempty"`
+	LastError      string                `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time            `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time            `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	ReplayOf       *primitive.ObjectID   `bson:"replay_of,omitempty" json:"replay_of,omitempty"`
+	CreatedAt      time.Time             `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time             `bson:"updated_at" json:"updated_at"`
+}
+
+// WebhookEvent is an outbox row written when a domain event is published. The
+// delivery worker fans it out to matching subscriptions, so events are not lost
+// when the worker is busy or the process restarts
+type WebhookEvent struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	EventID      string             `bson:"event_id" json:"event_id"`
+	EventType    string             `bson:"event_type" json:"event_type"`
+	Payload      string             `bson:"payload" json:"payload"`
+	Dispatched   bool               `bson:"dispatched" json:"dispatched"`
+	ClaimedUntil *time.Time         `bson:"claimed_until,omitempty" json:"claimed_until,omitempty"`
+	ExpiresAt    *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // Set once dispatched, removed by a TTL index
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AccountTokenRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewAccountTokenRepository(db *qmgo.Database) *AccountTokenRepository {
+	return &AccountTokenRepository{
+		collection: db.Collection("account_tokens"),
+	}
+}
+
+// EnsureIndexes indexes a user's tokens and removes tokens once they expire
+func (r *AccountTokenRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"user_id", "purpose"}},
+		{
+			Key:          []string{"expires_at"},
+			IndexOptions: officialOpts.Index().SetExpireAfterSeconds(0),
+		},
+	})
+}
+
+func (r *AccountTokenRepository) Create(ctx context.Context, token *models.AccountToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	_, err := r.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (r *AccountTokenRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.AccountToken, error) {
+	var token models.AccountToken
+	err := r.collection.Find(ctx, bson.M{"_id": id}).One(&token)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed uses up the token if it is still unused and unexpired. ErrNotFound
+// means it was used or expired in the meantime
+func (r *AccountTokenRepository) MarkUsed(ctx context.Context, id primitive.ObjectID, now time.Time) error {
+	err := r.collection.UpdateOne(ctx, bson.M{
+		"_id":        id,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}, bson.M{"$set": bson.M{"used_at": now}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// UseAll uses up the user's outstanding tokens for the purpose
+func (r *AccountTokenRepository) UseAll(ctx context.Context, userID primitive.ObjectID, purpose models.AccountTokenPurpose, now time.Time) error {
+	_, err := r.collection.UpdateAll(ctx, bson.M{
+		"user_id": userID,
+		"purpose": purpose,
+		"used_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"used_at": now}})
+	return err
+}
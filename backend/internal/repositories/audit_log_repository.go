@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditLogRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewAuditLogRepository(db *qmgo.Database) *AuditLogRepository {
+	return &AuditLogRepository{
+		collection: db.Collection("audit_logs"),
+	}
+}
+
+// EnsureIndexes indexes entries by time, by affected user and by action
+func (r *AuditLogRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"-created_at"}},
+		{Key: []string{"user_id", "-created_at"}},
+		{Key: []string{"action", "-created_at"}},
+	})
+}
+
+func (r *AuditLogRepository) Create(ctx context.Context, entry *models.AuditEntry) error {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	_, err := r.collection.InsertOne(ctx, entry)
+	return err
+}
+
+// Find returns the matching entries, newest first
+func (r *AuditLogRepository) Find(ctx context.Context, filter bson.M, limit int64) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	err := r.collection.Find(ctx, filter).Sort("-created_at").Limit(limit).All(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AuthSessionRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewAuthSessionRepository(db *qmgo.Database) *AuthSessionRepository {
+	return &AuthSessionRepository{
+		collection: db.Collection("auth_sessions"),
+	}
+}
+
+// EnsureIndexes indexes a user's sessions and removes sessions once their
+// refresh token has expired
+func (r *AuthSessionRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"user_id", "-last_used_at"}},
+		{
+			Key:          []string{"expires_at"},
+			IndexOptions: officialOpts.Index().SetExpireAfterSeconds(0),
+		},
+	})
+}
+
+func (r *AuthSessionRepository) Create(ctx context.Context, session *models.AuthSession) error {
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	_, err := r.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (r *AuthSessionRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.AuthSession, error) {
+	var session models.AuthSession
+	err := r.collection.Find(ctx, bson.M{"_id": id}).One(&session)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// FindActiveByUser returns the user's sessions that are neither revoked nor
+// expired, most recently used first
+func (r *AuthSessionRepository) FindActiveByUser(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]models.AuthSession, error) {
+	var sessions []models.AuthSession
+	err := r.collection.Find(ctx, bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}).Sort("-last_used_at").All(&sessions)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Rotate stores the session's new refresh token hash, client details and
+// expiry, but only if the session still holds previousHash and is not revoked.
+// ErrNotFound means another refresh won
+func (r *AuthSessionRepository) Rotate(ctx context.Context, session *models.AuthSession, previousHash string) error {
+	err := r.collection.UpdateOne(ctx, bson.M{
+		"_id":          session.ID,
+		"refresh_hash": previousHash,
+		"revoked_at":   bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{
+		"refresh_hash":  session.RefreshHash,
+		"previous_hash": previousHash,
+		"user_agent":    session.UserAgent,
+		"ip":            session.IP,
+		"last_used_at":  session.LastUsedAt,
+		"expires_at":    session.ExpiresAt,
+	}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// SetMFA records that an active session passed a second factor
+func (r *AuthSessionRepository) SetMFA(ctx context.Context, id primitive.ObjectID) error {
+	err := r.collection.UpdateOne(ctx, bson.M{
+		"_id":        id,
+		"revoked_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"mfa": true}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Revoke marks the matching sessions revoked and returns how many were
+func (r *AuthSessionRepository) Revoke(ctx context.Context, filter bson.M, at time.Time, reason models.SessionRevokeReason) (int64, error) {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	result, err := r.collection.UpdateAll(ctx, filter, bson.M{"$set": bson.M{
+		"revoked_at":     at,
+		"revoked_reason": reason,
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -22,6 +22,9 @@ func (r *BookingRepository) collection() *qmgo.Collection {
 }
 
 func (r *BookingRepository) Create(ctx context.Context, booking *models.Booking) error {
+	if booking.ID.IsZero() {
+		booking.ID = primitive.NewObjectID()
+	}
 	_, err := r.collection().InsertOne(ctx, booking)
 	return err
 }
@@ -60,6 +63,29 @@ func (r *BookingRepository) Update(ctx context.Context, booking *models.Booking)
 	return r.collection().UpdateOne(ctx, bson.M{"_id": booking.ID}, bson.M{"$set": booking})
 }
 
+// UpdateWhere applies update to the booking only while it still matches
+// filter and returns the updated booking, or ErrNotFound when it no longer
+// matches
+func (r *BookingRepository) UpdateWhere(ctx context.Context, id primitive.ObjectID, filter bson.M, update bson.M) (*models.Booking, error) {
+	match := bson.M{"_id": id}
+	for k, v := range filter {
+		match[k] = v
+	}
+
+	var booking models.Booking
+	err := r.collection().Find(ctx, match).Apply(qmgo.Change{
+		Update:    update,
+		ReturnNew: true,
+	}, &booking)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &booking, nil
+}
+
 func (r *BookingRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	err := r.collection().Remove(ctx, bson.M{"_id": id})
 	if err != nil {
@@ -83,6 +109,16 @@ func (r *BookingRepository) FindOne(ctx context.Context, filter bson.M) (*models
 	return &booking, nil
 }
 
+// Find returns all bookings matching the filter
+func (r *BookingRepository) Find(ctx context.Context, filter bson.M) ([]models.Booking, error) {
+	var bookings []models.Booking
+	err := r.collection().Find(ctx, filter).All(&bookings)
+	if err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
 // Count returns the number of documents matching the filter
 func (r *BookingRepository) Count(ctx context.Context, filter bson.M) (int64, error) {
 	count, err := r.collection().Find(ctx, filter).Count()
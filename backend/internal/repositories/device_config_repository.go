@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DeviceConfigRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewDeviceConfigRepository(db *qmgo.Database) *DeviceConfigRepository {
+	return &DeviceConfigRepository{
+		collection: db.Collection("device_configs"),
+	}
+}
+
+// EnsureIndexes keeps one config document per device
+func (r *DeviceConfigRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateOneIndex(ctx, options.IndexModel{
+		Key:          []string{"device_id"},
+		IndexOptions: officialOpts.Index().SetUnique(true),
+	})
+}
+
+func (r *DeviceConfigRepository) FindByDevice(ctx context.Context, deviceID primitive.ObjectID) (*models.DeviceConfig, error) {
+	var config models.DeviceConfig
+	err := r.collection.Find(ctx, bson.M{"device_id": deviceID}).One(&config)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Save stores a new version of a device config. previousVersion is the
+// version the change was based on; ErrDuplicate is returned if another
+// change was saved in the meantime
+func (r *DeviceConfigRepository) Save(ctx context.Context, config *models.DeviceConfig, previousVersion int) error {
+	if previousVersion == 0 {
+		if config.ID.IsZero() {
+			config.ID = primitive.NewObjectID()
+		}
+		_, err := r.collection.InsertOne(ctx, config)
+		if qmgo.IsDup(err) {
+			return ErrDuplicate
+		}
+		return err
+	}
+
+	err := r.collection.UpdateOne(ctx,
+		bson.M{"device_id": config.DeviceID, "version": previousVersion},
+		bson.M{"$set": bson.M{
+			"version":    config.Version,
+			"config":     config.Config,
+			"updated_by": config.UpdatedBy,
+			"updated_at": config.UpdatedAt,
+		}},
+	)
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Acknowledge records the version a device applied
+func (r *DeviceConfigRepository) Acknowledge(ctx context.Context, deviceID primitive.ObjectID, version int, at time.Time) error {
+	err := r.collection.UpdateOne(ctx,
+		bson.M{"device_id": deviceID},
+		bson.M{"$set": bson.M{"applied_version": version, "applied_at": at}},
+	)
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
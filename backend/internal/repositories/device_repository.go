@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DeviceRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewDeviceRepository(db *qmgo.Database) *DeviceRepository {
+	return &DeviceRepository{
+		collection: db.Collection("devices"),
+	}
+}
+
+// EnsureIndexes makes device IDs unique
+func (r *DeviceRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"device_id"}, IndexOptions: officialOpts.Index().SetUnique(true)},
+		{Key: []string{"location_id"}},
+	})
+}
+
+// Create stores a device, returning ErrDuplicate if the device ID is taken
+func (r *DeviceRepository) Create(ctx context.Context, device *models.Device) error {
+	if device.ID.IsZero() {
+		device.ID = primitive.NewObjectID()
+	}
+	_, err := r.collection.InsertOne(ctx, device)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *DeviceRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Device, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *DeviceRepository) FindByDeviceID(ctx context.Context, deviceID string) (*models.Device, error) {
+	return r.findOne(ctx, bson.M{"device_id": deviceID})
+}
+
+func (r *DeviceRepository) findOne(ctx context.Context, filter bson.M) (*models.Device, error) {
+	var device models.Device
+	err := r.collection.Find(ctx, filter).One(&device)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (r *DeviceRepository) Find(ctx context.Context, filter bson.M) ([]models.Device, error) {
+	var devices []models.Device
+	err := r.collection.Find(ctx, filter).Sort("device_id").All(&devices)
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+func (r *DeviceRepository) Update(ctx context.Context, device *models.Device) error {
+	err := r.collection.UpdateOne(ctx, bson.M{"_id": device.ID}, bson.M{"$set": device})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// TouchLastSeen records when the device last called in
+func (r *DeviceRepository) TouchLastSeen(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_seen_at": at}})
+}
+
+// RecordHeartbeat stores the health reported in a heartbeat
+func (r *DeviceRepository) RecordHeartbeat(ctx context.Context, device *models.Device) error {
+	set := bson.M{
+		"last_seen_at":     device.LastSeenAt,
+		"firmware_version": device.FirmwareVersion,
+		"last_error":       device.LastError,
+	}
+	if device.BatteryLevel != nil {
+		set["battery_level"] = *device.BatteryLevel
+	}
+	return r.collection.UpdateOne(ctx, bson.M{"_id": device.ID}, bson.M{"$set": set})
+}
+
+// FindSilent returns active devices that are not yet marked offline and
+// have not called in since before. Devices that never called in count from
+// their creation time
+func (r *DeviceRepository) FindSilent(ctx context.Context, before time.Time) ([]models.Device, error) {
+	return r.Find(ctx, bson.M{
+		"status":        models.DeviceStatusActive,
+		"offline_since": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"last_seen_at": bson.M{"$lt": before}},
+			{"last_seen_at": bson.M{"$exists": false}, "created_at": bson.M{"$lt": before}},
+		},
+	})
+}
+
+// MarkOffline flags a device as offline. It reports false if another
+// instance already did so
+func (r *DeviceRepository) MarkOffline(ctx context.Context, id primitive.ObjectID, since time.Time) (bool, error) {
+	err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "offline_since": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"offline_since": since}},
+	)
+	if err == qmgo.ErrNoSuchDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MarkOnline clears the offline flag. It reports false if the device was not offline
+func (r *DeviceRepository) MarkOnline(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "offline_since": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"offline_since": ""}},
+	)
+	if err == qmgo.ErrNoSuchDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
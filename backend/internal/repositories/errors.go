@@ -3,5 +3,6 @@ package repositories
 import "errors"
 
 var (
-	ErrNotFound = errors.New("entity not found")
+	ErrNotFound  = errors.New("entity not found")
+	ErrDuplicate = errors.New("duplicate entity")
 )
@@ -0,0 +1,105 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ExternalIdentityRepository struct {
+	db *qmgo.Database
+}
+
+func NewExternalIdentityRepository(db *qmgo.Database) *ExternalIdentityRepository {
+	return &ExternalIdentityRepository{db: db}
+}
+
+func (r *ExternalIdentityRepository) identityCollection() *qmgo.Collection {
+	return r.db.Collection("external_identities")
+}
+
+func (r *ExternalIdentityRepository) stateCollection() *qmgo.Collection {
+	return r.db.Collection("oidc_login_states")
+}
+
+// EnsureIndexes keeps a provider account linked to one user, and a user
+// linked to one account per provider, and removes abandoned sign ins
+func (r *ExternalIdentityRepository) EnsureIndexes(ctx context.Context) error {
+	err := r.identityCollection().CreateIndexes(ctx, []options.IndexModel{
+		{
+			Key:          []string{"provider", "subject"},
+			IndexOptions: officialOpts.Index().SetUnique(true),
+		},
+		{
+			Key:          []string{"user_id", "provider"},
+			IndexOptions: officialOpts.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return r.stateCollection().CreateOneIndex(ctx, options.IndexModel{
+		Key:          []string{"expires_at"},
+		IndexOptions: officialOpts.Index().SetExpireAfterSeconds(0),
+	})
+}
+
+func (r *ExternalIdentityRepository) FindBySubject(ctx context.Context, provider, subject string) (*models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	err := r.identityCollection().Find(ctx, bson.M{"provider": provider, "subject": subject}).One(&identity)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// Create links an identity, returning ErrDuplicate if the provider account
+// or the user is already linked
+func (r *ExternalIdentityRepository) Create(ctx context.Context, identity *models.ExternalIdentity) error {
+	if identity.ID.IsZero() {
+		identity.ID = primitive.NewObjectID()
+	}
+	_, err := r.identityCollection().InsertOne(ctx, identity)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Touch records a sign in with the identity
+func (r *ExternalIdentityRepository) Touch(ctx context.Context, id primitive.ObjectID, email string, at time.Time) error {
+	return r.identityCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"email":         email,
+		"last_login_at": at,
+	}})
+}
+
+func (r *ExternalIdentityRepository) CreateState(ctx context.Context, state *models.OIDCLoginState) error {
+	if state.ID.IsZero() {
+		state.ID = primitive.NewObjectID()
+	}
+	_, err := r.stateCollection().InsertOne(ctx, state)
+	return err
+}
+
+// TakeState removes and returns a sign in state so its callback runs once
+func (r *ExternalIdentityRepository) TakeState(ctx context.Context, id primitive.ObjectID) (*models.OIDCLoginState, error) {
+	var state models.OIDCLoginState
+	err := r.stateCollection().Find(ctx, bson.M{"_id": id}).Apply(qmgo.Change{Remove: true}, &state)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &state, nil
+}
@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type GuestSessionRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewGuestSessionRepository(db *qmgo.Database) *GuestSessionRepository {
+	return &GuestSessionRepository{
+		collection: db.Collection("guest_sessions"),
+	}
+}
+
+// EnsureIndexes makes pay codes unique and indexes plate lookups at the gates
+func (r *GuestSessionRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"pay_code"}, IndexOptions: officialOpts.Index().SetUnique(true)},
+		{Key: []string{"plate_number", "status"}},
+		{Key: []string{"location_id", "status", "-entry_at"}},
+	})
+}
+
+// Create stores a session, returning ErrDuplicate if its pay code is taken
+func (r *GuestSessionRepository) Create(ctx context.Context, session *models.GuestSession) error {
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	_, err := r.collection.InsertOne(ctx, session)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *GuestSessionRepository) Update(ctx context.Context, session *models.GuestSession) error {
+	return r.collection.UpdateOne(ctx, bson.M{"_id": session.ID}, bson.M{"$set": session})
+}
+
+// UpdateWhere applies update to the session only while it still matches
+// filter and returns the updated session, or ErrNotFound when it no longer
+// matches
+func (r *GuestSessionRepository) UpdateWhere(ctx context.Context, id primitive.ObjectID, filter bson.M, update bson.M) (*models.GuestSession, error) {
+	match := bson.M{"_id": id}
+	for k, v := range filter {
+		match[k] = v
+	}
+
+	var session models.GuestSession
+	err := r.collection.Find(ctx, match).Apply(qmgo.Change{
+		Update:    update,
+		ReturnNew: true,
+	}, &session)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *GuestSessionRepository) FindOne(ctx context.Context, filter bson.M) (*models.GuestSession, error) {
+	var session models.GuestSession
+	err := r.collection.Find(ctx, filter).One(&session)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Find returns sessions matching the filter, newest first. A zero limit returns all
+func (r *GuestSessionRepository) Find(ctx context.Context, filter bson.M, limit int64) ([]models.GuestSession, error) {
+	var sessions []models.GuestSession
+	query := r.collection.Find(ctx, filter).Sort("-entry_at")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.All(&sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LoginThrottleRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewLoginThrottleRepository(db *qmgo.Database) *LoginThrottleRepository {
+	return &LoginThrottleRepository{
+		collection: db.Collection("login_throttles"),
+	}
+}
+
+// EnsureIndexes keeps one counter per scope and key and removes counters
+// once they expire
+func (r *LoginThrottleRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{
+			Key:          []string{"scope", "key"},
+			IndexOptions: officialOpts.Index().SetUnique(true),
+		},
+		{
+			Key:          []string{"expires_at"},
+			IndexOptions: officialOpts.Index().SetExpireAfterSeconds(0),
+		},
+	})
+}
+
+func (r *LoginThrottleRepository) Find(ctx context.Context, scope models.LoginThrottleScope, key string) (*models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	err := r.collection.Find(ctx, bson.M{"scope": scope, "key": key}).One(&throttle)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// RecordFailure atomically counts a failed login and returns the counter
+// after the increment. Failures older than window that no longer block
+// are forgotten first, so the count restarts
+func (r *LoginThrottleRepository) RecordFailure(ctx context.Context, scope models.LoginThrottleScope, key string, now time.Time, window time.Duration) (*models.LoginThrottle, error) {
+	_, err := r.collection.UpdateAll(ctx, bson.M{
+		"scope":           scope,
+		"key":             key,
+		"last_failure_at": bson.M{"$lt": now.Add(-window)},
+		"$or": []bson.M{
+			{"blocked_until": bson.M{"$exists": false}},
+			{"blocked_until": bson.M{"$lte": now}},
+		},
+	}, bson.M{
+		"$set":   bson.M{"failures": 0, "first_failure_at": now},
+		"$unset": bson.M{"blocked_until": "", "locked_at": ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var throttle models.LoginThrottle
+	err = r.collection.Find(ctx, bson.M{"scope": scope, "key": key}).Apply(qmgo.Change{
+		Update: bson.M{
+			"$inc":         bson.M{"failures": 1},
+			"$set":         bson.M{"last_failure_at": now},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "first_failure_at": now},
+			"$max":         bson.M{"expires_at": now.Add(window)},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &throttle)
+	if qmgo.IsDup(err) {
+		// A concurrent first failure inserted the counter, count against it
+		return r.RecordFailure(ctx, scope, key, now, window)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// Block extends the counter's block to until, never shortening an existing
+// one. A lockedAt time marks the block as a lockout
+func (r *LoginThrottleRepository) Block(ctx context.Context, id primitive.ObjectID, until time.Time, lockedAt *time.Time) error {
+	update := bson.M{"$max": bson.M{"blocked_until": until, "expires_at": until}}
+	if lockedAt != nil {
+		update["$set"] = bson.M{"locked_at": *lockedAt}
+	}
+	err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Clear forgets the failures counted for the key
+func (r *LoginThrottleRepository) Clear(ctx context.Context, scope models.LoginThrottleScope, key string) (bool, error) {
+	result, err := r.collection.RemoveAll(ctx, bson.M{"scope": scope, "key": key})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
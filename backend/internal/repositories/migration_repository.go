@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type MigrationRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewMigrationRepository(db *qmgo.Database) *MigrationRepository {
+	return &MigrationRepository{
+		collection: db.Collection("migrations"),
+	}
+}
+
+// IsApplied reports whether the migration has already run
+func (r *MigrationRepository) IsApplied(ctx context.Context, id string) (bool, error) {
+	count, err := r.collection.Find(ctx, bson.M{"_id": id}).Count()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// MarkApplied records that the migration ran. Recording it twice is not an error
+func (r *MigrationRepository) MarkApplied(ctx context.Context, id string, now time.Time) error {
+	_, err := r.collection.InsertOne(ctx, &models.Migration{ID: id, AppliedAt: now})
+	if qmgo.IsDup(err) {
+		return nil
+	}
+	return err
+}
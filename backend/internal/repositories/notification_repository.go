@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type NotificationRepository struct {
+	db *qmgo.Database
+}
+
+func NewNotificationRepository(db *qmgo.Database) *NotificationRepository {
+	return &NotificationRepository{db: db}
+}
+
+func (r *NotificationRepository) notificationCollection() *qmgo.Collection {
+	return r.db.Collection("notifications")
+}
+
+func (r *NotificationRepository) preferenceCollection() *qmgo.Collection {
+	return r.db.Collection("notification_preferences")
+}
+
+// EnsureIndexes creates the indexes backing outbox deduplication and lookups
+func (r *NotificationRepository) EnsureIndexes(ctx context.Context) error {
+	err := r.notificationCollection().CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"user_id", "-created_at"}},
+		{
+			Key: []string{"dedupe_key", "channel"},
+			IndexOptions: officialOpts.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"dedupe_key": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return r.preferenceCollection().CreateOneIndex(ctx, options.IndexModel{
+		Key:          []string{"user_id"},
+		IndexOptions: officialOpts.Index().SetUnique(true),
+	})
+}
+
+// Create stores an outbox entry, returning ErrDuplicate if its dedupe key was already used
+func (r *NotificationRepository) Create(ctx context.Context, notification *models.Notification) error {
+	if notification.ID.IsZero() {
+		notification.ID = primitive.NewObjectID()
+	}
+	_, err := r.notificationCollection().InsertOne(ctx, notification)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *NotificationRepository) Update(ctx context.Context, notification *models.Notification) error {
+	return r.notificationCollection().UpdateOne(ctx, bson.M{"_id": notification.ID}, bson.M{"$set": notification})
+}
+
+func (r *NotificationRepository) FindByUser(ctx context.Context, userID primitive.ObjectID, limit int64) ([]models.Notification, error) {
+	var notifications []models.Notification
+	err := r.notificationCollection().Find(ctx, bson.M{"user_id": userID}).Sort("-created_at").Limit(limit).All(&notifications)
+	if err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (r *NotificationRepository) FindPreferences(ctx context.Context, userID primitive.ObjectID) (*models.NotificationPreferences, error) {
+	var prefs models.NotificationPreferences
+	err := r.preferenceCollection().Find(ctx, bson.M{"user_id": userID}).One(&prefs)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+func (r *NotificationRepository) SavePreferences(ctx context.Context, prefs *models.NotificationPreferences) error {
+	if prefs.ID.IsZero() {
+		prefs.ID = primitive.NewObjectID()
+	}
+	_, err := r.preferenceCollection().Upsert(ctx, bson.M{"user_id": prefs.UserID}, prefs)
+	return err
+}
@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OccupancyMismatchRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewOccupancyMismatchRepository(db *qmgo.Database) *OccupancyMismatchRepository {
+	return &OccupancyMismatchRepository{
+		collection: db.Collection("occupancy_mismatches"),
+	}
+}
+
+// EnsureIndexes creates the indexes used to look up open mismatches per
+// location. Only one mismatch of a type can be open for a slot at a time
+func (r *OccupancyMismatchRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"location_id", "status", "-first_detected_at"}},
+		{
+			Key: []string{"location_id", "slot_number", "type"},
+			IndexOptions: officialOpts.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": models.MismatchStatusOpen}),
+		},
+	})
+}
+
+// Create stores a mismatch, returning ErrDuplicate if the same one is already open
+func (r *OccupancyMismatchRepository) Create(ctx context.Context, mismatch *models.OccupancyMismatch) error {
+	if mismatch.ID.IsZero() {
+		mismatch.ID = primitive.NewObjectID()
+	}
+	_, err := r.collection.InsertOne(ctx, mismatch)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *OccupancyMismatchRepository) Update(ctx context.Context, mismatch *models.OccupancyMismatch) error {
+	return r.collection.UpdateOne(ctx, bson.M{"_id": mismatch.ID}, bson.M{"$set": mismatch})
+}
+
+// FindOpen returns the unresolved mismatches of a location
+func (r *OccupancyMismatchRepository) FindOpen(ctx context.Context, locationID primitive.ObjectID) ([]models.OccupancyMismatch, error) {
+	return r.Find(ctx, bson.M{"location_id": locationID, "status": models.MismatchStatusOpen}, 0)
+}
+
+// Find returns mismatches matching the filter, newest first. A zero limit returns all
+func (r *OccupancyMismatchRepository) Find(ctx context.Context, filter bson.M, limit int64) ([]models.OccupancyMismatch, error) {
+	var mismatches []models.OccupancyMismatch
+	query := r.collection.Find(ctx, filter).Sort("-first_detected_at")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.All(&mismatches); err != nil {
+		return nil, err
+	}
+	return mismatches, nil
+}
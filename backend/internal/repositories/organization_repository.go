@@ -0,0 +1,254 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OrganizationRepository struct {
+	db *qmgo.Database
+}
+
+func NewOrganizationRepository(db *qmgo.Database) *OrganizationRepository {
+	return &OrganizationRepository{db: db}
+}
+
+func (r *OrganizationRepository) organizationCollection() *qmgo.Collection {
+	return r.db.Collection("organizations")
+}
+
+func (r *OrganizationRepository) memberCollection() *qmgo.Collection {
+	return r.db.Collection("organization_members")
+}
+
+func (r *OrganizationRepository) spendCollection() *qmgo.Collection {
+	return r.db.Collection("organization_member_spend")
+}
+
+// EnsureIndexes keeps a user to one membership per organization, finds the
+// organizations of a user and keeps one spend counter per member and month
+func (r *OrganizationRepository) EnsureIndexes(ctx context.Context) error {
+	err := r.memberCollection().CreateIndexes(ctx, []options.IndexModel{
+		{
+			Key:          []string{"organization_id", "user_id"},
+			IndexOptions: officialOpts.Index().SetUnique(true),
+		},
+		{Key: []string{"user_id"}},
+	})
+	if err != nil {
+		return err
+	}
+	return r.spendCollection().CreateOneIndex(ctx, options.IndexModel{
+		Key:          []string{"member_id", "period"},
+		IndexOptions: officialOpts.Index().SetUnique(true),
+	})
+}
+
+func (r *OrganizationRepository) Create(ctx context.Context, organization *models.Organization) error {
+	if organization.ID.IsZero() {
+		organization.ID = primitive.NewObjectID()
+	}
+	_, err := r.organizationCollection().InsertOne(ctx, organization)
+	return err
+}
+
+func (r *OrganizationRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Organization, error) {
+	var organization models.Organization
+	err := r.organizationCollection().Find(ctx, bson.M{"_id": id}).One(&organization)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// FindByIDs returns the organizations with the given IDs, by name
+func (r *OrganizationRepository) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Organization, error) {
+	organizations := []models.Organization{}
+	err := r.organizationCollection().Find(ctx, bson.M{"_id": bson.M{"$in": ids}}).Sort("name").All(&organizations)
+	if err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+func (r *OrganizationRepository) FindAll(ctx context.Context) ([]models.Organization, error) {
+	organizations := []models.Organization{}
+	err := r.organizationCollection().Find(ctx, bson.M{}).Sort("name").All(&organizations)
+	if err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+func (r *OrganizationRepository) Update(ctx context.Context, organization *models.Organization) error {
+	err := r.organizationCollection().UpdateOne(ctx, bson.M{"_id": organization.ID}, bson.M{"$set": organization})
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *OrganizationRepository) CreateMember(ctx context.Context, member *models.OrgMember) error {
+	if member.ID.IsZero() {
+		member.ID = primitive.NewObjectID()
+	}
+	_, err := r.memberCollection().InsertOne(ctx, member)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *OrganizationRepository) FindMember(ctx context.Context, organizationID, userID primitive.ObjectID) (*models.OrgMember, error) {
+	var member models.OrgMember
+	err := r.memberCollection().Find(ctx, bson.M{"organization_id": organizationID, "user_id": userID}).One(&member)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+// FindMembers returns the members of an organization, oldest first
+func (r *OrganizationRepository) FindMembers(ctx context.Context, organizationID primitive.ObjectID) ([]models.OrgMember, error) {
+	members := []models.OrgMember{}
+	err := r.memberCollection().Find(ctx, bson.M{"organization_id": organizationID}).Sort("created_at").All(&members)
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// FindMemberships returns every membership of a user
+func (r *OrganizationRepository) FindMemberships(ctx context.Context, userID primitive.ObjectID) ([]models.OrgMember, error) {
+	members := []models.OrgMember{}
+	err := r.memberCollection().Find(ctx, bson.M{"user_id": userID}).All(&members)
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (r *OrganizationRepository) CountManagers(ctx context.Context, organizationID primitive.ObjectID) (int64, error) {
+	return r.memberCollection().Find(ctx, bson.M{
+		"organization_id": organizationID,
+		"role":            models.OrgRoleFleetManager,
+		"status":          bson.M{"$ne": models.OrgMemberInvited},
+	}).Count()
+}
+
+// AcceptMember activates the user's pending invitation to the organization
+func (r *OrganizationRepository) AcceptMember(ctx context.Context, organizationID, userID primitive.ObjectID, now time.Time) error {
+	err := r.memberCollection().UpdateOne(ctx, bson.M{
+		"organization_id": organizationID,
+		"user_id":         userID,
+		"status":          models.OrgMemberInvited,
+	}, bson.M{"$set": bson.M{
+		"status":      models.OrgMemberActive,
+		"accepted_at": now,
+		"updated_at":  now,
+	}})
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateMember replaces the member's role and spending limit
+func (r *OrganizationRepository) UpdateMember(ctx context.Context, member *models.OrgMember) error {
+	update := bson.M{"$set": bson.M{
+		"role":           member.Role,
+		"spending_limit": member.SpendingLimit,
+		"updated_at":     member.UpdatedAt,
+	}}
+	if member.SpendingLimit == nil {
+		update = bson.M{
+			"$set":   bson.M{"role": member.Role, "updated_at": member.UpdatedAt},
+			"$unset": bson.M{"spending_limit": ""},
+		}
+	}
+	err := r.memberCollection().UpdateOne(ctx, bson.M{"_id": member.ID}, update)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *OrganizationRepository) DeleteMember(ctx context.Context, id primitive.ObjectID) error {
+	err := r.memberCollection().RemoveId(ctx, id)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateMemberSpend starts a member's counter for a month, returning
+// ErrDuplicate if it already exists
+func (r *OrganizationRepository) CreateMemberSpend(ctx context.Context, spend *models.OrgMemberSpend) error {
+	if spend.ID.IsZero() {
+		spend.ID = primitive.NewObjectID()
+	}
+	_, err := r.spendCollection().InsertOne(ctx, spend)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *OrganizationRepository) FindMemberSpend(ctx context.Context, memberID primitive.ObjectID, period string) (*models.OrgMemberSpend, error) {
+	var spend models.OrgMemberSpend
+	err := r.spendCollection().Find(ctx, bson.M{"member_id": memberID, "period": period}).One(&spend)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &spend, nil
+}
+
+// AddMemberSpend atomically adds amount to the member's counter for the
+// month. A non-nil limit only lets it through while the total stays within
+// the limit. Returns ErrNotFound when the counter does not exist or the
+// limit would be exceeded
+func (r *OrganizationRepository) AddMemberSpend(ctx context.Context, memberID primitive.ObjectID, period string, amount float64, limit *float64, now time.Time) error {
+	filter := bson.M{"member_id": memberID, "period": period}
+	if limit != nil {
+		filter["spent"] = bson.M{"$lte": *limit - amount}
+	}
+	err := r.spendCollection().UpdateOne(ctx, filter, bson.M{
+		"$inc": bson.M{"spent": amount},
+		"$set": bson.M{"updated_at": now},
+	})
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -2,13 +2,23 @@ package repositories
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/qiniu/qmgo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// NearbyLocation is a parking location returned by a geospatial search
+// together with its distance from the search point in meters
+type NearbyLocation struct {
+	models.ParkingLocation `bson:",inline"`
+	Distance               float64 `bson:"distance"`
+}
+
 type ParkingLocationRepository struct {
 	collection *qmgo.Collection
 }
@@ -19,6 +29,18 @@ func NewParkingLocationRepository(db *qmgo.Database) *ParkingLocationRepository
 	}
 }
 
+// EnsureIndexes creates the 2dsphere index used by nearby searches
+func (r *ParkingLocationRepository) EnsureIndexes(ctx context.Context) error {
+	coll, err := r.collection.CloneCollection()
+	if err != nil {
+		return err
+	}
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "location", Value: "2dsphere"}},
+	})
+	return err
+}
+
 func (r *ParkingLocationRepository) Create(ctx context.Context, location *models.ParkingLocation) error {
 	_, err := r.collection.InsertOne(ctx, location)
 	return err
@@ -45,6 +67,24 @@ func (r *ParkingLocationRepository) FindAll(ctx context.Context) ([]models.Parki
 	return locations, nil
 }
 
+// FindNearby returns locations within radius meters of the given point, closest first
+func (r *ParkingLocationRepository) FindNearby(ctx context.Context, lat, lng, radius float64) ([]NearbyLocation, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":          models.NewGeoPoint(lat, lng),
+			"distanceField": "distance",
+			"maxDistance":   radius,
+			"spherical":     true,
+		}}},
+	}
+
+	var locations []NearbyLocation
+	if err := r.collection.Aggregate(ctx, pipeline).All(&locations); err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
 func (r *ParkingLocationRepository) Update(ctx context.Context, location *models.ParkingLocation) error {
 	return r.collection.UpdateOne(ctx, bson.M{"_id": location.ID}, bson.M{"$set": location})
 }
@@ -55,6 +95,59 @@ func (r *ParkingLocationRepository) UpdateSlotStatus(ctx context.Context, locati
 		bson.M{"$set": bson.M{"slots.$.is_occupied": isOccupied}})
 }
 
+// ReserveSlot marks a slot occupied only if it is still free and returns the
+// location as it is after the update, or ErrNotFound when the slot is taken
+func (r *ParkingLocationRepository) ReserveSlot(ctx context.Context, locationID primitive.ObjectID, slotNumber string) (*models.ParkingLocation, error) {
+	var location models.ParkingLocation
+	err := r.collection.Find(ctx, bson.M{
+		"_id":   locationID,
+		"slots": bson.M{"$elemMatch": bson.M{"number": slotNumber, "is_occupied": false}},
+	}).Apply(qmgo.Change{
+		Update:    bson.M{"$set": bson.M{"slots.$.is_occupied": true}},
+		ReturnNew: true,
+	}, &location)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &location, nil
+}
+
+// UpdateSlotStatuses sets the occupancy of several slots in a single write
+// and returns the location as it is after the update
+func (r *ParkingLocationRepository) UpdateSlotStatuses(ctx context.Context, locationID primitive.ObjectID, changes map[string]bool) (*models.ParkingLocation, error) {
+	coll, err := r.collection.CloneCollection()
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{}
+	filters := make([]interface{}, 0, len(changes))
+	i := 0
+	for number, isOccupied := range changes {
+		name := fmt.Sprintf("s%d", i)
+		set[fmt.Sprintf("slots.$[%s].is_occupied", name)] = isOccupied
+		filters = append(filters, bson.M{name + ".number": number})
+		i++
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetArrayFilters(options.ArrayFilters{Filters: filters}).
+		SetReturnDocument(options.After)
+
+	var location models.ParkingLocation
+	err = coll.FindOneAndUpdate(ctx, bson.M{"_id": locationID}, bson.M{"$set": set}, opts).Decode(&location)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &location, nil
+}
+
 func (r *ParkingLocationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	err := r.collection.Remove(ctx, bson.M{"_id": id})
 	if err != nil {
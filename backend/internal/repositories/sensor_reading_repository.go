@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SensorReadingRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewSensorReadingRepository(db *qmgo.Database) *SensorReadingRepository {
+	return &SensorReadingRepository{
+		collection: db.Collection("sensor_readings"),
+	}
+}
+
+// EnsureIndexes creates the per-slot history index and the TTL index that
+// expires readings after the retention period
+func (r *SensorReadingRepository) EnsureIndexes(ctx context.Context, retention time.Duration) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"location_id", "slot_number", "-received_at"}},
+		{
+			Key:          []string{"received_at"},
+			IndexOptions: officialOpts.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+		},
+	})
+}
+
+func (r *SensorReadingRepository) CreateMany(ctx context.Context, readings []models.SensorReading) error {
+	for i := range readings {
+		if readings[i].ID.IsZero() {
+			readings[i].ID = primitive.NewObjectID()
+		}
+	}
+	_, err := r.collection.InsertMany(ctx, readings)
+	return err
+}
+
+// FindLatest returns the most recent readings for a slot, newest first
+func (r *SensorReadingRepository) FindLatest(ctx context.Context, locationID primitive.ObjectID, slotNumber string, limit int64) ([]models.SensorReading, error) {
+	var readings []models.SensorReading
+	err := r.collection.Find(ctx, bson.M{
+		"location_id": locationID,
+		"slot_number": slotNumber,
+	}).Sort("-received_at").Limit(limit).All(&readings)
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// FindSince returns every reading for a slot received at or after since,
+// newest first
+func (r *SensorReadingRepository) FindSince(ctx context.Context, locationID primitive.ObjectID, slotNumber string, since time.Time) ([]models.SensorReading, error) {
+	var readings []models.SensorReading
+	err := r.collection.Find(ctx, bson.M{
+		"location_id": locationID,
+		"slot_number": slotNumber,
+		"received_at": bson.M{"$gte": since},
+	}).Sort("-received_at").All(&readings)
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// FindLastBefore returns the newest reading for a slot received before the
+// given time
+func (r *SensorReadingRepository) FindLastBefore(ctx context.Context, locationID primitive.ObjectID, slotNumber string, before time.Time) (*models.SensorReading, error) {
+	var reading models.SensorReading
+	err := r.collection.Find(ctx, bson.M{
+		"location_id": locationID,
+		"slot_number": slotNumber,
+		"received_at": bson.M{"$lt": before},
+	}).Sort("-received_at").One(&reading)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &reading, nil
+}
+
+// Find returns readings matching the filter, newest first
+func (r *SensorReadingRepository) Find(ctx context.Context, filter bson.M, limit int64) ([]models.SensorReading, error) {
+	var readings []models.SensorReading
+	err := r.collection.Find(ctx, filter).Sort("-received_at").Limit(limit).All(&readings)
+	if err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
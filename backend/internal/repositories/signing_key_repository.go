@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SigningKeyRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewSigningKeyRepository(db *qmgo.Database) *SigningKeyRepository {
+	return &SigningKeyRepository{
+		collection: db.Collection("signing_keys"),
+	}
+}
+
+// EnsureIndexes makes rotation sequences unique and removes keys once they
+// can no longer verify any token
+func (r *SigningKeyRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"sequence"}, IndexOptions: officialOpts.Index().SetUnique(true)},
+		{
+			Key:          []string{"expires_at"},
+			IndexOptions: officialOpts.Index().SetExpireAfterSeconds(0),
+		},
+	})
+}
+
+// Create stores a key, returning ErrDuplicate if its sequence is taken
+func (r *SigningKeyRepository) Create(ctx context.Context, key *models.SigningKey) error {
+	_, err := r.collection.InsertOne(ctx, key)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// FindValid returns the keys that have not expired, newest first
+func (r *SigningKeyRepository) FindValid(ctx context.Context, now time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.collection.Find(ctx, bson.M{
+		"$or": []bson.M{
+			{"expires_at": bson.M{"$exists": false}},
+			{"expires_at": bson.M{"$gt": now}},
+		},
+	}).Sort("-sequence").All(&keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ExpireBefore sets the expiry of the keys older than the sequence that do
+// not have one yet
+func (r *SigningKeyRepository) ExpireBefore(ctx context.Context, sequence int64, expiresAt time.Time) error {
+	_, err := r.collection.UpdateAll(ctx, bson.M{
+		"sequence":   bson.M{"$lt": sequence},
+		"expires_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"expires_at": expiresAt}})
+	return err
+}
+
+// FindPlaintext returns the keys still stored without encryption
+func (r *SigningKeyRepository) FindPlaintext(ctx context.Context) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.collection.Find(ctx, bson.M{"private_key_pem": bson.M{"$exists": true}}).All(&keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Seal replaces the plaintext private key of a key with its encrypted form
+func (r *SigningKeyRepository) Seal(ctx context.Context, id, encrypted string) error {
+	err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"encrypted_private_key": encrypted},
+		"$unset": bson.M{"private_key_pem": ""},
+	})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,263 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	telemetryCollection = "telemetry"
+	rollupCollection    = "telemetry_hourly"
+	alertCollection     = "device_alerts"
+	dirtyHourCollection = "telemetry_dirty_hours"
+)
+
+// TelemetryRetention sets how long each kind of telemetry is kept
+type TelemetryRetention struct {
+	Raw    time.Duration
+	Rollup time.Duration
+	Alert  time.Duration
+}
+
+type TelemetryRepository struct {
+	db *qmgo.Database
+}
+
+func NewTelemetryRepository(db *qmgo.Database) *TelemetryRepository {
+	return &TelemetryRepository{db: db}
+}
+
+func (r *TelemetryRepository) pointCollection() *qmgo.Collection {
+	return r.db.Collection(telemetryCollection)
+}
+
+func (r *TelemetryRepository) rollupCollection() *qmgo.Collection {
+	return r.db.Collection(rollupCollection)
+}
+
+func (r *TelemetryRepository) alertCollection() *qmgo.Collection {
+	return r.db.Collection(alertCollection)
+}
+
+func (r *TelemetryRepository) dirtyHourCollection() *qmgo.Collection {
+	return r.db.Collection(dirtyHourCollection)
+}
+
+// EnsureCollections creates the raw telemetry store as a time-series
+// collection, falling back to a regular collection with a TTL index on
+// servers without time-series support, and indexes the rollups and alerts
+func (r *TelemetryRepository) EnsureCollections(ctx context.Context, retention TelemetryRetention) error {
+	res := r.db.RunCommand(ctx, bson.D{
+		{Key: "create", Value: telemetryCollection},
+		{Key: "timeseries", Value: bson.M{
+			"timeField":   "timestamp",
+			"metaField":   "meta",
+			"granularity": "seconds",
+		}},
+		{Key: "expireAfterSeconds", Value: int64(retention.Raw.Seconds())},
+	})
+	if err := res.Err(); err != nil && !isNamespaceExists(err) {
+		err = r.pointCollection().CreateIndexes(ctx, []options.IndexModel{
+			{Key: []string{"meta.module_id", "-timestamp"}},
+			{
+				Key:          []string{"timestamp"},
+				IndexOptions: officialOpts.Index().SetExpireAfterSeconds(int32(retention.Raw.Seconds())),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := r.rollupCollection().CreateIndexes(ctx, []options.IndexModel{
+		{
+			Key:          []string{"meta.device_id", "meta.sensor_type", "hour"},
+			IndexOptions: officialOpts.Index().SetUnique(true),
+		},
+		{
+			Key:          []string{"hour"},
+			IndexOptions: officialOpts.Index().SetExpireAfterSeconds(int32(retention.Rollup.Seconds())),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.alertCollection().CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"module_id", "-timestamp"}},
+		{Key: []string{"severity", "-timestamp"}},
+		{
+			Key:          []string{"timestamp"},
+			IndexOptions: officialOpts.Index().SetExpireAfterSeconds(int32(retention.Alert.Seconds())),
+		},
+	})
+}
+
+func isNamespaceExists(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 48
+}
+
+func (r *TelemetryRepository) InsertPoints(ctx context.Context, points []models.TelemetryPoint) error {
+	_, err := r.pointCollection().InsertMany(ctx, points)
+	return err
+}
+
+// FindPoints returns raw telemetry matching the filter, newest first
+func (r *TelemetryRepository) FindPoints(ctx context.Context, filter bson.M, limit int64) ([]models.TelemetryPoint, error) {
+	var points []models.TelemetryPoint
+	err := r.pointCollection().Find(ctx, filter).Sort("-timestamp").Limit(limit).All(&points)
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// AggregateRollups computes min, max and average of every numeric reading
+// per device and sensor type for points with from <= timestamp < to. The
+// grouping runs in the database so a busy hour is never loaded into memory.
+// Booleans count as 0 and 1, other non-numeric readings are skipped
+func (r *TelemetryRepository) AggregateRollups(ctx context.Context, from, to time.Time) ([]models.TelemetryRollup, error) {
+	value := bson.M{"$switch": bson.M{
+		"branches": []bson.M{
+			{"case": bson.M{"$eq": []interface{}{bson.M{"$type": "$kv.v"}, "bool"}}, "then": bson.M{"$cond": []interface{}{"$kv.v", 1, 0}}},
+			{"case": bson.M{"$isNumber": "$kv.v"}, "then": bson.M{"$toDouble": "$kv.v"}},
+		},
+		"default": nil,
+	}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"timestamp": bson.M{"$gte": from, "$lt": to},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"meta": 1,
+			"kv":   bson.M{"$objectToArray": "$readings"},
+		}}},
+		// The first reading of each point counts the point as a sample
+		{{Key: "$unwind", Value: bson.M{"path": "$kv", "includeArrayIndex": "index"}}},
+		{{Key: "$set", Value: bson.M{"value": value}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"device_id":   "$meta.device_id",
+				"sensor_type": "$meta.sensor_type",
+				"metric":      "$kv.k",
+			},
+			"meta":    bson.M{"$first": "$meta"},
+			"samples": bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$eq": []interface{}{"$index", 0}}, 1, 0}}},
+			"count":   bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$eq": []interface{}{"$value", nil}}, 0, 1}}},
+			"min":     bson.M{"$min": "$value"},
+			"max":     bson.M{"$max": "$value"},
+			"avg":     bson.M{"$avg": "$value"},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"device_id":   "$_id.device_id",
+				"sensor_type": "$_id.sensor_type",
+			},
+			"meta":    bson.M{"$first": "$meta"},
+			"samples": bson.M{"$sum": "$samples"},
+			"metrics": bson.M{"$push": bson.M{
+				"k": "$_id.metric",
+				"v": bson.M{"count": "$count", "min": "$min", "max": "$max", "avg": "$avg"},
+			}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":     0,
+			"meta":    1,
+			"samples": 1,
+			"metrics": bson.M{"$arrayToObject": bson.M{"$filter": bson.M{
+				"input": "$metrics",
+				"cond":  bson.M{"$gt": []interface{}{"$$this.v.count", 0}},
+			}}},
+		}}},
+	}
+
+	var rollups []models.TelemetryRollup
+	if err := r.pointCollection().Aggregate(ctx, pipeline).All(&rollups); err != nil {
+		return nil, err
+	}
+	for i := range rollups {
+		rollups[i].Hour = from
+	}
+	return rollups, nil
+}
+
+// MarkHoursDirty records hours that received points so the next rollup run
+// recomputes them, however late the points arrived
+func (r *TelemetryRepository) MarkHoursDirty(ctx context.Context, hours []time.Time) error {
+	for _, hour := range hours {
+		if _, err := r.dirtyHourCollection().UpsertId(ctx, hour, bson.M{"_id": hour}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindDirtyHours returns the hours marked dirty that start before the given time, oldest first
+func (r *TelemetryRepository) FindDirtyHours(ctx context.Context, before time.Time) ([]time.Time, error) {
+	var docs []struct {
+		Hour time.Time `bson:"_id"`
+	}
+	err := r.dirtyHourCollection().Find(ctx, bson.M{"_id": bson.M{"$lt": before}}).Sort("_id").All(&docs)
+	if err != nil {
+		return nil, err
+	}
+	hours := make([]time.Time, len(docs))
+	for i, doc := range docs {
+		hours[i] = doc.Hour
+	}
+	return hours, nil
+}
+
+// ClearDirtyHour removes the mark of an hour that is about to be rolled up
+func (r *TelemetryRepository) ClearDirtyHour(ctx context.Context, hour time.Time) error {
+	err := r.dirtyHourCollection().RemoveId(ctx, hour)
+	if err != nil && err != qmgo.ErrNoSuchDocuments {
+		return err
+	}
+	return nil
+}
+
+// SaveRollup inserts or replaces the rollup for a device, sensor type and hour
+func (r *TelemetryRepository) SaveRollup(ctx context.Context, rollup *models.TelemetryRollup) error {
+	_, err := r.rollupCollection().Upsert(ctx, bson.M{
+		"meta.device_id":   rollup.Meta.DeviceID,
+		"meta.sensor_type": rollup.Meta.SensorType,
+		"hour":             rollup.Hour,
+	}, rollup)
+	return err
+}
+
+// FindRollups returns hourly rollups matching the filter, newest first
+func (r *TelemetryRepository) FindRollups(ctx context.Context, filter bson.M, limit int64) ([]models.TelemetryRollup, error) {
+	var rollups []models.TelemetryRollup
+	err := r.rollupCollection().Find(ctx, filter).Sort("-hour").Limit(limit).All(&rollups)
+	if err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
+
+func (r *TelemetryRepository) InsertAlerts(ctx context.Context, alerts []models.DeviceAlert) error {
+	_, err := r.alertCollection().InsertMany(ctx, alerts)
+	return err
+}
+
+// FindAlerts returns alerts matching the filter, newest first
+func (r *TelemetryRepository) FindAlerts(ctx context.Context, filter bson.M, limit int64) ([]models.DeviceAlert, error) {
+	var alerts []models.DeviceAlert
+	err := r.alertCollection().Find(ctx, filter).Sort("-timestamp").Limit(limit).All(&alerts)
+	if err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
@@ -0,0 +1,188 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type UserMFARepository struct {
+	db *qmgo.Database
+}
+
+func NewUserMFARepository(db *qmgo.Database) *UserMFARepository {
+	return &UserMFARepository{db: db}
+}
+
+func (r *UserMFARepository) mfaCollection() *qmgo.Collection {
+	return r.db.Collection("user_mfa")
+}
+
+func (r *UserMFARepository) challengeCollection() *qmgo.Collection {
+	return r.db.Collection("mfa_challenges")
+}
+
+// EnsureIndexes keeps one enrolment per user and removes login challenges
+// once they expire
+func (r *UserMFARepository) EnsureIndexes(ctx context.Context) error {
+	err := r.mfaCollection().CreateOneIndex(ctx, options.IndexModel{
+		Key:          []string{"user_id"},
+		IndexOptions: officialOpts.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	return r.challengeCollection().CreateOneIndex(ctx, options.IndexModel{
+		Key:          []string{"expires_at"},
+		IndexOptions: officialOpts.Index().SetExpireAfterSeconds(0),
+	})
+}
+
+func (r *UserMFARepository) FindByUser(ctx context.Context, userID primitive.ObjectID) (*models.UserMFA, error) {
+	var mfa models.UserMFA
+	err := r.mfaCollection().Find(ctx, bson.M{"user_id": userID}).One(&mfa)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// ReplacePending stores a new unconfirmed enrolment for the user, replacing
+// an earlier unconfirmed one. ErrDuplicate means MFA is already enabled
+func (r *UserMFARepository) ReplacePending(ctx context.Context, mfa *models.UserMFA) error {
+	if _, err := r.mfaCollection().RemoveAll(ctx, bson.M{
+		"user_id":      mfa.UserID,
+		"confirmed_at": bson.M{"$exists": false},
+	}); err != nil {
+		return err
+	}
+	if mfa.ID.IsZero() {
+		mfa.ID = primitive.NewObjectID()
+	}
+	_, err := r.mfaCollection().InsertOne(ctx, mfa)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Confirm enables a pending enrolment with its recovery codes
+func (r *UserMFARepository) Confirm(ctx context.Context, id primitive.ObjectID, step int64, recoveryHashes []string, at time.Time) error {
+	err := r.mfaCollection().UpdateOne(ctx, bson.M{
+		"_id":          id,
+		"confirmed_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{
+		"confirmed_at":         at,
+		"last_used_step":       step,
+		"recovery_code_hashes": recoveryHashes,
+		"updated_at":           at,
+	}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// UseStep records an accepted TOTP code. ErrNotFound means a code of this or
+// a later step was already used
+func (r *UserMFARepository) UseStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	err := r.mfaCollection().UpdateOne(ctx, bson.M{
+		"_id":            id,
+		"last_used_step": bson.M{"$lt": step},
+	}, bson.M{"$set": bson.M{"last_used_step": step, "updated_at": time.Now()}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// UseRecoveryCode removes a recovery code hash. ErrNotFound means the code
+// is not, or no longer, one of the user's
+func (r *UserMFARepository) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	err := r.mfaCollection().UpdateOne(ctx, bson.M{
+		"_id":                  id,
+		"recovery_code_hashes": hash,
+	}, bson.M{
+		"$pull": bson.M{"recovery_code_hashes": hash},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *UserMFARepository) SetRecoveryCodes(ctx context.Context, id primitive.ObjectID, hashes []string) error {
+	err := r.mfaCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"recovery_code_hashes": hashes,
+		"updated_at":           time.Now(),
+	}})
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DeleteByUser removes the user's enrolment and returns whether there was one
+func (r *UserMFARepository) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (bool, error) {
+	result, err := r.mfaCollection().RemoveAll(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r *UserMFARepository) CreateChallenge(ctx context.Context, challenge *models.MFAChallenge) error {
+	if challenge.ID.IsZero() {
+		challenge.ID = primitive.NewObjectID()
+	}
+	_, err := r.challengeCollection().InsertOne(ctx, challenge)
+	return err
+}
+
+func (r *UserMFARepository) FindChallenge(ctx context.Context, id primitive.ObjectID) (*models.MFAChallenge, error) {
+	var challenge models.MFAChallenge
+	err := r.challengeCollection().Find(ctx, bson.M{"_id": id}).One(&challenge)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// CountChallengeAttempt atomically counts a code attempt against the
+// challenge and returns the attempts made, including this one
+func (r *UserMFARepository) CountChallengeAttempt(ctx context.Context, id primitive.ObjectID) (int, error) {
+	var challenge models.MFAChallenge
+	err := r.challengeCollection().Find(ctx, bson.M{"_id": id}).Apply(qmgo.Change{
+		Update:    bson.M{"$inc": bson.M{"attempts": 1}},
+		ReturnNew: true,
+	}, &challenge)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	return challenge.Attempts, nil
+}
+
+// DeleteChallenge removes a challenge. ErrNotFound means it was already used
+func (r *UserMFARepository) DeleteChallenge(ctx context.Context, id primitive.ObjectID) error {
+	err := r.challengeCollection().RemoveId(ctx, id)
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrNotFound
+	}
+	return err
+}
@@ -71,3 +71,16 @@ func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 func (r *UserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	return r.collection.RemoveId(ctx, id)
 }
+
+// MarkEmailsVerified marks the unverified addresses of users with the status
+// as verified and returns how many it marked
+func (r *UserRepository) MarkEmailsVerified(ctx context.Context, status string, now time.Time) (int64, error) {
+	result, err := r.collection.UpdateAll(ctx, bson.M{
+		"status":            status,
+		"email_verified_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"email_verified_at": now}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,123 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type VehicleClaimRepository struct {
+	collection *qmgo.Collection
+}
+
+func NewVehicleClaimRepository(db *qmgo.Database) *VehicleClaimRepository {
+	return &VehicleClaimRepository{
+		collection: db.Collection("vehicle_claims"),
+	}
+}
+
+// EnsureIndexes allows a user one open claim per vehicle and finds claims by
+// status and by claimant
+func (r *VehicleClaimRepository) EnsureIndexes(ctx context.Context) error {
+	return r.collection.CreateIndexes(ctx, []options.IndexModel{
+		{
+			Key: []string{"vehicle_id", "claimant_id"},
+			IndexOptions: officialOpts.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": models.VehicleClaimPending}),
+		},
+		{Key: []string{"status", "created_at"}},
+		{Key: []string{"claimant_id", "-created_at"}},
+	})
+}
+
+func (r *VehicleClaimRepository) Create(ctx context.Context, claim *models.VehicleClaim) error {
+	if claim.ID.IsZero() {
+		claim.ID = primitive.NewObjectID()
+	}
+	_, err := r.collection.InsertOne(ctx, claim)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *VehicleClaimRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.VehicleClaim, error) {
+	var claim models.VehicleClaim
+	err := r.collection.Find(ctx, bson.M{"_id": id}).One(&claim)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &claim, nil
+}
+
+// Find returns the matching claims, oldest first so reviewers work in order
+func (r *VehicleClaimRepository) Find(ctx context.Context, filter bson.M) ([]models.VehicleClaim, error) {
+	claims := []models.VehicleClaim{}
+	err := r.collection.Find(ctx, filter).Sort("created_at").All(&claims)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Review closes a pending claim with the reviewer's decision. ErrNotFound
+// means it was not pending anymore
+func (r *VehicleClaimRepository) Review(ctx context.Context, id primitive.ObjectID, status models.VehicleClaimStatus, reviewerID *primitive.ObjectID, note string, now time.Time) (*models.VehicleClaim, error) {
+	set := bson.M{
+		"status":      status,
+		"reviewed_at": now,
+		"review_note": note,
+		"updated_at":  now,
+	}
+	if reviewerID != nil {
+		set["reviewed_by"] = *reviewerID
+	}
+
+	var claim models.VehicleClaim
+	err := r.collection.Find(ctx, bson.M{"_id": id, "status": models.VehicleClaimPending}).Apply(qmgo.Change{
+		Update:    bson.M{"$set": set},
+		ReturnNew: true,
+	}, &claim)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &claim, nil
+}
+
+// CloseOthers rejects the other pending claims on a vehicle once one of them
+// has been approved
+func (r *VehicleClaimRepository) CloseOthers(ctx context.Context, vehicleID, approvedID primitive.ObjectID, note string, now time.Time) error {
+	_, err := r.collection.UpdateAll(ctx, bson.M{
+		"vehicle_id": vehicleID,
+		"_id":        bson.M{"$ne": approvedID},
+		"status":     models.VehicleClaimPending,
+	}, bson.M{"$set": bson.M{
+		"status":      models.VehicleClaimRejected,
+		"reviewed_at": now,
+		"review_note": note,
+		"updated_at":  now,
+	}})
+	return err
+}
+
+// CountPending counts the open claims on a vehicle
+func (r *VehicleClaimRepository) CountPending(ctx context.Context, vehicleID primitive.ObjectID, claimType models.VehicleClaimType) (int64, error) {
+	return r.collection.Find(ctx, bson.M{
+		"vehicle_id": vehicleID,
+		"type":       claimType,
+		"status":     models.VehicleClaimPending,
+	}).Count()
+}
@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/qiniu/qmgo"
@@ -63,6 +64,15 @@ func (r *VehicleRepository) FindByOwner(ctx context.Context, ownerID primitive.O
 	return vehicles, nil
 }
 
+func (r *VehicleRepository) FindByOrganization(ctx context.Context, organizationID primitive.ObjectID) ([]models.Vehicle, error) {
+	var vehicles []models.Vehicle
+	err := r.collection.Find(ctx, bson.M{"organization_id": organizationID}).All(&vehicles)
+	if err != nil {
+		return nil, err
+	}
+	return vehicles, nil
+}
+
 func (r *VehicleRepository) Create(ctx context.Context, vehicle *models.Vehicle) error {
 	_, err := r.collection.InsertOne(ctx, vehicle)
 
@@ -80,6 +90,139 @@ func (r *VehicleRepository) Update(ctx context.Context, vehicle *models.Vehicle)
 	return nil
 }
 
+// AssignDriver assigns a vehicle already in the organization's fleet to
+// another member. The owner is left unchanged
+func (r *VehicleRepository) AssignDriver(ctx context.Context, id, organizationID, driverID primitive.ObjectID) error {
+	return r.updateOne(ctx,
+		bson.M{"_id": id, "organization_id": organizationID},
+		bson.M{"$set": bson.M{"assigned_driver": driverID}})
+}
+
+// InviteToOrganization asks the owner to accept the vehicle into the
+// organization's fleet, assigned to driverID once accepted
+func (r *VehicleRepository) InviteToOrganization(ctx context.Context, id, organizationID, driverID primitive.ObjectID) error {
+	return r.updateOne(ctx,
+		bson.M{"_id": id, "organization_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"organization_invite": organizationID, "assigned_driver": driverID}})
+}
+
+// JoinOrganization moves a vehicle that is not in a fleet into the
+// organization's, assigned to driverID. The owner is left unchanged
+func (r *VehicleRepository) JoinOrganization(ctx context.Context, id, organizationID, driverID primitive.ObjectID) error {
+	return r.updateOne(ctx,
+		bson.M{"_id": id, "organization_id": bson.M{"$exists": false}},
+		bson.M{
+			"$set":   bson.M{"organization_id": organizationID, "assigned_driver": driverID},
+			"$unset": bson.M{"organization_invite": ""},
+		})
+}
+
+// AcceptOrganization lets the owner accept the organization's invitation,
+// moving the vehicle into its fleet
+func (r *VehicleRepository) AcceptOrganization(ctx context.Context, id, ownerID, organizationID primitive.ObjectID) error {
+	return r.updateOne(ctx,
+		bson.M{
+			"_id":                 id,
+			"owner":               ownerID,
+			"organization_invite": organizationID,
+			"organization_id":     bson.M{"$exists": false},
+		},
+		bson.M{
+			"$set":   bson.M{"organization_id": organizationID},
+			"$unset": bson.M{"organization_invite": ""},
+		})
+}
+
+// LeaveOrganization takes the vehicle out of the organization's fleet or
+// withdraws its invitation. The vehicle stays with its owner
+func (r *VehicleRepository) LeaveOrganization(ctx context.Context, id, organizationID primitive.ObjectID) error {
+	return r.updateOne(ctx,
+		bson.M{"_id": id, "$or": []bson.M{
+			{"organization_id": organizationID},
+			{"organization_invite": organizationID},
+		}},
+		bson.M{"$unset": bson.M{"organization_id": "", "organization_invite": "", "assigned_driver": ""}})
+}
+
+// updateOne applies the update to the vehicle matching the filter, mapping a
+// miss to ErrNotFound
+func (r *VehicleRepository) updateOne(ctx context.Context, filter, update bson.M) error {
+	err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// SetVerification records the verification status of the vehicle
+func (r *VehicleRepository) SetVerification(ctx context.Context, id primitive.ObjectID, status models.VehicleVerification, verifiedAt *time.Time) error {
+	return r.updateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"verification_status": status,
+		"verified_at":         verifiedAt,
+	}})
+}
+
+// Transfer gives a verified vehicle to a new owner. The previous owner's
+// drivers and organization do not move with it
+func (r *VehicleRepository) Transfer(ctx context.Context, id, owner primitive.ObjectID, now time.Time) error {
+	return r.updateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{
+			"owner":               owner,
+			"verification_status": models.VehicleVerified,
+			"verified_at":         now,
+		},
+		"$unset": bson.M{"drivers": "", "organization_id": "", "organization_invite": "", "assigned_driver": ""},
+	})
+}
+
+// FindByDriver returns the vehicles the user was invited to drive
+func (r *VehicleRepository) FindByDriver(ctx context.Context, userID primitive.ObjectID) ([]models.Vehicle, error) {
+	vehicles := []models.Vehicle{}
+	err := r.collection.Find(ctx, bson.M{"drivers.user_id": userID}).All(&vehicles)
+	if err != nil {
+		return nil, err
+	}
+	return vehicles, nil
+}
+
+// AddDriver invites a driver. ErrDuplicate means the user already is one
+func (r *VehicleRepository) AddDriver(ctx context.Context, id primitive.ObjectID, driver models.VehicleDriver) error {
+	err := r.updateOne(ctx,
+		bson.M{"_id": id, "drivers.user_id": bson.M{"$ne": driver.UserID}},
+		bson.M{"$push": bson.M{"drivers": driver}})
+	if err == ErrNotFound {
+		if _, findErr := r.FindByID(ctx, id); findErr != nil {
+			return findErr
+		}
+		return ErrDuplicate
+	}
+	return err
+}
+
+// AcceptDriver activates the user's pending invitation
+func (r *VehicleRepository) AcceptDriver(ctx context.Context, id, userID primitive.ObjectID, now time.Time) error {
+	return r.updateOne(ctx, bson.M{
+		"_id": id,
+		"drivers": bson.M{"$elemMatch": bson.M{
+			"user_id": userID,
+			"status":  models.VehicleDriverInvited,
+		}},
+	}, bson.M{"$set": bson.M{
+		"drivers.$.status":      models.VehicleDriverActive,
+		"drivers.$.accepted_at": now,
+	}})
+}
+
+// RemoveDriver takes the user off the vehicle's drivers
+func (r *VehicleRepository) RemoveDriver(ctx context.Context, id, userID primitive.ObjectID) error {
+	return r.updateOne(ctx,
+		bson.M{"_id": id, "drivers.user_id": userID},
+		bson.M{"$pull": bson.M{"drivers": bson.M{"user_id": userID}}})
+}
+
 func (r *VehicleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	err := r.collection.RemoveId(ctx, id)
 	if err != nil {
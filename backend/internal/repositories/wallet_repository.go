@@ -2,11 +2,15 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type WalletRepository struct {
@@ -25,6 +29,24 @@ func (r *WalletRepository) transactionCollection() *qmgo.Collection {
 	return r.db.Collection("transactions")
 }
 
+// EnsureIndexes keeps one wallet per organization and indexes transactions
+// for statements and per member spending
+func (r *WalletRepository) EnsureIndexes(ctx context.Context) error {
+	err := r.walletCollection().CreateOneIndex(ctx, options.IndexModel{
+		Key: []string{"organization_id"},
+		IndexOptions: officialOpts.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"organization_id": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+	return r.transactionCollection().CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"wallet_id", "-created_at"}},
+		{Key: []string{"wallet_id", "user_id", "created_at"}},
+	})
+}
+
 func (r *WalletRepository) FindByUserID(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error) {
 	var wallet models.Wallet
 	err := r.walletCollection().Find(ctx, bson.M{"user_id": userID}).One(&wallet)
@@ -37,8 +59,26 @@ func (r *WalletRepository) FindByUserID(ctx context.Context, userID primitive.Ob
 	return &wallet, nil
 }
 
+func (r *WalletRepository) FindByOrganizationID(ctx context.Context, organizationID primitive.ObjectID) (*models.Wallet, error) {
+	var wallet models.Wallet
+	err := r.walletCollection().Find(ctx, bson.M{"organization_id": organizationID}).One(&wallet)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &wallet, nil
+}
+
 func (r *WalletRepository) Create(ctx context.Context, wallet *models.Wallet) error {
+	if wallet.ID.IsZero() {
+		wallet.ID = primitive.NewObjectID()
+	}
 	_, err := r.walletCollection().InsertOne(ctx, wallet)
+	if qmgo.IsDup(err) {
+		return ErrDuplicate
+	}
 	return err
 }
 
@@ -54,6 +94,9 @@ func (r *WalletRepository) Update(ctx context.Context, wallet *models.Wallet) er
 }
 
 func (r *WalletRepository) CreateTransaction(ctx context.Context, transaction *models.Transaction) error {
+	if transaction.ID.IsZero() {
+		transaction.ID = primitive.NewObjectID()
+	}
 	_, err := r.transactionCollection().InsertOne(ctx, transaction)
 	return err
 }
@@ -66,3 +109,91 @@ func (r *WalletRepository) GetTransactions(ctx context.Context, walletID primiti
 	}
 	return transactions, nil
 }
+
+// Credit adds the amount to the balance in a single write and returns the
+// wallet as it is after the update
+func (r *WalletRepository) Credit(ctx context.Context, walletID primitive.ObjectID, amount float64, now time.Time) (*models.Wallet, error) {
+	var wallet models.Wallet
+	err := r.walletCollection().Find(ctx, bson.M{"_id": walletID}).Apply(qmgo.Change{
+		Update:    bson.M{"$inc": bson.M{"balance": amount}, "$set": bson.M{"updated_at": now}},
+		ReturnNew: true,
+	}, &wallet)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// Debit takes the amount from the balance only when the balance covers it,
+// so concurrent charges to a shared wallet cannot overdraw it. ErrNotFound
+// means the balance was too low
+func (r *WalletRepository) Debit(ctx context.Context, walletID primitive.ObjectID, amount float64, now time.Time) (*models.Wallet, error) {
+	var wallet models.Wallet
+	err := r.walletCollection().Find(ctx, bson.M{
+		"_id":     walletID,
+		"balance": bson.M{"$gte": amount},
+	}).Apply(qmgo.Change{
+		Update:    bson.M{"$inc": bson.M{"balance": -amount}, "$set": bson.M{"updated_at": now}},
+		ReturnNew: true,
+	}, &wallet)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// FindTransactions returns the wallet's transactions created in [from, to),
+// oldest first. A nil bound is open
+func (r *WalletRepository) FindTransactions(ctx context.Context, walletID primitive.ObjectID, from, to *time.Time) ([]models.Transaction, error) {
+	filter := bson.M{"wallet_id": walletID}
+	createdAt := bson.M{}
+	if from != nil {
+		createdAt["$gte"] = *from
+	}
+	if to != nil {
+		createdAt["$lt"] = *to
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	var transactions []models.Transaction
+	err := r.transactionCollection().Find(ctx, filter).Sort("created_at").All(&transactions)
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// SumCharges totals what the user was charged to the wallet since the given time
+func (r *WalletRepository) SumCharges(ctx context.Context, walletID, userID primitive.ObjectID, since time.Time) (float64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"wallet_id":  walletID,
+			"user_id":    userID,
+			"type":       models.TransactionTypeDeduct,
+			"created_at": bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"total": bson.M{"$sum": "$amount"},
+		}}},
+	}
+
+	var result []struct {
+		Total float64 `bson:"total"`
+	}
+	if err := r.transactionCollection().Aggregate(ctx, pipeline).All(&result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Total, nil
+}
@@ -0,0 +1,197 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WebhookRepository struct {
+	db *qmgo.Database
+}
+
+func NewWebhookRepository(db *qmgo.Database) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) subscriptionCollection() *qmgo.Collection {
+	return r.db.Collection("webhook_subscriptions")
+}
+
+func (r *WebhookRepository) deliveryCollection() *qmgo.Collection {
+	return r.db.Collection("webhook_deliveries")
+}
+
+func (r *WebhookRepository) eventCollection() *qmgo.Collection {
+	return r.db.Collection("webhook_events")
+}
+
+// EnsureIndexes creates the indexes used by the delivery worker and log queries
+func (r *WebhookRepository) EnsureIndexes(ctx context.Context) error {
+	err := r.deliveryCollection().CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"status", "next_attempt_at"}},
+		{Key: []string{"subscription_id", "-created_at"}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.eventCollection().CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"dispatched", "created_at"}},
+		{
+			Key:          []string{"expires_at"},
+			IndexOptions: officialOpts.Index().SetExpireAfterSeconds(0),
+		},
+	})
+}
+
+// CreateEvent stores an outbox row for a published event
+func (r *WebhookRepository) CreateEvent(ctx context.Context, event *models.WebhookEvent) error {
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	_, err := r.eventCollection().InsertOne(ctx, event)
+	return err
+}
+
+// ClaimPendingEvent atomically picks the oldest event that has not been fanned
+// out and is not leased by another worker, and leases it until now+lease
+func (r *WebhookRepository) ClaimPendingEvent(ctx context.Context, now time.Time, lease time.Duration) (*models.WebhookEvent, error) {
+	var event models.WebhookEvent
+	err := r.eventCollection().Find(ctx, bson.M{
+		"dispatched": false,
+		"$or": []bson.M{
+			{"claimed_until": bson.M{"$exists": false}},
+			{"claimed_until": bson.M{"$lte": now}},
+		},
+	}).Sort("created_at").Apply(qmgo.Change{
+		Update:    bson.M{"$set": bson.M{"claimed_until": now.Add(lease)}},
+		ReturnNew: true,
+	}, &event)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &event, nil
+}
+
+// MarkEventDispatched records that deliveries exist for the event and lets the
+// TTL index remove it after retention
+func (r *WebhookRepository) MarkEventDispatched(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error {
+	return r.eventCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"dispatched": true, "expires_at": expiresAt},
+		"$unset": bson.M{"claimed_until": ""},
+	})
+}
+
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	if sub.ID.IsZero() {
+		sub.ID = primitive.NewObjectID()
+	}
+	_, err := r.subscriptionCollection().InsertOne(ctx, sub)
+	return err
+}
+
+func (r *WebhookRepository) FindSubscriptionByID(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+	err := r.subscriptionCollection().Find(ctx, bson.M{"_id": id}).One(&sub)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (r *WebhookRepository) FindSubscriptions(ctx context.Context, filter bson.M) ([]models.WebhookSubscription, error) {
+	var subs []models.WebhookSubscription
+	err := r.subscriptionCollection().Find(ctx, filter).Sort("-created_at").All(&subs)
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (r *WebhookRepository) UpdateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	err := r.subscriptionCollection().UpdateOne(ctx, bson.M{"_id": sub.ID}, bson.M{"$set": sub})
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, id primitive.ObjectID) error {
+	err := r.subscriptionCollection().Remove(ctx, bson.M{"_id": id})
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *WebhookRepository) CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if delivery.ID.IsZero() {
+		delivery.ID = primitive.NewObjectID()
+	}
+	_, err := r.deliveryCollection().InsertOne(ctx, delivery)
+	return err
+}
+
+func (r *WebhookRepository) FindDeliveryByID(ctx context.Context, id primitive.ObjectID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.deliveryCollection().Find(ctx, bson.M{"_id": id}).One(&delivery)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (r *WebhookRepository) FindDeliveriesBySubscription(ctx context.Context, subscriptionID primitive.ObjectID, limit int64) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.deliveryCollection().Find(ctx, bson.M{"subscription_id": subscriptionID}).Sort("-created_at").Limit(limit).All(&deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimDueDelivery atomically picks a pending delivery that is due and pushes
+// its next attempt time forward by lease, so concurrent workers skip it
+func (r *WebhookRepository) ClaimDueDelivery(ctx context.Context, now time.Time, lease time.Duration) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.deliveryCollection().Find(ctx, bson.M{
+		"status":          models.WebhookDeliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}).Sort("next_attempt_at").Apply(qmgo.Change{
+		Update:    bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}},
+		ReturnNew: true,
+	}, &delivery)
+	if err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return r.deliveryCollection().UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": delivery})
+}
@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/dfanso/parkme-backend/internal/authz"
+)
+
+// Policy lists who may call each route registered in RegisterRoutes. Every
+// API route needs an entry, main verifies the table against the router at
+// startup. Self service routes check ownership of the resource in the handler.
+// Destructive admin routes also need a session signed in with a second factor
+var Policy = authz.Policy{
+	// Public
+	authz.Key(http.MethodPost, "/api/auth/register"):               authz.Public(),
+	authz.Key(http.MethodPost, "/api/auth/login"):                  authz.Public(),
+	authz.Key(http.MethodPost, "/api/auth/refresh"):                authz.Public(),
+	authz.Key(http.MethodPost, "/api/auth/verify-email"):           authz.Public(),
+	authz.Key(http.MethodPost, "/api/auth/password/forgot"):        authz.Public(),
+	authz.Key(http.MethodPost, "/api/auth/password/reset"):         authz.Public(),
+	authz.Key(http.MethodPost, "/api/auth/mfa/verify"):             authz.Public(),
+	authz.Key(http.MethodGet, "/api/auth/oidc/providers"):          authz.Public(),
+	authz.Key(http.MethodGet, "/api/auth/oidc/:provider/login"):    authz.Public(),
+	authz.Key(http.MethodGet, "/api/auth/oidc/:provider/callback"): authz.Public(),
+	authz.Key(http.MethodGet, "/api/guest/:code"):                  authz.Public(),
+
+	// Arduino devices
+	authz.Key(http.MethodPost, "/api/arduino/gate/enter/upload"): authz.Device(),
+	authz.Key(http.MethodPost, "/api/arduino/gate/exit/upload"):  authz.Device(),
+	authz.Key(http.MethodPost, "/api/arduino/spot/status"):       authz.Device(),
+	authz.Key(http.MethodPost, "/api/arduino/spot/snapshot"):     authz.Device(),
+	authz.Key(http.MethodPost, "/api/arduino/heartbeat"):         authz.Device(),
+	authz.Key(http.MethodGet, "/api/arduino/config"):             authz.Device(),
+	authz.Key(http.MethodPost, "/api/arduino/config/ack"):        authz.Device(),
+	authz.Key(http.MethodGet, "/api/arduino/display"):            authz.Device(),
+	authz.Key(http.MethodPost, "/api/arduino/telemetry"):         authz.Device(),
+	authz.Key(http.MethodPost, "/api/arduino/alerts"):            authz.Device(),
+
+	// Users, a user may read and update their own account
+	authz.Key(http.MethodGet, "/api/users"):             authz.Allow(authz.PermUsersManage),
+	authz.Key(http.MethodGet, "/api/users/:id"):         authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPut, "/api/users/:id"):         authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodDelete, "/api/users/:id"):      authz.Allow(authz.PermUsersManage).WithMFA(),
+	authz.Key(http.MethodPost, "/api/users/:id/unlock"): authz.Allow(authz.PermUsersManage).WithMFA(),
+	authz.Key(http.MethodDelete, "/api/users/:id/mfa"):  authz.Allow(authz.PermUsersManage).WithMFA(),
+	authz.Key(http.MethodGet, "/api/audit-logs"):        authz.Allow(authz.PermAuditView),
+	authz.Key(http.MethodGet, "/api/user/stats"):        authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodGet, "/api/auth/profile"):      authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPut, "/api/auth/profile"):      authz.Allow(authz.PermAccount),
+
+	// Sessions of the caller
+	authz.Key(http.MethodPost, "/api/auth/verify-email/resend"): authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPost, "/api/auth/logout"):              authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPost, "/api/auth/logout-all"):          authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodGet, "/api/auth/sessions"):             authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodDelete, "/api/auth/sessions/:id"):      authz.Allow(authz.PermAccount),
+
+	// Two-factor authentication of the caller
+	authz.Key(http.MethodGet, "/api/auth/mfa"):                 authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPost, "/api/auth/mfa/enroll"):         authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPost, "/api/auth/mfa/enroll/confirm"): authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPost, "/api/auth/mfa/disable"):        authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPost, "/api/auth/mfa/recovery-codes"): authz.Allow(authz.PermAccount),
+
+	// Vehicles, owners or staff
+	authz.Key(http.MethodPost, "/api/vehicles"):                       authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/vehicles"):                        authz.Allow(authz.PermVehiclesView),
+	authz.Key(http.MethodGet, "/api/vehicles/:id"):                    authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/vehicles/user/:userId"):           authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPut, "/api/vehicles/:id"):                    authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodDelete, "/api/vehicles/:id"):                 authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/vehicles/shared"):                 authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/vehicles/claims"):                 authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/vehicles/claims"):                authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/vehicles/:id/verification"):      authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/vehicles/:id/drivers"):           authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/vehicles/:id/drivers/accept"):    authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodDelete, "/api/vehicles/:id/drivers/:userId"): authz.Allow(authz.PermSelfService),
+
+	// Vehicle claim review, admins only
+	authz.Key(http.MethodGet, "/api/vehicle-claims"):              authz.Allow(authz.PermVehiclesVerify),
+	authz.Key(http.MethodGet, "/api/vehicle-claims/:id"):          authz.Allow(authz.PermVehiclesVerify),
+	authz.Key(http.MethodPost, "/api/vehicle-claims/:id/approve"): authz.Allow(authz.PermVehiclesVerify).WithMFA(),
+	authz.Key(http.MethodPost, "/api/vehicle-claims/:id/reject"):  authz.Allow(authz.PermVehiclesVerify).WithMFA(),
+
+	// Bookings, owners or staff at the booking's location
+	authz.Key(http.MethodPost, "/api/bookings"):            authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/bookings/:id"):         authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/bookings/user"):        authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/bookings/vehicle/:id"): authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPut, "/api/bookings/:id/cancel"):  authz.Allow(authz.PermSelfService),
+
+	// Wallet and notifications always act on the caller, notification
+	// settings are part of the account
+	authz.Key(http.MethodPost, "/api/wallet/topup"):             authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/wallet/balance"):            authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/wallet/transactions"):       authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/notifications"):             authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodGet, "/api/notifications/preferences"): authz.Allow(authz.PermAccount),
+	authz.Key(http.MethodPut, "/api/notifications/preferences"): authz.Allow(authz.PermAccount),
+
+	// Organizations, members and fleet managers are checked in the handler
+	authz.Key(http.MethodPost, "/api/organizations"):                                authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/organizations"):                                 authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/organizations/:id"):                             authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPut, "/api/organizations/:id"):                             authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/organizations/:id/members"):                     authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/organizations/:id/members"):                    authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/organizations/:id/members/accept"):             authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPut, "/api/organizations/:id/members/:userId"):             authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodDelete, "/api/organizations/:id/members/:userId"):          authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/organizations/:id/vehicles"):                    authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/organizations/:id/vehicles"):                   authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/organizations/:id/vehicles/:vehicleId/accept"): authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodDelete, "/api/organizations/:id/vehicles/:vehicleId"):      authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/organizations/:id/wallet"):                      authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/organizations/:id/wallet/topup"):               authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodGet, "/api/organizations/:id/statements/:period"):          authz.Allow(authz.PermSelfService),
+
+	// Locations
+	authz.Key(http.MethodPost, "/api/locations"):               authz.Allow(authz.PermLocationsManage).WithMFA(),
+	authz.Key(http.MethodGet, "/api/locations"):                authz.Allow(authz.PermLocationsView),
+	authz.Key(http.MethodGet, "/api/locations/nearby"):         authz.Allow(authz.PermLocationsView),
+	authz.Key(http.MethodGet, "/api/locations/:id"):            authz.Allow(authz.PermLocationsView),
+	authz.Key(http.MethodGet, "/api/locations/:id/stream"):     authz.Allow(authz.PermLocationsView),
+	authz.Key(http.MethodGet, "/api/locations/:id/display"):    authz.Allow(authz.PermLocationsView),
+	authz.Key(http.MethodPut, "/api/locations/:id"):            authz.AllowAt(authz.PermLocationsConfigure, "id"),
+	authz.Key(http.MethodPut, "/api/locations/:id/slot"):       authz.AllowAt(authz.PermSlotsOverride, "id"),
+	authz.Key(http.MethodGet, "/api/locations/:id/readings"):   authz.AllowAt(authz.PermOccupancyMonitor, "id"),
+	authz.Key(http.MethodGet, "/api/locations/:id/mismatches"): authz.AllowAt(authz.PermOccupancyMonitor, "id"),
+	authz.Key(http.MethodDelete, "/api/locations/:id"):         authz.Allow(authz.PermLocationsManage).WithMFA(),
+
+	// Guest sessions, staff are limited to their locations in the handler
+	authz.Key(http.MethodGet, "/api/guest"):               authz.Allow(authz.PermGuestsSettle),
+	authz.Key(http.MethodPost, "/api/guest/:code/pay"):    authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/guest/:code/claim"):  authz.Allow(authz.PermSelfService),
+	authz.Key(http.MethodPost, "/api/guest/:code/settle"): authz.Allow(authz.PermGuestsSettle),
+
+	// Device registry
+	authz.Key(http.MethodPost, "/api/devices"):            authz.Allow(authz.PermDevicesManage).WithMFA(),
+	authz.Key(http.MethodGet, "/api/devices"):             authz.Allow(authz.PermDevicesManage),
+	authz.Key(http.MethodGet, "/api/devices/health"):      authz.Allow(authz.PermDevicesManage),
+	authz.Key(http.MethodGet, "/api/devices/telemetry"):   authz.Allow(authz.PermDevicesManage),
+	authz.Key(http.MethodGet, "/api/devices/alerts"):      authz.Allow(authz.PermDevicesManage),
+	authz.Key(http.MethodGet, "/api/devices/:id"):         authz.Allow(authz.PermDevicesManage),
+	authz.Key(http.MethodGet, "/api/devices/:id/config"):  authz.Allow(authz.PermDevicesManage),
+	authz.Key(http.MethodPut, "/api/devices/:id/config"):  authz.Allow(authz.PermDevicesManage),
+	authz.Key(http.MethodPost, "/api/devices/:id/rotate"): authz.Allow(authz.PermDevicesManage).WithMFA(),
+	authz.Key(http.MethodPost, "/api/devices/:id/revoke"): authz.Allow(authz.PermDevicesManage).WithMFA(),
+
+	// Webhooks
+	authz.Key(http.MethodGet, "/api/webhooks/event-types"):                    authz.Allow(authz.PermWebhooksManage),
+	authz.Key(http.MethodPost, "/api/webhooks"):                               authz.Allow(authz.PermWebhooksManage).WithMFA(),
+	authz.Key(http.MethodGet, "/api/webhooks"):                                authz.Allow(authz.PermWebhooksManage),
+	authz.Key(http.MethodGet, "/api/webhooks/:id"):                            authz.Allow(authz.PermWebhooksManage),
+	authz.Key(http.MethodPut, "/api/webhooks/:id"):                            authz.Allow(authz.PermWebhooksManage).WithMFA(),
+	authz.Key(http.MethodDelete, "/api/webhooks/:id"):                         authz.Allow(authz.PermWebhooksManage).WithMFA(),
+	authz.Key(http.MethodGet, "/api/webhooks/:id/deliveries"):                 authz.Allow(authz.PermWebhooksManage),
+	authz.Key(http.MethodPost, "/api/webhooks/deliveries/:deliveryId/replay"): authz.Allow(authz.PermWebhooksManage).WithMFA(),
+}
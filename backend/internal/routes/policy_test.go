@@ -0,0 +1,362 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dfanso/parkme-backend/internal/authz"
+	"github.com/dfanso/parkme-backend/internal/controllers"
+	"github.com/dfanso/parkme-backend/internal/models"
+	customMiddleware "github.com/dfanso/parkme-backend/pkg/middleware"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The resources a request acts on belong to the owner and sit at location.
+// Scoped staff in scope are assigned to location, out of scope to elsewhere
+var (
+	location  = primitive.NewObjectID()
+	elsewhere = primitive.NewObjectID()
+	owner     = primitive.NewObjectID()
+)
+
+type actor struct {
+	name      string
+	principal authz.Principal
+}
+
+// actors are the columns of the matrix, in order
+var actors = []actor{
+	{"admin", authz.Principal{UserID: primitive.NewObjectID(), Role: models.RoleAdmin}},
+	{"site_operator in scope", authz.Principal{UserID: primitive.NewObjectID(), Role: models.RoleSiteOperator, LocationIDs: []primitive.ObjectID{location}}},
+	{"site_operator out of scope", authz.Principal{UserID: primitive.NewObjectID(), Role: models.RoleSiteOperator, LocationIDs: []primitive.ObjectID{elsewhere}}},
+	{"attendant", authz.Principal{UserID: primitive.NewObjectID(), Role: models.RoleAttendant, LocationIDs: []primitive.ObjectID{location}}},
+	{"driver owner", authz.Principal{UserID: owner, Role: models.RoleUser}},
+	{"driver non-owner", authz.Principal{UserID: primitive.NewObjectID(), Role: models.RoleUser}},
+}
+
+// The ownership and location scope rules the handlers apply after the route
+// policy. These tests never run a handler, so the rules stand in for the
+// handlers' own checks and the matrix only verifies the route level gates
+func ownerOr(override authz.Permission) func(authz.Principal) bool {
+	return func(p authz.Principal) bool { return p.CanActFor(owner, override) }
+}
+
+func ownerOrAt(perm authz.Permission) func(authz.Principal) bool {
+	return func(p authz.Principal) bool { return p.UserID == owner || p.CanAt(perm, location) }
+}
+
+func ownerOnly(p authz.Principal) bool { return p.UserID == owner }
+
+func inScope(p authz.Principal) bool { return p.CanAccessLocation(location) }
+
+// routeAccess is a row of the matrix. want has one Y or N per actor, or is
+// "public" or "device" for routes that do not take a user. check is the rule
+// the handler is expected to apply to an actor the route policy let through
+type routeAccess struct {
+	route string
+	want  string
+	check func(authz.Principal) bool
+}
+
+// Columns: admin, site_operator in scope, site_operator out of scope,
+// attendant, driver owner, driver non-owner
+var matrix = []routeAccess{
+	// Public
+	{"POST /api/auth/register", "public", nil},
+	{"POST /api/auth/login", "public", nil},
+	{"POST /api/auth/refresh", "public", nil},
+	{"POST /api/auth/verify-email", "public", nil},
+	{"POST /api/auth/password/forgot", "public", nil},
+	{"POST /api/auth/password/reset", "public", nil},
+	{"POST /api/auth/mfa/verify", "public", nil},
+	{"GET /api/auth/oidc/providers", "public", nil},
+	{"GET /api/auth/oidc/:provider/login", "public", nil},
+	{"GET /api/auth/oidc/:provider/callback", "public", nil},
+	{"GET /api/guest/:code", "public", nil},
+
+	// Arduino devices
+	{"POST /api/arduino/gate/enter/upload", "device", nil},
+	{"POST /api/arduino/gate/exit/upload", "device", nil},
+	{"POST /api/arduino/spot/status", "device", nil},
+	{"POST /api/arduino/spot/snapshot", "device", nil},
+	{"POST /api/arduino/heartbeat", "device", nil},
+	{"GET /api/arduino/config", "device", nil},
+	{"POST /api/arduino/config/ack", "device", nil},
+	{"GET /api/arduino/display", "device", nil},
+	{"POST /api/arduino/telemetry", "device", nil},
+	{"POST /api/arduino/alerts", "device", nil},
+
+	// Users and the caller's account
+	{"GET /api/users", "YNNNNN", nil},
+	{"GET /api/users/:id", "YNNNYN", ownerOr(authz.PermUsersManage)},
+	{"PUT /api/users/:id", "YNNNYN", ownerOr(authz.PermUsersManage)},
+	{"DELETE /api/users/:id", "YNNNNN", nil},
+	{"POST /api/users/:id/unlock", "YNNNNN", nil},
+	{"DELETE /api/users/:id/mfa", "YNNNNN", nil},
+	{"GET /api/audit-logs", "YNNNNN", nil},
+	{"GET /api/user/stats", "YYYYYY", nil},
+	{"GET /api/auth/profile", "YYYYYY", nil},
+	{"PUT /api/auth/profile", "YYYYYY", nil},
+	{"POST /api/auth/verify-email/resend", "YYYYYY", nil},
+	{"POST /api/auth/logout", "YYYYYY", nil},
+	{"POST /api/auth/logout-all", "YYYYYY", nil},
+	{"GET /api/auth/sessions", "YYYYYY", nil},
+	{"DELETE /api/auth/sessions/:id", "YYYYYY", nil},
+	{"GET /api/auth/mfa", "YYYYYY", nil},
+	{"POST /api/auth/mfa/enroll", "YYYYYY", nil},
+	{"POST /api/auth/mfa/enroll/confirm", "YYYYYY", nil},
+	{"POST /api/auth/mfa/disable", "YYYYYY", nil},
+	{"POST /api/auth/mfa/recovery-codes", "YYYYYY", nil},
+
+	// Vehicles
+	{"POST /api/vehicles", "YYYYYY", nil},
+	{"GET /api/vehicles", "YYYYNN", nil},
+	{"GET /api/vehicles/:id", "YYYYYN", ownerOr(authz.PermVehiclesView)},
+	{"GET /api/vehicles/user/:userId", "YYYYYN", ownerOr(authz.PermVehiclesView)},
+	{"PUT /api/vehicles/:id", "YNNNYN", ownerOr(authz.PermVehiclesManage)},
+	{"DELETE /api/vehicles/:id", "YNNNYN", ownerOr(authz.PermVehiclesManage)},
+	{"GET /api/vehicles/shared", "YYYYYY", nil},
+	{"GET /api/vehicles/claims", "YYYYYY", nil},
+	{"POST /api/vehicles/claims", "YYYYYY", nil},
+	{"POST /api/vehicles/:id/verification", "NNNNYN", ownerOnly},
+	{"POST /api/vehicles/:id/drivers", "YNNNYN", ownerOr(authz.PermVehiclesManage)},
+	{"POST /api/vehicles/:id/drivers/accept", "YYYYYY", nil},
+	{"DELETE /api/vehicles/:id/drivers/:userId", "YNNNYN", ownerOr(authz.PermVehiclesManage)},
+	{"GET /api/vehicle-claims", "YNNNNN", nil},
+	{"GET /api/vehicle-claims/:id", "YNNNNN", nil},
+	{"POST /api/vehicle-claims/:id/approve", "YNNNNN", nil},
+	{"POST /api/vehicle-claims/:id/reject", "YNNNNN", nil},
+
+	// Bookings, only drivers of the vehicle may book with it
+	{"POST /api/bookings", "NNNNYN", ownerOnly},
+	{"GET /api/bookings/:id", "YYNYYN", ownerOrAt(authz.PermBookingsView)},
+	{"GET /api/bookings/user", "YYYYYY", nil},
+	{"GET /api/bookings/vehicle/:id", "YYYYYY", nil}, // Filtered to the bookings the caller may see
+	{"PUT /api/bookings/:id/cancel", "YYNNYN", ownerOrAt(authz.PermBookingsManage)},
+
+	// Wallet and notifications of the caller
+	{"POST /api/wallet/topup", "YYYYYY", nil},
+	{"GET /api/wallet/balance", "YYYYYY", nil},
+	{"GET /api/wallet/transactions", "YYYYYY", nil},
+	{"GET /api/notifications", "YYYYYY", nil},
+	{"GET /api/notifications/preferences", "YYYYYY", nil},
+	{"PUT /api/notifications/preferences", "YYYYYY", nil},
+
+	// Organizations, the owner is the fleet manager
+	{"POST /api/organizations", "YYYYYY", nil},
+	{"GET /api/organizations", "YYYYYY", nil},
+	{"GET /api/organizations/:id", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"PUT /api/organizations/:id", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"GET /api/organizations/:id/members", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"POST /api/organizations/:id/members", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"PUT /api/organizations/:id/members/:userId", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"DELETE /api/organizations/:id/members/:userId", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"POST /api/organizations/:id/members/accept", "YYYYYY", nil},
+	{"GET /api/organizations/:id/vehicles", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"POST /api/organizations/:id/vehicles", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"DELETE /api/organizations/:id/vehicles/:vehicleId", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"POST /api/organizations/:id/vehicles/:vehicleId/accept", "NNNNYN", ownerOnly},
+	{"GET /api/organizations/:id/wallet", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"POST /api/organizations/:id/wallet/topup", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+	{"GET /api/organizations/:id/statements/:period", "YNNNYN", ownerOr(authz.PermOrganizationsManage)},
+
+	// Locations
+	{"POST /api/locations", "YNNNNN", nil},
+	{"GET /api/locations", "YYYYYY", nil},
+	{"GET /api/locations/nearby", "YYYYYY", nil},
+	{"GET /api/locations/:id", "YYYYYY", nil},
+	{"GET /api/locations/:id/stream", "YYYYYY", nil},
+	{"GET /api/locations/:id/display", "YYYYYY", nil},
+	{"PUT /api/locations/:id", "YYNNNN", nil},
+	{"PUT /api/locations/:id/slot", "YYNYNN", nil},
+	{"GET /api/locations/:id/readings", "YYNYNN", nil},
+	{"GET /api/locations/:id/mismatches", "YYNYNN", nil},
+	{"DELETE /api/locations/:id", "YNNNNN", nil},
+
+	// Guest sessions
+	{"GET /api/guest", "YYNYNN", inScope},
+	{"POST /api/guest/:code/pay", "YYYYYY", nil},
+	{"POST /api/guest/:code/claim", "YYYYYY", nil},
+	{"POST /api/guest/:code/settle", "YYNYNN", inScope},
+
+	// Device registry
+	{"POST /api/devices", "YNNNNN", nil},
+	{"GET /api/devices", "YNNNNN", nil},
+	{"GET /api/devices/health", "YNNNNN", nil},
+	{"GET /api/devices/telemetry", "YNNNNN", nil},
+	{"GET /api/devices/alerts", "YNNNNN", nil},
+	{"GET /api/devices/:id", "YNNNNN", nil},
+	{"GET /api/devices/:id/config", "YNNNNN", nil},
+	{"PUT /api/devices/:id/config", "YNNNNN", nil},
+	{"POST /api/devices/:id/rotate", "YNNNNN", nil},
+	{"POST /api/devices/:id/revoke", "YNNNNN", nil},
+
+	// Webhooks
+	{"GET /api/webhooks/event-types", "YNNNNN", nil},
+	{"POST /api/webhooks", "YNNNNN", nil},
+	{"GET /api/webhooks", "YNNNNN", nil},
+	{"GET /api/webhooks/:id", "YNNNNN", nil},
+	{"PUT /api/webhooks/:id", "YNNNNN", nil},
+	{"DELETE /api/webhooks/:id", "YNNNNN", nil},
+	{"GET /api/webhooks/:id/deliveries", "YNNNNN", nil},
+	{"POST /api/webhooks/deliveries/:deliveryId/replay", "YNNNNN", nil},
+}
+
+// registeredRoutes builds the router the server runs and returns its API routes
+func registeredRoutes() []*echo.Route {
+	e := echo.New()
+	RegisterRoutes(e, &controllers.UserController{}, &controllers.AuthController{}, &controllers.VehicleController{}, &controllers.ArduinoController{}, &controllers.BookingController{}, &controllers.WalletController{}, &controllers.ParkingLocationController{}, &controllers.UserStatsController{}, &controllers.StreamController{}, &controllers.WebhookController{}, &controllers.NotificationController{}, &controllers.DeviceController{}, &controllers.TelemetryController{}, &controllers.ReconciliationController{}, &controllers.DisplayController{}, &controllers.GuestController{}, &controllers.AuditController{}, &controllers.OIDCController{}, &controllers.OrganizationController{}, &controllers.VehicleClaimController{})
+
+	var api []*echo.Route
+	for _, route := range e.Routes() {
+		if route.Method == echo.RouteNotFound || !strings.HasPrefix(route.Path, "/api") {
+			continue
+		}
+		api = append(api, route)
+	}
+	return api
+}
+
+// authorize runs the policy middleware for the route as the principal and
+// reports whether the request reached the handler
+func authorize(t *testing.T, method, path string, principal authz.Principal) bool {
+	t.Helper()
+	e := echo.New()
+	req := httptest.NewRequest(method, "/", nil)
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.SetPath(path)
+
+	// Every path parameter names the resource at the location
+	var names, values []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			names = append(names, segment[1:])
+			values = append(values, location.Hex())
+		}
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+
+	c.Set("userID", principal.UserID)
+	c.Set("userRole", string(principal.Role))
+	c.Set("userLocations", principal.LocationIDs)
+	c.Set("userStatus", models.UserStatusActive)
+	c.Set("mfa", principal.MFA)
+	c.Set("mfaRequired", principal.MFARequired)
+
+	reached := false
+	handler := customMiddleware.Authorize(Policy)(func(echo.Context) error {
+		reached = true
+		return nil
+	})
+	if err := handler(c); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return reached
+}
+
+func TestPolicyMatrixCoversEveryRoute(t *testing.T) {
+	rows := make(map[string]bool, len(matrix))
+	for _, row := range matrix {
+		if rows[row.route] {
+			t.Errorf("%s is listed twice", row.route)
+		}
+		rows[row.route] = true
+	}
+
+	registered := make(map[string]bool)
+	for _, route := range registeredRoutes() {
+		key := authz.Key(route.Method, route.Path)
+		registered[key] = true
+		if !rows[key] {
+			t.Errorf("%s has no row in the authorization matrix", key)
+		}
+	}
+	for _, row := range matrix {
+		if !registered[row.route] {
+			t.Errorf("%s is not a registered route", row.route)
+		}
+	}
+}
+
+// TestPolicyRouteGates checks the permission, second factor and location
+// gates of each route's policy rule by running the authorization middleware.
+// Handlers do not run, the row's check takes the place of their ownership and
+// scope checks
+func TestPolicyRouteGates(t *testing.T) {
+	for _, row := range matrix {
+		method, path, _ := strings.Cut(row.route, " ")
+		rule, ok := Policy.Lookup(method, path)
+		if !ok {
+			t.Errorf("%s has no policy rule", row.route)
+			continue
+		}
+
+		switch row.want {
+		case "public":
+			if rule.Access != authz.AccessPublic {
+				t.Errorf("%s should be public", row.route)
+			}
+			continue
+		case "device":
+			if rule.Access != authz.AccessDevice {
+				t.Errorf("%s should be called by devices", row.route)
+			}
+			continue
+		}
+
+		if rule.Access != authz.AccessUser {
+			t.Errorf("%s should need a signed in user", row.route)
+			continue
+		}
+		if len(row.want) != len(actors) {
+			t.Fatalf("%s: want %q has %d columns, expected %d", row.route, row.want, len(row.want), len(actors))
+		}
+
+		for i, a := range actors {
+			principal := a.principal
+			principal.MFA = true
+			got := authorize(t, method, path, principal)
+			if got && row.check != nil {
+				got = row.check(principal)
+			}
+			if want := row.want[i] == 'Y'; got != want {
+				t.Errorf("%s as %s: allowed = %v, want %v", row.route, a.name, got, want)
+			}
+		}
+	}
+}
+
+func TestPolicyMFARoutesNeedSecondFactor(t *testing.T) {
+	admin := authz.Principal{UserID: primitive.NewObjectID(), Role: models.RoleAdmin}
+	for _, route := range registeredRoutes() {
+		rule, ok := Policy.Lookup(route.Method, route.Path)
+		if !ok || rule.Access != authz.AccessUser || !rule.MFA {
+			continue
+		}
+		if authorize(t, route.Method, route.Path, admin) {
+			t.Errorf("%s %s allowed an admin session without a second factor", route.Method, route.Path)
+		}
+	}
+}
+
+func TestPolicyAdminMutationsNeedSecondFactor(t *testing.T) {
+	for _, key := range []string{
+		authz.Key(http.MethodDelete, "/api/users/:id"),
+		authz.Key(http.MethodPost, "/api/webhooks"),
+		authz.Key(http.MethodPut, "/api/webhooks/:id"),
+		authz.Key(http.MethodDelete, "/api/webhooks/:id"),
+		authz.Key(http.MethodPost, "/api/webhooks/deliveries/:deliveryId/replay"),
+		authz.Key(http.MethodPost, "/api/devices/:id/rotate"),
+		authz.Key(http.MethodPost, "/api/devices/:id/revoke"),
+		authz.Key(http.MethodPost, "/api/vehicle-claims/:id/approve"),
+		authz.Key(http.MethodPost, "/api/vehicle-claims/:id/reject"),
+	} {
+		if !Policy[key].MFA {
+			t.Errorf("%s should need a second factor", key)
+		}
+	}
+}
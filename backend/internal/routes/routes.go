@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/dfanso/parkme-backend/internal/controllers"
+	"github.com/dfanso/parkme-backend/internal/models"
 	customMiddleware "github.com/dfanso/parkme-backend/pkg/middleware"
 	"github.com/labstack/echo/v4"
 )
@@ -16,22 +17,58 @@ func RegisterRoutes(
 	walletController *controllers.WalletController,
 	parkingLocationController *controllers.ParkingLocationController,
 	userStatsController *controllers.UserStatsController,
+	streamController *controllers.StreamController,
+	webhookController *controllers.WebhookController,
+	notificationController *controllers.NotificationController,
+	deviceController *controllers.DeviceController,
+	telemetryController *controllers.TelemetryController,
+	reconciliationController *controllers.ReconciliationController,
+	displayController *controllers.DisplayController,
+	guestController *controllers.GuestController,
+	auditController *controllers.AuditController,
+	oidcController *controllers.OIDCController,
+	organizationController *controllers.OrganizationController,
+	vehicleClaimController *controllers.VehicleClaimController,
 ) {
 	// Public routes
 	auth := e.Group("/api/auth")
 	auth.POST("/register", authController.Register)
 	auth.POST("/login", authController.Login)
+	auth.POST("/refresh", authController.Refresh)
+	auth.POST("/verify-email", authController.VerifyEmail)
+	auth.POST("/password/forgot", authController.ForgotPassword)
+	auth.POST("/password/reset", authController.ResetPassword)
+	auth.POST("/mfa/verify", authController.VerifyMFA)
 
-	// Arduino routes
+	// Sign in with an OpenID Connect provider
+	auth.GET("/oidc/providers", oidcController.GetProviders)
+	auth.GET("/oidc/:provider/login", oidcController.Login)
+	auth.GET("/oidc/:provider/callback", oidcController.Callback)
+
+	// Public keys for verifying access tokens
+	e.GET("/.well-known/jwks.json", authController.GetJWKS)
+
+	// Guest pay codes can be looked up without an account
+	e.GET("/api/guest/:code", guestController.GetQuote)
+
+	// Arduino routes, authenticated per device
 	arduino := e.Group("/api/arduino")
-	arduino.Use(customMiddleware.ValidateAPIKey())
-	arduino.POST("/gate/enter/upload", arduinoController.GateEnter)
-	arduino.POST("/gate/exit/upload", arduinoController.GateExit)
-	arduino.POST("/spot/status", arduinoController.UpdateSpotStatus)
+	arduino.Use(customMiddleware.DeviceAuth(deviceController.GetDeviceService()))
+	arduino.POST("/gate/enter/upload", arduinoController.GateEnter, customMiddleware.RequireDeviceType(models.DeviceTypeGateEnter))
+	arduino.POST("/gate/exit/upload", arduinoController.GateExit, customMiddleware.RequireDeviceType(models.DeviceTypeGateExit))
+	arduino.POST("/spot/status", arduinoController.UpdateSpotStatus, customMiddleware.RequireDeviceType(models.DeviceTypeSlotSensor))
+	arduino.POST("/spot/snapshot", arduinoController.UpdateSpotSnapshot, customMiddleware.RequireDeviceType(models.DeviceTypeSlotSensor))
+	arduino.POST("/heartbeat", deviceController.Heartbeat)
+	arduino.GET("/config", deviceController.FetchConfig)
+	arduino.POST("/config/ack", deviceController.AcknowledgeConfig)
+	arduino.GET("/display", displayController.GetDeviceFeed)
+	arduino.POST("/telemetry", telemetryController.IngestTelemetry)
+	arduino.POST("/alerts", telemetryController.IngestAlerts)
 
 	// Protected routes
 	api := e.Group("/api")
-	api.Use(customMiddleware.AuthMiddleware(authController.GetJWTManager(), userController.GetUserService()))
+	api.Use(customMiddleware.AuthMiddleware(authController.GetJWTManager(), userController.GetUserService(), authController.GetSessionService(), authController.GetMFAService()))
+	api.Use(customMiddleware.Authorize(Policy))
 
 	// User routes
 	users := api.Group("/users")
@@ -39,6 +76,11 @@ func RegisterRoutes(
 	users.GET("/:id", userController.GetByID)
 	users.PUT("/:id", userController.Update)
 	users.DELETE("/:id", userController.Delete)
+	users.POST("/:id/unlock", userController.Unlock)
+	users.DELETE("/:id/mfa", userController.ResetMFA)
+
+	// Audit log of security events
+	api.GET("/audit-logs", auditController.GetEntries)
 
 	// User Stats route
 	api.GET("/user/stats", userStatsController.GetUserStats)
@@ -47,15 +89,39 @@ func RegisterRoutes(
 	auth = api.Group("/auth")
 	auth.GET("/profile", authController.GetProfile)
 	auth.PUT("/profile", authController.UpdateProfile)
+	auth.POST("/verify-email/resend", authController.ResendVerification)
+	auth.POST("/logout", authController.Logout)
+	auth.POST("/logout-all", authController.LogoutAll)
+	auth.GET("/sessions", authController.GetSessions)
+	auth.DELETE("/sessions/:id", authController.RevokeSession)
+	auth.GET("/mfa", authController.GetMFAStatus)
+	auth.POST("/mfa/enroll", authController.BeginMFAEnrolment)
+	auth.POST("/mfa/enroll/confirm", authController.ConfirmMFAEnrolment)
+	auth.POST("/mfa/disable", authController.DisableMFA)
+	auth.POST("/mfa/recovery-codes", authController.RenewRecoveryCodes)
 
 	// Vehicle routes
 	vehicles := api.Group("/vehicles")
 	vehicles.POST("", vehicleController.Create)
 	vehicles.GET("", vehicleController.GetAll)
+	vehicles.GET("/shared", vehicleController.GetShared)
+	vehicles.GET("/claims", vehicleController.GetClaims)
+	vehicles.POST("/claims", vehicleController.OpenClaim)
 	vehicles.GET("/:id", vehicleController.GetByID)
 	vehicles.GET("/user/:userId", vehicleController.GetUserVehicles)
 	vehicles.PUT("/:id", vehicleController.Update)
 	vehicles.DELETE("/:id", vehicleController.Delete)
+	vehicles.POST("/:id/verification", vehicleController.RequestVerification)
+	vehicles.POST("/:id/drivers", vehicleController.InviteDriver)
+	vehicles.POST("/:id/drivers/accept", vehicleController.AcceptDriverInvite)
+	vehicles.DELETE("/:id/drivers/:userId", vehicleController.RemoveDriver)
+
+	// Vehicle claim review
+	vehicleClaims := api.Group("/vehicle-claims")
+	vehicleClaims.GET("", vehicleClaimController.GetAll)
+	vehicleClaims.GET("/:id", vehicleClaimController.GetByID)
+	vehicleClaims.POST("/:id/approve", vehicleClaimController.Approve)
+	vehicleClaims.POST("/:id/reject", vehicleClaimController.Reject)
 
 	// Booking routes
 	bookings := api.Group("/bookings")
@@ -71,12 +137,73 @@ func RegisterRoutes(
 	wallet.GET("/balance", walletController.GetBalance)
 	wallet.GET("/transactions", walletController.GetTransactions)
 
+	// Organization routes, membership is checked in the handlers
+	organizations := api.Group("/organizations")
+	organizations.POST("", organizationController.Create)
+	organizations.GET("", organizationController.GetAll)
+	organizations.GET("/:id", organizationController.GetByID)
+	organizations.PUT("/:id", organizationController.Update)
+	organizations.GET("/:id/members", organizationController.GetMembers)
+	organizations.POST("/:id/members", organizationController.AddMember)
+	organizations.POST("/:id/members/accept", organizationController.AcceptInvitation)
+	organizations.PUT("/:id/members/:userId", organizationController.UpdateMember)
+	organizations.DELETE("/:id/members/:userId", organizationController.RemoveMember)
+	organizations.GET("/:id/vehicles", organizationController.GetVehicles)
+	organizations.POST("/:id/vehicles", organizationController.AssignVehicle)
+	organizations.POST("/:id/vehicles/:vehicleId/accept", organizationController.AcceptVehicle)
+	organizations.DELETE("/:id/vehicles/:vehicleId", organizationController.ReleaseVehicle)
+	organizations.GET("/:id/wallet", organizationController.GetWallet)
+	organizations.POST("/:id/wallet/topup", organizationController.TopUp)
+	organizations.GET("/:id/statements/:period", organizationController.GetStatement)
+
 	// Parking Location routes
 	locations := api.Group("/locations")
 	locations.POST("", parkingLocationController.CreateLocation)
 	locations.GET("", parkingLocationController.GetAllLocations)
+	locations.GET("/nearby", parkingLocationController.GetNearbyLocations)
 	locations.GET("/:id", parkingLocationController.GetLocation)
+	locations.GET("/:id/stream", streamController.StreamLocation)
+	locations.GET("/:id/display", displayController.GetLocationFeed)
 	locations.PUT("/:id", parkingLocationController.UpdateLocation)
 	locations.PUT("/:id/slot", parkingLocationController.UpdateSlotStatus)
+	locations.GET("/:id/readings", parkingLocationController.GetSensorReadings)
+	locations.GET("/:id/mismatches", reconciliationController.GetMismatches)
 	locations.DELETE("/:id", parkingLocationController.DeleteLocation)
+
+	// Guest session routes
+	guest := api.Group("/guest")
+	guest.GET("", guestController.GetAll)
+	guest.POST("/:code/pay", guestController.Pay)
+	guest.POST("/:code/claim", guestController.Claim)
+	guest.POST("/:code/settle", guestController.SettleCash)
+
+	// Notification routes
+	notifications := api.Group("/notifications")
+	notifications.GET("", notificationController.GetNotifications)
+	notifications.GET("/preferences", notificationController.GetPreferences)
+	notifications.PUT("/preferences", notificationController.UpdatePreferences)
+
+	// Device registry routes
+	devices := api.Group("/devices")
+	devices.POST("", deviceController.Provision)
+	devices.GET("", deviceController.GetAll)
+	devices.GET("/health", deviceController.GetHealth)
+	devices.GET("/telemetry", telemetryController.GetTelemetry)
+	devices.GET("/alerts", telemetryController.GetAlerts)
+	devices.GET("/:id", deviceController.GetByID)
+	devices.GET("/:id/config", deviceController.GetConfig)
+	devices.PUT("/:id/config", deviceController.UpdateConfig)
+	devices.POST("/:id/rotate", deviceController.Rotate)
+	devices.POST("/:id/revoke", deviceController.Revoke)
+
+	// Webhook routes
+	webhooks := api.Group("/webhooks")
+	webhooks.GET("/event-types", webhookController.GetEventTypes)
+	webhooks.POST("", webhookController.Create)
+	webhooks.GET("", webhookController.GetAll)
+	webhooks.GET("/:id", webhookController.GetByID)
+	webhooks.PUT("/:id", webhookController.Update)
+	webhooks.DELETE("/:id", webhookController.Delete)
+	webhooks.GET("/:id/deliveries", webhookController.GetDeliveries)
+	webhooks.POST("/deliveries/:deliveryId/replay", webhookController.ReplayDelivery)
 }
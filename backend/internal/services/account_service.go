@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"github.com/dfanso/parkme-backend/pkg/mailer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	ErrInvalidAccountToken  = errors.New("link is invalid or has expired")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+)
+
+// AccountService sends and redeems the emailed links that verify an email
+// address and reset a forgotten password. Issuing a link voids the earlier
+// links of the same kind
+type AccountService struct {
+	cfg         *config.Config
+	repo        *repositories.AccountTokenRepository
+	userService *UserService
+	sessions    *AuthSessionService
+	throttle    *LoginThrottleService
+	mailer      mailer.Mailer
+}
+
+func NewAccountService(
+	cfg *config.Config,
+	repo *repositories.AccountTokenRepository,
+	userService *UserService,
+	sessions *AuthSessionService,
+	throttle *LoginThrottleService,
+	mailer mailer.Mailer,
+) *AccountService {
+	return &AccountService{
+		cfg:         cfg,
+		repo:        repo,
+		userService: userService,
+		sessions:    sessions,
+		throttle:    throttle,
+		mailer:      mailer,
+	}
+}
+
+// issue stores a new token for the user and returns the app link carrying it
+func (s *AccountService) issue(ctx context.Context, user *models.User, purpose models.AccountTokenPurpose, ttl time.Duration, path string) (string, error) {
+	now := time.Now()
+	if err := s.repo.UseAll(ctx, user.ID, purpose, now); err != nil {
+		return "", err
+	}
+
+	secret, err := generateTokenSecret()
+	if err != nil {
+		return "", err
+	}
+	token := &models.AccountToken{
+		UserID:     user.ID,
+		Purpose:    purpose,
+		Email:      user.Email,
+		SecretHash: hashTokenSecret(secret),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if err := s.repo.Create(ctx, token); err != nil {
+		return "", err
+	}
+
+	value := token.ID.Hex() + "." + secret
+	return strings.TrimRight(s.cfg.Account.AppURL, "/") + path + "?token=" + url.QueryEscape(value), nil
+}
+
+// redeem uses up a token and returns its user. The token is refused if the
+// user's email has changed since it was sent
+func (s *AccountService) redeem(ctx context.Context, value string, purpose models.AccountTokenPurpose) (*models.User, error) {
+	id, secret, ok := splitSecretToken(value)
+	if !ok {
+		return nil, ErrInvalidAccountToken
+	}
+	token, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	if token.Purpose != purpose || !secretMatches(secret, token.SecretHash) {
+		return nil, ErrInvalidAccountToken
+	}
+	if err := s.repo.MarkUsed(ctx, token.ID, time.Now()); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+
+	user, err := s.userService.GetByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidAccountToken
+		}
+		return nil, err
+	}
+	if !strings.EqualFold(user.Email, token.Email) {
+		return nil, ErrInvalidAccountToken
+	}
+	return user, nil
+}
+
+// SendVerification emails a link that verifies the user's address
+func (s *AccountService) SendVerification(ctx context.Context, user *models.User) error {
+	if user.Status != models.UserStatusPendingVerification {
+		return ErrEmailAlreadyVerified
+	}
+	link, err := s.issue(ctx, user, models.AccountTokenVerifyEmail, s.cfg.Account.VerificationTTL, "/verify-email")
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mailer.Mail{
+		To:      user.Email,
+		Subject: "Verify your ParkMe email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to verify your email address and start parking:\n\n%s\n\nThe link expires in %s.\n",
+			user.Name, link, s.cfg.Account.VerificationTTL),
+	})
+}
+
+// VerifyEmail redeems a verification link and activates the account
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+	user, err := s.redeem(ctx, token, models.AccountTokenVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.markVerified(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *AccountService) markVerified(ctx context.Context, user *models.User) error {
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	if user.Status == models.UserStatusPendingVerification {
+		user.Status = models.UserStatusActive
+	}
+	return s.userService.Update(ctx, user)
+}
+
+// RequestPasswordReset emails a reset link if the address belongs to an
+// account that may sign in. Callers are not told whether it does
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userService.FindOne(ctx, bson.M{"email": strings.TrimSpace(email)})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if CheckUserCanSignIn(user) != nil {
+		log.Printf("account: password reset requested for inactive user %s", user.ID.Hex())
+		return nil
+	}
+
+	link, err := s.issue(ctx, user, models.AccountTokenPasswordReset, s.cfg.Account.PasswordResetTTL, "/reset-password")
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mailer.Mail{
+		To:      user.Email,
+		Subject: "Reset your ParkMe password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to choose a new password:\n\n%s\n\nThe link expires in %s. If you did not ask for a new password you can ignore this email.\n",
+			user.Name, link, s.cfg.Account.PasswordResetTTL),
+	})
+}
+
+// ResetPassword redeems a reset link, sets the new password and signs the
+// user out everywhere. The link also proves the address, so a pending
+// account is verified and a login lockout is lifted
+func (s *AccountService) ResetPassword(ctx context.Context, token, password string) error {
+	if err := models.ValidatePassword(password); err != nil {
+		return err
+	}
+	user, err := s.redeem(ctx, token, models.AccountTokenPasswordReset)
+	if err != nil {
+		return err
+	}
+
+	user.Password = password
+	if err := user.HashPassword(); err != nil {
+		return err
+	}
+	if err := s.markVerified(ctx, user); err != nil {
+		return err
+	}
+	if _, err := s.sessions.RevokeAll(ctx, user.ID, models.SessionRevokedPasswordReset); err != nil {
+		return err
+	}
+	if _, err := s.throttle.Unlock(ctx, user, nil, "password reset"); err != nil {
+		return err
+	}
+	return s.repo.UseAll(ctx, user.ID, models.AccountTokenPasswordReset, time.Now())
+}
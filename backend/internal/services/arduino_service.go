@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -19,6 +18,7 @@ import (
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/repositories"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var (
@@ -42,19 +42,24 @@ type AlertData struct {
 	Details   map[string]interface{} `json:"details"`
 }
 
-type ModuleStatus struct {
-	ModuleID     string    `json:"moduleId"`
-	Status       string    `json:"status"`
-	LastSeen     time.Time `json:"lastSeen"`
-	BatteryLevel int       `json:"batteryLevel"`
-	Error        string    `json:"error,omitempty"`
-}
+const (
+	ModuleStatusOnline  = "online"
+	ModuleStatusOffline = "offline"
+	ModuleStatusRevoked = "revoked"
+)
 
-type ModuleConfig struct {
-	SampleRate    int     `json:"sampleRate"`
-	Threshold     float64 `json:"threshold"`
-	AlertEnabled  bool    `json:"alertEnabled"`
-	LedBrightness int     `json:"ledBrightness"`
+// ModuleStatus summarizes a device's health for the admin dashboard
+type ModuleStatus struct {
+	ModuleID        string             `json:"moduleId"`
+	Name            string             `json:"name,omitempty"`
+	Type            models.DeviceType  `json:"type"`
+	LocationID      primitive.ObjectID `json:"locationId"`
+	Status          string             `json:"status"`
+	LastSeen        *time.Time         `json:"lastSeen,omitempty"`
+	OfflineSince    *time.Time         `json:"offlineSince,omitempty"`
+	BatteryLevel    *int               `json:"batteryLevel,omitempty"`
+	FirmwareVersion string             `json:"firmwareVersion,omitempty"`
+	Error           string             `json:"error,omitempty"`
 }
 
 type NumberPlateResult struct {
@@ -84,7 +89,7 @@ func NewArduinoService(cfg *localconfig.Config, vehicleService *VehicleService)
 	}, nil
 }
 
-func (s *ArduinoService) ExtractNumberPlate(data multipart.File) (*NumberPlateResult, error) {
+func (s *ArduinoService) ExtractNumberPlate(data io.Reader) (*NumberPlateResult, error) {
 	ctx := context.Background()
 
 	// Use Rekognition service
@@ -97,11 +102,16 @@ func (s *ArduinoService) ExtractNumberPlate(data multipart.File) (*NumberPlateRe
 	text := cleanNumberPlateText(result.Text)
 	isValid := isValidNumberPlate(text)
 
-	// Find the vehicle by plate number
+	// Find the vehicle by plate number. The plate is returned with
+	// ErrVehicleNotFound so unregistered vehicles can enter as guests
 	vehicle, err := s.vehicleService.FindOne(ctx, bson.M{"plate_number": text})
 	if err != nil {
 		if err == repositories.ErrNotFound {
-			return nil, ErrVehicleNotFound
+			return &NumberPlateResult{
+				Text:       text,
+				IsValid:    isValid,
+				Confidence: result.Confidence,
+			}, ErrVehicleNotFound
 		}
 		return nil, err
 	}
@@ -115,7 +125,7 @@ func (s *ArduinoService) ExtractNumberPlate(data multipart.File) (*NumberPlateRe
 	}, nil
 }
 
-func (s *ArduinoService) SaveImageToFolder(data multipart.File, filename string) (string, error) {
+func (s *ArduinoService) SaveImageToFolder(data io.Reader, filename string) (string, error) {
 	// Define the folder to save images
 	saveDir := "./uploads"
 
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditQuery filters audit entries. Zero values are ignored
+type AuditQuery struct {
+	Action models.AuditAction
+	UserID *primitive.ObjectID
+	From   *time.Time
+	To     *time.Time
+	Limit  int64
+}
+
+type AuditService struct {
+	repo *repositories.AuditLogRepository
+}
+
+func NewAuditService(repo *repositories.AuditLogRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// Record stores an audit entry, stamping it with the current time
+func (s *AuditService) Record(ctx context.Context, entry *models.AuditEntry) error {
+	entry.CreatedAt = time.Now()
+	return s.repo.Create(ctx, entry)
+}
+
+// GetEntries returns the matching audit entries, newest first
+func (s *AuditService) GetEntries(ctx context.Context, query AuditQuery) ([]models.AuditEntry, error) {
+	filter := bson.M{}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	if query.UserID != nil {
+		filter["user_id"] = *query.UserID
+	}
+	if r := timeRange(query.From, query.To); len(r) > 0 {
+		filter["created_at"] = r
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	return s.repo.Find(ctx, filter, limit)
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"github.com/dfanso/parkme-backend/pkg/auth"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrAccountInactive     = errors.New("account is not active")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionExpired      = errors.New("session has expired or was signed out")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, the session has been signed out")
+)
+
+// AuthSessionService signs users in and out. Each sign in is a session with a
+// refresh token that is replaced on every use
+type AuthSessionService struct {
+	cfg         *config.Config
+	repo        *repositories.AuthSessionRepository
+	userService *UserService
+	jwtManager  *auth.JWTManager
+}
+
+func NewAuthSessionService(
+	cfg *config.Config,
+	repo *repositories.AuthSessionRepository,
+	userService *UserService,
+	jwtManager *auth.JWTManager,
+) *AuthSessionService {
+	return &AuthSessionService{
+		cfg:         cfg,
+		repo:        repo,
+		userService: userService,
+		jwtManager:  jwtManager,
+	}
+}
+
+// Refresh tokens and emailed account tokens are "<record id>.<secret>" so the
+// record is found by ID and only the secret's hash has to be compared
+func generateTokenSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func splitSecretToken(token string) (primitive.ObjectID, string, bool) {
+	id, secret, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok || secret == "" {
+		return primitive.NilObjectID, "", false
+	}
+	recordID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, "", false
+	}
+	return recordID, secret, true
+}
+
+// secretMatches compares a token secret with a stored hash in constant time
+func secretMatches(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashTokenSecret(secret)), []byte(hash)) == 1
+}
+
+// CheckUserCanSignIn returns ErrAccountInactive for inactive and banned
+// users. Accounts pending email verification may sign in with limited access
+func CheckUserCanSignIn(user *models.User) error {
+	switch user.Status {
+	case models.UserStatusActive, models.UserStatusPendingVerification:
+		return nil
+	default:
+		return ErrAccountInactive
+	}
+}
+
+// SignIn starts a session for a user whose credentials have been checked.
+// mfa records that the user also passed a second factor
+func (s *AuthSessionService) SignIn(ctx context.Context, user *models.User, userAgent, ip string, mfa bool) (*dto.LoginResponse, error) {
+	if err := CheckUserCanSignIn(user); err != nil {
+		return nil, err
+	}
+
+	secret, err := generateTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &models.AuthSession{
+		UserID:      user.ID,
+		RefreshHash: hashTokenSecret(secret),
+		UserAgent:   userAgent,
+		IP:          ip,
+		MFA:         mfa,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(s.cfg.Auth.RefreshTokenTTL),
+	}
+	if err := s.repo.Create(ctx, session); err != nil {
+		return nil, err
+	}
+	return s.issue(user, session, secret)
+}
+
+func (s *AuthSessionService) issue(user *models.User, session *models.AuthSession, secret string) (*dto.LoginResponse, error) {
+	token, expiresAt, err := s.jwtManager.GenerateToken(user.ID, string(user.Role), session.ID, session.MFA)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	return &dto.LoginResponse{
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: session.ID.Hex() + "." + secret,
+		User:         user,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Presenting a refresh token that was already exchanged signs the
+// session out, since either the client or an attacker holds a stolen copy
+func (s *AuthSessionService) Refresh(ctx context.Context, refreshToken, userAgent, ip string) (*dto.LoginResponse, error) {
+	sessionID, secret, ok := splitSecretToken(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	session, err := s.repo.FindByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if !session.IsActive(now) {
+		return nil, ErrSessionExpired
+	}
+	if !secretMatches(secret, session.RefreshHash) {
+		if session.PreviousHash != "" && secretMatches(secret, session.PreviousHash) {
+			if _, err := s.repo.Revoke(ctx, bson.M{"_id": session.ID}, now, models.SessionRevokedTokenReuse); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := s.userService.GetByID(ctx, session.UserID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if inactive := CheckUserCanSignIn(user); inactive != nil {
+		if _, err := s.RevokeAll(ctx, user.ID, models.SessionRevokedUserInactive); err != nil {
+			return nil, err
+		}
+		return nil, inactive
+	}
+
+	next, err := generateTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+	previous := session.RefreshHash
+	session.RefreshHash = hashTokenSecret(next)
+	session.UserAgent = userAgent
+	session.IP = ip
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(s.cfg.Auth.RefreshTokenTTL)
+	if err := s.repo.Rotate(ctx, session, previous); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	return s.issue(user, session, next)
+}
+
+// Validate returns ErrSessionExpired unless the session behind an access
+// token is still signed in
+func (s *AuthSessionService) Validate(ctx context.Context, sessionID primitive.ObjectID) error {
+	session, err := s.repo.FindByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrSessionExpired
+		}
+		return err
+	}
+	if !session.IsActive(time.Now()) {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// MarkMFA records that the session passed a second factor. Access tokens
+// issued from its next refresh carry the mfa claim
+func (s *AuthSessionService) MarkMFA(ctx context.Context, sessionID primitive.ObjectID) error {
+	if err := s.repo.SetMFA(ctx, sessionID); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrSessionExpired
+		}
+		return err
+	}
+	return nil
+}
+
+// SignOut revokes one of the user's sessions
+func (s *AuthSessionService) SignOut(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	revoked, err := s.repo.Revoke(ctx, bson.M{"_id": sessionID, "user_id": userID}, time.Now(), models.SessionRevokedLogout)
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAll signs the user out everywhere and returns how many sessions ended
+func (s *AuthSessionService) RevokeAll(ctx context.Context, userID primitive.ObjectID, reason models.SessionRevokeReason) (int64, error) {
+	return s.repo.Revoke(ctx, bson.M{"user_id": userID}, time.Now(), reason)
+}
+
+// RevokeOthers signs the user out everywhere but the current session
+func (s *AuthSessionService) RevokeOthers(ctx context.Context, userID, currentID primitive.ObjectID, reason models.SessionRevokeReason) (int64, error) {
+	return s.repo.Revoke(ctx, bson.M{"user_id": userID, "_id": bson.M{"$ne": currentID}}, time.Now(), reason)
+}
+
+// GetSessions lists the user's signed in sessions, marking the current one
+func (s *AuthSessionService) GetSessions(ctx context.Context, userID, currentID primitive.ObjectID) ([]models.AuthSession, error) {
+	sessions, err := s.repo.FindActiveByUser(ctx, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/dfanso/parkme-backend/internal/events"
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/repositories"
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,45 +20,69 @@ var (
 	ErrBookingInPast            = errors.New("cannot book in the past")
 	ErrInsufficientWalletPoints = errors.New("insufficient wallet balance, minimum 300 points required")
 	ErrNoAvailableSlots         = errors.New("no available parking slots at this location")
+	ErrVehicleNotDrivable       = errors.New("you are not an authorized driver of this vehicle")
+	ErrSpotIncompatible         = errors.New("parking spot does not fit this vehicle")
+	ErrBookingNotActive         = errors.New("only active bookings can be completed")
 )
 
 type BookingService struct {
-	repo     *repositories.BookingRepository
-	vehicle  *VehicleService
-	wallet   *WalletService
-	location *ParkingLocationService
-	user     *UserService
+	repo          *repositories.BookingRepository
+	vehicle       *VehicleService
+	organizations *OrganizationService
+	location      *ParkingLocationService
+	user          *UserService
+	bus           *events.Bus
 }
 
-func NewBookingService(repo *repositories.BookingRepository, vehicleService *VehicleService, walletService *WalletService, locationService *ParkingLocationService, userService *UserService) *BookingService {
+func NewBookingService(repo *repositories.BookingRepository, vehicleService *VehicleService, organizationService *OrganizationService, locationService *ParkingLocationService, userService *UserService, bus *events.Bus) *BookingService {
 	return &BookingService{
-		repo:     repo,
-		vehicle:  vehicleService,
-		wallet:   walletService,
-		location: locationService,
-		user:     userService,
+		repo:          repo,
+		vehicle:       vehicleService,
+		organizations: organizationService,
+		location:      locationService,
+		user:          userService,
+		bus:           bus,
 	}
 }
 
-// findAvailableSlot finds an available parking slot at the given location
-func (s *BookingService) findAvailableSlot(ctx context.Context, locationID primitive.ObjectID) (string, error) {
+// publish announces a booking lifecycle change on the event bus
+func (s *BookingService) publish(eventType events.Type, booking *models.Booking) {
+	s.bus.Publish(events.New(eventType, booking.LocationID, events.NewBookingEvent(booking)))
+}
+
+// findAvailableSlot finds the free slot at the given location that suits the
+// vehicle best. A nil vehicle is an unregistered one and is placed like a car
+func (s *BookingService) findAvailableSlot(ctx context.Context, locationID primitive.ObjectID, vehicle *models.Vehicle) (string, error) {
 	location, err := s.location.GetLocation(ctx, locationID)
 	if err != nil {
 		return "", err
 	}
-
-	for _, slot := range location.Slots {
-		if !slot.IsOccupied {
-			return slot.Number, nil
-		}
+	if vehicle == nil {
+		vehicle = &models.Vehicle{}
 	}
 
+	if i := location.FindSlotFor(vehicle); i >= 0 {
+		return location.Slots[i].Number, nil
+	}
+	if location.FreeSlots() > 0 {
+		return "", fmt.Errorf("%w: none of the free slots fit a %s %s", ErrNoAvailableSlots, vehicle.EffectiveSize(), vehicle.EffectiveCategory())
+	}
 	return "", ErrNoAvailableSlots
 }
 
 func (s *BookingService) CreateBooking(ctx context.Context, booking *models.Booking) error {
-	// Check wallet balance first
-	balance, err := s.wallet.GetBalance(ctx, booking.UserID)
+	// The vehicle's owner and the drivers they authorized may book with it
+	vehicle, err := s.vehicle.GetByID(ctx, booking.VehicleID)
+	if err != nil {
+		return err
+	}
+	if !vehicle.CanBeDrivenBy(booking.UserID) {
+		return ErrVehicleNotDrivable
+	}
+
+	// Check the balance of the wallet that will pay first, fleet vehicles may
+	// be paid for by their organization
+	balance, err := s.organizations.AvailableBalance(ctx, booking.VehicleID, booking.UserID)
 	if err != nil {
 		return err
 	}
@@ -104,17 +129,13 @@ func (s *BookingService) CreateBooking(ctx context.Context, booking *models.Book
 			return fmt.Errorf("failed to get location: %w", err)
 		}
 
-		spotExists := false
-		for _, slot := range location.Slots {
-			if slot.Number == *booking.SpotNumber {
-				spotExists = true
-				break
-			}
-		}
-
-		if !spotExists {
+		slotIndex := location.FindSlot(*booking.SpotNumber)
+		if slotIndex == -1 {
 			return fmt.Errorf("spot number %s does not exist in this location", *booking.SpotNumber)
 		}
+		if !location.Slots[slotIndex].Fits(vehicle) {
+			return ErrSpotIncompatible
+		}
 
 		// If end time is provided, check availability for the time range
 		if booking.EndTime != nil {
@@ -129,7 +150,7 @@ func (s *BookingService) CreateBooking(ctx context.Context, booking *models.Book
 		}
 	} else {
 		// For on-site booking, find an available slot
-		spotNumber, err := s.findAvailableSlot(ctx, booking.LocationID)
+		spotNumber, err := s.findAvailableSlot(ctx, booking.LocationID, vehicle)
 		if err != nil {
 			return err
 		}
@@ -148,18 +169,20 @@ func (s *BookingService) CreateBooking(ctx context.Context, booking *models.Book
 		}
 	}
 
-	// Verify vehicle exists
-	_, err = s.vehicle.GetByID(ctx, booking.VehicleID)
-	if err != nil {
-		return err
-	}
-
-	// Set timestamps and status
+	// Set timestamps and status. Bookings created at the entry gate are
+	// already active because the vehicle is inside
 	booking.CreatedAt = time.Now()
 	booking.UpdatedAt = time.Now()
-	booking.Status = models.BookingStatusPending
+	if booking.Status != models.BookingStatusActive {
+		booking.Status = models.BookingStatusPending
+	}
 
-	return s.repo.Create(ctx, booking)
+	if err := s.repo.Create(ctx, booking); err != nil {
+		return err
+	}
+
+	s.publish(events.TypeBookingCreated, booking)
+	return nil
 }
 
 func (s *BookingService) GetBooking(ctx context.Context, id primitive.ObjectID) (*models.Booking, error) {
@@ -198,7 +221,13 @@ func (s *BookingService) CancelBooking(ctx context.Context, id primitive.ObjectI
 	if booking.Status != models.BookingStatusPending {
 		return errors.New("booking is not pending")
 	}
-	return s.UpdateBookingStatus(ctx, id, models.BookingStatusCancelled)
+	if err := s.UpdateBookingStatus(ctx, id, models.BookingStatusCancelled); err != nil {
+		return err
+	}
+
+	booking.Status = models.BookingStatusCancelled
+	s.publish(events.TypeBookingCancelled, booking)
+	return nil
 }
 
 func (s *BookingService) isSlotAvailable(ctx context.Context, spotNumber string, start, end time.Time) bool {
@@ -251,23 +280,57 @@ func (s *BookingService) FindBookingByFilter(ctx context.Context, filter bson.M)
 	return booking, nil
 }
 
-// Add method to complete on-site booking
-func (s *BookingService) CompleteOnSiteBooking(ctx context.Context, bookingID primitive.ObjectID, endTime time.Time, totalAmount float64) error {
-	booking, err := s.repo.FindByID(ctx, bookingID)
-	if err != nil {
+// RecordBooking stores a booking whose slot and status were already settled,
+// such as a guest session claimed by the vehicle's owner
+func (s *BookingService) RecordBooking(ctx context.Context, booking *models.Booking) error {
+	if err := s.repo.Create(ctx, booking); err != nil {
 		return err
 	}
+	s.publish(events.TypeBookingCreated, booking)
+	return nil
+}
 
-	if booking.BookingType != models.BookingTypeOnSite {
-		return errors.New("only on-site bookings can be completed this way")
+// ReassignSpot moves a booking to the slot its vehicle is actually parked in
+func (s *BookingService) ReassignSpot(ctx context.Context, booking *models.Booking, spotNumber string) error {
+	booking.SpotNumber = &spotNumber
+	booking.UpdatedAt = time.Now()
+	return s.repo.Update(ctx, booking)
+}
+
+// CompleteBooking closes an active booking with its payment once the vehicle
+// has left. The booking is moved out of active with a conditional update
+// before pay runs, so concurrent exits cannot charge the same stay twice. If
+// pay fails the booking is reopened
+func (s *BookingService) CompleteBooking(ctx context.Context, bookingID primitive.ObjectID, endTime time.Time, totalAmount float64, pay func() error) error {
+	now := time.Now()
+	booking, err := s.repo.UpdateWhere(ctx, bookingID, bson.M{"status": models.BookingStatusActive}, bson.M{
+		"$set": bson.M{
+			"status":       models.BookingStatusCompleted,
+			"end_time":     endTime,
+			"total_amount": totalAmount,
+			"updated_at":   now,
+		},
+	})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrBookingNotActive
+		}
+		return err
 	}
 
-	booking.EndTime = &endTime
-	booking.TotalAmount = &totalAmount
-	booking.Status = models.BookingStatusCompleted
-	booking.UpdatedAt = time.Now()
+	if err := pay(); err != nil {
+		_, reopen := s.repo.UpdateWhere(ctx, bookingID, bson.M{"status": models.BookingStatusCompleted}, bson.M{
+			"$set":   bson.M{"status": models.BookingStatusActive, "updated_at": time.Now()},
+			"$unset": bson.M{"end_time": "", "total_amount": ""},
+		})
+		if reopen != nil {
+			return fmt.Errorf("%w (reopening booking failed: %v)", err, reopen)
+		}
+		return err
+	}
 
-	return s.repo.Update(ctx, booking)
+	s.publish(events.TypeBookingCompleted, booking)
+	return nil
 }
 
 func (s *BookingService) GetUserBookings(ctx context.Context, userID primitive.ObjectID) ([]models.Booking, error) {
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrDeviceNotFound      = errors.New("device not found")
+	ErrInvalidDevice       = errors.New("invalid device")
+	ErrDeviceIDTaken       = errors.New("device ID is already in use")
+	ErrDeviceUnauthorized  = errors.New("invalid device credentials")
+	ErrDeviceRevoked       = errors.New("device has been revoked")
+	ErrInvalidDeviceConfig = errors.New("invalid device config")
+	ErrConfigConflict      = errors.New("device config was changed by someone else")
+	ErrInvalidConfigAck    = errors.New("acknowledged config version does not exist")
+)
+
+// lastSeenInterval limits how often authentication writes last_seen_at,
+// sensors call in every few seconds
+const lastSeenInterval = 15 * time.Second
+
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,64}$`)
+
+// Heartbeat is the health a device reports when it checks in
+type Heartbeat struct {
+	BatteryLevel    *int   `json:"battery_level"`
+	FirmwareVersion string `json:"firmware_version"`
+	Error           string `json:"error"`
+}
+
+type DeviceService struct {
+	repo          *repositories.DeviceRepository
+	configs       *repositories.DeviceConfigRepository
+	location      *ParkingLocationService
+	bus           *events.Bus
+	offlineAfter  time.Duration
+	checkInterval time.Duration
+}
+
+func NewDeviceService(cfg *localconfig.Config, repo *repositories.DeviceRepository, configs *repositories.DeviceConfigRepository, locationService *ParkingLocationService, bus *events.Bus) *DeviceService {
+	return &DeviceService{
+		repo:          repo,
+		configs:       configs,
+		location:      locationService,
+		bus:           bus,
+		offlineAfter:  cfg.Device.OfflineAfter,
+		checkInterval: cfg.Device.HealthCheckInterval,
+	}
+}
+
+// Start runs the health monitor that reports devices gone silent until ctx is cancelled
+func (s *DeviceService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.checkSilentDevices(ctx)
+			}
+		}
+	}()
+}
+
+// checkSilentDevices marks devices offline once they have not called in for
+// the offline threshold and raises a device.offline alert for each
+func (s *DeviceService) checkSilentDevices(ctx context.Context) {
+	now := time.Now()
+	devices, err := s.repo.FindSilent(ctx, now.Add(-s.offlineAfter))
+	if err != nil {
+		log.Printf("devices: failed to find silent devices: %v", err)
+		return
+	}
+
+	for i := range devices {
+		device := &devices[i]
+		marked, err := s.repo.MarkOffline(ctx, device.ID, now)
+		if err != nil {
+			log.Printf("devices: failed to mark %s offline: %v", device.DeviceID, err)
+			continue
+		}
+		if !marked {
+			continue
+		}
+		device.OfflineSince = &now
+		log.Printf("devices: %s %s at location %s is offline, last seen %v", device.Type, device.DeviceID, device.LocationID.Hex(), device.LastSeenAt)
+		s.bus.Publish(events.New(events.TypeDeviceOffline, device.LocationID, events.NewDeviceEvent(device)))
+	}
+}
+
+// markSeen records that the device called in and clears its offline flag
+func (s *DeviceService) markSeen(ctx context.Context, device *models.Device, now time.Time) error {
+	if device.LastSeenAt == nil || now.Sub(*device.LastSeenAt) >= lastSeenInterval || device.OfflineSince != nil {
+		if err := s.repo.TouchLastSeen(ctx, device.ID, now); err != nil {
+			return err
+		}
+		device.LastSeenAt = &now
+	}
+	return s.markOnline(ctx, device)
+}
+
+func (s *DeviceService) markOnline(ctx context.Context, device *models.Device) error {
+	if device.OfflineSince == nil {
+		return nil
+	}
+	cleared, err := s.repo.MarkOnline(ctx, device.ID)
+	if err != nil {
+		return err
+	}
+	device.OfflineSince = nil
+	if cleared {
+		s.bus.Publish(events.New(events.TypeDeviceOnline, device.LocationID, events.NewDeviceEvent(device)))
+	}
+	return nil
+}
+
+func generateDeviceSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "dvs_" + hex.EncodeToString(buf), nil
+}
+
+func hashDeviceSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Provision registers a device bound to an existing location and returns its
+// secret. The secret is only available here and after a rotation
+func (s *DeviceService) Provision(ctx context.Context, device *models.Device) (string, error) {
+	if !device.Type.IsValid() {
+		return "", fmt.Errorf("%w: unknown device type %q", ErrInvalidDevice, device.Type)
+	}
+	if device.DeviceID == "" {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		device.DeviceID = string(device.Type) + "-" + hex.EncodeToString(suffix)
+	}
+	if !deviceIDPattern.MatchString(device.DeviceID) {
+		return "", fmt.Errorf("%w: device ID must be 3-64 letters, digits, '.', '_' or '-'", ErrInvalidDevice)
+	}
+	if _, err := s.location.GetLocation(ctx, device.LocationID); err != nil {
+		return "", err
+	}
+
+	secret, err := generateDeviceSecret()
+	if err != nil {
+		return "", err
+	}
+
+	device.SecretHash = hashDeviceSecret(secret)
+	device.Status = models.DeviceStatusActive
+	device.CreatedAt = time.Now()
+	device.UpdatedAt = time.Now()
+	if err := s.repo.Create(ctx, device); err != nil {
+		if errors.Is(err, repositories.ErrDuplicate) {
+			return "", ErrDeviceIDTaken
+		}
+		return "", err
+	}
+	return secret, nil
+}
+
+// Authenticate checks a device's credentials and returns the device if it is
+// active. Unknown devices and wrong secrets are reported the same way
+func (s *DeviceService) Authenticate(ctx context.Context, deviceID, secret string) (*models.Device, error) {
+	device, err := s.repo.FindByDeviceID(ctx, deviceID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrDeviceUnauthorized
+		}
+		return nil, err
+	}
+
+	given := hashDeviceSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(given), []byte(device.SecretHash)) != 1 {
+		return nil, ErrDeviceUnauthorized
+	}
+	if device.Status != models.DeviceStatusActive {
+		return nil, ErrDeviceRevoked
+	}
+
+	if err := s.markSeen(ctx, device, time.Now()); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// RecordHeartbeat stores the health an authenticated device reports
+func (s *DeviceService) RecordHeartbeat(ctx context.Context, device *models.Device, heartbeat Heartbeat) error {
+	if heartbeat.BatteryLevel != nil && (*heartbeat.BatteryLevel < 0 || *heartbeat.BatteryLevel > 100) {
+		return fmt.Errorf("%w: battery level must be between 0 and 100", ErrInvalidSensorData)
+	}
+
+	now := time.Now()
+	device.LastSeenAt = &now
+	device.BatteryLevel = heartbeat.BatteryLevel
+	device.FirmwareVersion = heartbeat.FirmwareVersion
+	device.LastError = heartbeat.Error
+	if err := s.repo.RecordHeartbeat(ctx, device); err != nil {
+		return err
+	}
+	return s.markOnline(ctx, device)
+}
+
+// GetHealth returns the health of every device, optionally only for one location
+func (s *DeviceService) GetHealth(ctx context.Context, locationID *primitive.ObjectID) ([]ModuleStatus, error) {
+	devices, err := s.GetDevices(ctx, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	statuses := make([]ModuleStatus, 0, len(devices))
+	for i := range devices {
+		statuses = append(statuses, s.moduleStatus(&devices[i], now))
+	}
+	return statuses, nil
+}
+
+func (s *DeviceService) moduleStatus(device *models.Device, now time.Time) ModuleStatus {
+	status := ModuleStatusOnline
+	switch {
+	case device.Status == models.DeviceStatusRevoked:
+		status = ModuleStatusRevoked
+	case device.OfflineSince != nil, device.LastSeenAt == nil, now.Sub(*device.LastSeenAt) > s.offlineAfter:
+		status = ModuleStatusOffline
+	}
+
+	return ModuleStatus{
+		ModuleID:        device.DeviceID,
+		Name:            device.Name,
+		Type:            device.Type,
+		LocationID:      device.LocationID,
+		Status:          status,
+		LastSeen:        device.LastSeenAt,
+		OfflineSince:    device.OfflineSince,
+		BatteryLevel:    device.BatteryLevel,
+		FirmwareVersion: device.FirmwareVersion,
+		Error:           device.LastError,
+	}
+}
+
+func (s *DeviceService) GetDevice(ctx context.Context, id primitive.ObjectID) (*models.Device, error) {
+	device, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, err
+	}
+	return device, nil
+}
+
+// GetDevices lists devices, optionally only those bound to one location
+func (s *DeviceService) GetDevices(ctx context.Context, locationID *primitive.ObjectID) ([]models.Device, error) {
+	filter := bson.M{}
+	if locationID != nil {
+		filter["location_id"] = *locationID
+	}
+	return s.repo.Find(ctx, filter)
+}
+
+// RotateSecret replaces an active device's secret. The old secret stops
+// working immediately
+func (s *DeviceService) RotateSecret(ctx context.Context, id primitive.ObjectID) (*models.Device, string, error) {
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if device.Status != models.DeviceStatusActive {
+		return nil, "", ErrDeviceRevoked
+	}
+
+	secret, err := generateDeviceSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	device.SecretHash = hashDeviceSecret(secret)
+	device.RotatedAt = &now
+	device.UpdatedAt = now
+	if err := s.repo.Update(ctx, device); err != nil {
+		return nil, "", err
+	}
+	return device, secret, nil
+}
+
+// Revoke permanently disables a device
+func (s *DeviceService) Revoke(ctx context.Context, id primitive.ObjectID) (*models.Device, error) {
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if device.Status == models.DeviceStatusRevoked {
+		return device, nil
+	}
+
+	now := time.Now()
+	device.Status = models.DeviceStatusRevoked
+	device.RevokedAt = &now
+	device.UpdatedAt = now
+	if err := s.repo.Update(ctx, device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// GetConfig returns the device's config. Devices without a stored config get
+// the defaults as version 0
+func (s *DeviceService) GetConfig(ctx context.Context, device *models.Device) (*models.DeviceConfig, error) {
+	config, err := s.configs.FindByDevice(ctx, device.ID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return &models.DeviceConfig{
+				DeviceID: device.ID,
+				Config:   models.DefaultModuleConfig,
+			}, nil
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+// GetConfigByID returns the config of the device with the given ID
+func (s *DeviceService) GetConfigByID(ctx context.Context, id primitive.ObjectID) (*models.DeviceConfig, error) {
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetConfig(ctx, device)
+}
+
+// UpdateConfig stores new settings for a device as the next config version.
+// When expectedVersion is set the update fails with ErrConfigConflict unless
+// it matches the current version
+func (s *DeviceService) UpdateConfig(ctx context.Context, id primitive.ObjectID, settings models.ModuleConfig, expectedVersion *int, updatedBy primitive.ObjectID) (*models.DeviceConfig, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDeviceConfig, err)
+	}
+
+	config, err := s.GetConfigByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != nil && *expectedVersion != config.Version {
+		return nil, ErrConfigConflict
+	}
+
+	previousVersion := config.Version
+	config.Version++
+	config.Config = settings
+	config.UpdatedBy = updatedBy
+	config.UpdatedAt = time.Now()
+	if err := s.configs.Save(ctx, config, previousVersion); err != nil {
+		if errors.Is(err, repositories.ErrDuplicate) {
+			return nil, ErrConfigConflict
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+// AcknowledgeConfig records that the device applied the given config version
+func (s *DeviceService) AcknowledgeConfig(ctx context.Context, device *models.Device, version int) (*models.DeviceConfig, error) {
+	config, err := s.GetConfig(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || version > config.Version {
+		return nil, ErrInvalidConfigAck
+	}
+
+	now := time.Now()
+	if err := s.configs.Acknowledge(ctx, device.ID, version, now); err != nil {
+		return nil, err
+	}
+	config.AppliedVersion = version
+	config.AppliedAt = &now
+	return config, nil
+}
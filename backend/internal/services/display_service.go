@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrZoneNotFound = errors.New("zone not found")
+
+// Display messages shown next to the free count
+const (
+	DisplayMessageClosed = "CLOSED"
+	DisplayMessageFull   = "FULL"
+	DisplayMessageEVOnly = "EV only"
+)
+
+// DisplayService computes the availability shown on entrance displays from
+// stored slot states and reservations rather than any single controller
+type DisplayService struct {
+	bookingRepo *repositories.BookingRepository
+	location    *ParkingLocationService
+	hold        time.Duration
+}
+
+func NewDisplayService(cfg *localconfig.Config, bookingRepo *repositories.BookingRepository, locationService *ParkingLocationService) *DisplayService {
+	return &DisplayService{
+		bookingRepo: bookingRepo,
+		location:    locationService,
+		hold:        cfg.Display.ReservationHold,
+	}
+}
+
+// GetFeed returns the availability of a location, or of one of its zones
+// when zone is set. Free slots reserved for a pre-booking that has started or
+// starts within the hold window are not counted as free
+func (s *DisplayService) GetFeed(ctx context.Context, locationID primitive.ObjectID, zone string) (*dto.DisplayFeed, error) {
+	location, err := s.location.GetLocation(ctx, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := location.Slots
+	if zone != "" {
+		slots = nil
+		for _, slot := range location.Slots {
+			if slot.Zone == zone {
+				slots = append(slots, slot)
+			}
+		}
+		if len(slots) == 0 {
+			return nil, ErrZoneNotFound
+		}
+	}
+
+	now := time.Now()
+	held, err := s.heldSlots(ctx, location, now)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &dto.DisplayFeed{
+		Zone:  zone,
+		Total: len(slots),
+		Types: make(map[string]int),
+	}
+	for _, slot := range slots {
+		if slot.IsOccupied {
+			continue
+		}
+		if held[slot.Number] {
+			feed.Held++
+			continue
+		}
+		feed.Free++
+		feed.Types[slot.SlotType()]++
+	}
+
+	switch open, _ := location.IsOpenAt(now); {
+	case !open:
+		feed.Message = DisplayMessageClosed
+	case feed.Free == 0:
+		feed.Message = DisplayMessageFull
+	case feed.Types[models.SlotTypeElectric] == feed.Free:
+		feed.Message = DisplayMessageEVOnly
+	}
+	return feed, nil
+}
+
+// heldSlots returns the slots reserved for pre-bookings whose vehicle has not
+// entered yet and that started or start within the hold window
+func (s *DisplayService) heldSlots(ctx context.Context, location *models.ParkingLocation, now time.Time) (map[string]bool, error) {
+	reservations, err := s.bookingRepo.Find(ctx, bson.M{
+		"location_id":  location.ID,
+		"status":       models.BookingStatusPending,
+		"booking_type": models.BookingTypePreBooked,
+		"spot_number":  bson.M{"$ne": nil},
+		"start_time":   bson.M{"$lte": now.Add(s.hold)},
+		"$or": []bson.M{
+			{"end_time": nil},
+			{"end_time": bson.M{"$gt": now}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	held := make(map[string]bool, len(reservations))
+	for _, booking := range reservations {
+		held[*booking.SpotNumber] = true
+	}
+	return held, nil
+}
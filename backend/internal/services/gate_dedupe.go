@@ -0,0 +1,93 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// gateDedupe remembers each gate's recent decision per plate so a trigger
+// firing repeatedly while a car waits at the barrier gets the same answer
+// instead of a new booking check. Concurrent triggers for the same plate wait
+// for the first one to finish
+type gateDedupe struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*dedupeEntry
+}
+
+type dedupeEntry struct {
+	done     chan struct{}
+	decision *GateDecision
+	err      error
+	at       time.Time
+}
+
+func newGateDedupe(window time.Duration) *gateDedupe {
+	return &gateDedupe{
+		window:  window,
+		entries: make(map[string]*dedupeEntry),
+	}
+}
+
+// isGateRefusal reports whether err is a decision about the vehicle rather
+// than a failure worth retrying
+func isGateRefusal(err error) bool {
+	for _, refusal := range []error{
+		ErrLocationClosed,
+		ErrVehicleNotFound,
+		ErrVehicleOwnerNotFound,
+		ErrVehicleInside,
+		ErrVehicleNotInside,
+		ErrInsufficientBalance,
+		ErrNoAvailableSlots,
+	} {
+		if errors.Is(err, refusal) {
+			return true
+		}
+	}
+	return false
+}
+
+// do runs process unless the gate decided for the same key within the
+// window, in which case the earlier decision is returned marked as a duplicate
+func (d *gateDedupe) do(key string, process func() (*GateDecision, error)) (*GateDecision, error) {
+	if d.window <= 0 {
+		return process()
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	for k, entry := range d.entries {
+		if !entry.at.IsZero() && now.Sub(entry.at) >= d.window {
+			delete(d.entries, k)
+		}
+	}
+	if entry, ok := d.entries[key]; ok {
+		d.mu.Unlock()
+		<-entry.done
+		if entry.decision == nil {
+			return nil, entry.err
+		}
+		duplicate := *entry.decision
+		duplicate.Duplicate = true
+		return &duplicate, entry.err
+	}
+	entry := &dedupeEntry{done: make(chan struct{})}
+	d.entries[key] = entry
+	d.mu.Unlock()
+
+	entry.decision, entry.err = process()
+
+	d.mu.Lock()
+	if entry.err != nil && !isGateRefusal(entry.err) {
+		// Let the next trigger retry after an unexpected failure
+		delete(d.entries, key)
+	} else {
+		entry.at = time.Now()
+	}
+	d.mu.Unlock()
+	close(entry.done)
+
+	return entry.decision, entry.err
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrGateImageRequired    = errors.New("gate image is required")
+	ErrVehicleInside        = errors.New("vehicle is already inside")
+	ErrVehicleNotInside     = errors.New("vehicle has not entered through a gate")
+	ErrVehicleOwnerNotFound = errors.New("vehicle owner not found")
+)
+
+type GateDirection string
+
+const (
+	GateDirectionEnter GateDirection = "enter"
+	GateDirectionExit  GateDirection = "exit"
+)
+
+// GateDecision tells a gate whether to open the barrier. It is returned over
+// HTTP and published back to the gate over MQTT
+type GateDecision struct {
+	Gate        GateDirection       `json:"gate"`
+	Open        bool                `json:"open"`
+	Reason      string              `json:"reason,omitempty"`
+	PlateNumber string              `json:"plate_number,omitempty"`
+	BookingID   *primitive.ObjectID `json:"booking_id,omitempty"`
+	SpotNumber  string              `json:"spot_number,omitempty"`
+	Amount      float64             `json:"amount,omitempty"`
+	Duplicate   bool                `json:"duplicate,omitempty"` // Repeated trigger answered from the dedupe window
+	Guest       bool                `json:"guest,omitempty"`     // Unregistered vehicle parked as a guest
+	PayCode     string              `json:"pay_code,omitempty"`  // Shown on the gate so a guest can pay
+	PayURL      string              `json:"pay_url,omitempty"`   // Encoded in the QR code shown on the exit gate
+}
+
+// DeniedGateDecision builds the decision sent to a gate whose request failed
+func DeniedGateDecision(gate GateDirection, err error) *GateDecision {
+	return &GateDecision{Gate: gate, Reason: err.Error()}
+}
+
+// GateService holds the entry and exit rules shared by the HTTP and MQTT
+// transports. Anti-passback requires a vehicle to be outside, without an
+// active booking, to enter and inside this location to exit
+type GateService struct {
+	arduino       *ArduinoService
+	booking       *BookingService
+	user          *UserService
+	location      *ParkingLocationService
+	organizations *OrganizationService
+	guest         *GuestService
+	bus           *events.Bus
+	dedupe        *gateDedupe
+}
+
+func NewGateService(
+	cfg *localconfig.Config,
+	arduinoService *ArduinoService,
+	bookingService *BookingService,
+	userService *UserService,
+	locationService *ParkingLocationService,
+	organizationService *OrganizationService,
+	guestService *GuestService,
+	bus *events.Bus,
+) *GateService {
+	return &GateService{
+		arduino:       arduinoService,
+		booking:       bookingService,
+		user:          userService,
+		location:      locationService,
+		organizations: organizationService,
+		guest:         guestService,
+		bus:           bus,
+		dedupe:        newGateDedupe(cfg.Gate.DedupeWindow),
+	}
+}
+
+// publishGateEvent announces a vehicle passing a gate
+func (s *GateService) publishGateEvent(eventType events.Type, booking *models.Booking, vehicle *models.Vehicle, amount float64) {
+	payload := events.GateEvent{
+		PlateNumber: vehicle.PlateNumber,
+		VehicleID:   vehicle.ID,
+		UserID:      booking.UserID,
+		BookingID:   booking.ID,
+		Amount:      amount,
+	}
+	if booking.SpotNumber != nil {
+		payload.SpotNumber = *booking.SpotNumber
+	}
+	s.bus.Publish(events.New(eventType, booking.LocationID, payload))
+}
+
+// recognize stores the gate image and looks up the vehicle on its plate
+func (s *GateService) recognize(image []byte, imageName string) (*NumberPlateResult, error) {
+	if len(image) == 0 {
+		return nil, ErrGateImageRequired
+	}
+	if _, err := s.arduino.SaveImageToFolder(bytes.NewReader(image), imageName); err != nil {
+		return nil, fmt.Errorf("failed to save gate image: %w", err)
+	}
+	return s.arduino.ExtractNumberPlate(bytes.NewReader(image))
+}
+
+func (s *GateService) owner(ctx context.Context, vehicle *models.Vehicle) (*models.User, error) {
+	owner, err := s.user.GetByID(ctx, vehicle.Owner)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrVehicleOwnerNotFound
+		}
+		return nil, err
+	}
+	return owner, nil
+}
+
+// parkingCharge prices a stay per started hour at the location's current rate
+func parkingCharge(location *models.ParkingLocation, start, end time.Time) float64 {
+	hours := math.Ceil(end.Sub(start).Hours())
+	return hours * location.CurrentRate()
+}
+
+func (s *GateService) guestDecision(gate GateDirection, session *models.GuestSession) *GateDecision {
+	decision := &GateDecision{
+		Gate:        gate,
+		Open:        true,
+		PlateNumber: session.PlateNumber,
+		SpotNumber:  session.SpotNumber,
+		Guest:       true,
+		PayCode:     session.PayCode,
+	}
+	if session.Amount != nil {
+		decision.Amount = *session.Amount
+	}
+	return decision
+}
+
+func gateDecision(gate GateDirection, booking *models.Booking, vehicle *models.Vehicle, amount float64) *GateDecision {
+	decision := &GateDecision{
+		Gate:        gate,
+		Open:        true,
+		PlateNumber: vehicle.PlateNumber,
+		BookingID:   &booking.ID,
+		Amount:      amount,
+	}
+	if booking.SpotNumber != nil {
+		decision.SpotNumber = *booking.SpotNumber
+	}
+	return decision
+}
+
+// Enter recognizes the vehicle at an entry gate and activates its current
+// pre-booking, or starts an on-site booking when it has none
+func (s *GateService) Enter(ctx context.Context, device *models.Device, image []byte, ext string) (*GateDecision, error) {
+	// The location is the one the gate device is bound to
+	locationID := device.LocationID
+
+	// Refuse entry outside opening hours
+	if _, err := s.location.EnsureOpen(ctx, locationID, time.Now()); err != nil {
+		return nil, err
+	}
+
+	plateResult, err := s.recognize(image, uuid.New().String()+ext)
+	if errors.Is(err, ErrVehicleNotFound) && s.guest.Accepts(plateResult) {
+		return s.dedupe.do(device.DeviceID+"/"+plateResult.Text, func() (*GateDecision, error) {
+			return s.enterGuest(ctx, locationID, plateResult.Text)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.dedupe.do(device.DeviceID+"/"+plateResult.Vehicle.PlateNumber, func() (*GateDecision, error) {
+		return s.enter(ctx, locationID, plateResult.Vehicle)
+	})
+}
+
+func (s *GateService) enterGuest(ctx context.Context, locationID primitive.ObjectID, plate string) (*GateDecision, error) {
+	session, err := s.guest.Enter(ctx, locationID, plate)
+	if err != nil {
+		return nil, err
+	}
+	return s.guestDecision(GateDirectionEnter, session), nil
+}
+
+func (s *GateService) enter(ctx context.Context, locationID primitive.ObjectID, vehicle *models.Vehicle) (*GateDecision, error) {
+	// Anti-passback: a vehicle with an active booking is still inside
+	_, err := s.booking.FindBookingByFilter(ctx, bson.M{
+		"vehicle_id": vehicle.ID,
+		"status":     models.BookingStatusActive,
+	})
+	if err == nil {
+		return nil, ErrVehicleInside
+	}
+	if err != ErrBookingNotFound {
+		return nil, fmt.Errorf("failed to check existing bookings: %w", err)
+	}
+
+	if _, err := s.owner(ctx, vehicle); err != nil {
+		return nil, err
+	}
+
+	// Check for existing pre-booked booking
+	existingBooking, err := s.booking.FindBookingByFilter(ctx, bson.M{
+		"vehicle_id":   vehicle.ID,
+		"location_id":  locationID,
+		"status":       models.BookingStatusPending,
+		"booking_type": models.BookingTypePreBooked,
+		"start_time": bson.M{
+			"$lte": time.Now(),
+		},
+		"end_time": bson.M{
+			"$gte": time.Now(),
+		},
+	})
+
+	var booking *models.Booking
+	if err == nil && existingBooking != nil {
+		// Update existing pre-booked booking
+		existingBooking.Status = models.BookingStatusActive
+		if err := s.booking.UpdateBookingStatus(ctx, existingBooking.ID, models.BookingStatusActive); err != nil {
+			return nil, fmt.Errorf("failed to update existing booking: %w", err)
+		}
+		booking = existingBooking
+	} else {
+		// Create new on-site booking
+		now := time.Now()
+		booking = &models.Booking{
+			VehicleID:   vehicle.ID,
+			UserID:      vehicle.Owner,
+			LocationID:  locationID,
+			StartTime:   now,
+			Status:      models.BookingStatusActive, // Set as active immediately
+			BookingType: models.BookingTypeOnSite,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		// For on-site bookings at the gate, we don't specify a spot number
+		// Let the booking service find an available spot
+		if err := s.booking.CreateBooking(ctx, booking); err != nil {
+			return nil, fmt.Errorf("failed to create booking: %w", err)
+		}
+	}
+
+	s.publishGateEvent(events.TypeGateEntered, booking, vehicle, 0)
+	return gateDecision(GateDirectionEnter, booking, vehicle, 0), nil
+}
+
+// Exit recognizes the vehicle at an exit gate, charges the stay to the
+// driver's wallet or their organization's and completes the booking
+func (s *GateService) Exit(ctx context.Context, device *models.Device, image []byte, ext string) (*GateDecision, error) {
+	// The location is the one the gate device is bound to
+	locationID := device.LocationID
+
+	plateResult, err := s.recognize(image, uuid.New().String()+"_exit"+ext)
+	if errors.Is(err, ErrVehicleNotFound) && s.guest.Accepts(plateResult) {
+		return s.dedupe.do(device.DeviceID+"/"+plateResult.Text, func() (*GateDecision, error) {
+			return s.exitGuest(ctx, locationID, plateResult.Text)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.dedupe.do(device.DeviceID+"/"+plateResult.Vehicle.PlateNumber, func() (*GateDecision, error) {
+		decision, err := s.exit(ctx, locationID, plateResult.Vehicle)
+		// The plate may have been registered after it entered as a guest
+		if errors.Is(err, ErrVehicleNotInside) && s.guest.enabled {
+			return s.exitGuest(ctx, locationID, plateResult.Vehicle.PlateNumber)
+		}
+		return decision, err
+	})
+}
+
+// exitGuest opens the barrier for a paid guest. An unpaid guest gets a closed
+// decision carrying the amount and pay code along with ErrGuestPaymentRequired
+func (s *GateService) exitGuest(ctx context.Context, locationID primitive.ObjectID, plate string) (*GateDecision, error) {
+	session, err := s.guest.Exit(ctx, locationID, plate)
+	if errors.Is(err, ErrGuestPaymentRequired) {
+		decision := s.guestDecision(GateDirectionExit, session)
+		decision.Open = false
+		decision.Reason = err.Error()
+		decision.PayURL = s.guest.PayURL(session.PayCode)
+		return decision, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.guestDecision(GateDirectionExit, session), nil
+}
+
+func (s *GateService) exit(ctx context.Context, locationID primitive.ObjectID, vehicle *models.Vehicle) (*GateDecision, error) {
+	// Anti-passback: only a vehicle that entered this location can leave it
+	activeBooking, err := s.booking.FindBookingByFilter(ctx, bson.M{
+		"vehicle_id":  vehicle.ID,
+		"location_id": locationID,
+		"status":      models.BookingStatusActive,
+	})
+	if err != nil {
+		if err == ErrBookingNotFound {
+			return nil, ErrVehicleNotInside
+		}
+		return nil, err
+	}
+
+	location, err := s.location.GetLocation(ctx, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate parking duration and amount
+	endTime := time.Now()
+	totalAmount := parkingCharge(location, activeBooking.StartTime, endTime)
+
+	if _, err := s.owner(ctx, vehicle); err != nil {
+		return nil, err
+	}
+
+	// Complete the booking first so a concurrent exit cannot charge the stay
+	// again, then deduct payment from the wallet the vehicle's billing policy
+	// names. The driver who booked pays, on-site bookings are made for the owner
+	err = s.booking.CompleteBooking(ctx, activeBooking.ID, endTime, totalAmount, func() error {
+		_, err := s.organizations.ChargeStay(ctx, vehicle, activeBooking.UserID, totalAmount, fmt.Sprintf("Parking payment for %s", vehicle.PlateNumber))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrBookingNotActive) {
+			return nil, ErrVehicleNotInside
+		}
+		return nil, err
+	}
+
+	// Update spot status to unoccupied
+	if activeBooking.SpotNumber != nil {
+		if err := s.location.UpdateSlotStatus(ctx, locationID, *activeBooking.SpotNumber, false); err != nil {
+			return nil, fmt.Errorf("failed to update spot status: %w", err)
+		}
+	}
+
+	s.publishGateEvent(events.TypeGateExited, activeBooking, vehicle, totalAmount)
+	return gateDecision(GateDirectionExit, activeBooking, vehicle, totalAmount), nil
+}
@@ -0,0 +1,494 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrGuestSessionNotFound      = errors.New("guest session not found")
+	ErrGuestPaymentRequired      = errors.New("payment required before exit")
+	ErrGuestSessionPaid          = errors.New("guest session is already paid")
+	ErrGuestSessionClosed        = errors.New("guest session is closed")
+	ErrGuestSessionClaimed       = errors.New("guest session is already claimed")
+	ErrGuestSessionBusy          = errors.New("a paid guest session can be claimed once the vehicle has left")
+	ErrGuestVehicleNotRegistered = errors.New("register the vehicle before claiming the session")
+)
+
+const (
+	// Pay codes leave out characters that are easily confused on a display
+	payCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	payCodeLength   = 6
+	payCodeAttempts = 5
+	// Entries racing for the last slots retry this often before giving up
+	slotReserveAttempts = 3
+	guestListLimit      = 200
+)
+
+// guestStatusesUnpaid are the on-site statuses a payment can settle
+var guestStatusesUnpaid = []models.GuestSessionStatus{
+	models.GuestStatusInside,
+	models.GuestStatusAwaitingPayment,
+}
+
+// GuestService lets vehicles whose plate is not registered park as guests
+// and pay with the code shown on the exit gate
+type GuestService struct {
+	repo     *repositories.GuestSessionRepository
+	booking  *BookingService
+	vehicle  *VehicleService
+	location *ParkingLocationService
+	wallet   *WalletService
+	bus      *events.Bus
+	enabled  bool
+	payURL   string
+}
+
+func NewGuestService(
+	cfg *localconfig.Config,
+	repo *repositories.GuestSessionRepository,
+	bookingService *BookingService,
+	vehicleService *VehicleService,
+	locationService *ParkingLocationService,
+	walletService *WalletService,
+	bus *events.Bus,
+) *GuestService {
+	return &GuestService{
+		repo:     repo,
+		booking:  bookingService,
+		vehicle:  vehicleService,
+		location: locationService,
+		wallet:   walletService,
+		bus:      bus,
+		enabled:  cfg.Guest.Enabled,
+		payURL:   cfg.Guest.PayURL,
+	}
+}
+
+// Accepts reports whether an unregistered plate read at a gate can be
+// handled as a guest. Unreadable plates are still refused
+func (s *GuestService) Accepts(plate *NumberPlateResult) bool {
+	return s.enabled && plate != nil && plate.IsValid
+}
+
+// PayURL is the link encoded in the QR code shown on the exit gate
+func (s *GuestService) PayURL(code string) string {
+	return s.payURL + code
+}
+
+func (s *GuestService) publish(eventType events.Type, session *models.GuestSession) {
+	payload := events.GuestEvent{
+		SessionID:   session.ID,
+		PlateNumber: session.PlateNumber,
+		SpotNumber:  session.SpotNumber,
+	}
+	if session.Amount != nil {
+		payload.Amount = *session.Amount
+	}
+	s.bus.Publish(events.New(eventType, session.LocationID, payload))
+}
+
+func generatePayCode() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(payCodeAlphabet)))
+	for i := 0; i < payCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(payCodeAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// findOnSite returns the session of a guest vehicle that is still inside,
+// at any location when locationID is nil
+func (s *GuestService) findOnSite(ctx context.Context, plate string, locationID *primitive.ObjectID) (*models.GuestSession, error) {
+	filter := bson.M{
+		"plate_number": plate,
+		"status":       bson.M{"$in": models.GuestStatusesOnSite},
+	}
+	if locationID != nil {
+		filter["location_id"] = *locationID
+	}
+	return s.repo.FindOne(ctx, filter)
+}
+
+// Enter opens a guest session for an unregistered plate and assigns it a
+// free slot. Entry is refused when the location is full or the plate is
+// already inside
+func (s *GuestService) Enter(ctx context.Context, locationID primitive.ObjectID, plate string) (*models.GuestSession, error) {
+	if _, err := s.findOnSite(ctx, plate, nil); err == nil {
+		return nil, ErrVehicleInside
+	} else if !errors.Is(err, repositories.ErrNotFound) {
+		return nil, err
+	}
+
+	spotNumber, err := s.reserveSlot(ctx, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &models.GuestSession{
+		LocationID:  locationID,
+		PlateNumber: plate,
+		SpotNumber:  spotNumber,
+		Status:      models.GuestStatusInside,
+		EntryAt:     now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	for attempt := 0; ; attempt++ {
+		if session.PayCode, err = generatePayCode(); err != nil {
+			break
+		}
+		err = s.repo.Create(ctx, session)
+		if !errors.Is(err, repositories.ErrDuplicate) || attempt == payCodeAttempts-1 {
+			break
+		}
+	}
+	if err != nil {
+		// Give the slot back, the vehicle is not let in
+		if release := s.location.UpdateSlotStatus(ctx, locationID, spotNumber, false); release != nil {
+			log.Printf("guests: failed to release slot %s at %s: %v", spotNumber, locationID.Hex(), release)
+		}
+		return nil, err
+	}
+
+	s.publish(events.TypeGuestEntered, session)
+	return session, nil
+}
+
+// reserveSlot picks a free slot and occupies it with a conditional update,
+// trying another one when a concurrent entry took it first
+func (s *GuestService) reserveSlot(ctx context.Context, locationID primitive.ObjectID) (string, error) {
+	for attempt := 0; attempt < slotReserveAttempts; attempt++ {
+		spotNumber, err := s.booking.findAvailableSlot(ctx, locationID, nil)
+		if err != nil {
+			return "", err
+		}
+		err = s.location.ReserveSlot(ctx, locationID, spotNumber)
+		if err == nil {
+			return spotNumber, nil
+		}
+		if !errors.Is(err, ErrSlotTaken) {
+			return "", err
+		}
+	}
+	return "", ErrNoAvailableSlots
+}
+
+// Exit lets a paid guest out. An unpaid guest is priced and refused with
+// ErrGuestPaymentRequired so the gate can show the pay code
+func (s *GuestService) Exit(ctx context.Context, locationID primitive.ObjectID, plate string) (*models.GuestSession, error) {
+	session, err := s.findOnSite(ctx, plate, &locationID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrVehicleNotInside
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if session.Status != models.GuestStatusPaid {
+		if err := s.quote(ctx, session, now); err != nil {
+			return nil, err
+		}
+		// Conditional so a payment made meanwhile is not overwritten
+		awaiting, err := s.repo.UpdateWhere(ctx, session.ID, bson.M{"status": bson.M{"$in": guestStatusesUnpaid}}, bson.M{
+			"$set": bson.M{
+				"status":     models.GuestStatusAwaitingPayment,
+				"amount":     *session.Amount,
+				"updated_at": now,
+			},
+		})
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return s.Exit(ctx, locationID, plate)
+			}
+			return nil, err
+		}
+		return awaiting, ErrGuestPaymentRequired
+	}
+
+	session.Status = models.GuestStatusExited
+	session.ExitAt = &now
+	session.UpdatedAt = now
+	if err := s.repo.Update(ctx, session); err != nil {
+		return nil, err
+	}
+	if session.SpotNumber != "" {
+		if err := s.location.UpdateSlotStatus(ctx, locationID, session.SpotNumber, false); err != nil {
+			return nil, err
+		}
+	}
+
+	s.publish(events.TypeGuestExited, session)
+	return session, nil
+}
+
+// quote prices the stay so far at the location's current rate
+func (s *GuestService) quote(ctx context.Context, session *models.GuestSession, now time.Time) error {
+	location, err := s.location.GetLocation(ctx, session.LocationID)
+	if err != nil {
+		return err
+	}
+	amount := parkingCharge(location, session.EntryAt, now)
+	session.Amount = &amount
+	return nil
+}
+
+// GetSession returns the guest session with the pay code
+func (s *GuestService) GetSession(ctx context.Context, code string) (*models.GuestSession, error) {
+	session, err := s.repo.FindOne(ctx, bson.M{"pay_code": strings.ToUpper(strings.TrimSpace(code))})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrGuestSessionNotFound
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetQuote returns what a guest owes, priced up to now while unpaid
+func (s *GuestService) GetQuote(ctx context.Context, code string) (*dto.GuestQuote, error) {
+	session, err := s.GetSession(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if session.IsOnSite() && session.Status != models.GuestStatusPaid {
+		if err := s.quote(ctx, session, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	quote := &dto.GuestQuote{
+		PayCode:     session.PayCode,
+		PlateNumber: session.PlateNumber,
+		LocationID:  session.LocationID,
+		Status:      session.Status,
+		EntryAt:     session.EntryAt,
+		PaidAt:      session.PaidAt,
+	}
+	if session.Amount != nil {
+		quote.Amount = *session.Amount
+	}
+	return quote, nil
+}
+
+// Pay settles a guest session from the wallet of the user who entered the code
+func (s *GuestService) Pay(ctx context.Context, code string, userID primitive.ObjectID) (*models.GuestSession, error) {
+	return s.settle(ctx, code, userID, models.GuestPaymentWallet)
+}
+
+// SettleCash records a guest session paid in cash to staff
+func (s *GuestService) SettleCash(ctx context.Context, code string, staffID primitive.ObjectID) (*models.GuestSession, error) {
+	return s.settle(ctx, code, staffID, models.GuestPaymentCash)
+}
+
+func (s *GuestService) settle(ctx context.Context, code string, payerID primitive.ObjectID, method models.GuestPaymentMethod) (*models.GuestSession, error) {
+	session, err := s.GetSession(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case session.Status == models.GuestStatusPaid:
+		return nil, ErrGuestSessionPaid
+	case !session.IsOnSite():
+		return nil, ErrGuestSessionClosed
+	}
+
+	now := time.Now()
+	if err := s.quote(ctx, session, now); err != nil {
+		return nil, err
+	}
+
+	// Mark the session paid before taking the money, so a second payment for
+	// the same code finds it paid and is refused
+	unpaid := bson.M{"status": bson.M{"$in": guestStatusesUnpaid}}
+	paid, err := s.repo.UpdateWhere(ctx, session.ID, unpaid, bson.M{
+		"$set": bson.M{
+			"status":         models.GuestStatusPaid,
+			"amount":         *session.Amount,
+			"paid_at":        now,
+			"paid_by":        payerID,
+			"payment_method": method,
+			"updated_at":     now,
+		},
+	})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, s.settleConflict(ctx, code)
+		}
+		return nil, err
+	}
+
+	if method == models.GuestPaymentWallet {
+		if _, err := s.wallet.Deduct(ctx, payerID, *session.Amount, fmt.Sprintf("Guest parking for %s", session.PlateNumber)); err != nil {
+			_, rollback := s.repo.UpdateWhere(ctx, session.ID, bson.M{"status": models.GuestStatusPaid}, bson.M{
+				"$set":   bson.M{"status": session.Status, "updated_at": time.Now()},
+				"$unset": bson.M{"paid_at": "", "paid_by": "", "payment_method": ""},
+			})
+			if rollback != nil {
+				return nil, fmt.Errorf("%w (restoring guest session failed: %v)", err, rollback)
+			}
+			return nil, err
+		}
+	}
+	session = paid
+
+	s.publish(events.TypeGuestPaid, session)
+	return session, nil
+}
+
+// settleConflict explains why a session could not be marked paid
+func (s *GuestService) settleConflict(ctx context.Context, code string) error {
+	session, err := s.GetSession(ctx, code)
+	if err != nil {
+		return err
+	}
+	if session.Status == models.GuestStatusPaid {
+		return ErrGuestSessionPaid
+	}
+	return ErrGuestSessionClosed
+}
+
+// Claim moves a guest session into the account of the user who owns the
+// plate. A vehicle still inside gets an active booking billed at the exit
+// gate as usual; a finished stay is added to the user's booking history
+func (s *GuestService) Claim(ctx context.Context, code string, userID primitive.ObjectID) (*models.GuestSession, error) {
+	session, err := s.GetSession(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case session.ClaimedBy != nil:
+		return nil, ErrGuestSessionClaimed
+	case session.Status == models.GuestStatusPaid:
+		return nil, ErrGuestSessionBusy
+	}
+
+	// The plate must be registered to the user or shared with them
+	vehicle, err := s.vehicle.FindOne(ctx, bson.M{"plate_number": session.PlateNumber})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrGuestVehicleNotRegistered
+		}
+		return nil, err
+	}
+	if !vehicle.CanBeDrivenBy(userID) {
+		return nil, ErrGuestVehicleNotRegistered
+	}
+
+	now := time.Now()
+	booking := &models.Booking{
+		ID:          primitive.NewObjectID(),
+		VehicleID:   vehicle.ID,
+		UserID:      userID,
+		LocationID:  session.LocationID,
+		StartTime:   session.EntryAt,
+		BookingType: models.BookingTypeOnSite,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if session.SpotNumber != "" {
+		booking.SpotNumber = &session.SpotNumber
+	}
+
+	// Claim the session before creating the booking, so a payment or a
+	// second claim racing this one finds it taken and only one booking is
+	// ever created for it
+	filter := bson.M{"claimed_by": bson.M{"$exists": false}}
+	set := bson.M{
+		"claimed_by": userID,
+		"claimed_at": now,
+		"booking_id": booking.ID,
+		"updated_at": now,
+	}
+	if session.IsOnSite() {
+		filter["status"] = bson.M{"$in": guestStatusesUnpaid}
+		set["status"] = models.GuestStatusClaimed
+		booking.Status = models.BookingStatusActive
+	} else {
+		filter["status"] = models.GuestStatusExited
+		booking.Status = models.BookingStatusCompleted
+		booking.EndTime = session.ExitAt
+		booking.TotalAmount = session.Amount
+	}
+	claimed, err := s.repo.UpdateWhere(ctx, session.ID, filter, bson.M{"$set": set})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, s.claimConflict(ctx, code)
+		}
+		return nil, err
+	}
+
+	if err := s.booking.RecordBooking(ctx, booking); err != nil {
+		_, rollback := s.repo.UpdateWhere(ctx, session.ID, bson.M{"booking_id": booking.ID, "status": claimed.Status}, bson.M{
+			"$set":   bson.M{"status": session.Status, "updated_at": time.Now()},
+			"$unset": bson.M{"claimed_by": "", "claimed_at": "", "booking_id": ""},
+		})
+		if rollback != nil {
+			return nil, fmt.Errorf("%w (restoring guest session failed: %v)", err, rollback)
+		}
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// claimConflict explains why a session could not be claimed
+func (s *GuestService) claimConflict(ctx context.Context, code string) error {
+	session, err := s.GetSession(ctx, code)
+	if err != nil {
+		return err
+	}
+	if session.ClaimedBy != nil {
+		return ErrGuestSessionClaimed
+	}
+	return ErrGuestSessionBusy
+}
+
+// GetSessions lists guest sessions, newest first, optionally filtered by
+// location and status
+func (s *GuestService) GetSessions(ctx context.Context, locationID *primitive.ObjectID, status models.GuestSessionStatus) ([]models.GuestSession, error) {
+	filter := bson.M{}
+	if locationID != nil {
+		filter["location_id"] = *locationID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	return s.repo.Find(ctx, filter, guestListLimit)
+}
+
+// OnSiteSlots returns the slots held by guests still inside a location
+func (s *GuestService) OnSiteSlots(ctx context.Context, locationID primitive.ObjectID) (map[string]bool, error) {
+	sessions, err := s.repo.Find(ctx, bson.M{
+		"location_id": locationID,
+		"status":      bson.M{"$in": models.GuestStatusesOnSite},
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	slots := make(map[string]bool, len(sessions))
+	for _, session := range sessions {
+		if session.SpotNumber != "" {
+			slots[session.SpotNumber] = true
+		}
+	}
+	return slots, nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrLoginThrottled = errors.New("too many failed login attempts, try again later")
+	ErrAccountLocked  = errors.New("account is temporarily locked after too many failed login attempts")
+)
+
+// LoginThrottleService slows down password guessing. Failed logins are
+// counted per email and per IP address in Mongo so every server instance
+// sees them. After a few free attempts each failure on an account doubles
+// the wait before the next attempt, and enough failures lock the account or
+// the IP address for a while
+type LoginThrottleService struct {
+	cfg   *config.Config
+	repo  *repositories.LoginThrottleRepository
+	audit *AuditService
+}
+
+func NewLoginThrottleService(cfg *config.Config, repo *repositories.LoginThrottleRepository, audit *AuditService) *LoginThrottleService {
+	return &LoginThrottleService{
+		cfg:   cfg,
+		repo:  repo,
+		audit: audit,
+	}
+}
+
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check returns ErrAccountLocked or ErrLoginThrottled with how long to wait
+// if a login for the email from the IP address is not allowed yet
+func (s *LoginThrottleService) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now()
+
+	account, err := s.repo.Find(ctx, models.LoginThrottleAccount, normalizeLoginEmail(email))
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		return 0, err
+	}
+	if account != nil {
+		if wait := account.BlockedFor(now); wait > 0 {
+			if account.LockedAt != nil {
+				return wait, ErrAccountLocked
+			}
+			return wait, ErrLoginThrottled
+		}
+	}
+
+	if ip == "" {
+		return 0, nil
+	}
+	address, err := s.repo.Find(ctx, models.LoginThrottleIP, ip)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		return 0, err
+	}
+	if address != nil {
+		if wait := address.BlockedFor(now); wait > 0 {
+			return wait, ErrLoginThrottled
+		}
+	}
+	return 0, nil
+}
+
+// backoff returns how long an account waits after its nth failure
+func (s *LoginThrottleService) backoff(failures int) time.Duration {
+	over := failures - s.cfg.Login.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	delay := s.cfg.Login.BaseDelay
+	for i := 1; i < over && delay < s.cfg.Login.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.cfg.Login.MaxDelay {
+		delay = s.cfg.Login.MaxDelay
+	}
+	return delay
+}
+
+// RecordFailure counts a failed login for the email and the IP address and
+// blocks them when due. userID is the account the email belongs to, if any
+func (s *LoginThrottleService) RecordFailure(ctx context.Context, email, ip string, userID *primitive.ObjectID) error {
+	now := time.Now()
+	key := normalizeLoginEmail(email)
+
+	account, err := s.repo.RecordFailure(ctx, models.LoginThrottleAccount, key, now, s.cfg.Login.FailureWindow)
+	if err != nil {
+		return err
+	}
+	switch {
+	case account.Failures == s.cfg.Login.LockoutThreshold:
+		// Only the failure that reaches the threshold locks, so a lockout is
+		// audited once even with concurrent attempts
+		if err := s.repo.Block(ctx, account.ID, now.Add(s.cfg.Login.LockoutDuration), &now); err != nil {
+			return err
+		}
+		s.record(ctx, &models.AuditEntry{
+			Action:  models.AuditLoginAccountLocked,
+			UserID:  userID,
+			Subject: key,
+			IP:      ip,
+			Detail:  fmt.Sprintf("%d failed logins, locked for %s", account.Failures, s.cfg.Login.LockoutDuration),
+		})
+	case s.backoff(account.Failures) > 0:
+		if err := s.repo.Block(ctx, account.ID, now.Add(s.backoff(account.Failures)), nil); err != nil {
+			return err
+		}
+	}
+
+	if ip == "" {
+		return nil
+	}
+	address, err := s.repo.RecordFailure(ctx, models.LoginThrottleIP, ip, now, s.cfg.Login.FailureWindow)
+	if err != nil {
+		return err
+	}
+	if address.Failures == s.cfg.Login.IPLockoutThreshold {
+		if err := s.repo.Block(ctx, address.ID, now.Add(s.cfg.Login.LockoutDuration), &now); err != nil {
+			return err
+		}
+		s.record(ctx, &models.AuditEntry{
+			Action:  models.AuditLoginIPLocked,
+			Subject: ip,
+			IP:      ip,
+			Detail:  fmt.Sprintf("%d failed logins, locked for %s", address.Failures, s.cfg.Login.LockoutDuration),
+		})
+	}
+	return nil
+}
+
+// RecordSuccess forgets the account's failures. The IP address counter is
+// kept so one valid account cannot reset it
+func (s *LoginThrottleService) RecordSuccess(ctx context.Context, email string) error {
+	_, err := s.repo.Clear(ctx, models.LoginThrottleAccount, normalizeLoginEmail(email))
+	return err
+}
+
+// Unlock lifts a lockout or backoff on the user's account. actorID is the
+// admin unlocking it, unset when the user did by resetting their password
+func (s *LoginThrottleService) Unlock(ctx context.Context, user *models.User, actorID *primitive.ObjectID, detail string) (bool, error) {
+	key := normalizeLoginEmail(user.Email)
+	throttle, err := s.repo.Find(ctx, models.LoginThrottleAccount, key)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := s.repo.Clear(ctx, models.LoginThrottleAccount, key); err != nil {
+		return false, err
+	}
+	if throttle.LockedAt == nil || throttle.BlockedFor(time.Now()) == 0 {
+		return false, nil
+	}
+
+	s.record(ctx, &models.AuditEntry{
+		Action:  models.AuditLoginAccountUnlocked,
+		ActorID: actorID,
+		UserID:  &user.ID,
+		Subject: key,
+		Detail:  detail,
+	})
+	return true, nil
+}
+
+// record writes an audit entry. A failed write is logged rather than failing
+// the login it belongs to
+func (s *LoginThrottleService) record(ctx context.Context, entry *models.AuditEntry) {
+	if err := s.audit.Record(ctx, entry); err != nil {
+		log.Printf("login throttle: failed to record %s audit entry for %s: %v", entry.Action, entry.Subject, err)
+	}
+}
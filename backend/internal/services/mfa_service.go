@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"github.com/dfanso/parkme-backend/pkg/auth"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolling     = errors.New("start two-factor enrolment first")
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrInvalidMFAToken     = errors.New("login challenge is invalid or has expired, sign in again")
+	ErrMFAAttemptsExceeded = errors.New("too many invalid codes, sign in again")
+)
+
+// totpSkew accepts codes from one step either side of now for clock drift
+const totpSkew = 1
+
+// recoveryCodeAlphabet is the base32 alphabet, 32 characters so every
+// random byte maps to one without bias
+const recoveryCodeAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// MFAService enrols users in TOTP two-factor authentication and runs the
+// second step of their login
+type MFAService struct {
+	cfg         *config.Config
+	repo        *repositories.UserMFARepository
+	userService *UserService
+	sessions    *AuthSessionService
+	throttle    *LoginThrottleService
+	audit       *AuditService
+}
+
+func NewMFAService(
+	cfg *config.Config,
+	repo *repositories.UserMFARepository,
+	userService *UserService,
+	sessions *AuthSessionService,
+	throttle *LoginThrottleService,
+	audit *AuditService,
+) *MFAService {
+	return &MFAService{
+		cfg:         cfg,
+		repo:        repo,
+		userService: userService,
+		sessions:    sessions,
+		throttle:    throttle,
+		audit:       audit,
+	}
+}
+
+// Required reports whether users of the role must use two-factor authentication
+func (s *MFAService) Required(role models.Role) bool {
+	for _, r := range s.cfg.MFA.RequiredRoles {
+		if models.Role(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the user's enrolment, nil if there is none
+func (s *MFAService) find(ctx context.Context, userID primitive.ObjectID) (*models.UserMFA, error) {
+	mfa, err := s.repo.FindByUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return mfa, nil
+}
+
+// Enabled reports whether the user signs in with a second factor
+func (s *MFAService) Enabled(ctx context.Context, userID primitive.ObjectID) (bool, error) {
+	mfa, err := s.find(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return mfa.Enabled(), nil
+}
+
+func (s *MFAService) GetStatus(ctx context.Context, user *models.User) (*dto.MFAStatusResponse, error) {
+	mfa, err := s.find(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	status := &dto.MFAStatusResponse{Required: s.Required(user.Role)}
+	if mfa.Enabled() {
+		status.Enabled = true
+		status.ConfirmedAt = mfa.ConfirmedAt
+		status.RecoveryCodesRemaining = len(mfa.RecoveryCodeHashes)
+	}
+	return status, nil
+}
+
+// BeginEnrolment creates a new TOTP secret for the user to add to an
+// authenticator app. It takes effect once ConfirmEnrolment sees a code
+func (s *MFAService) BeginEnrolment(ctx context.Context, user *models.User) (*dto.MFAEnrolmentResponse, error) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = s.repo.ReplacePending(ctx, &models.UserMFA{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		if errors.Is(err, repositories.ErrDuplicate) {
+			return nil, ErrMFAAlreadyEnabled
+		}
+		return nil, err
+	}
+	return &dto.MFAEnrolmentResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(s.cfg.MFA.Issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmEnrolment enables two-factor authentication with a code from the
+// app and returns the recovery codes, which are only shown this once. The
+// current session counts as having passed the second factor
+func (s *MFAService) ConfirmEnrolment(ctx context.Context, user *models.User, sessionID primitive.ObjectID, code string) ([]string, error) {
+	mfa, err := s.find(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil {
+		return nil, ErrMFANotEnrolling
+	}
+	if mfa.Enabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	step, ok := auth.ValidateTOTP(mfa.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := s.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Confirm(ctx, mfa.ID, step, hashes, time.Now()); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrMFANotEnrolling
+		}
+		return nil, err
+	}
+	if err := s.sessions.MarkMFA(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	s.record(ctx, &models.AuditEntry{Action: models.AuditMFAEnabled, ActorID: &user.ID, UserID: &user.ID, Subject: user.Email})
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off after checking a current code
+func (s *MFAService) Disable(ctx context.Context, user *models.User, code string) error {
+	mfa, err := s.enabled(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCode(ctx, user, mfa, code); err != nil {
+		return err
+	}
+	if _, err := s.repo.DeleteByUser(ctx, user.ID); err != nil {
+		return err
+	}
+	s.record(ctx, &models.AuditEntry{Action: models.AuditMFADisabled, ActorID: &user.ID, UserID: &user.ID, Subject: user.Email})
+	return nil
+}
+
+// Reset removes a user's two-factor enrolment for an admin, for users who
+// lost their device and recovery codes. The user's sessions are signed out
+func (s *MFAService) Reset(ctx context.Context, user *models.User, actorID primitive.ObjectID) error {
+	removed, err := s.repo.DeleteByUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrMFANotEnabled
+	}
+	if _, err := s.sessions.RevokeAll(ctx, user.ID, models.SessionRevokedMFAReset); err != nil {
+		return err
+	}
+	s.record(ctx, &models.AuditEntry{Action: models.AuditMFAReset, ActorID: &actorID, UserID: &user.ID, Subject: user.Email})
+	return nil
+}
+
+// RenewRecoveryCodes replaces the recovery codes after checking a current
+// TOTP code and returns the new ones
+func (s *MFAService) RenewRecoveryCodes(ctx context.Context, user *models.User, code string) ([]string, error) {
+	mfa, err := s.enabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkTOTP(ctx, mfa, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := s.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetRecoveryCodes(ctx, mfa.ID, hashes); err != nil {
+		return nil, err
+	}
+	s.record(ctx, &models.AuditEntry{Action: models.AuditMFARecoveryCodesRenewed, ActorID: &user.ID, UserID: &user.ID, Subject: user.Email})
+	return codes, nil
+}
+
+// StartChallenge begins the second login step for a user whose password
+// checked out
+func (s *MFAService) StartChallenge(ctx context.Context, user *models.User) (*dto.MFAChallengeResponse, error) {
+	secret, err := generateTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	challenge := &models.MFAChallenge{
+		UserID:     user.ID,
+		SecretHash: hashTokenSecret(secret),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.cfg.MFA.ChallengeTTL),
+	}
+	if err := s.repo.CreateChallenge(ctx, challenge); err != nil {
+		return nil, err
+	}
+	return &dto.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    challenge.ID.Hex() + "." + secret,
+		ExpiresAt:   challenge.ExpiresAt,
+	}, nil
+}
+
+// CompleteChallenge checks the code for a login challenge and signs the user
+// in with the second factor satisfied. Invalid codes count as failed logins
+func (s *MFAService) CompleteChallenge(ctx context.Context, token, code, userAgent, ip string) (*dto.LoginResponse, error) {
+	challengeID, secret, ok := splitSecretToken(token)
+	if !ok {
+		return nil, ErrInvalidMFAToken
+	}
+	challenge, err := s.repo.FindChallenge(ctx, challengeID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+	if !secretMatches(secret, challenge.SecretHash) || !time.Now().Before(challenge.ExpiresAt) {
+		return nil, ErrInvalidMFAToken
+	}
+	attempts, err := s.repo.CountChallengeAttempt(ctx, challenge.ID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+	if attempts > s.cfg.MFA.MaxAttempts {
+		if err := s.repo.DeleteChallenge(ctx, challenge.ID); err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			return nil, err
+		}
+		return nil, ErrMFAAttemptsExceeded
+	}
+
+	user, err := s.userService.GetByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+	mfa, err := s.enabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCode(ctx, user, mfa, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if err := s.throttle.RecordFailure(ctx, user.Email, ip, &user.ID); err != nil {
+				log.Printf("mfa: failed to record failed attempt for user %s: %v", user.ID.Hex(), err)
+			}
+		}
+		return nil, err
+	}
+
+	// Each challenge signs in once
+	if err := s.repo.DeleteChallenge(ctx, challenge.ID); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+	if err := s.throttle.RecordSuccess(ctx, user.Email); err != nil {
+		log.Printf("mfa: failed to clear failed attempts for user %s: %v", user.ID.Hex(), err)
+	}
+	return s.sessions.SignIn(ctx, user, userAgent, ip, true)
+}
+
+func (s *MFAService) enabled(ctx context.Context, userID primitive.ObjectID) (*models.UserMFA, error) {
+	mfa, err := s.find(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !mfa.Enabled() {
+		return nil, ErrMFANotEnabled
+	}
+	return mfa, nil
+}
+
+// checkCode accepts a TOTP code or, failing that, uses up a recovery code
+func (s *MFAService) checkCode(ctx context.Context, user *models.User, mfa *models.UserMFA, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == auth.TOTPDigits {
+		return s.checkTOTP(ctx, mfa, code)
+	}
+
+	err := s.repo.UseRecoveryCode(ctx, mfa.ID, hashTokenSecret(normalizeRecoveryCode(code)))
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	s.record(ctx, &models.AuditEntry{
+		Action:  models.AuditMFARecoveryCodeUsed,
+		ActorID: &user.ID,
+		UserID:  &user.ID,
+		Subject: user.Email,
+		Detail:  fmt.Sprintf("%d recovery codes left", len(mfa.RecoveryCodeHashes)-1),
+	})
+	return nil
+}
+
+// checkTOTP accepts a code once, a replayed code is invalid
+func (s *MFAService) checkTOTP(ctx context.Context, mfa *models.UserMFA, code string) error {
+	step, ok := auth.ValidateTOTP(mfa.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	if err := s.repo.UseStep(ctx, mfa.ID, step); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+// newRecoveryCodes returns recovery codes like "abcde-fghjk" and their hashes
+func (s *MFAService) newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, s.cfg.MFA.RecoveryCodes)
+	hashes := make([]string, len(codes))
+	buf := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		var b strings.Builder
+		for j, c := range buf {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(recoveryCodeAlphabet[c&31])
+		}
+		codes[i] = b.String()
+		hashes[i] = hashTokenSecret(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// record writes an audit entry, logging rather than failing on error
+func (s *MFAService) record(ctx context.Context, entry *models.AuditEntry) {
+	if err := s.audit.Record(ctx, entry); err != nil {
+		log.Printf("mfa: failed to record %s audit entry for %s: %v", entry.Action, entry.Subject, err)
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+)
+
+// migration is a one-off data change run once at startup. It must be safe to
+// run again if the server stops before it is recorded
+type migration struct {
+	id  string
+	run func(ctx context.Context, s *MigrationService, now time.Time) error
+}
+
+// migrations run in order, new ones are appended
+var migrations = []migration{
+	{id: "2026-10-backfill-email-verified", run: backfillEmailVerified},
+}
+
+type MigrationService struct {
+	repo  *repositories.MigrationRepository
+	users *repositories.UserRepository
+}
+
+func NewMigrationService(repo *repositories.MigrationRepository, users *repositories.UserRepository) *MigrationService {
+	return &MigrationService{repo: repo, users: users}
+}
+
+// Run applies the migrations that have not run yet
+func (s *MigrationService) Run(ctx context.Context) error {
+	for _, m := range migrations {
+		applied, err := s.repo.IsApplied(ctx, m.id)
+		if err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		now := time.Now()
+		if err := m.run(ctx, s, now); err != nil {
+			return fmt.Errorf("migration %s: %w", m.id, err)
+		}
+		if err := s.repo.MarkApplied(ctx, m.id, now); err != nil {
+			return err
+		}
+		log.Printf("migrations: applied %s", m.id)
+	}
+	return nil
+}
+
+// backfillEmailVerified marks the addresses of accounts that were active
+// before email verification existed as verified, so signing in with a
+// provider never takes them over
+func backfillEmailVerified(ctx context.Context, s *MigrationService, now time.Time) error {
+	count, err := s.users.MarkEmailsVerified(ctx, models.UserStatusActive, now)
+	if err != nil {
+		return err
+	}
+	log.Printf("migrations: marked %d existing addresses verified", count)
+	return nil
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"github.com/dfanso/parkme-backend/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUnknownTemplate   = errors.New("unknown notification template")
+	ErrInvalidChannel    = errors.New("invalid notification channel")
+	ErrRecipientNotFound = errors.New("notification recipient not found")
+)
+
+const (
+	notificationEventBuffer = 256
+	notificationListLimit   = 50
+	notificationTimeFormat  = "2006-01-02 15:04"
+)
+
+type NotificationService struct {
+	repo          *repositories.NotificationRepository
+	bookingRepo   *repositories.BookingRepository
+	user          *UserService
+	organizations *OrganizationService
+	location      *ParkingLocationService
+	vehicle       *VehicleService
+	bus           *events.Bus
+	providers     map[models.NotificationChannel]notifier.Provider
+	reminderLead  time.Duration
+	scanInterval  time.Duration
+}
+
+func NewNotificationService(
+	cfg *localconfig.Config,
+	repo *repositories.NotificationRepository,
+	bookingRepo *repositories.BookingRepository,
+	userService *UserService,
+	organizationService *OrganizationService,
+	locationService *ParkingLocationService,
+	vehicleService *VehicleService,
+	bus *events.Bus,
+	providers map[models.NotificationChannel]notifier.Provider,
+) *NotificationService {
+	return &NotificationService{
+		repo:          repo,
+		bookingRepo:   bookingRepo,
+		user:          userService,
+		organizations: organizationService,
+		location:      locationService,
+		vehicle:       vehicleService,
+		bus:           bus,
+		providers:     providers,
+		reminderLead:  cfg.Notification.ReminderLead,
+		scanInterval:  cfg.Notification.ScanInterval,
+	}
+}
+
+// Start reacts to gate and booking events and periodically scans bookings
+// for reminders and overstays until ctx is cancelled
+func (s *NotificationService) Start(ctx context.Context) {
+	sub := s.bus.Subscribe(func(e events.Event) bool {
+		switch e.Type {
+		case events.TypeGateEntered, events.TypeGateExited, events.TypeBookingCreated:
+			return true
+		}
+		return false
+	}, notificationEventBuffer)
+
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+
+	go func() {
+		for event := range sub.C {
+			if err := s.handleEvent(ctx, event); err != nil {
+				log.Printf("notifications: failed to handle %s event %s: %v", event.Type, event.ID, err)
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(s.scanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.scanBookings(ctx)
+			}
+		}
+	}()
+}
+
+func (s *NotificationService) handleEvent(ctx context.Context, event events.Event) error {
+	switch data := event.Data.(type) {
+	case events.GateEvent:
+		booking, err := s.bookingRepo.FindByID(ctx, data.BookingID)
+		if err != nil {
+			return err
+		}
+		details := s.bookingDetails(ctx, booking)
+		details["Time"] = event.OccurredAt.Format(notificationTimeFormat)
+
+		if event.Type == events.TypeGateEntered {
+			if err := s.Notify(ctx, booking.UserID, TemplateGateEntry, details, "gate_entry:"+booking.ID.Hex()); err != nil {
+				return err
+			}
+			return s.checkLowBalance(ctx, booking)
+		}
+
+		details["Amount"] = data.Amount
+		if booking.EndTime != nil {
+			details["Duration"] = booking.EndTime.Sub(booking.StartTime).Round(time.Minute).String()
+		}
+		return s.Notify(ctx, booking.UserID, TemplateGateExit, details, "gate_exit:"+booking.ID.Hex())
+	case events.BookingEvent:
+		booking, err := s.bookingRepo.FindByID(ctx, data.BookingID)
+		if err != nil {
+			return err
+		}
+		return s.checkLowBalance(ctx, booking)
+	}
+	return nil
+}
+
+// checkLowBalance warns the driver when the wallet paying for the stay does
+// not cover its estimated cost, using at least one hour for open-ended stays
+func (s *NotificationService) checkLowBalance(ctx context.Context, booking *models.Booking) error {
+	location, err := s.location.GetLocation(ctx, booking.LocationID)
+	if err != nil {
+		return err
+	}
+
+	hours := 1.0
+	if booking.EndTime != nil {
+		hours = math.Max(1, math.Ceil(booking.EndTime.Sub(booking.StartTime).Hours()))
+	}
+	estimate := hours * location.CurrentRate()
+
+	balance, err := s.organizations.AvailableBalance(ctx, booking.VehicleID, booking.UserID)
+	if err != nil {
+		return err
+	}
+	if balance >= estimate {
+		return nil
+	}
+
+	details := s.bookingDetails(ctx, booking)
+	details["Balance"] = balance
+	details["Estimate"] = estimate
+	return s.Notify(ctx, booking.UserID, TemplateLowBalance, details, "low_balance:"+booking.ID.Hex())
+}
+
+// scanBookings sends start reminders, expiry warnings and overstay alerts.
+// Dedupe keys make repeated scans safe
+func (s *NotificationService) scanBookings(ctx context.Context) {
+	now := time.Now()
+	horizon := now.Add(s.reminderLead)
+
+	scans := []struct {
+		template string
+		filter   bson.M
+	}{
+		{TemplateBookingReminder, bson.M{
+			"status":       models.BookingStatusPending,
+			"booking_type": models.BookingTypePreBooked,
+			"start_time":   bson.M{"$gt": now, "$lte": horizon},
+		}},
+		{TemplateBookingExpiring, bson.M{
+			"status":   bson.M{"$in": []models.BookingStatus{models.BookingStatusActive, models.BookingStatusPending}},
+			"end_time": bson.M{"$gt": now, "$lte": horizon},
+		}},
+		{TemplateOverstay, bson.M{
+			"status":   models.BookingStatusActive,
+			"end_time": bson.M{"$lt": now},
+		}},
+	}
+
+	for _, scan := range scans {
+		bookings, err := s.bookingRepo.Find(ctx, scan.filter)
+		if err != nil {
+			log.Printf("notifications: failed to scan bookings for %s: %v", scan.template, err)
+			continue
+		}
+		for i := range bookings {
+			booking := &bookings[i]
+			key := fmt.Sprintf("%s:%s", scan.template, booking.ID.Hex())
+			if err := s.Notify(ctx, booking.UserID, scan.template, s.bookingDetails(ctx, booking), key); err != nil {
+				log.Printf("notifications: failed to send %s for booking %s: %v", scan.template, booking.ID.Hex(), err)
+			}
+		}
+	}
+}
+
+// bookingDetails collects the template fields describing a booking
+func (s *NotificationService) bookingDetails(ctx context.Context, booking *models.Booking) map[string]interface{} {
+	details := map[string]interface{}{
+		"Start":    booking.StartTime.Format(notificationTimeFormat),
+		"Location": "your parking location",
+		"Plate":    "your vehicle",
+		"Spot":     "",
+		"End":      "",
+		"Duration": "",
+	}
+	if booking.EndTime != nil {
+		details["End"] = booking.EndTime.Format(notificationTimeFormat)
+	}
+	if booking.SpotNumber != nil {
+		details["Spot"] = *booking.SpotNumber
+	}
+	if location, err := s.location.GetLocation(ctx, booking.LocationID); err == nil {
+		details["Location"] = location.Name
+	}
+	if vehicle, err := s.vehicle.GetByID(ctx, booking.VehicleID); err == nil {
+		details["Plate"] = vehicle.PlateNumber
+	}
+	return details
+}
+
+// Notify renders a template and sends it on every channel the user enabled.
+// A non-empty dedupeKey guarantees the message is sent at most once per channel
+func (s *NotificationService) Notify(ctx context.Context, userID primitive.ObjectID, templateName string, data map[string]interface{}, dedupeKey string) error {
+	tmpl, ok := notificationTemplates[templateName]
+	if !ok {
+		return ErrUnknownTemplate
+	}
+
+	prefs, err := s.GetPreferences(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if prefs.IsMuted(templateName) {
+		return nil
+	}
+
+	user, err := s.user.GetByID(ctx, userID)
+	if err != nil {
+		return ErrRecipientNotFound
+	}
+	data["Name"] = user.Name
+
+	subject, body, err := tmpl.render(data)
+	if err != nil {
+		return err
+	}
+
+	for _, channel := range prefs.Channels {
+		notification := &models.Notification{
+			UserID:    userID,
+			Channel:   channel,
+			Template:  templateName,
+			To:        recipientAddress(channel, user, prefs),
+			Subject:   subject,
+			Body:      body,
+			Status:    models.NotificationStatusQueued,
+			DedupeKey: dedupeKey,
+			CreatedAt: time.Now(),
+		}
+		if err := s.repo.Create(ctx, notification); err != nil {
+			if errors.Is(err, repositories.ErrDuplicate) {
+				continue
+			}
+			return err
+		}
+
+		s.deliver(ctx, notification)
+		if err := s.repo.Update(ctx, notification); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *NotificationService) deliver(ctx context.Context, notification *models.Notification) {
+	provider, ok := s.providers[notification.Channel]
+	switch {
+	case !ok:
+		notification.Status = models.NotificationStatusSkipped
+		notification.Error = "no provider configured for channel"
+		return
+	case notification.To == "":
+		notification.Status = models.NotificationStatusSkipped
+		notification.Error = "no address for channel"
+		return
+	}
+
+	err := provider.Send(ctx, notifier.Message{
+		To:      notification.To,
+		Subject: notification.Subject,
+		Body:    notification.Body,
+	})
+	if err != nil {
+		notification.Status = models.NotificationStatusFailed
+		notification.Error = err.Error()
+		return
+	}
+
+	now := time.Now()
+	notification.Status = models.NotificationStatusSent
+	notification.SentAt = &now
+}
+
+func recipientAddress(channel models.NotificationChannel, user *models.User, prefs *models.NotificationPreferences) string {
+	switch channel {
+	case models.NotificationChannelEmail:
+		return user.Email
+	case models.NotificationChannelSMS:
+		return prefs.PhoneNumber
+	case models.NotificationChannelPush:
+		return prefs.PushToken
+	}
+	return ""
+}
+
+// GetPreferences returns the user's saved preferences or the defaults
+func (s *NotificationService) GetPreferences(ctx context.Context, userID primitive.ObjectID) (*models.NotificationPreferences, error) {
+	prefs, err := s.repo.FindPreferences(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return models.DefaultNotificationPreferences(userID), nil
+		}
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// UpdatePreferences validates and stores the user's notification preferences
+func (s *NotificationService) UpdatePreferences(ctx context.Context, prefs *models.NotificationPreferences) error {
+	for _, channel := range prefs.Channels {
+		switch channel {
+		case models.NotificationChannelEmail, models.NotificationChannelPush, models.NotificationChannelSMS:
+		default:
+			return fmt.Errorf("%w: %s", ErrInvalidChannel, channel)
+		}
+	}
+	for _, name := range prefs.Muted {
+		if !IsNotificationTemplate(name) {
+			return fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+		}
+	}
+
+	existing, err := s.repo.FindPreferences(ctx, prefs.UserID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		return err
+	}
+	if existing != nil {
+		prefs.ID = existing.ID
+	}
+
+	prefs.UpdatedAt = time.Now()
+	return s.repo.SavePreferences(ctx, prefs)
+}
+
+// GetNotifications returns the user's most recent notifications
+func (s *NotificationService) GetNotifications(ctx context.Context, userID primitive.ObjectID) ([]models.Notification, error) {
+	return s.repo.FindByUser(ctx, userID, notificationListLimit)
+}
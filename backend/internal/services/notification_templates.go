@@ -0,0 +1,65 @@
+package services
+
+import (
+	"bytes"
+	"text/template"
+)
+
+const (
+	TemplateBookingReminder = "booking_reminder"
+	TemplateBookingExpiring = "booking_expiring"
+	TemplateGateEntry       = "gate_entry_receipt"
+	TemplateGateExit        = "gate_exit_receipt"
+	TemplateLowBalance      = "low_balance"
+	TemplateOverstay        = "overstay"
+)
+
+type notificationTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newNotificationTemplate(name, subject, body string) notificationTemplate {
+	return notificationTemplate{
+		subject: template.Must(template.New(name + "_subject").Parse(subject)),
+		body:    template.Must(template.New(name + "_body").Parse(body)),
+	}
+}
+
+func (t notificationTemplate) render(data map[string]interface{}) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+	return subject.String(), body.String(), nil
+}
+
+var notificationTemplates = map[string]notificationTemplate{
+	TemplateBookingReminder: newNotificationTemplate(TemplateBookingReminder,
+		"Your parking at {{.Location}} starts soon",
+		"Hi {{.Name}}, your reservation for {{.Plate}} at {{.Location}} starts at {{.Start}}{{if .Spot}} in spot {{.Spot}}{{end}}."),
+	TemplateBookingExpiring: newNotificationTemplate(TemplateBookingExpiring,
+		"Your parking at {{.Location}} is about to end",
+		"Hi {{.Name}}, your reservation for {{.Plate}} at {{.Location}} ends at {{.End}}. Please leave on time to avoid overstay charges."),
+	TemplateGateEntry: newNotificationTemplate(TemplateGateEntry,
+		"Welcome to {{.Location}}",
+		"Hi {{.Name}}, {{.Plate}} entered {{.Location}} at {{.Time}}{{if .Spot}}. Your spot is {{.Spot}}{{end}}."),
+	TemplateGateExit: newNotificationTemplate(TemplateGateExit,
+		"Receipt for your parking at {{.Location}}",
+		"Hi {{.Name}}, {{.Plate}} left {{.Location}} at {{.Time}}. {{.Amount}} points were charged for {{.Duration}}."),
+	TemplateLowBalance: newNotificationTemplate(TemplateLowBalance,
+		"Your wallet balance is low",
+		"Hi {{.Name}}, your balance of {{.Balance}} points is below the estimated {{.Estimate}} points for your stay at {{.Location}}. Please top up your wallet."),
+	TemplateOverstay: newNotificationTemplate(TemplateOverstay,
+		"Your parking at {{.Location}} has expired",
+		"Hi {{.Name}}, your reservation for {{.Plate}} at {{.Location}} ended at {{.End}} but the vehicle has not left yet. Additional charges apply."),
+}
+
+// IsNotificationTemplate reports whether name is a known template
+func IsNotificationTemplate(name string) bool {
+	_, ok := notificationTemplates[name]
+	return ok
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"github.com/dfanso/parkme-backend/pkg/oidc"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	ErrUnknownOIDCProvider    = errors.New("unknown sign in provider")
+	ErrInvalidOIDCState       = errors.New("sign in has expired or was already completed, start again")
+	ErrOIDCLoginFailed        = errors.New("the identity provider did not confirm the sign in")
+	ErrOIDCEmailNotVerified   = errors.New("the identity provider has not verified your email address")
+	ErrOIDCDomainNotAllowed   = errors.New("your email domain may not sign in with this provider")
+	ErrOIDCNoAccount          = errors.New("no ParkMe account uses this email address")
+	ErrExternalIdentityLinked = errors.New("this account is already linked to another identity at the provider")
+)
+
+// OIDCResult is the outcome of a completed provider sign in. Users with
+// two-factor authentication get a challenge instead of tokens
+type OIDCResult struct {
+	Tokens    *dto.LoginResponse
+	Challenge *dto.MFAChallengeResponse
+}
+
+type oidcProvider struct {
+	cfg    config.OIDCProvider
+	client *oidc.Provider
+}
+
+// OIDCService signs users in with OpenID Connect providers using the
+// authorization code flow with PKCE. Provider accounts are linked to users
+// by verified email, and users are created on their first sign in where the
+// provider allows it
+type OIDCService struct {
+	cfg         *config.Config
+	repo        *repositories.ExternalIdentityRepository
+	userService *UserService
+	sessions    *AuthSessionService
+	mfa         *MFAService
+	audit       *AuditService
+	providers   map[string]*oidcProvider
+	order       []string
+}
+
+func NewOIDCService(
+	cfg *config.Config,
+	repo *repositories.ExternalIdentityRepository,
+	userService *UserService,
+	sessions *AuthSessionService,
+	mfa *MFAService,
+	audit *AuditService,
+) (*OIDCService, error) {
+	s := &OIDCService{
+		cfg:         cfg,
+		repo:        repo,
+		userService: userService,
+		sessions:    sessions,
+		mfa:         mfa,
+		audit:       audit,
+		providers:   map[string]*oidcProvider{},
+	}
+	for _, p := range cfg.OIDC.Providers {
+		if p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %q needs an issuer and a client ID", p.Name)
+		}
+		if _, ok := s.providers[p.Name]; ok {
+			return nil, fmt.Errorf("oidc provider %q is configured twice", p.Name)
+		}
+		s.providers[p.Name] = &oidcProvider{
+			cfg: p,
+			client: oidc.NewProvider(oidc.Config{
+				Issuer:       p.Issuer,
+				ClientID:     p.ClientID,
+				ClientSecret: p.ClientSecret,
+				Scopes:       p.Scopes,
+			}, nil),
+		}
+		s.order = append(s.order, p.Name)
+	}
+	return s, nil
+}
+
+// Providers lists the providers users can sign in with
+func (s *OIDCService) Providers() []dto.OIDCProviderInfo {
+	list := make([]dto.OIDCProviderInfo, 0, len(s.order))
+	for _, name := range s.order {
+		list = append(list, dto.OIDCProviderInfo{
+			Name:        name,
+			DisplayName: s.providers[name].cfg.DisplayName,
+			LoginURL:    "/api/auth/oidc/" + url.PathEscape(name) + "/login",
+		})
+	}
+	return list
+}
+
+// RedirectURL is where the browser is sent once the callback has run
+func (s *OIDCService) RedirectURL() string {
+	return s.cfg.OIDC.RedirectURL
+}
+
+func (s *OIDCService) callbackURL(name string) string {
+	return strings.TrimRight(s.cfg.OIDC.CallbackBaseURL, "/") + "/api/auth/oidc/" + url.PathEscape(name) + "/callback"
+}
+
+// BeginLogin stores a new sign in state and returns the provider URL to
+// send the browser to
+func (s *OIDCService) BeginLogin(ctx context.Context, name, loginHint string) (string, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return "", ErrUnknownOIDCProvider
+	}
+
+	secret, err := generateTokenSecret()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.RandomString(16)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	state := &models.OIDCLoginState{
+		Provider:     name,
+		SecretHash:   hashTokenSecret(secret),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(s.cfg.OIDC.StateTTL),
+	}
+	if err := s.repo.CreateState(ctx, state); err != nil {
+		return "", err
+	}
+
+	return provider.client.AuthCodeURL(ctx, s.callbackURL(name), state.ID.Hex()+"."+secret, nonce, oidc.CodeChallengeS256(verifier), loginHint)
+}
+
+// CompleteLogin handles the provider's callback: it checks the state,
+// redeems the code, verifies the ID token and signs in the linked user
+func (s *OIDCService) CompleteLogin(ctx context.Context, name, stateValue, code, userAgent, ip string) (*OIDCResult, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	stateID, secret, ok := splitSecretToken(stateValue)
+	if !ok {
+		return nil, ErrInvalidOIDCState
+	}
+	state, err := s.repo.TakeState(ctx, stateID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+	if state.Provider != name || !secretMatches(secret, state.SecretHash) || !time.Now().Before(state.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	token, err := provider.client.Exchange(ctx, s.callbackURL(name), code, state.CodeVerifier)
+	if err != nil {
+		log.Printf("oidc: %s code exchange failed: %v", name, err)
+		return nil, ErrOIDCLoginFailed
+	}
+	claims, err := provider.client.VerifyIDToken(ctx, token.IDToken, state.Nonce)
+	if err != nil {
+		log.Printf("oidc: %s ID token rejected: %v", name, err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	user, err := s.resolveUser(ctx, provider, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, err := s.mfa.Enabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		if err := CheckUserCanSignIn(user); err != nil {
+			return nil, err
+		}
+		challenge, err := s.mfa.StartChallenge(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCResult{Challenge: challenge}, nil
+	}
+	tokens, err := s.sessions.SignIn(ctx, user, userAgent, ip, false)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCResult{Tokens: tokens}, nil
+}
+
+// resolveUser returns the user linked to the provider account, linking the
+// user with the same verified email or creating one if there is none
+func (s *OIDCService) resolveUser(ctx context.Context, provider *oidcProvider, claims *oidc.Claims) (*models.User, error) {
+	now := time.Now()
+	email := strings.TrimSpace(claims.Email)
+
+	identity, err := s.repo.FindBySubject(ctx, provider.cfg.Name, claims.Subject)
+	if err == nil {
+		user, err := s.userService.GetByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.repo.Touch(ctx, identity.ID, email, now); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, repositories.ErrNotFound) {
+		return nil, err
+	}
+
+	// Unlinked provider accounts are matched by email, which the provider
+	// must vouch for
+	if email == "" || !bool(claims.EmailVerified) {
+		return nil, ErrOIDCEmailNotVerified
+	}
+	if !emailDomainAllowed(email, provider.cfg.AllowedDomains) {
+		return nil, ErrOIDCDomainNotAllowed
+	}
+
+	user, err := s.userService.FindOne(ctx, bson.M{"email": bson.M{"$in": []string{email, strings.ToLower(email)}}})
+	created := false
+	switch {
+	case err == nil:
+		// An account still waiting for its address to be verified may have
+		// been registered by someone else, the provider's user takes it over
+		// from them
+		if user.Status == models.UserStatusPendingVerification && user.EmailVerifiedAt == nil {
+			if err := s.reclaim(ctx, user, provider, now); err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, repositories.ErrNotFound):
+		if !provider.cfg.AutoCreate {
+			return nil, ErrOIDCNoAccount
+		}
+		if user, err = s.createUser(ctx, email, claims.Name, now); err != nil {
+			return nil, err
+		}
+		created = true
+	default:
+		return nil, err
+	}
+
+	err = s.repo.Create(ctx, &models.ExternalIdentity{
+		UserID:      user.ID,
+		Provider:    provider.cfg.Name,
+		Subject:     claims.Subject,
+		Email:       email,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	})
+	if err != nil {
+		if errors.Is(err, repositories.ErrDuplicate) {
+			return nil, ErrExternalIdentityLinked
+		}
+		return nil, err
+	}
+
+	if created {
+		s.record(ctx, &models.AuditEntry{Action: models.AuditOIDCUserCreated, UserID: &user.ID, Subject: email, Detail: "provider " + provider.cfg.Name})
+	}
+	s.record(ctx, &models.AuditEntry{Action: models.AuditOIDCIdentityLinked, UserID: &user.ID, Subject: email, Detail: "provider " + provider.cfg.Name})
+	return user, nil
+}
+
+// reclaim hands an account pending email verification to the provider's
+// user, who proved they own the address. Whoever registered it loses the
+// password, sessions and second factor they set up
+func (s *OIDCService) reclaim(ctx context.Context, user *models.User, provider *oidcProvider, now time.Time) error {
+	password, err := generateTokenSecret()
+	if err != nil {
+		return err
+	}
+	user.Password = password
+	if err := user.HashPassword(); err != nil {
+		return err
+	}
+	user.EmailVerifiedAt = &now
+	user.Status = models.UserStatusActive
+	if err := s.userService.Update(ctx, user); err != nil {
+		return err
+	}
+
+	if _, err := s.sessions.RevokeAll(ctx, user.ID, models.SessionRevokedAccountReclaimed); err != nil {
+		return err
+	}
+	if err := s.mfa.Reset(ctx, user, user.ID); err != nil && !errors.Is(err, ErrMFANotEnabled) {
+		return err
+	}
+	s.record(ctx, &models.AuditEntry{Action: models.AuditOIDCAccountReclaimed, UserID: &user.ID, Subject: user.Email, Detail: "provider " + provider.cfg.Name})
+	return nil
+}
+
+// createUser creates a verified user for a first provider sign in. The
+// random password is never shown, a password reset sets a usable one
+func (s *OIDCService) createUser(ctx context.Context, email, name string, now time.Time) (*models.User, error) {
+	password, err := generateTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	if len(name) < 2 {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if len(name) > 50 {
+		name = name[:50]
+	}
+
+	user := &models.User{
+		Name:            name,
+		Email:           strings.ToLower(email),
+		Password:        password,
+		Role:            models.RoleUser,
+		Status:          models.UserStatusActive,
+		EmailVerifiedAt: &now,
+	}
+	if err := user.BeforeCreate(); err != nil {
+		return nil, err
+	}
+	if err := s.userService.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func emailDomainAllowed(email string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range domains {
+		if domain == strings.ToLower(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// record writes an audit entry, logging rather than failing on error
+func (s *OIDCService) record(ctx context.Context, entry *models.AuditEntry) {
+	if err := s.audit.Record(ctx, entry); err != nil {
+		log.Printf("oidc: failed to record %s audit entry for %s: %v", entry.Action, entry.Subject, err)
+	}
+}
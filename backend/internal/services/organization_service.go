@@ -0,0 +1,727 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrOrganizationNotFound       = errors.New("organization not found")
+	ErrNotOrganizationMember      = errors.New("user is not a member of this organization")
+	ErrOrganizationMemberExists   = errors.New("user is already a member of this organization")
+	ErrMemberEmailNotFound        = errors.New("no user is registered with this email address")
+	ErrLastFleetManager           = errors.New("an organization needs at least one fleet manager")
+	ErrSpendingLimitReached       = errors.New("monthly spending limit reached")
+	ErrVehicleInOrganization      = errors.New("vehicle belongs to another organization")
+	ErrVehicleNotInOrganization   = errors.New("vehicle does not belong to this organization")
+	ErrOrganizationInviteNotFound = errors.New("no pending invitation from this organization")
+	ErrNotVehicleOwner            = errors.New("only the vehicle's owner can do this")
+	ErrInvalidStatementPeriod     = errors.New("statement period must be formatted as YYYY-MM")
+)
+
+// statementPeriodLayout formats the calendar month of a statement
+const statementPeriodLayout = "2006-01"
+
+// OrganizationService manages fleet organizations and decides which wallet
+// pays for the stays of their vehicles. Spending limits and statements use
+// calendar months in the server's time zone
+type OrganizationService struct {
+	repo     *repositories.OrganizationRepository
+	vehicles *VehicleService
+	wallet   *WalletService
+	user     *UserService
+}
+
+func NewOrganizationService(
+	repo *repositories.OrganizationRepository,
+	vehicleService *VehicleService,
+	walletService *WalletService,
+	userService *UserService,
+) *OrganizationService {
+	return &OrganizationService{
+		repo:     repo,
+		vehicles: vehicleService,
+		wallet:   walletService,
+		user:     userService,
+	}
+}
+
+// monthStart returns the first instant of the calendar month holding t
+func monthStart(t time.Time) time.Time {
+	year, month, _ := t.Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+}
+
+// Create stores a new organization with its creator as the first fleet
+// manager and opens its wallet
+func (s *OrganizationService) Create(ctx context.Context, organization *models.Organization, creatorID primitive.ObjectID) error {
+	if organization.BillingPolicy == "" {
+		organization.BillingPolicy = models.BillingOrganizationFirst
+	}
+	if err := organization.Validate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	organization.ID = primitive.NilObjectID
+	organization.CreatedBy = creatorID
+	organization.CreatedAt = now
+	organization.UpdatedAt = now
+	if err := s.repo.Create(ctx, organization); err != nil {
+		return err
+	}
+
+	err := s.repo.CreateMember(ctx, &models.OrgMember{
+		OrganizationID: organization.ID,
+		UserID:         creatorID,
+		Role:           models.OrgRoleFleetManager,
+		Status:         models.OrgMemberActive,
+		AcceptedAt:     &now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.wallet.GetOrCreateOrganizationWallet(ctx, organization.ID)
+	return err
+}
+
+func (s *OrganizationService) Get(ctx context.Context, id primitive.ObjectID) (*models.Organization, error) {
+	organization, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, err
+	}
+	return organization, nil
+}
+
+// GetMembership returns the user's membership of the organization. A
+// pending invitation is not a membership yet
+func (s *OrganizationService) GetMembership(ctx context.Context, organizationID, userID primitive.ObjectID) (*models.OrgMember, error) {
+	member, err := s.repo.FindMember(ctx, organizationID, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrNotOrganizationMember
+		}
+		return nil, err
+	}
+	if !member.IsActive() {
+		return nil, ErrNotOrganizationMember
+	}
+	return member, nil
+}
+
+// AcceptMembership makes the user a member of the organization that invited them
+func (s *OrganizationService) AcceptMembership(ctx context.Context, organizationID, userID primitive.ObjectID) error {
+	err := s.repo.AcceptMember(ctx, organizationID, userID, time.Now())
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrOrganizationInviteNotFound
+	}
+	return err
+}
+
+// GetForUser returns the organizations the user belongs to or was invited
+// to, with their role and membership status
+func (s *OrganizationService) GetForUser(ctx context.Context, userID primitive.ObjectID) ([]dto.OrganizationMembership, error) {
+	members, err := s.repo.FindMemberships(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberships := make(map[primitive.ObjectID]models.OrgMember, len(members))
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, member := range members {
+		memberships[member.OrganizationID] = member
+		ids = append(ids, member.OrganizationID)
+	}
+
+	organizations, err := s.repo.FindByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dto.OrganizationMembership, 0, len(organizations))
+	for _, organization := range organizations {
+		member := memberships[organization.ID]
+		status := models.OrgMemberActive
+		if !member.IsActive() {
+			status = models.OrgMemberInvited
+		}
+		result = append(result, dto.OrganizationMembership{Organization: organization, Role: member.Role, Status: status})
+	}
+	return result, nil
+}
+
+// GetAll returns every organization
+func (s *OrganizationService) GetAll(ctx context.Context) ([]dto.OrganizationMembership, error) {
+	organizations, err := s.repo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dto.OrganizationMembership, 0, len(organizations))
+	for _, organization := range organizations {
+		result = append(result, dto.OrganizationMembership{Organization: organization})
+	}
+	return result, nil
+}
+
+// Update changes the name and billing policy of an organization
+func (s *OrganizationService) Update(ctx context.Context, id primitive.ObjectID, req dto.OrganizationRequest) (*models.Organization, error) {
+	organization, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	organization.Name = req.Name
+	if req.BillingPolicy != "" {
+		organization.BillingPolicy = req.BillingPolicy
+	}
+	if err := organization.Validate(); err != nil {
+		return nil, err
+	}
+	organization.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, organization); err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// GetMembers returns the members of an organization with what each spent
+// from its wallet this month
+func (s *OrganizationService) GetMembers(ctx context.Context, organizationID primitive.ObjectID) ([]dto.OrgMemberResponse, error) {
+	members, err := s.repo.FindMembers(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.OrgMemberResponse, 0, len(members))
+	for _, member := range members {
+		response, err := s.memberResponse(ctx, member)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *response)
+	}
+	return result, nil
+}
+
+func (s *OrganizationService) memberResponse(ctx context.Context, member models.OrgMember) (*dto.OrgMemberResponse, error) {
+	response := &dto.OrgMemberResponse{OrgMember: member}
+	if user, err := s.user.GetByID(ctx, member.UserID); err == nil {
+		response.Name = user.Name
+		response.Email = user.Email
+	}
+
+	spent, err := s.wallet.GetOrganizationCharges(ctx, member.OrganizationID, member.UserID, monthStart(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	response.SpentThisMonth = spent
+	return response, nil
+}
+
+// GetMember returns a single member with what they spent this month
+func (s *OrganizationService) GetMember(ctx context.Context, organizationID, userID primitive.ObjectID) (*dto.OrgMemberResponse, error) {
+	member, err := s.GetMembership(ctx, organizationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.memberResponse(ctx, *member)
+}
+
+// AddMember invites the user registered with the email address to the
+// organization. They become a member once they accept
+func (s *OrganizationService) AddMember(ctx context.Context, organizationID primitive.ObjectID, req dto.AddOrgMemberRequest) (*models.OrgMember, error) {
+	email := strings.TrimSpace(req.Email)
+	user, err := s.user.FindOne(ctx, bson.M{"email": bson.M{"$in": []string{email, strings.ToLower(email)}}})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrMemberEmailNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	member := &models.OrgMember{
+		OrganizationID: organizationID,
+		UserID:         user.ID,
+		Role:           req.Role,
+		SpendingLimit:  req.SpendingLimit,
+		Status:         models.OrgMemberInvited,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if member.Role == "" {
+		member.Role = models.OrgRoleDriver
+	}
+	if err := member.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.CreateMember(ctx, member); err != nil {
+		if errors.Is(err, repositories.ErrDuplicate) {
+			return nil, ErrOrganizationMemberExists
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+// ensureManagerRemains refuses to demote or remove the last fleet manager
+func (s *OrganizationService) ensureManagerRemains(ctx context.Context, member *models.OrgMember) error {
+	if !member.IsManager() {
+		return nil
+	}
+	managers, err := s.repo.CountManagers(ctx, member.OrganizationID)
+	if err != nil {
+		return err
+	}
+	if managers <= 1 {
+		return ErrLastFleetManager
+	}
+	return nil
+}
+
+// UpdateMember changes a member's role and spending limit
+func (s *OrganizationService) UpdateMember(ctx context.Context, organizationID, userID primitive.ObjectID, req dto.UpdateOrgMemberRequest) (*models.OrgMember, error) {
+	member, err := s.GetMembership(ctx, organizationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Role != "" && req.Role != member.Role {
+		if err := s.ensureManagerRemains(ctx, member); err != nil {
+			return nil, err
+		}
+		member.Role = req.Role
+	}
+	member.SpendingLimit = req.SpendingLimit
+	if err := member.Validate(); err != nil {
+		return nil, err
+	}
+
+	member.UpdatedAt = time.Now()
+	if err := s.repo.UpdateMember(ctx, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// RemoveMember takes a user out of the organization. Vehicles assigned to
+// them stay in the organization but their stays are charged to the driver's
+// own wallet until the vehicle is assigned to a member again
+func (s *OrganizationService) RemoveMember(ctx context.Context, organizationID, userID primitive.ObjectID) error {
+	member, err := s.GetMembership(ctx, organizationID, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.ensureManagerRemains(ctx, member); err != nil {
+		return err
+	}
+	return s.repo.DeleteMember(ctx, member.ID)
+}
+
+func (s *OrganizationService) GetVehicles(ctx context.Context, organizationID primitive.ObjectID) ([]models.Vehicle, error) {
+	return s.vehicles.GetByOrganization(ctx, organizationID)
+}
+
+// AssignVehicle brings a member's vehicle into the organization, or assigns
+// one of its vehicles to another driver. The vehicle's owner stays its owner.
+// A vehicle brought in by someone other than its owner only joins once the
+// owner accepts
+func (s *OrganizationService) AssignVehicle(ctx context.Context, organizationID, actorID primitive.ObjectID, req dto.AssignOrgVehicleRequest) (*models.Vehicle, error) {
+	vehicle, err := s.vehicles.GetByID(ctx, req.VehicleID)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle.OrganizationID != nil && *vehicle.OrganizationID != organizationID {
+		return nil, ErrVehicleInOrganization
+	}
+
+	driverID := vehicle.Owner
+	if vehicle.AssignedDriver != nil {
+		driverID = *vehicle.AssignedDriver
+	}
+	if req.DriverID != nil {
+		driverID = *req.DriverID
+	}
+	if _, err := s.GetMembership(ctx, organizationID, driverID); err != nil {
+		return nil, err
+	}
+
+	if vehicle.OrganizationID != nil {
+		if err := s.vehicles.AssignDriver(ctx, vehicle.ID, organizationID, driverID); err != nil {
+			return nil, err
+		}
+		vehicle.AssignedDriver = &driverID
+		return vehicle, nil
+	}
+
+	// Only vehicles registered by a member can be brought in
+	if _, err := s.GetMembership(ctx, organizationID, vehicle.Owner); err != nil {
+		return nil, err
+	}
+	if vehicle.Owner == actorID {
+		if err := s.vehicles.JoinOrganization(ctx, vehicle.ID, organizationID, driverID); err != nil {
+			return nil, err
+		}
+		vehicle.OrganizationID = &organizationID
+	} else {
+		if err := s.vehicles.InviteToOrganization(ctx, vehicle.ID, organizationID, driverID); err != nil {
+			return nil, err
+		}
+		vehicle.OrganizationInvite = &organizationID
+	}
+	vehicle.AssignedDriver = &driverID
+	return vehicle, nil
+}
+
+// AcceptVehicle lets the owner accept the organization's invitation for
+// their vehicle. The owner and the driver must still be members
+func (s *OrganizationService) AcceptVehicle(ctx context.Context, organizationID, vehicleID, ownerID primitive.ObjectID) (*models.Vehicle, error) {
+	vehicle, err := s.vehicles.GetByID(ctx, vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle.Owner != ownerID {
+		return nil, ErrNotVehicleOwner
+	}
+	if vehicle.OrganizationInvite == nil || *vehicle.OrganizationInvite != organizationID {
+		return nil, ErrOrganizationInviteNotFound
+	}
+	if _, err := s.GetMembership(ctx, organizationID, ownerID); err != nil {
+		return nil, err
+	}
+	if vehicle.AssignedDriver != nil {
+		if _, err := s.GetMembership(ctx, organizationID, *vehicle.AssignedDriver); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.vehicles.AcceptOrganization(ctx, vehicle.ID, ownerID, organizationID); err != nil {
+		return nil, err
+	}
+	vehicle.OrganizationID = &organizationID
+	vehicle.OrganizationInvite = nil
+	return vehicle, nil
+}
+
+// ReleaseVehicle takes a vehicle out of the organization, or withdraws its
+// invitation. The vehicle stays with its owner
+func (s *OrganizationService) ReleaseVehicle(ctx context.Context, organizationID, vehicleID primitive.ObjectID) error {
+	if _, err := s.vehicles.GetByID(ctx, vehicleID); err != nil {
+		return err
+	}
+	return s.vehicles.LeaveOrganization(ctx, vehicleID, organizationID)
+}
+
+// WithdrawVehicle lets the owner take their vehicle out of the organization
+// or decline its invitation, whether or not they are still a member
+func (s *OrganizationService) WithdrawVehicle(ctx context.Context, organizationID, vehicleID, ownerID primitive.ObjectID) error {
+	vehicle, err := s.vehicles.GetByID(ctx, vehicleID)
+	if err != nil {
+		return err
+	}
+	if vehicle.Owner != ownerID {
+		return ErrNotVehicleOwner
+	}
+	return s.vehicles.LeaveOrganization(ctx, vehicleID, organizationID)
+}
+
+func (s *OrganizationService) GetWallet(ctx context.Context, organizationID primitive.ObjectID) (*models.Wallet, error) {
+	return s.wallet.GetOrCreateOrganizationWallet(ctx, organizationID)
+}
+
+func (s *OrganizationService) TopUp(ctx context.Context, organizationID, userID primitive.ObjectID, amount float64) (*models.Transaction, error) {
+	return s.wallet.TopUpOrganization(ctx, organizationID, userID, amount)
+}
+
+// payingMembership returns the organization and membership whose wallet pays
+// for the driver's stays in the vehicle. Both are nil when the driver pays
+// from their own wallet: the vehicle is not a fleet vehicle, the driver is
+// not a member or the organization bills drivers personally
+func (s *OrganizationService) payingMembership(ctx context.Context, vehicle *models.Vehicle, driverID primitive.ObjectID) (*models.Organization, *models.OrgMember, error) {
+	if vehicle.OrganizationID == nil {
+		return nil, nil, nil
+	}
+	organization, err := s.Get(ctx, *vehicle.OrganizationID)
+	if err != nil {
+		if errors.Is(err, ErrOrganizationNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	if organization.BillingPolicy == models.BillingPersonal {
+		return nil, nil, nil
+	}
+	member, err := s.GetMembership(ctx, organization.ID, driverID)
+	if err != nil {
+		if errors.Is(err, ErrNotOrganizationMember) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return organization, member, nil
+}
+
+// remainingLimit returns what the member may still spend this month
+func (s *OrganizationService) remainingLimit(ctx context.Context, member *models.OrgMember) (float64, error) {
+	if member.SpendingLimit == nil {
+		return math.Inf(1), nil
+	}
+	spent, err := s.monthSpend(ctx, member, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return math.Max(0, *member.SpendingLimit-spent), nil
+}
+
+// monthSpend returns what the member charged to the organization this month,
+// from the spend counter or, before the month's first charge, the wallet
+func (s *OrganizationService) monthSpend(ctx context.Context, member *models.OrgMember, now time.Time) (float64, error) {
+	spend, err := s.repo.FindMemberSpend(ctx, member.ID, now.Format(statementPeriodLayout))
+	if err == nil {
+		return spend.Spent, nil
+	}
+	if !errors.Is(err, repositories.ErrNotFound) {
+		return 0, err
+	}
+	return s.wallet.GetOrganizationCharges(ctx, member.OrganizationID, member.UserID, monthStart(now))
+}
+
+// reserveSpend counts amount against the member's monthly limit in a single
+// conditional write, failing with ErrSpendingLimitReached when it would
+// exceed the limit. The month's counter is started from the wallet's charges
+// the first time it is needed
+func (s *OrganizationService) reserveSpend(ctx context.Context, member *models.OrgMember, amount float64, now time.Time) error {
+	period := now.Format(statementPeriodLayout)
+	err := s.repo.AddMemberSpend(ctx, member.ID, period, amount, member.SpendingLimit, now)
+	if !errors.Is(err, repositories.ErrNotFound) {
+		return err
+	}
+
+	// No match: either the limit is reached or the month has no counter yet
+	if _, err := s.repo.FindMemberSpend(ctx, member.ID, period); err == nil {
+		return ErrSpendingLimitReached
+	} else if !errors.Is(err, repositories.ErrNotFound) {
+		return err
+	}
+	spent, err := s.wallet.GetOrganizationCharges(ctx, member.OrganizationID, member.UserID, monthStart(now))
+	if err != nil {
+		return err
+	}
+	err = s.repo.CreateMemberSpend(ctx, &models.OrgMemberSpend{
+		MemberID:  member.ID,
+		Period:    period,
+		Spent:     spent,
+		UpdatedAt: now,
+	})
+	if err != nil && !errors.Is(err, repositories.ErrDuplicate) {
+		return err
+	}
+
+	err = s.repo.AddMemberSpend(ctx, member.ID, period, amount, member.SpendingLimit, now)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrSpendingLimitReached
+	}
+	return err
+}
+
+// ChargeStay takes the price of a stay from the wallet the vehicle's
+// organization policy names. An organization billing first falls back to the
+// driver's own wallet when its wallet or the driver's limit cannot cover it
+func (s *OrganizationService) ChargeStay(ctx context.Context, vehicle *models.Vehicle, driverID primitive.ObjectID, amount float64, description string) (*models.Transaction, error) {
+	organization, member, err := s.payingMembership(ctx, vehicle, driverID)
+	if err != nil {
+		return nil, err
+	}
+	if organization == nil {
+		return s.wallet.Deduct(ctx, driverID, amount, description)
+	}
+
+	transaction, err := s.chargeOrganization(ctx, member, vehicle, amount, description)
+	if err == nil {
+		return transaction, nil
+	}
+	fallback := errors.Is(err, ErrSpendingLimitReached) ||
+		errors.Is(err, ErrInsufficientBalance) ||
+		errors.Is(err, ErrWalletNotFound)
+	if organization.BillingPolicy == models.BillingOrganizationFirst && fallback {
+		return s.wallet.Deduct(ctx, driverID, amount, description)
+	}
+	return nil, err
+}
+
+func (s *OrganizationService) chargeOrganization(ctx context.Context, member *models.OrgMember, vehicle *models.Vehicle, amount float64, description string) (*models.Transaction, error) {
+	now := time.Now()
+	if err := s.reserveSpend(ctx, member, amount, now); err != nil {
+		return nil, err
+	}
+
+	transaction, err := s.wallet.ChargeOrganization(ctx, member.OrganizationID, member.UserID, vehicle.ID, amount, description)
+	if err != nil {
+		// Nothing was charged, give the amount back to the limit
+		if releaseErr := s.repo.AddMemberSpend(ctx, member.ID, now.Format(statementPeriodLayout), -amount, nil, time.Now()); releaseErr != nil {
+			return nil, fmt.Errorf("%w (and failed to release spending limit: %v)", err, releaseErr)
+		}
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// AvailableBalance returns how much the wallets that would pay for the
+// driver's stay in the vehicle can cover
+func (s *OrganizationService) AvailableBalance(ctx context.Context, vehicleID, driverID primitive.ObjectID) (float64, error) {
+	vehicle, err := s.vehicles.GetByID(ctx, vehicleID)
+	if err != nil {
+		if errors.Is(err, ErrVehicleNotFound) {
+			return s.wallet.GetBalance(ctx, driverID)
+		}
+		return 0, err
+	}
+	organization, member, err := s.payingMembership(ctx, vehicle, driverID)
+	if err != nil {
+		return 0, err
+	}
+	if organization == nil {
+		return s.wallet.GetBalance(ctx, driverID)
+	}
+
+	wallet, err := s.GetWallet(ctx, organization.ID)
+	if err != nil {
+		return 0, err
+	}
+	remaining, err := s.remainingLimit(ctx, member)
+	if err != nil {
+		return 0, err
+	}
+	available := math.Min(wallet.Balance, remaining)
+
+	if organization.BillingPolicy == models.BillingOrganizationFirst {
+		personal, err := s.wallet.GetBalance(ctx, driverID)
+		if err != nil {
+			return 0, err
+		}
+		available = math.Max(available, personal)
+	}
+	return available, nil
+}
+
+// transactionNet is how a transaction changes the wallet balance
+func transactionNet(transaction models.Transaction) float64 {
+	if transaction.Type == models.TransactionTypeDeduct {
+		return -transaction.Amount
+	}
+	return transaction.Amount
+}
+
+// GetStatement summarizes the organization wallet over the calendar month
+// given as YYYY-MM, with charges broken down per driver and per vehicle
+func (s *OrganizationService) GetStatement(ctx context.Context, organizationID primitive.ObjectID, period string) (*dto.OrganizationStatement, error) {
+	from, err := time.ParseInLocation(statementPeriodLayout, period, time.Local)
+	if err != nil {
+		return nil, ErrInvalidStatementPeriod
+	}
+	to := from.AddDate(0, 1, 0)
+
+	organization, err := s.Get(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The closing balance is the current one less everything after the month
+	wallet, later, err := s.wallet.GetOrganizationTransactions(ctx, organizationID, &to, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, transactions, err := s.wallet.GetOrganizationTransactions(ctx, organizationID, &from, &to)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &dto.OrganizationStatement{
+		OrganizationID: organization.ID,
+		Name:           organization.Name,
+		Period:         from.Format(statementPeriodLayout),
+		From:           from,
+		To:             to,
+		ClosingBalance: wallet.Balance,
+		Drivers:        []dto.StatementLine{},
+		Vehicles:       []dto.StatementLine{},
+		Transactions:   transactions,
+	}
+	for _, transaction := range later {
+		statement.ClosingBalance -= transactionNet(transaction)
+	}
+	statement.OpeningBalance = statement.ClosingBalance
+
+	drivers := map[primitive.ObjectID]*dto.StatementLine{}
+	vehicles := map[primitive.ObjectID]*dto.StatementLine{}
+	for _, transaction := range transactions {
+		statement.OpeningBalance -= transactionNet(transaction)
+		switch transaction.Type {
+		case models.TransactionTypeTopUp:
+			statement.TopUps += transaction.Amount
+		case models.TransactionTypeRefund:
+			statement.Refunds += transaction.Amount
+		case models.TransactionTypeDeduct:
+			statement.Charges += transaction.Amount
+			if transaction.UserID != nil {
+				addStatementLine(drivers, *transaction.UserID, transaction.Amount)
+			}
+			if transaction.VehicleID != nil {
+				addStatementLine(vehicles, *transaction.VehicleID, transaction.Amount)
+			}
+		}
+	}
+
+	for id, line := range drivers {
+		if user, err := s.user.GetByID(ctx, id); err == nil {
+			line.Label = user.Name
+		}
+		statement.Drivers = append(statement.Drivers, *line)
+	}
+	for id, line := range vehicles {
+		if vehicle, err := s.vehicles.GetByID(ctx, id); err == nil {
+			line.Label = vehicle.PlateNumber
+		}
+		statement.Vehicles = append(statement.Vehicles, *line)
+	}
+	sortStatementLines(statement.Drivers)
+	sortStatementLines(statement.Vehicles)
+	return statement, nil
+}
+
+func addStatementLine(lines map[primitive.ObjectID]*dto.StatementLine, id primitive.ObjectID, amount float64) {
+	line, ok := lines[id]
+	if !ok {
+		line = &dto.StatementLine{ID: id}
+		lines[id] = line
+	}
+	line.Charges += amount
+	line.Count++
+}
+
+// sortStatementLines puts the largest spenders first
+func sortStatementLines(lines []dto.StatementLine) {
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Charges != lines[j].Charges {
+			return lines[i].Charges > lines[j].Charges
+		}
+		return lines[i].Label < lines[j].Label
+	})
+}
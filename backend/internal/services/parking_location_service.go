@@ -3,29 +3,48 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/dfanso/parkme-backend/internal/dto"
+	"github.com/dfanso/parkme-backend/internal/events"
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var (
 	ErrLocationNotFound = errors.New("parking location not found")
 	ErrSlotNotFound     = errors.New("parking slot not found")
+	ErrLocationClosed   = errors.New("parking location is closed")
+	ErrInvalidLocation  = errors.New("invalid parking location")
+	ErrSlotTaken        = errors.New("parking slot is already occupied")
+)
+
+const (
+	defaultReadingLimit = 100
+	maxReadingLimit     = 1000
 )
 
 type ParkingLocationService struct {
-	repo *repositories.ParkingLocationRepository
+	repo     *repositories.ParkingLocationRepository
+	readings *repositories.SensorReadingRepository
+	bus      *events.Bus
 }
 
-func NewParkingLocationService(repo *repositories.ParkingLocationRepository) *ParkingLocationService {
+func NewParkingLocationService(repo *repositories.ParkingLocationRepository, readings *repositories.SensorReadingRepository, bus *events.Bus) *ParkingLocationService {
 	return &ParkingLocationService{
-		repo: repo,
+		repo:     repo,
+		readings: readings,
+		bus:      bus,
 	}
 }
 
 func (s *ParkingLocationService) CreateLocation(ctx context.Context, location *models.ParkingLocation) error {
+	if err := location.ValidateSchedule(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLocation, err)
+	}
 	location.CreatedAt = time.Now()
 	location.UpdatedAt = time.Now()
 	location.TotalSlots = len(location.Slots)
@@ -47,7 +66,52 @@ func (s *ParkingLocationService) GetAllLocations(ctx context.Context) ([]models.
 	return s.repo.FindAll(ctx)
 }
 
+// FindNearby returns open/closed status, free slots and price for locations
+// within radius meters of the given coordinates
+func (s *ParkingLocationService) FindNearby(ctx context.Context, lat, lng, radius float64) ([]dto.NearbyLocationResponse, error) {
+	locations, err := s.repo.FindNearby(ctx, lat, lng, radius)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	results := make([]dto.NearbyLocationResponse, 0, len(locations))
+	for _, location := range locations {
+		isOpen, reason := location.IsOpenAt(now)
+		results = append(results, dto.NearbyLocationResponse{
+			ID:             location.ID,
+			Name:           location.Name,
+			Address:        location.Address,
+			Location:       location.Location,
+			DistanceMeters: location.Distance,
+			IsOpen:         isOpen,
+			ClosedReason:   reason,
+			FreeSlots:      location.FreeSlots(),
+			TotalSlots:     len(location.Slots),
+			HourlyRate:     location.CurrentRate(),
+		})
+	}
+	return results, nil
+}
+
+// EnsureOpen returns the location if it is open at the given time, otherwise
+// an ErrLocationClosed error carrying the reason
+func (s *ParkingLocationService) EnsureOpen(ctx context.Context, id primitive.ObjectID, at time.Time) (*models.ParkingLocation, error) {
+	location, err := s.GetLocation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if isOpen, reason := location.IsOpenAt(at); !isOpen {
+		return nil, fmt.Errorf("%w: %s", ErrLocationClosed, reason)
+	}
+	return location, nil
+}
+
 func (s *ParkingLocationService) UpdateLocation(ctx context.Context, location *models.ParkingLocation) error {
+	if err := location.ValidateSchedule(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLocation, err)
+	}
 	location.UpdatedAt = time.Now()
 	location.TotalSlots = len(location.Slots)
 	return s.repo.Update(ctx, location)
@@ -60,19 +124,229 @@ func (s *ParkingLocationService) UpdateSlotStatus(ctx context.Context, locationI
 		return err
 	}
 
-	slotExists := false
-	for _, slot := range location.Slots {
-		if slot.Number == slotNumber {
-			slotExists = true
-			break
+	slotIndex := location.FindSlot(slotNumber)
+	if slotIndex == -1 {
+		return ErrSlotNotFound
+	}
+
+	if err := s.repo.UpdateSlotStatus(ctx, locationID, slotNumber, isOccupied); err != nil {
+		return err
+	}
+
+	// Only notify subscribers about actual changes, sensors repeat the same state every cycle
+	if location.Slots[slotIndex].IsOccupied != isOccupied {
+		location.Slots[slotIndex].IsOccupied = isOccupied
+		s.publishSlotChange(location, slotNumber, isOccupied)
+	}
+
+	return nil
+}
+
+// ReserveSlot occupies a free slot, failing with ErrSlotTaken when another
+// vehicle got it first
+func (s *ParkingLocationService) ReserveSlot(ctx context.Context, locationID primitive.ObjectID, slotNumber string) error {
+	location, err := s.repo.ReserveSlot(ctx, locationID, slotNumber)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrSlotTaken
 		}
+		return err
 	}
 
-	if !slotExists {
-		return ErrSlotNotFound
+	s.publishSlotChange(location, slotNumber, true)
+	return nil
+}
+
+// RecordSensorReading stores a raw slot sensor reading and only changes the
+// slot's occupancy once the location's sensor policy considers the new state
+// stable: enough consecutive agreeing readings spanning the minimum duration
+func (s *ParkingLocationService) RecordSensorReading(ctx context.Context, locationID primitive.ObjectID, slotNumber string, isOccupied bool) (*dto.SlotReadingResponse, error) {
+	snapshot, err := s.RecordSensorSnapshot(ctx, locationID, []dto.SlotReading{
+		{SpotNumber: slotNumber, IsOccupied: isOccupied},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot.Slots[0], nil
+}
+
+// RecordSensorSnapshot stores the raw readings of every slot a controller
+// reports, debounces each one and applies the slots that changed in a single
+// write. The returned free count reflects the stored state after the write
+func (s *ParkingLocationService) RecordSensorSnapshot(ctx context.Context, locationID primitive.ObjectID, readings []dto.SlotReading) (*dto.SlotSnapshotResponse, error) {
+	if len(readings) == 0 {
+		return nil, fmt.Errorf("%w: snapshot contains no slots", ErrInvalidSensorData)
+	}
+
+	location, err := s.GetLocation(ctx, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool, len(readings))
+	raw := make([]models.SensorReading, 0, len(readings))
+	for _, reading := range readings {
+		if reading.SpotNumber == "" {
+			return nil, fmt.Errorf("%w: spot number is required", ErrInvalidSensorData)
+		}
+		if seen[reading.SpotNumber] {
+			return nil, fmt.Errorf("%w: spot %s reported twice", ErrInvalidSensorData, reading.SpotNumber)
+		}
+		seen[reading.SpotNumber] = true
+		if location.FindSlot(reading.SpotNumber) == -1 {
+			return nil, fmt.Errorf("%w: %s", ErrSlotNotFound, reading.SpotNumber)
+		}
+		raw = append(raw, models.SensorReading{
+			LocationID: locationID,
+			SlotNumber: reading.SpotNumber,
+			IsOccupied: reading.IsOccupied,
+			ReceivedAt: now,
+		})
+	}
+	if err := s.readings.CreateMany(ctx, raw); err != nil {
+		return nil, err
+	}
+
+	results := make([]dto.SlotReadingResponse, 0, len(readings))
+	changes := make(map[string]bool)
+	for _, reading := range readings {
+		result := dto.SlotReadingResponse{
+			LocationID:  locationID,
+			SlotNumber:  reading.SpotNumber,
+			RawOccupied: reading.IsOccupied,
+			IsOccupied:  location.Slots[location.FindSlot(reading.SpotNumber)].IsOccupied,
+		}
+		if reading.IsOccupied != result.IsOccupied {
+			stable, err := s.isStable(ctx, location, reading.SpotNumber, reading.IsOccupied, now)
+			if err != nil {
+				return nil, err
+			}
+			if stable {
+				changes[reading.SpotNumber] = reading.IsOccupied
+				result.IsOccupied = reading.IsOccupied
+				result.Changed = true
+			} else {
+				result.Pending = true
+			}
+		}
+		results = append(results, result)
+	}
+
+	if len(changes) > 0 {
+		location, err = s.repo.UpdateSlotStatuses(ctx, locationID, changes)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return nil, ErrLocationNotFound
+			}
+			return nil, err
+		}
+		s.publishSlotChanges(location, changes)
+	}
+
+	return &dto.SlotSnapshotResponse{
+		LocationID: locationID,
+		Slots:      results,
+		Changed:    len(changes),
+		FreeSlots:  location.FreeSlots(),
+		TotalSlots: len(location.Slots),
+	}, nil
+}
+
+// isStable reports whether the readings for a slot agree with isOccupied, in
+// the number and over the duration the sensor policy requires. Every reading
+// in the window, whichever of the sample count and the minimum stable time
+// reaches further back, must agree. Occupying and vacating use separate
+// sample counts for hysteresis
+func (s *ParkingLocationService) isStable(ctx context.Context, location *models.ParkingLocation, slotNumber string, isOccupied bool, now time.Time) (bool, error) {
+	policy := location.EffectiveSensorPolicy()
+	samples := policy.VacateSamples
+	if isOccupied {
+		samples = policy.OccupySamples
+	}
+	minStable := policy.MinStable()
+	cutoff := now.Add(-minStable)
+
+	window, err := s.readings.FindSince(ctx, location.ID, slotNumber, cutoff)
+	if err != nil {
+		return false, err
+	}
+	if len(window) < samples {
+		window, err = s.readings.FindLatest(ctx, location.ID, slotNumber, int64(samples))
+		if err != nil {
+			return false, err
+		}
+		if len(window) < samples {
+			return false, nil
+		}
+	}
+	for _, reading := range window {
+		if reading.IsOccupied != isOccupied {
+			return false, nil
+		}
 	}
 
-	return s.repo.UpdateSlotStatus(ctx, locationID, slotNumber, isOccupied)
+	// The readings agreed for long enough if the window reaches the cutoff,
+	// or the reading just before it agreed as well
+	if minStable == 0 || !window[len(window)-1].ReceivedAt.After(cutoff) {
+		return true, nil
+	}
+	before, err := s.readings.FindLastBefore(ctx, location.ID, slotNumber, cutoff)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return before.IsOccupied == isOccupied, nil
+}
+
+// GetSensorReadings returns raw readings for a location, newest first,
+// optionally limited to one slot and to readings received after since
+func (s *ParkingLocationService) GetSensorReadings(ctx context.Context, locationID primitive.ObjectID, slotNumber string, since *time.Time, limit int64) ([]models.SensorReading, error) {
+	location, err := s.GetLocation(ctx, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"location_id": locationID}
+	if slotNumber != "" {
+		if location.FindSlot(slotNumber) == -1 {
+			return nil, ErrSlotNotFound
+		}
+		filter["slot_number"] = slotNumber
+	}
+	if since != nil {
+		filter["received_at"] = bson.M{"$gte": *since}
+	}
+
+	if limit <= 0 {
+		limit = defaultReadingLimit
+	}
+	if limit > maxReadingLimit {
+		limit = maxReadingLimit
+	}
+	return s.readings.Find(ctx, filter, limit)
+}
+
+// publishSlotChange announces a slot change and the resulting free count
+func (s *ParkingLocationService) publishSlotChange(location *models.ParkingLocation, slotNumber string, isOccupied bool) {
+	s.publishSlotChanges(location, map[string]bool{slotNumber: isOccupied})
+}
+
+// publishSlotChanges announces each changed slot followed by a single
+// update of the free count
+func (s *ParkingLocationService) publishSlotChanges(location *models.ParkingLocation, changes map[string]bool) {
+	for slotNumber, isOccupied := range changes {
+		s.bus.Publish(events.New(events.TypeSlotStatusChanged, location.ID, events.SlotStatusChanged{
+			SlotNumber: slotNumber,
+			IsOccupied: isOccupied,
+		}))
+	}
+	s.bus.Publish(events.New(events.TypeFreeCountChanged, location.ID, events.FreeCountChanged{
+		FreeSlots:  location.FreeSlots(),
+		TotalSlots: len(location.Slots),
+	}))
 }
 
 func (s *ParkingLocationService) DeleteLocation(ctx context.Context, id primitive.ObjectID) error {
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const mismatchListLimit = 200
+
+// ReconciliationService compares slot occupancy with bookings and flags
+// vehicles parked without a booking, in the wrong slot or in a reserved slot
+type ReconciliationService struct {
+	repo        *repositories.OccupancyMismatchRepository
+	bookingRepo *repositories.BookingRepository
+	location    *ParkingLocationService
+	booking     *BookingService
+	guest       *GuestService
+	bus         *events.Bus
+	interval    time.Duration
+	grace       time.Duration
+	reassign    bool
+}
+
+func NewReconciliationService(
+	cfg *localconfig.Config,
+	repo *repositories.OccupancyMismatchRepository,
+	bookingRepo *repositories.BookingRepository,
+	locationService *ParkingLocationService,
+	bookingService *BookingService,
+	guestService *GuestService,
+	bus *events.Bus,
+) *ReconciliationService {
+	return &ReconciliationService{
+		repo:        repo,
+		bookingRepo: bookingRepo,
+		location:    locationService,
+		booking:     bookingService,
+		guest:       guestService,
+		bus:         bus,
+		interval:    cfg.Reconciliation.Interval,
+		grace:       cfg.Reconciliation.Grace,
+		reassign:    cfg.Reconciliation.Reassign,
+	}
+}
+
+// Start reconciles every location periodically until ctx is cancelled
+func (s *ReconciliationService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.reconcileAll(ctx)
+			}
+		}
+	}()
+}
+
+func (s *ReconciliationService) reconcileAll(ctx context.Context) {
+	locations, err := s.location.GetAllLocations(ctx)
+	if err != nil {
+		log.Printf("reconciliation: failed to load locations: %v", err)
+		return
+	}
+	for i := range locations {
+		if err := s.reconcileLocation(ctx, &locations[i], time.Now()); err != nil {
+			log.Printf("reconciliation: failed to reconcile location %s: %v", locations[i].ID.Hex(), err)
+		}
+	}
+}
+
+type mismatchKey struct {
+	slot         string
+	mismatchType models.MismatchType
+}
+
+// detectMismatches compares each slot's occupancy with the bookings that
+// should be using it right now
+func (s *ReconciliationService) detectMismatches(ctx context.Context, location *models.ParkingLocation, now time.Time) (map[mismatchKey]*primitive.ObjectID, error) {
+	active, err := s.bookingRepo.Find(ctx, bson.M{
+		"location_id": location.ID,
+		"status":      models.BookingStatusActive,
+		"spot_number": bson.M{"$exists": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := s.bookingRepo.Find(ctx, bson.M{
+		"location_id":  location.ID,
+		"status":       models.BookingStatusPending,
+		"booking_type": models.BookingTypePreBooked,
+		"start_time":   bson.M{"$lte": now},
+		"$or": []bson.M{
+			{"end_time": nil},
+			{"end_time": bson.M{"$gt": now}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Guests park without a booking
+	guestSlots, err := s.guest.OnSiteSlots(ctx, location.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	activeBySlot := make(map[string]primitive.ObjectID, len(active))
+	for _, booking := range active {
+		activeBySlot[*booking.SpotNumber] = booking.ID
+	}
+	reservedBySlot := make(map[string]primitive.ObjectID, len(reserved))
+	for _, booking := range reserved {
+		if booking.SpotNumber != nil {
+			reservedBySlot[*booking.SpotNumber] = booking.ID
+		}
+	}
+
+	detected := make(map[mismatchKey]*primitive.ObjectID)
+	for _, slot := range location.Slots {
+		bookingID, hasActive := activeBySlot[slot.Number]
+		switch {
+		case slot.IsOccupied && !hasActive && guestSlots[slot.Number]:
+			// A guest parked here
+		case slot.IsOccupied && !hasActive:
+			if reservationID, ok := reservedBySlot[slot.Number]; ok {
+				detected[mismatchKey{slot.Number, models.MismatchReservedSlotTaken}] = &reservationID
+			} else {
+				detected[mismatchKey{slot.Number, models.MismatchUnbookedOccupancy}] = nil
+			}
+		case !slot.IsOccupied && hasActive:
+			detected[mismatchKey{slot.Number, models.MismatchEmptyBookedSlot}] = &bookingID
+		}
+	}
+	return detected, nil
+}
+
+// reconcileLocation updates the location's open mismatches, alerts on the
+// ones that outlasted the grace period and optionally reassigns a booking
+// whose vehicle is parked in another free slot
+func (s *ReconciliationService) reconcileLocation(ctx context.Context, location *models.ParkingLocation, now time.Time) error {
+	detected, err := s.detectMismatches(ctx, location, now)
+	if err != nil {
+		return err
+	}
+
+	open, err := s.repo.FindOpen(ctx, location.ID)
+	if err != nil {
+		return err
+	}
+
+	current := make([]*models.OccupancyMismatch, 0, len(detected))
+	for i := range open {
+		mismatch := &open[i]
+		key := mismatchKey{mismatch.SlotNumber, mismatch.Type}
+		bookingID, stillDetected := detected[key]
+		if !stillDetected {
+			if err := s.resolve(ctx, mismatch, models.MismatchResolutionCleared, now); err != nil {
+				return err
+			}
+			continue
+		}
+		delete(detected, key)
+		mismatch.BookingID = bookingID
+		mismatch.LastDetectedAt = now
+		current = append(current, mismatch)
+	}
+
+	for key, bookingID := range detected {
+		mismatch := &models.OccupancyMismatch{
+			LocationID:      location.ID,
+			SlotNumber:      key.slot,
+			Type:            key.mismatchType,
+			BookingID:       bookingID,
+			Status:          models.MismatchStatusOpen,
+			FirstDetectedAt: now,
+			LastDetectedAt:  now,
+		}
+		if err := s.repo.Create(ctx, mismatch); err != nil {
+			// Another instance opened it since FindOpen, it is tracked from there
+			if errors.Is(err, repositories.ErrDuplicate) {
+				continue
+			}
+			return err
+		}
+		current = append(current, mismatch)
+	}
+
+	var persistent []*models.OccupancyMismatch
+	for _, mismatch := range current {
+		if now.Sub(mismatch.FirstDetectedAt) >= s.grace {
+			if mismatch.AlertedAt == nil {
+				mismatch.AlertedAt = &now
+				s.bus.Publish(events.New(events.TypeMismatchDetected, location.ID, *mismatch))
+			}
+			persistent = append(persistent, mismatch)
+		}
+		if err := s.repo.Update(ctx, mismatch); err != nil {
+			return err
+		}
+	}
+
+	if s.reassign {
+		return s.reassignWrongSlot(ctx, persistent, now)
+	}
+	return nil
+}
+
+// reassignWrongSlot moves a booking to the slot its vehicle is in when the
+// match is unambiguous: exactly one booked slot is empty and exactly one
+// unreserved slot is occupied without a booking
+func (s *ReconciliationService) reassignWrongSlot(ctx context.Context, mismatches []*models.OccupancyMismatch, now time.Time) error {
+	var empty, unbooked []*models.OccupancyMismatch
+	for _, mismatch := range mismatches {
+		switch mismatch.Type {
+		case models.MismatchEmptyBookedSlot:
+			empty = append(empty, mismatch)
+		case models.MismatchUnbookedOccupancy:
+			unbooked = append(unbooked, mismatch)
+		}
+	}
+	if len(empty) != 1 || len(unbooked) != 1 || empty[0].BookingID == nil {
+		return nil
+	}
+
+	booking, err := s.booking.GetBooking(ctx, *empty[0].BookingID)
+	if err != nil {
+		return err
+	}
+	if err := s.booking.ReassignSpot(ctx, booking, unbooked[0].SlotNumber); err != nil {
+		return err
+	}
+
+	empty[0].ReassignedTo = unbooked[0].SlotNumber
+	unbooked[0].BookingID = &booking.ID
+	if err := s.resolve(ctx, empty[0], models.MismatchResolutionReassigned, now); err != nil {
+		return err
+	}
+	return s.resolve(ctx, unbooked[0], models.MismatchResolutionReassigned, now)
+}
+
+func (s *ReconciliationService) resolve(ctx context.Context, mismatch *models.OccupancyMismatch, resolution string, now time.Time) error {
+	mismatch.Status = models.MismatchStatusResolved
+	mismatch.Resolution = resolution
+	mismatch.ResolvedAt = &now
+	if err := s.repo.Update(ctx, mismatch); err != nil {
+		return err
+	}
+	// Only mismatches attendants were alerted about need a follow-up
+	if mismatch.AlertedAt != nil {
+		s.bus.Publish(events.New(events.TypeMismatchResolved, mismatch.LocationID, *mismatch))
+	}
+	return nil
+}
+
+// GetMismatches returns a location's mismatches, newest first, optionally
+// filtered by status
+func (s *ReconciliationService) GetMismatches(ctx context.Context, locationID primitive.ObjectID, status models.MismatchStatus) ([]models.OccupancyMismatch, error) {
+	if _, err := s.location.GetLocation(ctx, locationID); err != nil {
+		return nil, err
+	}
+	filter := bson.M{"location_id": locationID}
+	if status != "" {
+		filter["status"] = status
+	}
+	return s.repo.Find(ctx, filter, mismatchListLimit)
+}
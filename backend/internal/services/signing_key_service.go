@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"github.com/dfanso/parkme-backend/pkg/auth"
+)
+
+// verifyLeeway keeps a superseded key a little longer than the last token it
+// signed, for clock differences between instances
+const verifyLeeway = time.Minute
+
+// SigningKeyService keeps the JWT manager's key set in sync with the stored
+// keys and rotates the signing key on schedule. Every instance runs it, the
+// unique key sequence lets only one of them create each new key
+type SigningKeyService struct {
+	cfg  *config.Config
+	repo *repositories.SigningKeyRepository
+	jwt  *auth.JWTManager
+	kek  []byte // Encrypts the private keys at rest
+}
+
+func NewSigningKeyService(cfg *config.Config, repo *repositories.SigningKeyRepository, jwt *auth.JWTManager) *SigningKeyService {
+	return &SigningKeyService{
+		cfg:  cfg,
+		repo: repo,
+		jwt:  jwt,
+	}
+}
+
+// Init loads the key set, creating the first key if there is none. The first
+// key comes from the configured PEM file when it exists. Keys stored before
+// they were encrypted are encrypted first
+func (s *SigningKeyService) Init(ctx context.Context) error {
+	kek, err := auth.ParseKeyEncryptionKey(s.cfg.JWT.KeyEncryptionKey)
+	if err != nil {
+		return err
+	}
+	s.kek = kek
+	if err := s.sealPlaintextKeys(ctx); err != nil {
+		return err
+	}
+
+	keys, err := s.repo.FindValid(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		if err := s.createInitialKey(ctx); err != nil {
+			return err
+		}
+	}
+	if err := s.rotateIfDue(ctx, time.Now()); err != nil {
+		return err
+	}
+	return s.reload(ctx)
+}
+
+// Start periodically rotates the signing key when due and picks up keys
+// created by other instances
+func (s *SigningKeyService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.cfg.JWT.KeyCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.rotateIfDue(ctx, time.Now()); err != nil {
+					log.Printf("signing keys: failed to rotate: %v", err)
+				}
+				if err := s.reload(ctx); err != nil {
+					log.Printf("signing keys: failed to reload: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// sealPlaintextKeys encrypts the keys stored in plaintext PEM
+func (s *SigningKeyService) sealPlaintextKeys(ctx context.Context) error {
+	keys, err := s.repo.FindPlaintext(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		privateKey, err := auth.ParsePrivateKey([]byte(key.PrivateKeyPEM))
+		if err != nil {
+			return fmt.Errorf("key %s: %w", key.ID, err)
+		}
+		sealed, err := auth.SealPrivateKey(s.kek, key.ID, privateKey)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.Seal(ctx, key.ID, sealed); err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			return err
+		}
+		log.Printf("signing keys: encrypted stored key %s", key.ID)
+	}
+	return nil
+}
+
+func (s *SigningKeyService) createInitialKey(ctx context.Context) error {
+	privateKey, err := auth.LoadPrivateKeyFile(s.cfg.JWT.InitialKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		privateKey, err = auth.GenerateKey()
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	key, err := s.newSigningKey(privateKey, 1, now, now)
+	if err != nil {
+		return err
+	}
+	// Another instance may have created the first key at the same time
+	if err := s.repo.Create(ctx, key); err != nil && !errors.Is(err, repositories.ErrDuplicate) {
+		return err
+	}
+	return nil
+}
+
+// rotateIfDue publishes the next key PublishAhead before the current one has
+// signed for RotateEvery. Older keys expire once the last token they can
+// have signed has expired
+func (s *SigningKeyService) rotateIfDue(ctx context.Context, now time.Time) error {
+	keys, err := s.repo.FindValid(ctx, now)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return auth.ErrNoSigningKey
+	}
+	latest := keys[0]
+	if now.Before(latest.ActivatesAt.Add(s.cfg.JWT.RotateEvery - s.cfg.JWT.PublishAhead)) {
+		return nil
+	}
+
+	privateKey, err := auth.GenerateKey()
+	if err != nil {
+		return err
+	}
+	next, err := s.newSigningKey(privateKey, latest.Sequence+1, now, now.Add(s.cfg.JWT.PublishAhead))
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Create(ctx, next); err != nil {
+		if errors.Is(err, repositories.ErrDuplicate) {
+			return nil
+		}
+		return err
+	}
+	log.Printf("signing keys: created key %s, signing from %s", next.ID, next.ActivatesAt.Format(time.RFC3339))
+
+	expiresAt := next.ActivatesAt.Add(s.cfg.Auth.AccessTokenTTL + verifyLeeway)
+	return s.repo.ExpireBefore(ctx, next.Sequence, expiresAt)
+}
+
+func (s *SigningKeyService) reload(ctx context.Context) error {
+	stored, err := s.repo.FindValid(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	keys := make([]auth.SigningKey, 0, len(stored))
+	for _, key := range stored {
+		privateKey, err := auth.OpenPrivateKey(s.kek, key.ID, key.EncryptedPrivateKey)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", key.ID, err)
+		}
+		keys = append(keys, auth.SigningKey{
+			ID:          key.ID,
+			PrivateKey:  privateKey,
+			ActivatesAt: key.ActivatesAt,
+			ExpiresAt:   key.ExpiresAt,
+		})
+	}
+	s.jwt.SetKeys(keys)
+	return nil
+}
+
+func (s *SigningKeyService) newSigningKey(privateKey *ecdsa.PrivateKey, sequence int64, createdAt, activatesAt time.Time) (*models.SigningKey, error) {
+	id := auth.KeyID(&privateKey.PublicKey)
+	sealed, err := auth.SealPrivateKey(s.kek, id, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &models.SigningKey{
+		ID:                  id,
+		Sequence:            sequence,
+		EncryptedPrivateKey: sealed,
+		CreatedAt:           createdAt,
+		ActivatesAt:         activatesAt,
+	}, nil
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxTelemetryBatch     = 500
+	maxTelemetryClockSkew = 5 * time.Minute
+	defaultTelemetryLimit = 200
+	maxTelemetryLimit     = 5000
+)
+
+// TelemetryQuery filters telemetry and alert queries. Zero values are ignored
+type TelemetryQuery struct {
+	ModuleID   string
+	LocationID *primitive.ObjectID
+	Type       string // Sensor type for telemetry, alert type for alerts
+	Severity   models.AlertSeverity
+	From       *time.Time
+	To         *time.Time
+	Limit      int64
+}
+
+type TelemetryService struct {
+	repo           *repositories.TelemetryRepository
+	bus            *events.Bus
+	rollupInterval time.Duration
+}
+
+func NewTelemetryService(cfg *localconfig.Config, repo *repositories.TelemetryRepository, bus *events.Bus) *TelemetryService {
+	return &TelemetryService{
+		repo:           repo,
+		bus:            bus,
+		rollupInterval: cfg.Telemetry.RollupInterval,
+	}
+}
+
+// Start periodically downsamples raw telemetry into hourly rollups until ctx is cancelled
+func (s *TelemetryService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.rollupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.rollupDirtyHours(ctx)
+			}
+		}
+	}()
+}
+
+// IngestTelemetry stores a batch of readings from an authenticated device
+func (s *TelemetryService) IngestTelemetry(ctx context.Context, device *models.Device, batch []SensorData) (int, error) {
+	if len(batch) == 0 || len(batch) > maxTelemetryBatch {
+		return 0, fmt.Errorf("%w: batch must contain 1 to %d readings", ErrInvalidSensorData, maxTelemetryBatch)
+	}
+
+	now := time.Now()
+	points := make([]models.TelemetryPoint, 0, len(batch))
+	for i, data := range batch {
+		if err := checkModule(device, data.ModuleID); err != nil {
+			return 0, err
+		}
+		if data.Type == "" || len(data.Readings) == 0 {
+			return 0, fmt.Errorf("%w: reading %d needs a sensorType and readings", ErrInvalidSensorData, i)
+		}
+		timestamp, err := telemetryTimestamp(data.Timestamp, now)
+		if err != nil {
+			return 0, err
+		}
+		points = append(points, models.TelemetryPoint{
+			ID: primitive.NewObjectID(),
+			Meta: models.TelemetryMeta{
+				DeviceID:   device.ID,
+				ModuleID:   device.DeviceID,
+				LocationID: device.LocationID,
+				SensorType: data.Type,
+			},
+			Timestamp:  timestamp,
+			Readings:   data.Readings,
+			ReceivedAt: now,
+		})
+	}
+
+	if err := s.repo.InsertPoints(ctx, points); err != nil {
+		return 0, err
+	}
+	if err := s.repo.MarkHoursDirty(ctx, touchedHours(points)); err != nil {
+		return 0, err
+	}
+	return len(points), nil
+}
+
+// IngestAlerts stores a batch of alerts from an authenticated device and
+// publishes each one as a device.alert event
+func (s *TelemetryService) IngestAlerts(ctx context.Context, device *models.Device, batch []AlertData) (int, error) {
+	if len(batch) == 0 || len(batch) > maxTelemetryBatch {
+		return 0, fmt.Errorf("%w: batch must contain 1 to %d alerts", ErrInvalidSensorData, maxTelemetryBatch)
+	}
+
+	now := time.Now()
+	alerts := make([]models.DeviceAlert, 0, len(batch))
+	for i, data := range batch {
+		if err := checkModule(device, data.ModuleID); err != nil {
+			return 0, err
+		}
+		severity := models.AlertSeverity(data.Severity)
+		if data.Type == "" || !severity.IsValid() {
+			return 0, fmt.Errorf("%w: alert %d needs an alertType and a severity of info, warning or critical", ErrInvalidSensorData, i)
+		}
+		timestamp, err := telemetryTimestamp(data.Timestamp, now)
+		if err != nil {
+			return 0, err
+		}
+		alerts = append(alerts, models.DeviceAlert{
+			ID:         primitive.NewObjectID(),
+			DeviceID:   device.ID,
+			ModuleID:   device.DeviceID,
+			LocationID: device.LocationID,
+			Type:       data.Type,
+			Severity:   severity,
+			Timestamp:  timestamp,
+			Details:    data.Details,
+			ReceivedAt: now,
+		})
+	}
+
+	if err := s.repo.InsertAlerts(ctx, alerts); err != nil {
+		return 0, err
+	}
+	for _, alert := range alerts {
+		s.bus.Publish(events.New(events.TypeDeviceAlert, alert.LocationID, alert))
+	}
+	return len(alerts), nil
+}
+
+// checkModule rejects data a device reports on behalf of another module
+func checkModule(device *models.Device, moduleID string) error {
+	if moduleID != "" && moduleID != device.DeviceID {
+		return fmt.Errorf("%w: moduleId %q does not match the authenticated device", ErrInvalidSensorData, moduleID)
+	}
+	return nil
+}
+
+// telemetryTimestamp uses the device timestamp when it has one, boards
+// without a clock leave it empty and get the receive time
+func telemetryTimestamp(timestamp, now time.Time) (time.Time, error) {
+	if timestamp.IsZero() {
+		return now, nil
+	}
+	if timestamp.After(now.Add(maxTelemetryClockSkew)) {
+		return time.Time{}, fmt.Errorf("%w: timestamp %s is in the future", ErrInvalidSensorData, timestamp.Format(time.RFC3339))
+	}
+	return timestamp, nil
+}
+
+// touchedHours returns the distinct hours the points fall in
+func touchedHours(points []models.TelemetryPoint) []time.Time {
+	seen := make(map[time.Time]bool)
+	var hours []time.Time
+	for _, point := range points {
+		hour := point.Timestamp.UTC().Truncate(time.Hour)
+		if !seen[hour] {
+			seen[hour] = true
+			hours = append(hours, hour)
+		}
+	}
+	return hours
+}
+
+// rollupDirtyHours recomputes every completed hour that received points since
+// it was last rolled up. The mark is cleared before recomputing, so points
+// arriving meanwhile mark the hour again for the next run
+func (s *TelemetryService) rollupDirtyHours(ctx context.Context) {
+	current := time.Now().UTC().Truncate(time.Hour)
+	hours, err := s.repo.FindDirtyHours(ctx, current)
+	if err != nil {
+		log.Printf("telemetry: failed to find hours to roll up: %v", err)
+		return
+	}
+
+	for _, hour := range hours {
+		if err := s.repo.ClearDirtyHour(ctx, hour); err != nil {
+			log.Printf("telemetry: failed to clear %s: %v", hour.Format(time.RFC3339), err)
+			continue
+		}
+		if err := s.rollupHour(ctx, hour); err != nil {
+			log.Printf("telemetry: failed to roll up %s: %v", hour.Format(time.RFC3339), err)
+			// Keep the hour for the next run
+			if err := s.repo.MarkHoursDirty(ctx, []time.Time{hour}); err != nil {
+				log.Printf("telemetry: failed to mark %s for retry: %v", hour.Format(time.RFC3339), err)
+			}
+		}
+	}
+}
+
+// rollupHour stores min, max and average of every numeric reading per
+// device and sensor type for the hour starting at hour
+func (s *TelemetryService) rollupHour(ctx context.Context, hour time.Time) error {
+	rollups, err := s.repo.AggregateRollups(ctx, hour, hour.Add(time.Hour))
+	if err != nil {
+		return err
+	}
+
+	for i := range rollups {
+		if err := s.repo.SaveRollup(ctx, &rollups[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q TelemetryQuery) limit() int64 {
+	if q.Limit <= 0 {
+		return defaultTelemetryLimit
+	}
+	if q.Limit > maxTelemetryLimit {
+		return maxTelemetryLimit
+	}
+	return q.Limit
+}
+
+func timeRange(from, to *time.Time) bson.M {
+	r := bson.M{}
+	if from != nil {
+		r["$gte"] = *from
+	}
+	if to != nil {
+		r["$lt"] = *to
+	}
+	return r
+}
+
+// GetTelemetry returns raw telemetry points, newest first
+func (s *TelemetryService) GetTelemetry(ctx context.Context, query TelemetryQuery) ([]models.TelemetryPoint, error) {
+	filter := telemetryFilter(query)
+	if r := timeRange(query.From, query.To); len(r) > 0 {
+		filter["timestamp"] = r
+	}
+	return s.repo.FindPoints(ctx, filter, query.limit())
+}
+
+// GetRollups returns hourly downsampled telemetry, newest first
+func (s *TelemetryService) GetRollups(ctx context.Context, query TelemetryQuery) ([]models.TelemetryRollup, error) {
+	filter := telemetryFilter(query)
+	if r := timeRange(query.From, query.To); len(r) > 0 {
+		filter["hour"] = r
+	}
+	return s.repo.FindRollups(ctx, filter, query.limit())
+}
+
+func telemetryFilter(query TelemetryQuery) bson.M {
+	filter := bson.M{}
+	if query.ModuleID != "" {
+		filter["meta.module_id"] = query.ModuleID
+	}
+	if query.LocationID != nil {
+		filter["meta.location_id"] = *query.LocationID
+	}
+	if query.Type != "" {
+		filter["meta.sensor_type"] = query.Type
+	}
+	return filter
+}
+
+// GetAlerts returns device alerts, newest first
+func (s *TelemetryService) GetAlerts(ctx context.Context, query TelemetryQuery) ([]models.DeviceAlert, error) {
+	filter := bson.M{}
+	if query.ModuleID != "" {
+		filter["module_id"] = query.ModuleID
+	}
+	if query.LocationID != nil {
+		filter["location_id"] = *query.LocationID
+	}
+	if query.Type != "" {
+		filter["type"] = query.Type
+	}
+	if query.Severity != "" {
+		filter["severity"] = query.Severity
+	}
+	if r := timeRange(query.From, query.To); len(r) > 0 {
+		filter["timestamp"] = r
+	}
+	return s.repo.FindAlerts(ctx, filter, query.limit())
+}
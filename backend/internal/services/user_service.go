@@ -48,6 +48,18 @@ func (s *UserService) Create(ctx context.Context, user *models.User) error {
 	return s.repo.Create(ctx, user)
 }
 
+// CheckEmailAvailable returns ErrUserExists when another user has the email
+func (s *UserService) CheckEmailAvailable(ctx context.Context, email string, userID primitive.ObjectID) error {
+	_, err := s.FindOne(ctx, bson.M{"email": email, "_id": bson.M{"$ne": userID}})
+	if err == nil {
+		return ErrUserExists
+	}
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
 func (s *UserService) Update(ctx context.Context, user *models.User) error {
 	return s.repo.Update(ctx, user)
 }
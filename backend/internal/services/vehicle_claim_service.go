@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrVehicleClaimNotFound   = errors.New("vehicle claim not found")
+	ErrVehicleClaimExists     = errors.New("you already have an open claim on this vehicle")
+	ErrVehicleClaimClosed     = errors.New("vehicle claim has already been reviewed")
+	ErrVehicleClaimOutdated   = errors.New("the vehicle changed since the claim was made")
+	ErrVehicleAlreadyVerified = errors.New("vehicle ownership is already verified")
+	ErrVehicleAlreadyOwned    = errors.New("you already own this vehicle")
+)
+
+// VehicleClaimService runs the review of registration documents. Owners ask
+// for their vehicle to be verified, anyone else holding the registration can
+// dispute a plate and have it transferred to them
+type VehicleClaimService struct {
+	repo    *repositories.VehicleClaimRepository
+	vehicle *VehicleService
+	audit   *AuditService
+}
+
+func NewVehicleClaimService(repo *repositories.VehicleClaimRepository, vehicleService *VehicleService, auditService *AuditService) *VehicleClaimService {
+	return &VehicleClaimService{
+		repo:    repo,
+		vehicle: vehicleService,
+		audit:   auditService,
+	}
+}
+
+func (s *VehicleClaimService) record(ctx context.Context, entry *models.AuditEntry) {
+	if err := s.audit.Record(ctx, entry); err != nil {
+		log.Printf("vehicle claims: failed to record %s audit entry for %s: %v", entry.Action, entry.Subject, err)
+	}
+}
+
+// CheckVerifiable returns an error when the owner cannot ask for the vehicle
+// to be verified, so no document is uploaded for nothing
+func (s *VehicleClaimService) CheckVerifiable(vehicle *models.Vehicle) error {
+	if vehicle.IsVerified() {
+		return ErrVehicleAlreadyVerified
+	}
+	return nil
+}
+
+// RequestVerification submits the owner's registration document for review
+// and marks the vehicle pending
+func (s *VehicleClaimService) RequestVerification(ctx context.Context, vehicle *models.Vehicle, documentKey, note string) (*models.VehicleClaim, error) {
+	if err := s.CheckVerifiable(vehicle); err != nil {
+		return nil, err
+	}
+
+	claim, err := s.create(ctx, vehicle, vehicle.Owner, models.VehicleClaimOwnership, documentKey, note)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.vehicle.SetVerification(ctx, vehicle.ID, models.VehiclePendingVerification); err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+// FindDisputable returns the vehicle registered with the plate when the
+// claimant may dispute who owns it
+func (s *VehicleClaimService) FindDisputable(ctx context.Context, plateNumber string, claimantID primitive.ObjectID) (*models.Vehicle, error) {
+	vehicle, err := s.vehicle.FindOne(ctx, bson.M{"plate_number": strings.TrimSpace(plateNumber)})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrVehicleNotFound
+		}
+		return nil, err
+	}
+	if vehicle.Owner == claimantID {
+		return nil, ErrVehicleAlreadyOwned
+	}
+	return vehicle, nil
+}
+
+// OpenDispute submits a claimant's registration document for a plate someone
+// else registered. The vehicle stays with its owner until an admin approves
+func (s *VehicleClaimService) OpenDispute(ctx context.Context, vehicle *models.Vehicle, claimantID primitive.ObjectID, documentKey, note string) (*models.VehicleClaim, error) {
+	if vehicle.Owner == claimantID {
+		return nil, ErrVehicleAlreadyOwned
+	}
+	return s.create(ctx, vehicle, claimantID, models.VehicleClaimTransfer, documentKey, note)
+}
+
+func (s *VehicleClaimService) create(ctx context.Context, vehicle *models.Vehicle, claimantID primitive.ObjectID, claimType models.VehicleClaimType, documentKey, note string) (*models.VehicleClaim, error) {
+	now := time.Now()
+	claim := &models.VehicleClaim{
+		VehicleID:   vehicle.ID,
+		PlateNumber: vehicle.PlateNumber,
+		ClaimantID:  claimantID,
+		Type:        claimType,
+		Status:      models.VehicleClaimPending,
+		DocumentKey: documentKey,
+		Note:        note,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.repo.Create(ctx, claim); err != nil {
+		if errors.Is(err, repositories.ErrDuplicate) {
+			return nil, ErrVehicleClaimExists
+		}
+		return nil, err
+	}
+	return claim, nil
+}
+
+func (s *VehicleClaimService) GetClaim(ctx context.Context, id primitive.ObjectID) (*models.VehicleClaim, error) {
+	claim, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrVehicleClaimNotFound
+		}
+		return nil, err
+	}
+	return claim, nil
+}
+
+// GetClaims returns the claims with the status, or all claims when it is
+// empty, oldest first
+func (s *VehicleClaimService) GetClaims(ctx context.Context, status models.VehicleClaimStatus) ([]models.VehicleClaim, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	return s.repo.Find(ctx, filter)
+}
+
+// GetUserClaims returns the claims the user made
+func (s *VehicleClaimService) GetUserClaims(ctx context.Context, userID primitive.ObjectID) ([]models.VehicleClaim, error) {
+	return s.repo.Find(ctx, bson.M{"claimant_id": userID})
+}
+
+// Approve accepts a claim. An ownership claim verifies the vehicle, a
+// transfer claim gives it to the claimant and rejects the other open claims
+func (s *VehicleClaimService) Approve(ctx context.Context, id, reviewerID primitive.ObjectID, note string) (*models.VehicleClaim, error) {
+	claim, err := s.GetClaim(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if claim.Status != models.VehicleClaimPending {
+		return nil, ErrVehicleClaimClosed
+	}
+
+	// The document was checked against the plate and owner of the time
+	vehicle, err := s.vehicle.GetByID(ctx, claim.VehicleID)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle.PlateNumber != claim.PlateNumber {
+		return nil, ErrVehicleClaimOutdated
+	}
+	if claim.Type == models.VehicleClaimOwnership && vehicle.Owner != claim.ClaimantID {
+		return nil, ErrVehicleClaimOutdated
+	}
+
+	claim, err = s.repo.Review(ctx, id, models.VehicleClaimApproved, &reviewerID, note, time.Now())
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrVehicleClaimClosed
+		}
+		return nil, err
+	}
+
+	if claim.Type == models.VehicleClaimOwnership {
+		if err := s.vehicle.SetVerification(ctx, vehicle.ID, models.VehicleVerified); err != nil {
+			return nil, err
+		}
+		s.record(ctx, &models.AuditEntry{
+			Action:  models.AuditVehicleVerified,
+			ActorID: &reviewerID,
+			UserID:  &claim.ClaimantID,
+			Subject: vehicle.PlateNumber,
+		})
+		return claim, nil
+	}
+
+	if err := s.vehicle.Transfer(ctx, vehicle.ID, claim.ClaimantID); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CloseOthers(ctx, vehicle.ID, claim.ID, "superseded by an approved transfer", time.Now()); err != nil {
+		return nil, err
+	}
+	s.record(ctx, &models.AuditEntry{
+		Action:  models.AuditVehicleTransferred,
+		ActorID: &reviewerID,
+		UserID:  &vehicle.Owner,
+		Subject: vehicle.PlateNumber,
+		Detail:  "transferred to user " + claim.ClaimantID.Hex(),
+	})
+	return claim, nil
+}
+
+// Reject turns a claim down. A vehicle whose only verification request was
+// rejected goes back to unverified
+func (s *VehicleClaimService) Reject(ctx context.Context, id, reviewerID primitive.ObjectID, note string) (*models.VehicleClaim, error) {
+	claim, err := s.repo.Review(ctx, id, models.VehicleClaimRejected, &reviewerID, note, time.Now())
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			if _, findErr := s.GetClaim(ctx, id); findErr != nil {
+				return nil, findErr
+			}
+			return nil, ErrVehicleClaimClosed
+		}
+		return nil, err
+	}
+
+	if claim.Type != models.VehicleClaimOwnership {
+		return claim, nil
+	}
+	vehicle, err := s.vehicle.GetByID(ctx, claim.VehicleID)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.repo.CountPending(ctx, vehicle.ID, models.VehicleClaimOwnership)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle.Verification == models.VehiclePendingVerification && pending == 0 {
+		if err := s.vehicle.SetVerification(ctx, vehicle.ID, models.VehicleUnverified); err != nil {
+			return nil, err
+		}
+	}
+	return claim, nil
+}
@@ -3,6 +3,8 @@ package services
 import (
 	"context"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/repositories"
@@ -14,15 +16,24 @@ var (
 	ErrVehicleExists   = errors.New("vehicle with this plate number already exists")
 	ErrVehicleNotFound = errors.New("vehicle not found")
 	ErrInvalidOwner    = errors.New("invalid vehicle owner")
+
+	ErrVehicleNotVerified   = errors.New("vehicle ownership is not verified")
+	ErrDriverIsOwner        = errors.New("the owner already drives this vehicle")
+	ErrDriverExists         = errors.New("user is already a driver of this vehicle")
+	ErrDriverNotFound       = errors.New("user is not a driver of this vehicle")
+	ErrDriverInviteNotFound = errors.New("no pending driver invitation for this vehicle")
+	ErrDriverEmailNotFound  = errors.New("no user is registered with this email address")
 )
 
 type VehicleService struct {
 	repo *repositories.VehicleRepository
+	user *UserService
 }
 
-func NewVehicleService(repo *repositories.VehicleRepository) *VehicleService {
+func NewVehicleService(repo *repositories.VehicleRepository, userService *UserService) *VehicleService {
 	return &VehicleService{
 		repo: repo,
+		user: userService,
 	}
 }
 
@@ -45,6 +56,142 @@ func (s *VehicleService) GetByOwner(ctx context.Context, ownerID primitive.Objec
 	return s.repo.FindByOwner(ctx, ownerID)
 }
 
+func (s *VehicleService) GetByOrganization(ctx context.Context, organizationID primitive.ObjectID) ([]models.Vehicle, error) {
+	return s.repo.FindByOrganization(ctx, organizationID)
+}
+
+// AssignDriver assigns a fleet vehicle of the organization to another member
+func (s *VehicleService) AssignDriver(ctx context.Context, id, organizationID, driverID primitive.ObjectID) error {
+	err := s.repo.AssignDriver(ctx, id, organizationID, driverID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrVehicleNotInOrganization
+	}
+	return err
+}
+
+// InviteToOrganization asks the vehicle's owner to accept it into the
+// organization's fleet
+func (s *VehicleService) InviteToOrganization(ctx context.Context, id, organizationID, driverID primitive.ObjectID) error {
+	err := s.repo.InviteToOrganization(ctx, id, organizationID, driverID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrVehicleInOrganization
+	}
+	return err
+}
+
+// JoinOrganization moves the vehicle into the organization's fleet, for
+// owners who bring in their own vehicle
+func (s *VehicleService) JoinOrganization(ctx context.Context, id, organizationID, driverID primitive.ObjectID) error {
+	err := s.repo.JoinOrganization(ctx, id, organizationID, driverID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrVehicleInOrganization
+	}
+	return err
+}
+
+// AcceptOrganization moves the owner's vehicle into the fleet of the
+// organization that invited it
+func (s *VehicleService) AcceptOrganization(ctx context.Context, id, ownerID, organizationID primitive.ObjectID) error {
+	err := s.repo.AcceptOrganization(ctx, id, ownerID, organizationID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrOrganizationInviteNotFound
+	}
+	return err
+}
+
+// LeaveOrganization takes the vehicle out of the organization's fleet, or
+// withdraws a pending invitation
+func (s *VehicleService) LeaveOrganization(ctx context.Context, id, organizationID primitive.ObjectID) error {
+	err := s.repo.LeaveOrganization(ctx, id, organizationID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrVehicleNotInOrganization
+	}
+	return err
+}
+
+// GetDrivenBy returns the vehicles the user was invited to drive
+func (s *VehicleService) GetDrivenBy(ctx context.Context, userID primitive.ObjectID) ([]models.Vehicle, error) {
+	return s.repo.FindByDriver(ctx, userID)
+}
+
+// SetVerification records the verification status of the vehicle
+func (s *VehicleService) SetVerification(ctx context.Context, id primitive.ObjectID, status models.VehicleVerification) error {
+	var verifiedAt *time.Time
+	if status == models.VehicleVerified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+	err := s.repo.SetVerification(ctx, id, status, verifiedAt)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrVehicleNotFound
+	}
+	return err
+}
+
+// Transfer gives the vehicle to a new, verified owner
+func (s *VehicleService) Transfer(ctx context.Context, id, ownerID primitive.ObjectID) error {
+	err := s.repo.Transfer(ctx, id, ownerID, time.Now())
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrVehicleNotFound
+	}
+	return err
+}
+
+// InviteDriver lets the user registered with the email address book with the
+// vehicle once they accept. Only verified vehicles can be shared
+func (s *VehicleService) InviteDriver(ctx context.Context, vehicle *models.Vehicle, email string) (*models.VehicleDriver, error) {
+	if !vehicle.IsVerified() {
+		return nil, ErrVehicleNotVerified
+	}
+
+	email = strings.TrimSpace(email)
+	user, err := s.user.FindOne(ctx, bson.M{"email": bson.M{"$in": []string{email, strings.ToLower(email)}}})
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrDriverEmailNotFound
+		}
+		return nil, err
+	}
+	if user.ID == vehicle.Owner {
+		return nil, ErrDriverIsOwner
+	}
+
+	driver := models.VehicleDriver{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Status:    models.VehicleDriverInvited,
+		InvitedAt: time.Now(),
+	}
+	if err := s.repo.AddDriver(ctx, vehicle.ID, driver); err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrDuplicate):
+			return nil, ErrDriverExists
+		case errors.Is(err, repositories.ErrNotFound):
+			return nil, ErrVehicleNotFound
+		}
+		return nil, err
+	}
+	return &driver, nil
+}
+
+// AcceptDriverInvite makes the user an authorized driver of the vehicle
+func (s *VehicleService) AcceptDriverInvite(ctx context.Context, id, userID primitive.ObjectID) error {
+	err := s.repo.AcceptDriver(ctx, id, userID, time.Now())
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrDriverInviteNotFound
+	}
+	return err
+}
+
+// RemoveDriver withdraws a driver's access or declines an invitation
+func (s *VehicleService) RemoveDriver(ctx context.Context, id, userID primitive.ObjectID) error {
+	err := s.repo.RemoveDriver(ctx, id, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrDriverNotFound
+	}
+	return err
+}
+
 func (s *VehicleService) Create(ctx context.Context, vehicle *models.Vehicle) error {
 	// Check if vehicle with same plate number exists
 	filter := bson.M{"plate_number": vehicle.PlateNumber}
@@ -56,6 +203,16 @@ func (s *VehicleService) Create(ctx context.Context, vehicle *models.Vehicle) er
 		return ErrVehicleExists
 	}
 
+	vehicle.Category = vehicle.EffectiveCategory()
+	vehicle.Size = vehicle.EffectiveSize()
+
+	// Ownership is unproven until an admin reviews a registration document
+	vehicle.Verification = models.VehicleUnverified
+	vehicle.VerifiedAt = nil
+	vehicle.Drivers = nil
+	vehicle.OrganizationID = nil
+	vehicle.AssignedDriver = nil
+	vehicle.OrganizationInvite = nil
 	return s.repo.Create(ctx, vehicle)
 }
 
@@ -82,8 +239,15 @@ func (s *VehicleService) Update(ctx context.Context, vehicle *models.Vehicle) er
 		if duplicate != nil {
 			return ErrVehicleExists
 		}
+
+		// A verification applies to the plate it was made for
+		vehicle.Verification = models.VehicleUnverified
+		vehicle.VerifiedAt = nil
 	}
 
+	vehicle.Category = vehicle.EffectiveCategory()
+	vehicle.Size = vehicle.EffectiveSize()
+
 	return s.repo.Update(ctx, vehicle)
 }
 
@@ -3,8 +3,10 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/dfanso/parkme-backend/internal/events"
 	"github.com/dfanso/parkme-backend/internal/models"
 	"github.com/dfanso/parkme-backend/internal/repositories"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,14 +20,30 @@ var (
 
 type WalletService struct {
 	repo *repositories.WalletRepository
+	bus  *events.Bus
 }
 
-func NewWalletService(repo *repositories.WalletRepository) *WalletService {
+func NewWalletService(repo *repositories.WalletRepository, bus *events.Bus) *WalletService {
 	return &WalletService{
 		repo: repo,
+		bus:  bus,
 	}
 }
 
+// publish announces a wallet transaction on the event bus
+func (s *WalletService) publish(eventType events.Type, wallet *models.Wallet, transaction *models.Transaction) {
+	s.bus.Publish(events.New(eventType, primitive.NilObjectID, events.WalletEvent{
+		WalletID:       wallet.ID,
+		UserID:         wallet.UserID,
+		OrganizationID: wallet.OrganizationID,
+		TransactionID:  transaction.ID,
+		Type:           transaction.Type,
+		Amount:         transaction.Amount,
+		Balance:        wallet.Balance,
+		Description:    transaction.Description,
+	}))
+}
+
 // GetOrCreateWallet gets the user's wallet or creates one if it doesn't exist
 func (s *WalletService) GetOrCreateWallet(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error) {
 	wallet, err := s.repo.FindByUserID(ctx, userID)
@@ -83,6 +101,7 @@ func (s *WalletService) TopUp(ctx context.Context, userID primitive.ObjectID, am
 		return nil, err
 	}
 
+	s.publish(events.TypeWalletToppedUp, wallet, transaction)
 	return transaction, nil
 }
 
@@ -124,6 +143,7 @@ func (s *WalletService) Deduct(ctx context.Context, userID primitive.ObjectID, a
 		return nil, err
 	}
 
+	s.publish(events.TypeWalletCharged, wallet, transaction)
 	return transaction, nil
 }
 
@@ -146,3 +166,141 @@ func (s *WalletService) GetBalance(ctx context.Context, userID primitive.ObjectI
 
 	return wallet.Balance, nil
 }
+
+// GetOrCreateOrganizationWallet gets the shared wallet of an organization or
+// creates one if it doesn't exist
+func (s *WalletService) GetOrCreateOrganizationWallet(ctx context.Context, organizationID primitive.ObjectID) (*models.Wallet, error) {
+	wallet, err := s.repo.FindByOrganizationID(ctx, organizationID)
+	if err == nil {
+		return wallet, nil
+	}
+	if err != repositories.ErrNotFound {
+		return nil, err
+	}
+
+	wallet = &models.Wallet{
+		OrganizationID: &organizationID,
+		Balance:        0,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	err = s.repo.Create(ctx, wallet)
+	if errors.Is(err, repositories.ErrDuplicate) {
+		// Created concurrently, use that one
+		return s.repo.FindByOrganizationID(ctx, organizationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// TopUpOrganization adds points to an organization wallet on behalf of a member
+func (s *WalletService) TopUpOrganization(ctx context.Context, organizationID, userID primitive.ObjectID, amount float64) (*models.Transaction, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	wallet, err := s.GetOrCreateOrganizationWallet(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	wallet, err = s.repo.Credit(ctx, wallet.ID, amount, now)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction := &models.Transaction{
+		WalletID:    wallet.ID,
+		Type:        models.TransactionTypeTopUp,
+		Amount:      amount,
+		Description: "Organization wallet top-up",
+		UserID:      &userID,
+		CreatedAt:   now,
+	}
+	if err := s.repo.CreateTransaction(ctx, transaction); err != nil {
+		// Take the points back so the balance matches the transaction log
+		if _, reverseErr := s.repo.Debit(ctx, wallet.ID, amount, time.Now()); reverseErr != nil {
+			return nil, fmt.Errorf("%w (and failed to reverse the top-up: %v)", err, reverseErr)
+		}
+		return nil, err
+	}
+
+	s.publish(events.TypeWalletToppedUp, wallet, transaction)
+	return transaction, nil
+}
+
+// ChargeOrganization deducts a driver's parking from an organization wallet.
+// The balance is taken in a single conditional write because the wallet is
+// shared by every driver of the organization
+func (s *WalletService) ChargeOrganization(ctx context.Context, organizationID, userID, vehicleID primitive.ObjectID, amount float64, description string) (*models.Transaction, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	wallet, err := s.repo.FindByOrganizationID(ctx, organizationID)
+	if err != nil {
+		if err == repositories.ErrNotFound {
+			return nil, ErrWalletNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	wallet, err = s.repo.Debit(ctx, wallet.ID, amount, now)
+	if err != nil {
+		if err == repositories.ErrNotFound {
+			return nil, ErrInsufficientBalance
+		}
+		return nil, err
+	}
+
+	transaction := &models.Transaction{
+		WalletID:    wallet.ID,
+		Type:        models.TransactionTypeDeduct,
+		Amount:      amount,
+		Description: description,
+		UserID:      &userID,
+		VehicleID:   &vehicleID,
+		CreatedAt:   now,
+	}
+	if err := s.repo.CreateTransaction(ctx, transaction); err != nil {
+		// Give the points back so the balance matches the transaction log
+		if _, refundErr := s.repo.Credit(ctx, wallet.ID, amount, time.Now()); refundErr != nil {
+			return nil, fmt.Errorf("%w (and failed to restore balance: %v)", err, refundErr)
+		}
+		return nil, err
+	}
+
+	s.publish(events.TypeWalletCharged, wallet, transaction)
+	return transaction, nil
+}
+
+// GetOrganizationCharges totals what a member was charged to the
+// organization wallet since the given time
+func (s *WalletService) GetOrganizationCharges(ctx context.Context, organizationID, userID primitive.ObjectID, since time.Time) (float64, error) {
+	wallet, err := s.repo.FindByOrganizationID(ctx, organizationID)
+	if err != nil {
+		if err == repositories.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return s.repo.SumCharges(ctx, wallet.ID, userID, since)
+}
+
+// GetOrganizationTransactions returns the organization wallet and its
+// transactions created in [from, to), oldest first. A nil bound is open
+func (s *WalletService) GetOrganizationTransactions(ctx context.Context, organizationID primitive.ObjectID, from, to *time.Time) (*models.Wallet, []models.Transaction, error) {
+	wallet, err := s.GetOrCreateOrganizationWallet(ctx, organizationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	transactions, err := s.repo.FindTransactions(ctx, wallet.ID, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wallet, transactions, nil
+}
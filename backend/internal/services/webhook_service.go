@@ -0,0 +1,398 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	localconfig "github.com/dfanso/parkme-backend/config"
+	"github.com/dfanso/parkme-backend/internal/events"
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook subscription not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrInvalidWebhookURL       = errors.New("webhook URL must be an absolute http or https URL")
+	ErrUnknownEventType        = errors.New("unknown event type")
+)
+
+const (
+	webhookPollInterval  = 5 * time.Second
+	webhookClaimLease    = time.Minute
+	webhookBaseBackoff   = 30 * time.Second
+	webhookMaxBackoff    = time.Hour
+	webhookDeliveryLimit = 100
+
+	// webhookOutboxTimeout bounds the outbox write made while publishing
+	webhookOutboxTimeout = 5 * time.Second
+	// webhookOutboxRetention is how long dispatched outbox rows are kept
+	webhookOutboxRetention = 7 * 24 * time.Hour
+)
+
+type WebhookService struct {
+	repo        *repositories.WebhookRepository
+	bus         *events.Bus
+	client      *http.Client
+	maxAttempts int
+	wake        chan struct{}
+}
+
+func NewWebhookService(cfg *localconfig.Config, repo *repositories.WebhookRepository, bus *events.Bus) *WebhookService {
+	return &WebhookService{
+		repo:        repo,
+		bus:         bus,
+		client:      &http.Client{Timeout: cfg.Webhook.Timeout},
+		maxAttempts: cfg.Webhook.MaxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Start records domain events in the outbox as they are published and runs the
+// delivery worker until ctx is cancelled
+func (s *WebhookService) Start(ctx context.Context) {
+	s.bus.Handle(func(e events.Event) bool {
+		return events.IsDomainType(e.Type)
+	}, s.record)
+
+	go s.runWorker(ctx)
+}
+
+// record writes the outbox row for an event. It runs inside Publish, so the
+// event is stored before the publisher moves on and cannot be dropped by a
+// slow worker
+func (s *WebhookService) record(event events.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("webhooks: failed to encode event %s: %v", event.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookOutboxTimeout)
+	defer cancel()
+
+	err = s.repo.CreateEvent(ctx, &models.WebhookEvent{
+		EventID:   event.ID,
+		EventType: string(event.Type),
+		Payload:   string(payload),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("webhooks: failed to record event %s: %v", event.ID, err)
+		return
+	}
+	s.notifyWorker()
+}
+
+// dispatchPending fans every outbox event out into deliveries. An event whose
+// fan-out is interrupted is claimed again once its lease expires, so delivery
+// is at least once and receivers should dedupe on the event ID
+func (s *WebhookService) dispatchPending(ctx context.Context) {
+	for ctx.Err() == nil {
+		event, err := s.repo.ClaimPendingEvent(ctx, time.Now(), webhookClaimLease)
+		if err != nil {
+			if err != repositories.ErrNotFound {
+				log.Printf("webhooks: failed to claim event: %v", err)
+			}
+			return
+		}
+		if err := s.enqueue(ctx, event); err != nil {
+			log.Printf("webhooks: failed to enqueue event %s: %v", event.EventID, err)
+			continue
+		}
+		if err := s.repo.MarkEventDispatched(ctx, event.ID, time.Now().Add(webhookOutboxRetention)); err != nil {
+			log.Printf("webhooks: failed to mark event %s dispatched: %v", event.EventID, err)
+		}
+	}
+}
+
+// enqueue stores a pending delivery for every active subscription interested in the event
+func (s *WebhookService) enqueue(ctx context.Context, event *models.WebhookEvent) error {
+	subs, err := s.repo.FindSubscriptions(ctx, bson.M{"active": true})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, sub := range subs {
+		if !sub.Matches(event.EventType) {
+			continue
+		}
+		delivery := &models.WebhookDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        event.EventID,
+			EventType:      event.EventType,
+			Payload:        event.Payload,
+			Status:         models.WebhookDeliveryPending,
+			NextAttemptAt:  &now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		if err := s.repo.CreateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WebhookService) notifyWorker() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *WebhookService) runWorker(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		s.dispatchPending(ctx)
+		s.processDue(ctx)
+	}
+}
+
+// processDue sends every delivery that is due, one at a time
+func (s *WebhookService) processDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		delivery, err := s.repo.ClaimDueDelivery(ctx, time.Now(), webhookClaimLease)
+		if err != nil {
+			if err != repositories.ErrNotFound {
+				log.Printf("webhooks: failed to claim delivery: %v", err)
+			}
+			return
+		}
+		s.attempt(ctx, delivery)
+	}
+}
+
+func (s *WebhookService) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	sub, err := s.repo.FindSubscriptionByID(ctx, delivery.SubscriptionID)
+	if err != nil {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.LastError = "subscription no longer exists"
+		delivery.UpdatedAt = time.Now()
+		s.saveDelivery(ctx, delivery)
+		return
+	}
+
+	delivery.Attempts++
+	code, sendErr := s.send(ctx, sub, delivery)
+	now := time.Now()
+	delivery.ResponseCode = code
+	delivery.UpdatedAt = now
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= s.maxAttempts:
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.LastError = sendErr.Error()
+	default:
+		next := now.Add(webhookBackoff(delivery.Attempts))
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = &next
+	}
+
+	s.saveDelivery(ctx, delivery)
+}
+
+func (s *WebhookService) saveDelivery(ctx context.Context, delivery *models.WebhookDelivery) {
+	if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+		log.Printf("webhooks: failed to update delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// send posts the signed payload and returns the response status code
+func (s *WebhookService) send(ctx context.Context, sub *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ParkMe-Webhooks/1.0")
+	req.Header.Set("X-ParkMe-Event", delivery.EventType)
+	req.Header.Set("X-ParkMe-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-ParkMe-Timestamp", timestamp)
+	req.Header.Set("X-ParkMe-Signature", "sha256="+SignWebhookPayload(sub.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload computes the hex HMAC-SHA256 of "<timestamp>.<payload>".
+// Receivers recompute it with their secret to verify a delivery
+func SignWebhookPayload(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff doubles the retry delay after each failed attempt
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return delay
+}
+
+func validateWebhook(sub *models.WebhookSubscription) error {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	for _, t := range sub.EventTypes {
+		if !events.IsDomainType(events.Type(t)) {
+			return fmt.Errorf("%w: %s", ErrUnknownEventType, t)
+		}
+	}
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// CreateSubscription validates and stores a subscription with a freshly generated secret
+func (s *WebhookService) CreateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	if err := validateWebhook(sub); err != nil {
+		return err
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return err
+	}
+
+	sub.Secret = secret
+	sub.Active = true
+	sub.CreatedAt = time.Now()
+	sub.UpdatedAt = time.Now()
+	return s.repo.CreateSubscription(ctx, sub)
+}
+
+func (s *WebhookService) GetSubscription(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	sub, err := s.repo.FindSubscriptionByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *WebhookService) GetSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return s.repo.FindSubscriptions(ctx, bson.M{})
+}
+
+// UpdateSubscription changes the URL, description, event types and active flag, keeping the secret
+func (s *WebhookService) UpdateSubscription(ctx context.Context, update *models.WebhookSubscription) (*models.WebhookSubscription, error) {
+	sub, err := s.GetSubscription(ctx, update.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateWebhook(update); err != nil {
+		return nil, err
+	}
+
+	sub.URL = update.URL
+	sub.Description = update.Description
+	sub.EventTypes = update.EventTypes
+	sub.Active = update.Active
+	sub.UpdatedAt = time.Now()
+	if err := s.repo.UpdateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *WebhookService) DeleteSubscription(ctx context.Context, id primitive.ObjectID) error {
+	err := s.repo.DeleteSubscription(ctx, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// GetDeliveries returns the most recent deliveries for a subscription
+func (s *WebhookService) GetDeliveries(ctx context.Context, subscriptionID primitive.ObjectID) ([]models.WebhookDelivery, error) {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+	return s.repo.FindDeliveriesBySubscription(ctx, subscriptionID, webhookDeliveryLimit)
+}
+
+// ReplayDelivery queues a new delivery with the same payload as an earlier one
+func (s *WebhookService) ReplayDelivery(ctx context.Context, id primitive.ObjectID) (*models.WebhookDelivery, error) {
+	original, err := s.repo.FindDeliveryByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	replay := &models.WebhookDelivery{
+		SubscriptionID: original.SubscriptionID,
+		EventID:        original.EventID,
+		EventType:      original.EventType,
+		Payload:        original.Payload,
+		Status:         models.WebhookDeliveryPending,
+		NextAttemptAt:  &now,
+		ReplayOf:       &original.ID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := s.repo.CreateDelivery(ctx, replay); err != nil {
+		return nil, err
+	}
+
+	s.notifyWorker()
+	return replay, nil
+}
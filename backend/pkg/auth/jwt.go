@@ -1,96 +1,122 @@
 package auth
 
 import (
-	"crypto/ecdsa"
-	"crypto/x509"
-	"encoding/pem"
+	"errors"
 	"fmt"
-	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var ErrNoSigningKey = errors.New("no active signing key")
+
 type JWTClaims struct {
-	UserID primitive.ObjectID `json:"user_id"`
-	Role   string             `json:"role"`
+	UserID    primitive.ObjectID `json:"user_id"`
+	Role      string             `json:"role"`
+	SessionID primitive.ObjectID `json:"sid"`
+	MFA       bool               `json:"mfa"` // The session signed in with a second factor
 	jwt.RegisteredClaims
 }
 
+// JWTManager signs access tokens with the newest active key of the key set
+// and verifies them with any key that has not expired, found by the token's
+// kid header
 type JWTManager struct {
-	privateKey *ecdsa.PrivateKey
-	publicKey  *ecdsa.PublicKey
+	issuer    string
+	accessTTL time.Duration
+
+	mu      sync.RWMutex
+	keys    map[string]SigningKey
+	ordered []SigningKey // Latest activation first
 }
 
-func NewJWTManager() (*JWTManager, error) {
-	// Read private key
-	privateKeyBytes, err := os.ReadFile("keys/private.pem")
-	if err != nil {
-		return nil, fmt.Errorf("could not read private key: %v", err)
+// NewJWTManager returns a manager without keys. Access tokens expire after
+// accessTTL and are renewed with the session's refresh token
+func NewJWTManager(issuer string, accessTTL time.Duration) *JWTManager {
+	return &JWTManager{
+		issuer:    issuer,
+		accessTTL: accessTTL,
+		keys:      map[string]SigningKey{},
 	}
+}
 
-	// Parse private key
-	block, _ := pem.Decode(privateKeyBytes)
-	if block == nil {
-		return nil, fmt.Errorf("failed to parse PEM block containing private key")
-	}
-
-	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %v", err)
+// SetKeys replaces the key set
+func (m *JWTManager) SetKeys(keys []SigningKey) {
+	byID := make(map[string]SigningKey, len(keys))
+	ordered := make([]SigningKey, 0, len(keys))
+	for _, key := range keys {
+		byID[key.ID] = key
+		ordered = append(ordered, key)
 	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].ActivatesAt.After(ordered[j].ActivatesAt)
+	})
 
-	// Read public key
-	publicKeyBytes, err := os.ReadFile("keys/public.pem")
-	if err != nil {
-		return nil, fmt.Errorf("could not read public key: %v", err)
-	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = byID
+	m.ordered = ordered
+}
 
-	// Parse public key
-	block, _ = pem.Decode(publicKeyBytes)
-	if block == nil {
-		return nil, fmt.Errorf("failed to parse PEM block containing public key")
+// signingKey returns the most recently activated key
+func (m *JWTManager) signingKey(now time.Time) (SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.ordered {
+		if !key.ActivatesAt.After(now) && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt)) {
+			return key, nil
+		}
 	}
+	return SigningKey{}, ErrNoSigningKey
+}
 
-	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+// GenerateToken issues an access token for the session and returns its
+// expiry. mfa records whether the session passed a second factor
+func (m *JWTManager) GenerateToken(userID primitive.ObjectID, role string, sessionID primitive.ObjectID, mfa bool) (string, time.Time, error) {
+	now := time.Now()
+	key, err := m.signingKey(now)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %v", err)
-	}
-
-	ecdsaPublicKey, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("public key is not an ECDSA key")
+		return "", time.Time{}, err
 	}
 
-	return &JWTManager{
-		privateKey: privateKey,
-		publicKey:  ecdsaPublicKey,
-	}, nil
-}
-
-func (m *JWTManager) GenerateToken(userID primitive.ObjectID, role string) (string, error) {
+	expiresAt := now.Add(m.accessTTL)
 	claims := &JWTClaims{
-		UserID: userID,
-		Role:   role,
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
+		MFA:       mfa,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    m.issuer,
+			Subject:   userID.Hex(),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
-	return token.SignedString(m.privateKey)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
 }
 
 func (m *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		kid, _ := token.Header["kid"].(string)
+		m.mu.RLock()
+		key, ok := m.keys[kid]
+		m.mu.RUnlock()
+		if !ok || (key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt)) {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
 		}
-		return m.publicKey, nil
-	})
+		return &key.PrivateKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}), jwt.WithIssuer(m.issuer))
 
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %v", err)
@@ -102,3 +128,19 @@ func (m *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error) {
 
 	return nil, fmt.Errorf("invalid token claims")
 }
+
+// JWKS returns the public keys that verify tokens, including keys published
+// ahead of their activation so verifiers can cache them in time
+func (m *JWTManager) JWKS() JWKSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	set := JWKSet{Keys: make([]JWK, 0, len(m.ordered))}
+	for _, key := range m.ordered {
+		jwk := PublicJWK(&key.PrivateKey.PublicKey)
+		jwk.KeyID = key.ID
+		jwk.Use = "sig"
+		jwk.Algorithm = jwt.SigningMethodES256.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SigningKey is one ES256 key of the key set. It signs tokens from
+// ActivatesAt until a newer key activates, and verifies them until ExpiresAt
+type SigningKey struct {
+	ID          string
+	PrivateKey  *ecdsa.PrivateKey
+	ActivatesAt time.Time
+	ExpiresAt   *time.Time
+}
+
+// JWK is the public part of a signing key as published in the JWKS
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// GenerateKey creates a new P-256 private key
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// ParsePrivateKey reads a P-256 key from an "EC PRIVATE KEY" PEM block
+func ParsePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse PEM block containing private key")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("private key is not a P-256 key")
+	}
+	return key, nil
+}
+
+// ParseKeyEncryptionKey decodes a base64 encoded 32 byte AES key used to
+// encrypt signing keys at rest
+func ParseKeyEncryptionKey(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, errors.New("no key encryption key is configured")
+	}
+	kek, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key encryption key is not valid base64: %v", err)
+	}
+	if len(kek) != 32 {
+		return nil, fmt.Errorf("key encryption key must be 32 bytes, got %d", len(kek))
+	}
+	return kek, nil
+}
+
+// SealPrivateKey encrypts the key with AES-256-GCM under the key encryption
+// key. The key ID is authenticated with it, so a sealed key cannot be stored
+// under another ID
+func SealPrivateKey(kek []byte, keyID string, key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newKeyCipher(kek)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, der, []byte(keyID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenPrivateKey decrypts a key sealed by SealPrivateKey
+func OpenPrivateKey(kek []byte, keyID, sealed string) (*ecdsa.PrivateKey, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sealed private key: %v", err)
+	}
+	aead, err := newKeyCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("sealed private key is too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	der, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key, is the key encryption key right? %v", err)
+	}
+	key, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("private key is not a P-256 key")
+	}
+	return key, nil
+}
+
+func newKeyCipher(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadPrivateKeyFile reads a PEM encoded private key from disk
+func LoadPrivateKeyFile(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private key: %w", err)
+	}
+	return ParsePrivateKey(data)
+}
+
+// PublicJWK describes the public key in JWK form
+func PublicJWK(key *ecdsa.PublicKey) JWK {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x := make([]byte, size)
+	y := make([]byte, size)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return JWK{
+		KeyType: "EC",
+		Curve:   "P-256",
+		X:       base64.RawURLEncoding.EncodeToString(x),
+		Y:       base64.RawURLEncoding.EncodeToString(y),
+	}
+}
+
+// KeyID returns the RFC 7638 thumbprint of the public key, used as the kid
+func KeyID(key *ecdsa.PublicKey) string {
+	jwk := PublicJWK(key)
+	// The thumbprint hashes the required members in lexicographic order
+	members, _ := json.Marshal(struct {
+		Crv string `json:"crv"`
+		Kty string `json:"kty"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y})
+	sum := sha256.Sum256(members)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
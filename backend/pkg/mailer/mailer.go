@@ -0,0 +1,80 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Mail is a plain text email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends account emails such as verification and password reset links
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// New returns the mailer for the driver. "console" logs mail and "file"
+// writes each mail as an .eml file into dir
+func New(driver, from, dir string) (Mailer, error) {
+	switch driver {
+	case "", "console":
+		return NewConsoleMailer(from), nil
+	case "file":
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("could not create mail directory: %w", err)
+		}
+		return NewFileMailer(from, dir), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
+}
+
+// ConsoleMailer writes mail to the server log. It is meant for local
+// development
+type ConsoleMailer struct {
+	from string
+}
+
+func NewConsoleMailer(from string) *ConsoleMailer {
+	return &ConsoleMailer{from: from}
+}
+
+func (m *ConsoleMailer) Send(ctx context.Context, mail Mail) error {
+	log.Printf("[mail] from=%s to=%s subject=%q\n%s", m.from, mail.To, mail.Subject, mail.Body)
+	return nil
+}
+
+// FileMailer stores each mail as an .eml file that mail clients can open
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+func NewFileMailer(from, dir string) *FileMailer {
+	return &FileMailer{from: from, dir: dir}
+}
+
+func (m *FileMailer) Send(ctx context.Context, mail Mail) error {
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mail.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405"), uuid.NewString()[:8])
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(b.String()), 0o600)
+}
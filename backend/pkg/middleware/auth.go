@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -11,10 +12,21 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 )
 
-func AuthMiddleware(jwtManager *auth.JWTManager, userService *services.UserService) echo.MiddlewareFunc {
+// AuthMiddleware checks the access token, that its session is still signed in
+// and that the user is active, on every request
+func AuthMiddleware(jwtManager *auth.JWTManager, userService *services.UserService, sessionService *services.AuthSessionService, mfaService *services.MFAService) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
+
+			// Browsers cannot set headers on EventSource connections, so event
+			// streams may pass the token as a query parameter instead
+			if authHeader == "" && isEventStreamRequest(c) {
+				if token := c.QueryParam("access_token"); token != "" {
+					authHeader = "Bearer " + token
+				}
+			}
+
 			if authHeader == "" {
 				return utils.ErrorResponse(c, http.StatusUnauthorized, "Authorization header is missing", nil)
 			}
@@ -31,16 +43,38 @@ func AuthMiddleware(jwtManager *auth.JWTManager, userService *services.UserServi
 
 			//check user is valid
 			filter := bson.M{"_id": claims.UserID}
-			_, err = userService.FindOne(c.Request().Context(), filter)
+			user, err := userService.FindOne(c.Request().Context(), filter)
 			if err != nil {
 				return utils.ErrorResponse(c, http.StatusUnauthorized, "User not valid!", nil)
 			}
+			if err := services.CheckUserCanSignIn(user); err != nil {
+				return utils.ErrorResponse(c, http.StatusForbidden, "Account is not active", nil)
+			}
 
-			// Add claims to context
+			// Tokens of signed out sessions stop working before they expire
+			if err := sessionService.Validate(c.Request().Context(), claims.SessionID); err != nil {
+				if errors.Is(err, services.ErrSessionExpired) {
+					return utils.ErrorResponse(c, http.StatusUnauthorized, "Session has ended", nil)
+				}
+				return utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to check session", err)
+			}
+
+			// The role and locations come from the stored user so changes
+			// apply without waiting for the token to expire
 			c.Set("userID", claims.UserID)
-			c.Set("userRole", claims.Role)
+			c.Set("userRole", string(user.Role))
+			c.Set("userLocations", user.LocationIDs)
+			c.Set("userStatus", user.Status)
+			c.Set("sessionID", claims.SessionID)
+			c.Set("mfa", claims.MFA)
+			c.Set("mfaRequired", mfaService.Required(user.Role))
 
 			return next(c)
 		}
 	}
 }
+
+func isEventStreamRequest(c echo.Context) bool {
+	return c.Request().Method == http.MethodGet &&
+		strings.Contains(c.Request().Header.Get("Accept"), "text/event-stream")
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dfanso/parkme-backend/internal/authz"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Authorize enforces the route's policy rule. Routes without a rule are
+// denied. It must run after AuthMiddleware
+func Authorize(policy authz.Policy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			rule, ok := policy.Lookup(c.Request().Method, c.Path())
+			if !ok {
+				return forbidden(c)
+			}
+			if rule.Access != authz.AccessUser {
+				return next(c)
+			}
+
+			principal := authz.FromContext(c)
+			if principal.NeedsMFA() && !principal.Can(rule.Permission) {
+				return utils.ErrorResponse(c, http.StatusForbidden, "Set up two-factor authentication and sign in again to continue", nil)
+			}
+			if !principal.Can(rule.Permission) {
+				return forbidden(c)
+			}
+			if rule.MFA && !principal.MFA {
+				return utils.ErrorResponse(c, http.StatusForbidden, "Sign in with two-factor authentication to perform this action", nil)
+			}
+			if rule.Location != "" {
+				locationID, err := primitive.ObjectIDFromHex(c.Param(rule.Location))
+				if err != nil {
+					return forbidden(c)
+				}
+				if !principal.CanAccessLocation(locationID) {
+					return forbidden(c)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+func forbidden(c echo.Context) error {
+	return utils.ErrorResponse(c, http.StatusForbidden, "You are not authorized to access this resource", nil)
+}
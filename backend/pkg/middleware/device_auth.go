@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dfanso/parkme-backend/internal/models"
+	"github.com/dfanso/parkme-backend/internal/services"
+	"github.com/dfanso/parkme-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// DeviceAuth authenticates Arduino modules using their X-Device-ID and
+// X-API-Key headers and stores the device in the context under "device"
+func DeviceAuth(deviceService *services.DeviceService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			deviceID := c.Request().Header.Get("X-Device-ID")
+			apiKey := c.Request().Header.Get("X-API-Key")
+			if deviceID == "" || apiKey == "" {
+				return utils.ErrorResponse(c, http.StatusUnauthorized, "Device ID and API key are required", nil)
+			}
+
+			device, err := deviceService.Authenticate(c.Request().Context(), deviceID, apiKey)
+			if err != nil {
+				switch {
+				case errors.Is(err, services.ErrDeviceUnauthorized):
+					return utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid device credentials", nil)
+				case errors.Is(err, services.ErrDeviceRevoked):
+					return utils.ErrorResponse(c, http.StatusForbidden, "Device has been revoked", nil)
+				default:
+					return utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to authenticate device", err)
+				}
+			}
+
+			c.Set("device", device)
+			return next(c)
+		}
+	}
+}
+
+// RequireDeviceType only lets devices of the given types through. It must run after DeviceAuth
+func RequireDeviceType(types ...models.DeviceType) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			device, _ := c.Get("device").(*models.Device)
+			if device != nil {
+				for _, t := range types {
+					if device.Type == t {
+						return next(c)
+					}
+				}
+			}
+			return utils.ErrorResponse(c, http.StatusForbidden, "This device is not allowed to call this endpoint", nil)
+		}
+	}
+}
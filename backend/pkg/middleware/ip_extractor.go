@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/labstack/echo/v4"
+)
+
+// IPExtractor decides where ctx.RealIP() comes from. Without trusted proxies
+// the connection's remote address is used and forwarding headers are ignored,
+// so clients cannot pick their own IP for login throttling and audit logs.
+// With proxies, X-Forwarded-For is only honoured for hops from those ranges
+func IPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, proxy := range trustedProxies {
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
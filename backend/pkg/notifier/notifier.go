@@ -0,0 +1,33 @@
+package notifier
+
+import (
+	"context"
+	"log"
+)
+
+// Message is a rendered notification ready to be handed to a provider
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Provider delivers messages over a single channel such as email, push or SMS
+type Provider interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ConsoleProvider writes messages to the server log instead of sending them.
+// It is meant for local development and testing
+type ConsoleProvider struct {
+	channel string
+}
+
+func NewConsoleProvider(channel string) *ConsoleProvider {
+	return &ConsoleProvider{channel: channel}
+}
+
+func (p *ConsoleProvider) Send(ctx context.Context, msg Message) error {
+	log.Printf("[notify:%s] to=%s subject=%q\n%s", p.channel, msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,29 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// RandomString returns n random bytes, base64url encoded. It is used for
+// state, nonce and PKCE verifier values
+func RandomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// NewCodeVerifier returns a PKCE code verifier (RFC 7636), 43 characters long
+func NewCodeVerifier() (string, error) {
+	return RandomString(32)
+}
+
+// CodeChallengeS256 derives the S256 code challenge sent with the
+// authorization request from the verifier sent with the token request
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
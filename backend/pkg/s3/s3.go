@@ -88,3 +88,18 @@ func (s *S3Client) GeneratePresignedURL(filename, fileType, folderName string) (
 func (s *S3Client) GetBucketName() string {
 	return s.bucketName
 }
+
+// GeneratePresignedGetURL returns a URL that downloads an object for a limited
+// time, for files that must not be publicly readable
+func (s *S3Client) GeneratePresignedGetURL(key string, expires time.Duration) (string, error) {
+	presignClient := s3.NewPresignClient(s.client)
+
+	request, err := presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned URL: %v", err)
+	}
+	return request.URL, nil
+}
@@ -19,34 +19,52 @@ func UploadFileToS3(file *multipart.FileHeader, s3Client *s3.S3Client, folderNam
 		return "", fmt.Errorf("file must be an image")
 	}
 
+	_, s3URL, err := uploadToS3(file, contentType, s3Client, folderName)
+	return s3URL, err
+}
+
+// UploadDocumentToS3 uploads a scanned document, an image or a PDF, and
+// returns its object key. Documents are read through presigned URLs
+func UploadDocumentToS3(file *multipart.FileHeader, s3Client *s3.S3Client, folderName string) (string, error) {
+	contentType := file.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") && contentType != "application/pdf" {
+		return "", fmt.Errorf("document must be an image or a PDF")
+	}
+
+	key, _, err := uploadToS3(file, contentType, s3Client, folderName)
+	return key, err
+}
+
+// uploadToS3 puts the file under the folder and returns its key and URL
+func uploadToS3(file *multipart.FileHeader, contentType string, s3Client *s3.S3Client, folderName string) (string, string, error) {
 	// Open the file
 	src, err := file.Open()
 	if err != nil {
-		return "", fmt.Errorf("failed to open file: %v", err)
+		return "", "", fmt.Errorf("failed to open file: %v", err)
 	}
 	defer src.Close()
 
 	// Read file into buffer
 	buffer := new(bytes.Buffer)
 	if _, err := io.Copy(buffer, src); err != nil {
-		return "", fmt.Errorf("failed to read file: %v", err)
+		return "", "", fmt.Errorf("failed to read file: %v", err)
 	}
 
 	// Generate presigned URL
-	presignedURL, _, s3URL, err := s3Client.GeneratePresignedURL(
+	presignedURL, filename, s3URL, err := s3Client.GeneratePresignedURL(
 		file.Filename,
 		contentType,
 		folderName,
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate upload URL: %v", err)
+		return "", "", fmt.Errorf("failed to generate upload URL: %v", err)
 	}
 
 	// Create HTTP client and request
 	client := &http.Client{}
 	req, err := http.NewRequest(http.MethodPut, presignedURL, bytes.NewReader(buffer.Bytes()))
 	if err != nil {
-		return "", fmt.Errorf("failed to create upload request: %v", err)
+		return "", "", fmt.Errorf("failed to create upload request: %v", err)
 	}
 
 	// Set only Content-Type header
@@ -55,15 +73,15 @@ func UploadFileToS3(file *multipart.FileHeader, s3Client *s3.S3Client, folderNam
 	// Upload to S3
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload to S3: %v", err)
+		return "", "", fmt.Errorf("failed to upload to S3: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		// Read error response
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to upload to S3: status: %d, body: %s", resp.StatusCode, string(body))
+		return "", "", fmt.Errorf("failed to upload to S3: status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	return s3URL, nil
+	return folderName + "/" + filename, s3URL, nil
 }
@@ -9,6 +9,7 @@ type ErrorResponseBody struct {
 	Success bool        `json:"success"`
 	Message string      `json:"message"`
 	Errors  interface{} `json:"errors,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
 func ErrorResponse(ctx echo.Context, code int, message string, err error) error {